@@ -0,0 +1,298 @@
+package datamonkey
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Account is a registered user. Its resources are owned by Subject, a session subject
+// like an anonymous one but exempt from expiry.
+type Account struct {
+	Id           string
+	Subject      string
+	Email        string // Set for accounts that sign in with a password
+	PasswordHash string // Empty for accounts that sign in with OIDC only
+	OIDCIssuer   string
+	OIDCSubject  string
+	Created      time.Time
+}
+
+// ClaimCounts are the numbers of each kind of resource moved by a claim
+type ClaimCounts struct {
+	Datasets       int
+	Jobs           int
+	Conversations  int
+	Visualizations int
+	Uploads        int
+}
+
+// AccountTracker defines the interface for storing accounts and their logins
+type AccountTracker interface {
+	// CreateAccount stores a new account, assigning its ID and a new session subject
+	CreateAccount(account *Account) error
+
+	// GetAccount retrieves an account by ID
+	GetAccount(id string) (*Account, error)
+
+	// GetAccountByEmail retrieves an account by email address
+	GetAccountByEmail(email string) (*Account, error)
+
+	// GetAccountByOIDC retrieves an account by the issuer and subject of its OIDC identity
+	GetAccountByOIDC(issuer string, subject string) (*Account, error)
+
+	// ClaimSubject moves every resource owned by an anonymous subject to an account's subject
+	ClaimSubject(accountSubject string, anonymousSubject string) (ClaimCounts, error)
+
+	// CreateLogin records a sign-in to an account and returns its ID
+	CreateLogin(accountID string) (string, error)
+
+	// IsLoginActive reports whether a login exists and has not ended
+	IsLoginActive(loginID string) (bool, error)
+
+	// EndLogin ends a login, so tokens issued for it no longer validate
+	EndLogin(loginID string) error
+}
+
+// SQLiteAccountTracker implements AccountTracker using the unified database
+type SQLiteAccountTracker struct {
+	db *sql.DB
+}
+
+// NewSQLiteAccountTracker creates a new SQLiteAccountTracker instance using the unified database
+func NewSQLiteAccountTracker(db *sql.DB) *SQLiteAccountTracker {
+	return &SQLiteAccountTracker{db: db}
+}
+
+// CreateAccount stores a new account, assigning its ID and a new session subject
+func (t *SQLiteAccountTracker) CreateAccount(account *Account) error {
+	account.Id = uuid.New().String()
+	account.Subject = uuid.New().String()
+	account.Created = time.Now()
+
+	tx, err := t.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := account.Created.Unix()
+	if _, err := tx.Exec(`INSERT INTO sessions (subject, created_at, last_seen) VALUES (?, ?, ?)`,
+		account.Subject, now, now); err != nil {
+		return fmt.Errorf("failed to create account session: %v", err)
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO accounts (id, subject, email, password_hash, oidc_issuer, oidc_subject, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		account.Id, account.Subject,
+		sql.NullString{String: account.Email, Valid: account.Email != ""},
+		sql.NullString{String: account.PasswordHash, Valid: account.PasswordHash != ""},
+		sql.NullString{String: account.OIDCIssuer, Valid: account.OIDCIssuer != ""},
+		sql.NullString{String: account.OIDCSubject, Valid: account.OIDCSubject != ""},
+		now, now,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return fmt.Errorf("account already exists")
+		}
+		return fmt.Errorf("failed to create account: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit account: %v", err)
+	}
+	log.Printf("Created account %s with subject %s", account.Id, account.Subject)
+	return nil
+}
+
+// getAccountWhere retrieves the account matching a condition
+func (t *SQLiteAccountTracker) getAccountWhere(condition string, args ...interface{}) (*Account, error) {
+	var account Account
+	var email, passwordHash, issuer, oidcSubject sql.NullString
+	var created int64
+	err := t.db.QueryRow(`
+	SELECT id, subject, email, password_hash, oidc_issuer, oidc_subject, created_at
+	FROM accounts WHERE `+condition, args...).Scan(
+		&account.Id, &account.Subject, &email, &passwordHash, &issuer, &oidcSubject, &created,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("account not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %v", err)
+	}
+	account.Email = email.String
+	account.PasswordHash = passwordHash.String
+	account.OIDCIssuer = issuer.String
+	account.OIDCSubject = oidcSubject.String
+	account.Created = time.Unix(created, 0)
+	return &account, nil
+}
+
+// GetAccount retrieves an account by ID
+func (t *SQLiteAccountTracker) GetAccount(id string) (*Account, error) {
+	return t.getAccountWhere("id = ?", id)
+}
+
+// GetAccountByEmail retrieves an account by email address
+func (t *SQLiteAccountTracker) GetAccountByEmail(email string) (*Account, error) {
+	return t.getAccountWhere("email = ?", email)
+}
+
+// GetAccountByOIDC retrieves an account by the issuer and subject of its OIDC identity
+func (t *SQLiteAccountTracker) GetAccountByOIDC(issuer string, subject string) (*Account, error) {
+	return t.getAccountWhere("oidc_issuer = ? AND oidc_subject = ?", issuer, subject)
+}
+
+// ClaimSubject moves every resource owned by an anonymous subject to an account's subject.
+// The anonymous session is left to expire; it no longer owns anything.
+func (t *SQLiteAccountTracker) ClaimSubject(accountSubject string, anonymousSubject string) (ClaimCounts, error) {
+	var counts ClaimCounts
+	tx, err := t.db.Begin()
+	if err != nil {
+		return counts, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var isAccount int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM accounts WHERE subject = ?`, anonymousSubject).Scan(&isAccount); err != nil {
+		return counts, fmt.Errorf("failed to check subject: %v", err)
+	}
+	if isAccount > 0 {
+		return counts, fmt.Errorf("subject belongs to an account and cannot be claimed")
+	}
+
+	datasets, err := claimDatasets(tx, accountSubject, anonymousSubject)
+	if err != nil {
+		return counts, err
+	}
+	counts.Datasets = datasets
+
+	for _, move := range []struct {
+		query string
+		count *int
+	}{
+		{`UPDATE jobs SET user_id = ? WHERE user_id = ?`, &counts.Jobs},
+		{`UPDATE conversations SET subject = ? WHERE subject = ?`, &counts.Conversations},
+		{`UPDATE visualizations SET user_id = ? WHERE user_id = ?`, &counts.Visualizations},
+		{`UPDATE uploads SET user_id = ? WHERE user_id = ?`, &counts.Uploads},
+	} {
+		result, err := tx.Exec(move.query, accountSubject, anonymousSubject)
+		if err != nil {
+			return counts, fmt.Errorf("failed to claim resources: %v", err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return counts, fmt.Errorf("failed to check rows affected: %v", err)
+		}
+		*move.count = int(rows)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return counts, fmt.Errorf("failed to commit claim: %v", err)
+	}
+	log.Printf("Claimed subject %s into %s: %+v", anonymousSubject, accountSubject, counts)
+	return counts, nil
+}
+
+// claimDatasets moves an anonymous subject's datasets to an account's subject. Dataset
+// IDs are derived from the owner (see UserDatasetID), so each dataset is rekeyed to the
+// ID the account would have given it, or merged into the account's dataset with the
+// same content; either way uploading the content again finds the claimed dataset.
+// Series keep their IDs, so clients can still list a series by the ID they know.
+func claimDatasets(tx *sql.Tx, accountSubject string, anonymousSubject string) (int, error) {
+	// References are moved before or after the dataset they point at, so they are
+	// checked when the claim commits
+	if _, err := tx.Exec(`PRAGMA defer_foreign_keys = ON`); err != nil {
+		return 0, fmt.Errorf("failed to defer foreign keys: %v", err)
+	}
+
+	rows, err := tx.Query(`SELECT id, content_hash FROM datasets WHERE user_id = ?`, anonymousSubject)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list datasets to claim: %v", err)
+	}
+	type claim struct{ oldID, newID string }
+	var claims []claim
+	for rows.Next() {
+		var id, contentHash string
+		if err := rows.Scan(&id, &contentHash); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan dataset: %v", err)
+		}
+		claims = append(claims, claim{id, UserDatasetID(contentHash, accountSubject)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list datasets to claim: %v", err)
+	}
+
+	for _, claim := range claims {
+		var existing int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM datasets WHERE id = ?`, claim.newID).Scan(&existing); err != nil {
+			return 0, fmt.Errorf("failed to check claimed dataset: %v", err)
+		}
+		if existing > 0 {
+			// The account already has this content; keep its dataset
+			if _, err := tx.Exec(`DELETE FROM datasets WHERE id = ?`, claim.oldID); err != nil {
+				return 0, fmt.Errorf("failed to merge dataset %s: %v", claim.oldID, err)
+			}
+		} else if _, err := tx.Exec(`UPDATE datasets SET id = ?, data_json = json_set(data_json, '$.id', ?), user_id = ? WHERE id = ?`,
+			claim.newID, claim.newID, accountSubject, claim.oldID); err != nil {
+			return 0, fmt.Errorf("failed to rekey dataset %s: %v", claim.oldID, err)
+		}
+
+		// Stored requests name their datasets too, so reruns find the claimed ones
+		for _, query := range []string{
+			`UPDATE jobs SET alignment_id = ?1, request_json = json_set(request_json, '$.alignment', ?1) WHERE alignment_id = ?2`,
+			`UPDATE jobs SET tree_id = ?1, request_json = json_set(request_json, '$.tree', ?1) WHERE tree_id = ?2`,
+			`UPDATE visualizations SET dataset_id = ?1 WHERE dataset_id = ?2`,
+			`UPDATE uploads SET version_of = ?1 WHERE version_of = ?2`,
+		} {
+			if _, err := tx.Exec(query, claim.newID, claim.oldID); err != nil {
+				return 0, fmt.Errorf("failed to update references to dataset %s: %v", claim.oldID, err)
+			}
+		}
+	}
+	return len(claims), nil
+}
+
+// CreateLogin records a sign-in to an account and returns its ID
+func (t *SQLiteAccountTracker) CreateLogin(accountID string) (string, error) {
+	id := uuid.New().String()
+	if _, err := t.db.Exec(`INSERT INTO logins (id, account_id, created_at) VALUES (?, ?, ?)`,
+		id, accountID, time.Now().Unix()); err != nil {
+		return "", fmt.Errorf("failed to create login: %v", err)
+	}
+	return id, nil
+}
+
+// IsLoginActive reports whether a login exists and has not ended
+func (t *SQLiteAccountTracker) IsLoginActive(loginID string) (bool, error) {
+	var active int
+	err := t.db.QueryRow(`SELECT COUNT(*) FROM logins WHERE id = ? AND ended_at IS NULL`, loginID).Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("failed to check login: %v", err)
+	}
+	return active > 0, nil
+}
+
+// EndLogin ends a login, so tokens issued for it no longer validate
+func (t *SQLiteAccountTracker) EndLogin(loginID string) error {
+	result, err := t.db.Exec(`UPDATE logins SET ended_at = ? WHERE id = ? AND ended_at IS NULL`, time.Now().Unix(), loginID)
+	if err != nil {
+		return fmt.Errorf("failed to end login: %v", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("login not found: %s", loginID)
+	}
+	return nil
+}
+
+// Ensure SQLiteAccountTracker implements AccountTracker interface
+var _ AccountTracker = (*SQLiteAccountTracker)(nil)
@@ -0,0 +1,968 @@
+package datamonkey
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Alignment formats recognized by ParseAlignment
+const (
+	AlignmentFormatFASTA   = "fasta"
+	AlignmentFormatNEXUS   = "nexus"
+	AlignmentFormatPHYLIP  = "phylip"
+	AlignmentFormatCLUSTAL = "clustal"
+)
+
+// alignmentDatasetTypes are dataset types that must contain an alignment
+var alignmentDatasetTypes = map[string]bool{
+	"fasta": true, "fas": true, "fa": true, "fna": true, "faa": true,
+	"nexus": true, "nex": true, "nxs": true,
+	"phylip": true, "phy": true,
+	"clustal": true, "aln": true,
+}
+
+// Alignment is a parsed multiple sequence alignment
+type Alignment struct {
+	Format    string
+	Sequences []AlignmentSequence
+
+	// Set while parsing: the sequences being read, whether to keep all their residues,
+	// and the NEXUS match character
+	builders  []*sequenceBuilder
+	keepAll   bool
+	matchchar byte
+	// tally counts the residues of alignments parsed only for their statistics
+	tally *residueTally
+}
+
+// AlignmentSequence is a named sequence and the line it was declared on.
+// Gaps are normalized to '-' and missing data to '?'.
+type AlignmentSequence struct {
+	Name string
+	Data string
+	Line int
+	// length is the number of residues when Data was counted but not kept
+	length int
+}
+
+// Length returns the number of residues in the sequence
+func (s AlignmentSequence) Length() int {
+	if s.Data == "" {
+		return s.length
+	}
+	return len(s.Data)
+}
+
+// residueTally counts each character of an alignment's sequences
+type residueTally [256]int
+
+// sequenceBuilder collects the residues of a sequence as it is parsed
+type sequenceBuilder struct {
+	data   strings.Builder
+	length int
+	keep   bool
+	tally  *residueTally
+	// reference is the first sequence, whose residues replace the match character
+	reference *sequenceBuilder
+	matchchar byte
+}
+
+// WriteByte appends a residue, replacing the match character with the reference's residue
+func (b *sequenceBuilder) WriteByte(c byte) error {
+	if b.reference != nil && b.matchchar != 0 && c == b.matchchar && b.length < b.reference.length {
+		c = b.reference.data.String()[b.length]
+	}
+	b.length++
+	if b.tally != nil {
+		b.tally[c]++
+	}
+	if b.keep {
+		b.data.WriteByte(c)
+	}
+	return nil
+}
+
+// Len returns the number of residues appended so far
+func (b *sequenceBuilder) Len() int {
+	return b.length
+}
+
+// newAlignment starts an alignment that parsers add sequences to. Unless keepAll is set,
+// only the first sequence's residues are kept and the rest are counted for Stats, so
+// large uploads can be checked without holding every sequence in memory.
+func newAlignment(format string, keepAll bool) *Alignment {
+	alignment := &Alignment{Format: format, keepAll: keepAll}
+	if !keepAll {
+		alignment.tally = &residueTally{}
+	}
+	return alignment
+}
+
+// addSequence starts a sequence declared at a line and returns its builder
+func (a *Alignment) addSequence(name string, line int) *sequenceBuilder {
+	builder := &sequenceBuilder{keep: a.keepAll || len(a.builders) == 0, tally: a.tally, matchchar: a.matchchar}
+	if len(a.builders) > 0 {
+		builder.reference = a.builders[0]
+	}
+	a.Sequences = append(a.Sequences, AlignmentSequence{Name: name, Line: line})
+	a.builders = append(a.builders, builder)
+	return builder
+}
+
+// finish moves the parsed residues into the sequences
+func (a *Alignment) finish() {
+	for i, builder := range a.builders {
+		a.Sequences[i].Data = builder.data.String()
+		if !builder.keep {
+			a.Sequences[i].length = builder.length
+		}
+	}
+	a.builders = nil
+}
+
+// AlignmentError reports malformed alignment input at a line of the file
+type AlignmentError struct {
+	Line    int
+	Message string
+}
+
+func (e *AlignmentError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+func alignmentErrorf(line int, format string, args ...interface{}) *AlignmentError {
+	return &AlignmentError{Line: line, Message: fmt.Sprintf(format, args...)}
+}
+
+// CheckAlignment parses an uploaded dataset as an alignment and returns its statistics.
+// Content that is not in an alignment format is skipped (nil stats) unless datasetType
+// names an alignment format, in which case it is an error. NEXUS files without
+// character data, such as tree files, are always skipped.
+func CheckAlignment(datasetType string, content []byte) (*AlignmentStats, error) {
+	return checkAlignment(datasetType, bytesSource(content))
+}
+
+// CheckAlignmentFile checks an uploaded file like CheckAlignment, reading it as a stream
+// and keeping only the first sequence in memory
+func CheckAlignmentFile(datasetType string, path string) (*AlignmentStats, error) {
+	return checkAlignment(datasetType, fileSource(path))
+}
+
+func checkAlignment(datasetType string, source contentSource) (*AlignmentStats, error) {
+	number, line, err := source.firstLine()
+	if err != nil {
+		return nil, alignmentErrorf(number, "failed to read alignment: %v", err)
+	}
+	format := alignmentFormatOf(line)
+	if format == "" {
+		if alignmentDatasetTypes[strings.ToLower(datasetType)] {
+			return nil, alignmentErrorf(number, "unrecognized alignment format, expected FASTA, NEXUS, PHYLIP or CLUSTAL")
+		}
+		return nil, nil
+	}
+
+	alignment, err := parseAlignment(source, format, number, false)
+	if err == errNEXUSNoData {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stats := alignment.Stats()
+	return &stats, nil
+}
+
+// DetectAlignmentFormat returns the alignment format of content, or "" if it is not an alignment.
+// NEXUS files without a DATA or CHARACTERS block (e.g. tree files) are not alignments.
+func DetectAlignmentFormat(content []byte) string {
+	format := alignmentFormatOf(firstContentLineText(content))
+	if format == AlignmentFormatNEXUS && !nexusDataBlockPattern.Match(content) {
+		return ""
+	}
+	return format
+}
+
+// alignmentFormatOf returns the alignment format a file's first non-blank line starts,
+// or "" if it starts none. Any NEXUS file may hold an alignment.
+func alignmentFormatOf(line string) string {
+	upper := strings.ToUpper(line)
+	switch {
+	case strings.HasPrefix(line, ">"):
+		return AlignmentFormatFASTA
+	case strings.HasPrefix(upper, "#NEXUS"):
+		return AlignmentFormatNEXUS
+	case strings.HasPrefix(upper, "CLUSTAL"):
+		return AlignmentFormatCLUSTAL
+	case phylipHeaderPattern.MatchString(line):
+		return AlignmentFormatPHYLIP
+	}
+	return ""
+}
+
+// ParseAlignment parses a FASTA, NEXUS, PHYLIP or CLUSTAL alignment and checks that
+// every sequence is named, uses sequence characters and has the same length
+func ParseAlignment(content []byte) (*Alignment, error) {
+	return parseAlignment(bytesSource(content), DetectAlignmentFormat(content), firstContentLine(content), true)
+}
+
+// parseAlignment parses an alignment in a known format whose first non-blank line is first
+func parseAlignment(source contentSource, format string, first int, keepAll bool) (*Alignment, error) {
+	var parse func(contentSource, bool) (*Alignment, error)
+	switch format {
+	case AlignmentFormatFASTA:
+		parse = parseFASTA
+	case AlignmentFormatNEXUS:
+		parse = parseNEXUS
+	case AlignmentFormatPHYLIP:
+		parse = parsePHYLIP
+	case AlignmentFormatCLUSTAL:
+		parse = parseCLUSTAL
+	default:
+		return nil, alignmentErrorf(first, "unrecognized alignment format, expected FASTA, NEXUS, PHYLIP or CLUSTAL")
+	}
+	alignment, err := parse(source, keepAll)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(alignment.Sequences) == 0 {
+		return nil, alignmentErrorf(first, "alignment has no sequences")
+	}
+	length := alignment.Sequences[0].Length()
+	for _, sequence := range alignment.Sequences {
+		if sequence.Length() == 0 {
+			return nil, alignmentErrorf(sequence.Line, "sequence %q is empty", sequence.Name)
+		}
+		if sequence.Length() != length {
+			return nil, alignmentErrorf(sequence.Line, "sequence %q has %d characters, expected %d like %q",
+				sequence.Name, sequence.Length(), length, alignment.Sequences[0].Name)
+		}
+	}
+	return alignment, nil
+}
+
+// appendResidues appends a chunk of sequence data, dropping whitespace and checking characters
+func appendResidues(data *sequenceBuilder, chunk string, line int, gap, missing byte) error {
+	for i := 0; i < len(chunk); i++ {
+		c := chunk[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			continue
+		case gap != 0 && c == gap, c == '-':
+			data.WriteByte('-')
+		case missing != 0 && c == missing, c == '?':
+			data.WriteByte('?')
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c == '.', c == '*', c == '~':
+			data.WriteByte(c)
+		default:
+			return alignmentErrorf(line, "invalid sequence character %q", c)
+		}
+	}
+	return nil
+}
+
+func parseFASTA(source contentSource, keepAll bool) (*Alignment, error) {
+	reader, err := source()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	alignment := newAlignment(AlignmentFormatFASTA, keepAll)
+	var builder *sequenceBuilder
+	lineNumber := 0
+	scanner := newLineScanner(reader)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, ">") {
+			name := strings.TrimSpace(line[1:])
+			if name == "" {
+				return nil, alignmentErrorf(lineNumber, "sequence name is empty")
+			}
+			builder = alignment.addSequence(name, lineNumber)
+			continue
+		}
+		if builder == nil {
+			return nil, alignmentErrorf(lineNumber, "sequence data before the first '>' header")
+		}
+		if err := appendResidues(builder, line, lineNumber, 0, 0); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, alignmentErrorf(lineNumber+1, "failed to read alignment: %v", err)
+	}
+	alignment.finish()
+	return alignment, nil
+}
+
+// NEXUS block and command patterns
+var (
+	nexusDataBlockPattern = regexp.MustCompile(`(?i)\bbegin\s+(data|characters)\s*;`)
+	phylipHeaderPattern   = regexp.MustCompile(`^\s*(\d+)\s+(\d+)`)
+)
+
+// nexusToken is a word, quoted name or punctuation in a NEXUS file, with its line
+type nexusToken struct {
+	text string
+	line int
+}
+
+// errNEXUSNoData is returned for NEXUS files without character data, such as tree files
+var errNEXUSNoData = alignmentErrorf(1, "NEXUS file has no DATA or CHARACTERS block")
+
+// nexusTokenizer reads the tokens of NEXUS content one at a time, dropping [comments]
+type nexusTokenizer struct {
+	reader *bufio.Reader
+	line   int
+	peeked []nexusToken
+}
+
+func newNEXUSTokenizer(reader io.Reader) *nexusTokenizer {
+	return &nexusTokenizer{reader: bufio.NewReader(reader), line: 1}
+}
+
+// peek returns up to n tokens without consuming them; fewer remain at the end of the content
+func (t *nexusTokenizer) peek(n int) ([]nexusToken, error) {
+	for len(t.peeked) < n {
+		token, ok, err := t.read()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return t.peeked, nil
+		}
+		t.peeked = append(t.peeked, token)
+	}
+	return t.peeked[:n], nil
+}
+
+// next consumes a token; ok is false at the end of the content
+func (t *nexusTokenizer) next() (nexusToken, bool, error) {
+	if len(t.peeked) > 0 {
+		token := t.peeked[0]
+		t.peeked = t.peeked[1:]
+		return token, true, nil
+	}
+	return t.read()
+}
+
+// readByte reads the next byte; ok is false at the end of the content
+func (t *nexusTokenizer) readByte() (byte, bool, error) {
+	c, err := t.reader.ReadByte()
+	if err == io.EOF {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, alignmentErrorf(t.line, "failed to read alignment: %v", err)
+	}
+	return c, true, nil
+}
+
+// read scans the next token from the content
+func (t *nexusTokenizer) read() (nexusToken, bool, error) {
+	for {
+		c, ok, err := t.readByte()
+		if !ok {
+			return nexusToken{}, false, err
+		}
+		switch {
+		case c == '\n':
+			t.line++
+		case c == ' ' || c == '\t' || c == '\r':
+		case c == '[':
+			start := t.line
+			for depth := 1; depth > 0; {
+				c, ok, err = t.readByte()
+				if err != nil {
+					return nexusToken{}, false, err
+				}
+				if !ok {
+					return nexusToken{}, false, alignmentErrorf(start, "unterminated comment")
+				}
+				switch c {
+				case '\n':
+					t.line++
+				case '[':
+					depth++
+				case ']':
+					depth--
+				}
+			}
+		case c == '\'' || c == '"':
+			start := t.line
+			var text strings.Builder
+			for {
+				d, ok, err := t.readByte()
+				if err != nil {
+					return nexusToken{}, false, err
+				}
+				if !ok {
+					return nexusToken{}, false, alignmentErrorf(start, "unterminated quoted name")
+				}
+				if d == c {
+					// Doubled quotes are an escaped quote
+					if next, err := t.reader.Peek(1); err == nil && next[0] == c {
+						t.reader.ReadByte()
+						text.WriteByte(c)
+						continue
+					}
+					break
+				}
+				if d == '\n' {
+					t.line++
+				}
+				text.WriteByte(d)
+			}
+			return nexusToken{text: text.String(), line: start}, true, nil
+		case c == ';' || c == '=':
+			return nexusToken{text: string(c), line: t.line}, true, nil
+		default:
+			var text strings.Builder
+			text.WriteByte(c)
+			for {
+				d, ok, err := t.readByte()
+				if err != nil {
+					return nexusToken{}, false, err
+				}
+				if !ok {
+					break
+				}
+				if strings.IndexByte(" \t\r\n;=[", d) >= 0 {
+					t.reader.UnreadByte()
+					break
+				}
+				text.WriteByte(d)
+			}
+			return nexusToken{text: text.String(), line: t.line}, true, nil
+		}
+	}
+}
+
+func parseNEXUS(source contentSource, keepAll bool) (*Alignment, error) {
+	reader, err := source()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	tokens := newNEXUSTokenizer(reader)
+
+	// Find the DATA or CHARACTERS block
+	var blockLine int
+	for {
+		window, err := tokens.peek(3)
+		if err != nil {
+			return nil, err
+		}
+		if len(window) < 3 {
+			return nil, errNEXUSNoData
+		}
+		block := strings.ToLower(window[1].text)
+		if strings.EqualFold(window[0].text, "begin") && (block == "data" || block == "characters") && window[2].text == ";" {
+			blockLine = window[2].line
+			tokens.peeked = tokens.peeked[3:]
+			break
+		}
+		tokens.next()
+	}
+
+	ntax, nchar := -1, -1
+	interleaved := false
+	var gap, missing, matchchar byte
+
+	// Read commands up to MATRIX
+	var matrixLine int
+	for {
+		token, ok, err := tokens.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, alignmentErrorf(blockLine, "DATA block has no MATRIX")
+		}
+		command := strings.ToLower(token.text)
+		if command == "matrix" {
+			matrixLine = token.line
+			break
+		}
+		if command == "end" || command == "endblock" {
+			return nil, alignmentErrorf(token.line, "DATA block has no MATRIX")
+		}
+
+		// Gather the command's arguments as key=value pairs
+		var args []nexusToken
+		for {
+			arg, ok, err := tokens.next()
+			if err != nil {
+				return nil, err
+			}
+			if !ok || arg.text == ";" {
+				break
+			}
+			args = append(args, arg)
+		}
+		for k := range args {
+			if command == "format" && strings.EqualFold(args[k].text, "interleave") {
+				interleaved = k+2 >= len(args) || args[k+1].text != "=" || !strings.EqualFold(args[k+2].text, "no")
+			}
+			if k+2 >= len(args) || args[k+1].text != "=" {
+				continue
+			}
+			key, value := strings.ToLower(args[k].text), args[k+2]
+			switch {
+			case command == "dimensions" && key == "ntax":
+				if ntax, err = strconv.Atoi(value.text); err != nil {
+					return nil, alignmentErrorf(value.line, "invalid NTAX %q", value.text)
+				}
+			case command == "dimensions" && key == "nchar":
+				if nchar, err = strconv.Atoi(value.text); err != nil {
+					return nil, alignmentErrorf(value.line, "invalid NCHAR %q", value.text)
+				}
+			case command == "format" && key == "gap":
+				gap = value.text[0]
+			case command == "format" && key == "missing":
+				missing = value.text[0]
+			case command == "format" && key == "matchchar":
+				matchchar = value.text[0]
+			}
+		}
+	}
+	if nchar < 0 {
+		return nil, alignmentErrorf(blockLine, "DATA block has no DIMENSIONS NCHAR")
+	}
+
+	// Group the matrix tokens by line: each row is a name followed by sequence chunks.
+	// Sequential matrices may wrap a sequence over several lines until it has NCHAR characters;
+	// interleaved matrices repeat the names in each block. Match characters are replaced
+	// with the first sequence's character as they are read.
+	alignment := newAlignment(AlignmentFormatNEXUS, keepAll)
+	alignment.matchchar = matchchar
+	data := make(map[string]*sequenceBuilder)
+	current := ""
+
+	terminated := false
+	for {
+		token, ok, err := tokens.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		if token.text == ";" {
+			terminated = true
+			break
+		}
+		line := token.line
+		row := []nexusToken{token}
+		for {
+			window, err := tokens.peek(1)
+			if err != nil {
+				return nil, err
+			}
+			if len(window) == 0 || window[0].line != line || window[0].text == ";" {
+				break
+			}
+			row = append(row, window[0])
+			tokens.next()
+		}
+
+		var builder *sequenceBuilder
+		if !interleaved && current != "" && data[current].Len() < nchar {
+			builder = data[current]
+		} else {
+			name := row[0].text
+			row = row[1:]
+			var known bool
+			if builder, known = data[name]; !known {
+				if ntax >= 0 && len(alignment.Sequences) == ntax {
+					return nil, alignmentErrorf(line, "more than NTAX=%d sequences in MATRIX", ntax)
+				}
+				builder = alignment.addSequence(name, line)
+				data[name] = builder
+			}
+			current = name
+		}
+		for _, chunk := range row {
+			if err := appendResidues(builder, chunk.text, chunk.line, gap, missing); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !terminated {
+		return nil, alignmentErrorf(matrixLine, "MATRIX is not terminated by ';'")
+	}
+
+	alignment.finish()
+	if ntax >= 0 && len(alignment.Sequences) != ntax {
+		return nil, alignmentErrorf(matrixLine, "MATRIX has %d sequences, expected NTAX=%d", len(alignment.Sequences), ntax)
+	}
+	for _, sequence := range alignment.Sequences {
+		if sequence.Length() != nchar {
+			return nil, alignmentErrorf(sequence.Line, "sequence %q has %d characters, expected NCHAR=%d", sequence.Name, sequence.Length(), nchar)
+		}
+	}
+	return alignment, nil
+}
+
+// phylipLine is a non-blank line of a PHYLIP file
+type phylipLine struct {
+	text   string
+	number int
+}
+
+// phylipReader reads the non-blank lines of a PHYLIP file
+type phylipReader struct {
+	scanner *bufio.Scanner
+	number  int
+	// last is the number of the last non-blank line read after the header, or 1
+	last int
+}
+
+// next returns the next non-blank line; ok is false at the end of the file
+func (r *phylipReader) next() (phylipLine, bool) {
+	for r.scanner.Scan() {
+		r.number++
+		if text := strings.TrimSpace(r.scanner.Text()); text != "" {
+			r.last = r.number
+			return phylipLine{text: text, number: r.number}, true
+		}
+	}
+	return phylipLine{}, false
+}
+
+// err returns the error that stopped the reader early, if any
+func (r *phylipReader) err() error {
+	if err := r.scanner.Err(); err != nil {
+		return alignmentErrorf(r.number+1, "failed to read alignment: %v", err)
+	}
+	return nil
+}
+
+// parsePHYLIP reads the file as a sequential alignment, then as an interleaved one
+func parsePHYLIP(source contentSource, keepAll bool) (*Alignment, error) {
+	alignment, sequentialErr := readPHYLIP(source, keepAll, parsePHYLIPSequential)
+	if sequentialErr == nil {
+		return alignment, nil
+	}
+	if alignment, err := readPHYLIP(source, keepAll, parsePHYLIPInterleaved); err == nil {
+		return alignment, nil
+	}
+	return nil, sequentialErr
+}
+
+// readPHYLIP reads the header of a PHYLIP file and the rows after it with parse
+func readPHYLIP(source contentSource, keepAll bool, parse func(*phylipReader, *Alignment, int, int) error) (*Alignment, error) {
+	reader, err := source()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	lines := &phylipReader{scanner: newLineScanner(reader)}
+	header, _ := lines.next()
+	if err := lines.err(); err != nil {
+		return nil, err
+	}
+	fields := phylipHeaderPattern.FindStringSubmatch(header.text)
+	if fields == nil {
+		return nil, alignmentErrorf(header.number, "expected a PHYLIP header with the number of sequences and characters")
+	}
+	ntax, _ := strconv.Atoi(fields[1])
+	nchar, _ := strconv.Atoi(fields[2])
+	if ntax == 0 || nchar == 0 {
+		return nil, alignmentErrorf(header.number, "PHYLIP header declares %d sequences of %d characters", ntax, nchar)
+	}
+	lines.last = 1
+
+	alignment := newAlignment(AlignmentFormatPHYLIP, keepAll)
+	err = parse(lines, alignment, ntax, nchar)
+	if readErr := lines.err(); readErr != nil {
+		return nil, readErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	alignment.finish()
+	return alignment, nil
+}
+
+// splitPHYLIPName splits a row into the name and the sequence data that follows it
+func splitPHYLIPName(line phylipLine) (string, string) {
+	fields := strings.Fields(line.text)
+	return fields[0], strings.TrimSpace(strings.TrimPrefix(line.text, fields[0]))
+}
+
+// parsePHYLIPSequential reads each sequence in full, possibly wrapped over several lines
+func parsePHYLIPSequential(lines *phylipReader, alignment *Alignment, ntax, nchar int) error {
+	line, ok := lines.next()
+	for len(alignment.Sequences) < ntax {
+		if !ok {
+			return alignmentErrorf(lines.last, "found %d sequences, expected %d", len(alignment.Sequences), ntax)
+		}
+		name, rest := splitPHYLIPName(line)
+		sequenceLine := line.number
+		builder := alignment.addSequence(name, sequenceLine)
+		if err := appendResidues(builder, rest, line.number, 0, 0); err != nil {
+			return err
+		}
+		for line, ok = lines.next(); ok && builder.Len() < nchar; line, ok = lines.next() {
+			if err := appendResidues(builder, line.text, line.number, 0, 0); err != nil {
+				return err
+			}
+		}
+		if builder.Len() != nchar {
+			return alignmentErrorf(sequenceLine, "sequence %q has %d characters, expected %d", name, builder.Len(), nchar)
+		}
+	}
+	if ok {
+		return alignmentErrorf(line.number, "unexpected data after %d sequences", ntax)
+	}
+	return nil
+}
+
+// parsePHYLIPInterleaved reads a block of named rows, then blocks of unnamed rows in the same order
+func parsePHYLIPInterleaved(lines *phylipReader, alignment *Alignment, ntax, nchar int) error {
+	var rows []*sequenceBuilder
+	count := 0
+	for line, ok := lines.next(); ok; line, ok = lines.next() {
+		text := line.text
+		if count < ntax {
+			var name string
+			name, text = splitPHYLIPName(line)
+			rows = append(rows, alignment.addSequence(name, line.number))
+		}
+		if err := appendResidues(rows[count%ntax], text, line.number, 0, 0); err != nil {
+			return err
+		}
+		count++
+	}
+	if count < ntax {
+		return alignmentErrorf(lines.last, "found %d sequences, expected %d", count, ntax)
+	}
+	for i, builder := range rows {
+		if builder.Len() != nchar {
+			return alignmentErrorf(alignment.Sequences[i].Line, "sequence %q has %d characters, expected %d",
+				alignment.Sequences[i].Name, builder.Len(), nchar)
+		}
+	}
+	return nil
+}
+
+func parseCLUSTAL(source contentSource, keepAll bool) (*Alignment, error) {
+	reader, err := source()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	alignment := newAlignment(AlignmentFormatCLUSTAL, keepAll)
+	data := make(map[string]*sequenceBuilder)
+
+	lineNumber := 0
+	scanner := newLineScanner(reader)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		// Skip the header, blank lines and conservation lines (which start with whitespace)
+		if lineNumber == 1 || strings.TrimSpace(line) == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, alignmentErrorf(lineNumber, "expected a sequence name followed by sequence data")
+		}
+		// An optional trailing residue count
+		if len(fields) > 2 {
+			if _, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+				fields = fields[:len(fields)-1]
+			}
+		}
+
+		name := fields[0]
+		builder, ok := data[name]
+		if !ok {
+			builder = alignment.addSequence(name, lineNumber)
+			data[name] = builder
+		}
+		if err := appendResidues(builder, strings.Join(fields[1:], ""), lineNumber, 0, 0); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, alignmentErrorf(lineNumber+1, "failed to read alignment: %v", err)
+	}
+	alignment.finish()
+	return alignment, nil
+}
+
+// Stats returns summary statistics of the alignment
+func (a *Alignment) Stats() AlignmentStats {
+	stats := AlignmentStats{
+		Format:              a.Format,
+		SequenceCount:       int32(len(a.Sequences)),
+		AmbiguousCharacters: map[string]int32{},
+		DuplicateNames:      []string{},
+	}
+	if len(a.Sequences) > 0 {
+		stats.AlignmentLength = int32(a.Sequences[0].Length())
+	}
+
+	tally := a.tally
+	if tally == nil {
+		tally = &residueTally{}
+		for _, sequence := range a.Sequences {
+			for i := 0; i < len(sequence.Data); i++ {
+				tally[sequence.Data[i]]++
+			}
+		}
+	}
+
+	var residues, nucleotides, gaps, total int
+	for c, count := range tally {
+		total += count
+		switch upper := upper(byte(c)); {
+		case upper == '-' || upper == '.' || upper == '~':
+			gaps += count
+		case upper != '?':
+			residues += count
+			if strings.IndexByte("ACGTUN", upper) >= 0 {
+				nucleotides += count
+			}
+		}
+	}
+
+	// Treat the alignment as nucleotides when nearly all residues are nucleotide characters
+	stats.Alphabet = "protein"
+	ambiguous := "BZJX?"
+	if residues == 0 || float64(nucleotides) >= 0.9*float64(residues) {
+		stats.Alphabet = "nucleotide"
+		ambiguous = "RYKMSWBDHVN?"
+	}
+
+	for c, count := range tally {
+		if upper := upper(byte(c)); count > 0 && strings.IndexByte(ambiguous, upper) >= 0 {
+			stats.AmbiguousCharacters[string(upper)] += int32(count)
+			stats.AmbiguousCount += int32(count)
+		}
+	}
+	if total > 0 {
+		stats.GapFraction = float64(gaps) / float64(total)
+	}
+
+	seen := make(map[string]int)
+	for _, sequence := range a.Sequences {
+		seen[sequence.Name]++
+		if seen[sequence.Name] == 2 {
+			stats.DuplicateNames = append(stats.DuplicateNames, sequence.Name)
+		}
+	}
+	sort.Strings(stats.DuplicateNames)
+	return stats
+}
+
+// FASTA writes the alignment in FASTA format, wrapping sequences at 60 characters
+func (a *Alignment) FASTA() []byte {
+	var b bytes.Buffer
+	for _, sequence := range a.Sequences {
+		b.WriteString(">" + sequence.Name + "\n")
+		for start := 0; start < len(sequence.Data); start += 60 {
+			end := start + 60
+			if end > len(sequence.Data) {
+				end = len(sequence.Data)
+			}
+			b.WriteString(sequence.Data[start:end] + "\n")
+		}
+	}
+	return b.Bytes()
+}
+
+func upper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+func newLineScanner(reader io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	return scanner
+}
+
+// contentSource opens dataset content for reading. Parsers that make more than one pass
+// over the content, like PHYLIP's, open it again.
+type contentSource func() (io.ReadCloser, error)
+
+// bytesSource reads content held in memory
+func bytesSource(content []byte) contentSource {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(content)), nil
+	}
+}
+
+// fileSource reads the file at path
+func fileSource(path string) contentSource {
+	return func() (io.ReadCloser, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open dataset: %v", err)
+		}
+		return file, nil
+	}
+}
+
+// firstLine returns the number and text of the first non-blank line
+func (s contentSource) firstLine() (int, string, error) {
+	reader, err := s()
+	if err != nil {
+		return 1, "", err
+	}
+	defer reader.Close()
+
+	scanner := newLineScanner(reader)
+	for number := 1; scanner.Scan(); number++ {
+		if text := strings.TrimSpace(scanner.Text()); text != "" {
+			return number, text, nil
+		}
+	}
+	return 1, "", scanner.Err()
+}
+
+// firstContentLine returns the number of the first non-blank line
+func firstContentLine(content []byte) int {
+	number, _ := firstNonBlankLine(content)
+	return number
+}
+
+func firstContentLineText(content []byte) string {
+	_, text := firstNonBlankLine(content)
+	return text
+}
+
+func firstNonBlankLine(content []byte) (int, string) {
+	for number := 1; len(content) > 0; number++ {
+		line := content
+		if end := bytes.IndexByte(content, '\n'); end >= 0 {
+			line, content = content[:end], content[end+1:]
+		} else {
+			content = nil
+		}
+		if text := strings.TrimSpace(string(line)); text != "" {
+			return number, text
+		}
+	}
+	return 1, ""
+}
@@ -0,0 +1,477 @@
+package datamonkey
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oidcStateTTL is how long a user has to complete sign-in at the OIDC provider
+const oidcStateTTL = 10 * time.Minute
+
+// oidcCookieName is the cookie that ties an OIDC callback to the browser that started sign-in
+const oidcCookieName = "datamonkey_oidc"
+
+// AuthAPI handles session tokens and the optional registered accounts layered on top
+// of anonymous sessions. An account owns a session subject like any other, so claiming
+// an anonymous session only moves its resources to the account's subject.
+type AuthAPI struct {
+	SessionService *SessionService
+	Accounts       AccountTracker
+	OIDC           *OIDCProvider // Optional; OIDC sign-in is disabled when nil
+}
+
+// NewAuthAPI creates a new AuthAPI instance
+func NewAuthAPI(sessionService *SessionService, accounts AccountTracker) *AuthAPI {
+	return &AuthAPI{
+		SessionService: sessionService,
+		Accounts:       accounts,
+	}
+}
+
+// dummyPasswordHash is verified against when an email has no account, so that
+// failed logins take as long whether or not the email is registered
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := HashPassword("datamonkey")
+	return hash
+})
+
+// enabled writes an error response and returns false if the session service is not available
+func (api *AuthAPI) enabled(c *gin.Context, needAccounts bool) bool {
+	if api.SessionService == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Session service not available"})
+		return false
+	}
+	if needAccounts && api.Accounts == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Accounts are not enabled"})
+		return false
+	}
+	return true
+}
+
+// writeAuthToken responds with tokens and the identity they carry
+func (api *AuthAPI) writeAuthToken(c *gin.Context, status int, tokens *TokenPair, subject string, accountID string) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, AuthToken{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    tokens.ExpiresIn,
+		RefreshToken: tokens.RefreshToken,
+		Subject:      subject,
+		AccountId:    accountID,
+	})
+}
+
+// signIn records a login to an account and responds with a token for it
+func (api *AuthAPI) signIn(c *gin.Context, status int, account *Account) {
+	loginID, err := api.Accounts.CreateLogin(account.Id)
+	if err != nil {
+		log.Printf("Error creating login: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+	tokens, err := api.SessionService.GenerateAccountTokens(account, loginID)
+	if err != nil {
+		log.Printf("Error generating account token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	api.writeAuthToken(c, status, tokens, account.Subject, account.Id)
+}
+
+// IssueToken starts a new anonymous session and returns its token
+// POST /api/v1/auth/token
+func (api *AuthAPI) IssueToken(c *gin.Context) {
+	if !api.enabled(c, false) {
+		return
+	}
+	subject, tokens, err := api.SessionService.NewSession()
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	api.writeAuthToken(c, http.StatusCreated, tokens, subject, "")
+}
+
+// Register creates an account with an email address and password and signs in to it
+// POST /api/v1/auth/register
+func (api *AuthAPI) Register(c *gin.Context) {
+	if !api.enabled(c, true) {
+		return
+	}
+
+	var credentials AccountCredentials
+	if err := c.ShouldBindJSON(&credentials); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(credentials.Email))
+	var problems []InvalidDataErrorErrorsInner
+	if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+		problems = append(problems, InvalidDataErrorErrorsInner{Field: "email", Message: "must be an email address"})
+	}
+	if len(credentials.Password) < MinPasswordLength {
+		problems = append(problems, InvalidDataErrorErrorsInner{Field: "password", Message: fmt.Sprintf("must be at least %d characters", MinPasswordLength)})
+	}
+	if len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, InvalidDataError{Errors: problems})
+		return
+	}
+
+	hash, err := HashPassword(credentials.Password)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+		return
+	}
+	account := &Account{Email: email, PasswordHash: hash}
+	if err := api.Accounts.CreateAccount(account); err != nil {
+		if strings.Contains(err.Error(), "already exists") {
+			c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+			return
+		}
+		log.Printf("Error creating account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+		return
+	}
+	api.signIn(c, http.StatusCreated, account)
+}
+
+// Login signs in to an account with its email address and password
+// POST /api/v1/auth/login
+func (api *AuthAPI) Login(c *gin.Context) {
+	if !api.enabled(c, true) {
+		return
+	}
+
+	var credentials AccountCredentials
+	if err := c.ShouldBindJSON(&credentials); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, err := api.Accounts.GetAccountByEmail(strings.ToLower(strings.TrimSpace(credentials.Email)))
+	hash := dummyPasswordHash()
+	if err == nil && account.PasswordHash != "" {
+		hash = account.PasswordHash
+	}
+	match, verifyErr := VerifyPassword(credentials.Password, hash)
+	if verifyErr != nil {
+		log.Printf("Error verifying password: %v", verifyErr)
+	}
+	if err != nil || account.PasswordHash == "" || !match {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		return
+	}
+	api.signIn(c, http.StatusOK, account)
+}
+
+// Logout ends the login the caller's account token was issued for, so it, every other
+// access token issued for the login and the login's refresh tokens stop working
+// POST /api/v1/auth/logout
+func (api *AuthAPI) Logout(c *gin.Context) {
+	if !api.enabled(c, true) {
+		return
+	}
+	claims, err := api.SessionService.GetClaims(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - valid token required to log out"})
+		return
+	}
+	if _, ok := claims["sid"].(string); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only account tokens can be logged out"})
+		return
+	}
+	if err := api.SessionService.EndLogin(claims); err != nil {
+		log.Printf("Error ending login: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RefreshToken exchanges a refresh token for a new access token and the next refresh
+// token. Each refresh token works once; presenting a used one revokes the tokens
+// rotated from it, since it may have been stolen.
+// POST /api/v1/auth/refresh
+func (api *AuthAPI) RefreshToken(c *gin.Context) {
+	if !api.enabled(c, false) {
+		return
+	}
+	if api.SessionService.Tokens == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Refresh tokens are not enabled"})
+		return
+	}
+
+	var request RefreshRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tokens, grant, err := api.SessionService.RefreshTokens(request.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRefreshTokenReused):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - refresh token was already used; sign in again"})
+		case errors.Is(err, ErrRefreshTokenInvalid):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - refresh token is invalid or expired"})
+		default:
+			log.Printf("Error refreshing token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		}
+		return
+	}
+	api.writeAuthToken(c, http.StatusOK, tokens, grant.Subject, grant.AccountID)
+}
+
+// RevokeToken revokes an access token, or a refresh token and every token rotated
+// from it. As in RFC 7009, unknown and invalid tokens are not an error.
+// POST /api/v1/auth/revoke
+func (api *AuthAPI) RevokeToken(c *gin.Context) {
+	if !api.enabled(c, false) {
+		return
+	}
+	if api.SessionService.Tokens == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Token revocation is not enabled"})
+		return
+	}
+
+	var request RevokeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := api.SessionService.RevokeToken(request.Token); err != nil {
+		log.Printf("Error revoking token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// GetJWKS publishes the public keys tokens are signed with, so other services can
+// verify them. HS256 secrets are never listed.
+// GET /api/v1/auth/jwks
+func (api *AuthAPI) GetJWKS(c *gin.Context) {
+	if !api.enabled(c, false) {
+		return
+	}
+	set, err := api.SessionService.keyRing().JWKS()
+	if err != nil {
+		log.Printf("Error loading signing keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load signing keys"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, set)
+}
+
+// ClaimSession moves the datasets, jobs, conversations, visualizations and uploads of
+// an anonymous session to the caller's account. Holding the anonymous session's token
+// proves ownership of its resources.
+// POST /api/v1/auth/claim
+func (api *AuthAPI) ClaimSession(c *gin.Context) {
+	if !api.enabled(c, true) {
+		return
+	}
+	claims, err := api.SessionService.GetClaims(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - valid token required to claim a session"})
+		return
+	}
+	if _, ok := claims["acct"].(string); !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden - sign in to an account to claim a session"})
+		return
+	}
+	accountSubject, _ := claims["sub"].(string)
+
+	var request ClaimRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	anonymous, err := api.SessionService.ValidateToken(request.SessionToken)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "session_token is not a valid session token"})
+		return
+	}
+	anonymousSubject, _ := anonymous["sub"].(string)
+	if _, isAccount := anonymous["acct"]; isAccount || anonymousSubject == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "session_token must be the token of an anonymous session"})
+		return
+	}
+	if anonymousSubject == accountSubject {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The session already belongs to this account"})
+		return
+	}
+
+	counts, err := api.Accounts.ClaimSubject(accountSubject, anonymousSubject)
+	if err != nil {
+		if strings.Contains(err.Error(), "cannot be claimed") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "session_token must be the token of an anonymous session"})
+			return
+		}
+		log.Printf("Error claiming session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to claim session"})
+		return
+	}
+	c.JSON(http.StatusOK, ClaimResult{
+		Datasets:       int32(counts.Datasets),
+		Jobs:           int32(counts.Jobs),
+		Conversations:  int32(counts.Conversations),
+		Visualizations: int32(counts.Visualizations),
+		Uploads:        int32(counts.Uploads),
+	})
+}
+
+// OIDCLogin redirects to the OIDC provider's sign-in page. The state parameter is a
+// short-lived token signed by the session service, carrying the nonce the ID token must
+// repeat, so the callback needs no server-side storage.
+// GET /api/v1/auth/oidc/login
+func (api *AuthAPI) OIDCLogin(c *gin.Context) {
+	if !api.enabled(c, true) {
+		return
+	}
+	if api.OIDC == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC sign-in is not configured"})
+		return
+	}
+
+	state, err := randomHex(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+		return
+	}
+	nonce, err := randomHex(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+		return
+	}
+	verifier, err := NewPKCEVerifier()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+		return
+	}
+
+	// The state, nonce and verifier stay in this browser, so a callback carrying a
+	// code and state from a sign-in started elsewhere is rejected
+	cookie, err := api.SessionService.GenerateToken(map[string]interface{}{
+		"type":     "oidc_state",
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"exp":      time.Now().Add(oidcStateTTL).Unix(),
+	})
+	if err != nil {
+		log.Printf("Error generating OIDC state: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+		return
+	}
+
+	authURL, err := api.OIDC.AuthCodeURL(state, nonce, PKCEChallenge(verifier))
+	if err != nil {
+		log.Printf("Error contacting OIDC provider: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "OIDC provider unavailable"})
+		return
+	}
+	api.setOIDCCookie(c, cookie, int(oidcStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// setOIDCCookie sets the sign-in state cookie, or clears it when maxAge is negative.
+// It is sent only to the OIDC endpoints and, with SameSite=Lax, only on the top-level
+// redirect back from the provider.
+func (api *AuthAPI) setOIDCCookie(c *gin.Context, value string, maxAge int) {
+	secure := strings.HasPrefix(api.OIDC.Config.RedirectURL, "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcCookieName, value, maxAge, "/api/v1/auth/oidc", "", secure, true)
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// OIDCCallback completes OIDC sign-in, creating an account the first time an identity
+// signs in, and returns a token for it
+// GET /api/v1/auth/oidc/callback
+func (api *AuthAPI) OIDCCallback(c *gin.Context) {
+	if !api.enabled(c, true) {
+		return
+	}
+	if api.OIDC == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC sign-in is not configured"})
+		return
+	}
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in failed: " + providerErr})
+		return
+	}
+
+	cookie, err := c.Cookie(oidcCookieName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired sign-in state"})
+		return
+	}
+	// A sign-in state is good for one callback
+	api.setOIDCCookie(c, "", -1)
+
+	state, err := api.SessionService.ValidateToken(cookie)
+	if err != nil || state["type"] != "oidc_state" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired sign-in state"})
+		return
+	}
+	expected, _ := state["state"].(string)
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(c.Query("state"))) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired sign-in state"})
+		return
+	}
+	nonce, _ := state["nonce"].(string)
+	verifier, _ := state["verifier"].(string)
+
+	idToken, err := api.OIDC.Exchange(c.Query("code"), verifier)
+	if err != nil {
+		log.Printf("Error exchanging OIDC code: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in failed"})
+		return
+	}
+	identity, err := api.OIDC.VerifyIDToken(idToken, nonce)
+	if err != nil {
+		log.Printf("Error verifying OIDC ID token: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in failed"})
+		return
+	}
+
+	status := http.StatusOK
+	account, err := api.Accounts.GetAccountByOIDC(identity.Issuer, identity.Subject)
+	if err != nil {
+		if !strings.Contains(err.Error(), "not found") {
+			log.Printf("Error looking up OIDC account: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+			return
+		}
+		account = &Account{OIDCIssuer: identity.Issuer, OIDCSubject: identity.Subject}
+		if err := api.Accounts.CreateAccount(account); err != nil {
+			log.Printf("Error creating OIDC account: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+			return
+		}
+		status = http.StatusCreated
+	}
+	api.signIn(c, status, account)
+}
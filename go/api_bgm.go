@@ -12,12 +12,14 @@ package datamonkey
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type BGMAPI struct {
@@ -103,7 +105,9 @@ func (api *BGMAPI) GetBGMJob(c *gin.Context) {
 
 	result, err := api.HandleGetJob(c, adapted, MethodBGM)
 	if err != nil {
-		if err.Error() == "job is not complete" {
+		if errors.Is(err, ErrJobAccessDenied) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden - you do not have access to this job"})
+		} else if err.Error() == "job is not complete" {
 			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -204,11 +208,16 @@ func (api *BGMAPI) StartBGMJob(c *gin.Context) {
 	}
 
 	var request BgmRequest
-	if err := c.BindJSON(&request); err != nil {
+	if err := c.ShouldBindBodyWith(&request, binding.JSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse job configuration"})
 		return
 	}
 
+	// Check the parameters against the spec before looking up any dataset
+	if writeValidationError(c, ValidateParametersJSON(&request, requestBody(c))) {
+		return
+	}
+
 	// Set the subject in the request for job tracking
 	if subject != "" {
 		request.UserToken = subject
@@ -248,6 +257,9 @@ func (api *BGMAPI) StartBGMJob(c *gin.Context) {
 
 	result, err := api.HandleStartJob(c, adapted, MethodBGM)
 	if err != nil {
+		if writeValidationError(c, err) {
+			return
+		}
 		if err.Error() == "authentication token required" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		} else if strings.Contains(err.Error(), "parameter is required") || strings.Contains(err.Error(), "invalid") {
@@ -37,8 +37,7 @@ func (api *ChatAPI) CreateConversation(c *gin.Context) {
 	if api.sessionService != nil {
 		subject, err = api.sessionService.GetOrCreateSubject(c)
 		if err != nil {
-			log.Printf("Error with session: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create or validate session"})
+			writeSessionError(c, err)
 			return
 		}
 	} else {
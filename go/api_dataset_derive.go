@@ -0,0 +1,259 @@
+package datamonkey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeriveDataset creates a new dataset by applying operations to an existing one: keeping
+// or dropping sequences, slicing sites, stripping gap columns, deduplicating and
+// translating. A tree given with tree_id is pruned to the derived alignment's sequences.
+// POST /api/v1/datasets/:datasetId/derive
+func (api *FileUploadAndQCAPI) DeriveDataset(c *gin.Context) {
+	// Require valid token for deriving datasets
+	var subject string
+	if api.sessionService != nil {
+		var err error
+		subject, err = api.sessionService.GetSubject(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - valid token required to derive datasets"})
+			return
+		}
+	}
+
+	var request DeriveDatasetRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		return
+	}
+
+	parent, content, ok := api.readOwnedDataset(c, c.Param("datasetId"), subject)
+	if !ok {
+		return
+	}
+	lineage := &DatasetLineage{ParentId: parent.GetId(), Operations: request.Operations}
+	metadata := DatasetMetadata{
+		Name:        request.Name,
+		Description: request.Description,
+		Created:     time.Now(),
+	}
+	if metadata.Name == "" {
+		metadata.Name = parent.GetMetadata().Name + " (derived)"
+	}
+
+	// Trees can only have leaves kept or dropped, which prunes them
+	if DetectAlignmentFormat(content) == "" && DetectTreeFormat(content) != "" {
+		if request.TreeId != "" {
+			writeInvalidData(c, "tree_id", "tree_id only applies when deriving from an alignment")
+			return
+		}
+		tree, err := ParseTree(content)
+		if err == nil {
+			tree, err = DeriveTree(tree, request.Operations)
+		}
+		if err != nil {
+			writeDerivationError(c, err)
+			return
+		}
+		metadata.Type = TreeFormatNewick
+		stats := tree.Stats()
+		derived, existing, err := api.storeDerivedDataset(subject, metadata, []byte(tree.Newick()), lineage, nil, &stats)
+		if err != nil {
+			log.Printf("Failed to store dataset derived from %s: %v", parent.GetId(), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store derived dataset"})
+			return
+		}
+		writeDerivedDataset(c, derived, existing, "")
+		return
+	}
+
+	alignment, err := ParseAlignment(content)
+	if err == nil {
+		alignment, err = DeriveAlignment(alignment, request.Operations)
+	}
+	if err != nil {
+		writeDerivationError(c, err)
+		return
+	}
+
+	// Prune the tree to the sequences that are left
+	treeID := ""
+	if request.TreeId != "" {
+		treeDataset, treeContent, ok := api.readOwnedDataset(c, request.TreeId, subject)
+		if !ok {
+			return
+		}
+		tree, err := ParseTree(treeContent)
+		if err != nil {
+			writeInvalidData(c, "tree_id", err.Error())
+			return
+		}
+		inTree := make(map[string]bool)
+		for _, leaf := range tree.Leaves() {
+			inTree[leaf] = true
+		}
+		var names, missing []string
+		for _, sequence := range alignment.Sequences {
+			names = append(names, sequence.Name)
+			if !inTree[sequence.Name] {
+				missing = append(missing, sequence.Name)
+			}
+		}
+		if len(missing) > 0 {
+			writeInvalidData(c, "tree_id", fmt.Sprintf("%d derived sequences are not leaves of the tree: %s", len(missing), quotedList(missing)))
+			return
+		}
+
+		treeID = treeDataset.GetId()
+		if len(names) < len(tree.Leaves()) {
+			pruned, err := PruneTree(tree, names)
+			if err != nil {
+				writeInvalidData(c, "tree_id", err.Error())
+				return
+			}
+			treeMetadata := DatasetMetadata{Name: treeDataset.GetMetadata().Name + " (pruned)", Type: TreeFormatNewick, Created: time.Now()}
+			treeLineage := &DatasetLineage{ParentId: treeID, Operations: []DatasetOperation{{Op: OperationKeep, Names: names}}}
+			stats := pruned.Stats()
+			prunedDataset, _, err := api.storeDerivedDataset(subject, treeMetadata, []byte(pruned.Newick()), treeLineage, nil, &stats)
+			if err != nil {
+				log.Printf("Failed to store tree pruned from %s: %v", treeID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store pruned tree"})
+				return
+			}
+			treeID = prunedDataset.GetId()
+		}
+	}
+
+	metadata.Type = AlignmentFormatFASTA
+	stats := alignment.Stats()
+	derived, existing, err := api.storeDerivedDataset(subject, metadata, alignment.FASTA(), lineage, &stats, nil)
+	if err != nil {
+		log.Printf("Failed to store dataset derived from %s: %v", parent.GetId(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store derived dataset"})
+		return
+	}
+	writeDerivedDataset(c, derived, existing, treeID)
+}
+
+// getOwnedDataset loads a dataset the caller owns, writing the error response and
+// returning false if that fails
+func (api *FileUploadAndQCAPI) getOwnedDataset(c *gin.Context, id string, subject string) (DatasetInterface, bool) {
+	dataset, err := api.datasetTracker.GetByUser(id, subject)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Dataset %s not found", id)})
+		} else {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden - you don't have access to this dataset"})
+		}
+		return nil, false
+	}
+	return dataset, true
+}
+
+// readOwnedDataset loads a dataset the caller owns and its content, writing the error
+// response and returning false if that fails
+func (api *FileUploadAndQCAPI) readOwnedDataset(c *gin.Context, id string, subject string) (DatasetInterface, []byte, bool) {
+	dataset, ok := api.getOwnedDataset(c, id, subject)
+	if !ok {
+		return nil, nil, false
+	}
+
+	content, err := ReadBlob(api.datasetTracker.GetBlobStore(), dataset.GetContentHash())
+	if err != nil {
+		log.Printf("Failed to read dataset %s: %v", dataset.GetId(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read dataset"})
+		return nil, nil, false
+	}
+	return dataset, content, true
+}
+
+// storeDerivedDataset stores derived content as a new dataset of the caller. If the
+// caller already has a dataset with the same content, that dataset is returned instead.
+func (api *FileUploadAndQCAPI) storeDerivedDataset(subject string, metadata DatasetMetadata, content []byte, lineage *DatasetLineage, alignment *AlignmentStats, tree *TreeStats) (DatasetInterface, bool, error) {
+	sum := sha256.Sum256(content)
+	contentHash := hex.EncodeToString(sum[:])
+	if existing := api.findUploadedDataset(subject, contentHash); existing != nil {
+		return existing, true, nil
+	}
+
+	// Write the content next to the blob store so it can be moved into place
+	dir := api.datasetTracker.GetDatasetDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, false, err
+	}
+	file, err := os.CreateTemp(dir, ".derived-*")
+	if err != nil {
+		return nil, false, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	dataset := newBaseDatasetWithHash(metadata, content, contentHash)
+	dataset.Alignment = alignment
+	dataset.Tree = tree
+	dataset.Lineage = lineage
+	if err := api.recordDataset(subject, dataset, file.Name()); err != nil {
+		return nil, false, err
+	}
+	return dataset, false, nil
+}
+
+// writeDerivedDataset answers a derivation with the new dataset, or with the caller's
+// existing dataset holding the same content
+func writeDerivedDataset(c *gin.Context, dataset DatasetInterface, existing bool, treeID string) {
+	status, code := "Dataset derived", http.StatusCreated
+	if existing {
+		status, code = "Dataset already exists", http.StatusOK
+	}
+	response := gin.H{"status": status, "id": dataset.GetId()}
+	if existing {
+		response["existing"] = true
+	}
+	if lineage := dataset.GetLineage(); lineage != nil {
+		response["lineage"] = lineage
+	}
+	if stats := dataset.GetAlignmentStats(); stats != nil {
+		response["alignment"] = stats
+	}
+	if stats := dataset.GetTreeStats(); stats != nil {
+		response["tree"] = stats
+	}
+	if treeID != "" {
+		response["tree_id"] = treeID
+	}
+	c.JSON(code, response)
+}
+
+// writeDerivationError answers a derivation that could not be applied, including a
+// parent dataset that is not a valid alignment or tree
+func writeDerivationError(c *gin.Context, err error) {
+	var derivationErr *DerivationError
+	if errors.As(err, &derivationErr) {
+		c.JSON(http.StatusUnprocessableEntity, derivationErr.InvalidData())
+		return
+	}
+	writeInvalidData(c, "datasetId", err.Error())
+}
+
+// writeInvalidData answers with a single 422 problem
+func writeInvalidData(c *gin.Context, field string, message string) {
+	c.JSON(http.StatusUnprocessableEntity, InvalidDataError{Errors: []InvalidDataErrorErrorsInner{{
+		Field:   field,
+		Message: message,
+	}}})
+}
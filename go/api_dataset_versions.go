@@ -0,0 +1,124 @@
+package datamonkey
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpdateDataset changes the name, description or tags of a dataset. Its content cannot
+// change; uploading corrected content with version_of creates a new version instead.
+// PATCH /api/v1/datasets/:datasetId
+func (api *FileUploadAndQCAPI) UpdateDataset(c *gin.Context) {
+	// Require valid token for updating datasets
+	var subject string
+	if api.sessionService != nil {
+		var err error
+		subject, err = api.sessionService.GetSubject(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - valid token required to update datasets"})
+			return
+		}
+	}
+
+	var request UpdateDatasetRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
+		return
+	}
+
+	dataset, ok := api.getOwnedDataset(c, c.Param("datasetId"), subject)
+	if !ok {
+		return
+	}
+
+	metadata := dataset.GetMetadata()
+	if request.Name != nil {
+		if strings.TrimSpace(*request.Name) == "" {
+			writeInvalidData(c, "name", "name cannot be empty")
+			return
+		}
+		metadata.Name = *request.Name
+	}
+	if request.Description != nil {
+		metadata.Description = *request.Description
+	}
+	if request.Tags != nil {
+		tags, err := normalizeTags(*request.Tags)
+		if err != nil {
+			writeInvalidData(c, "tags", err.Error())
+			return
+		}
+		metadata.Tags = tags
+	}
+	metadata.Updated = time.Now()
+
+	if err := api.datasetTracker.UpdateByUser(dataset.GetId(), subject, map[string]interface{}{"metadata": metadata}); err != nil {
+		log.Printf("Failed to update dataset %s: %v", dataset.GetId(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update dataset"})
+		return
+	}
+
+	updated, ok := api.getOwnedDataset(c, dataset.GetId(), subject)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, datasetSummary(updated))
+}
+
+// GetDatasetVersions lists every version of the logical dataset a dataset belongs to,
+// newest first
+// GET /api/v1/datasets/:datasetId/versions
+func (api *FileUploadAndQCAPI) GetDatasetVersions(c *gin.Context) {
+	// Require valid token for accessing datasets
+	var subject string
+	if api.sessionService != nil {
+		var err error
+		subject, err = api.sessionService.GetSubject(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - valid token required to access datasets"})
+			return
+		}
+	}
+
+	dataset, ok := api.getOwnedDataset(c, c.Param("datasetId"), subject)
+	if !ok {
+		return
+	}
+
+	versions, total, err := api.datasetTracker.ListByUserWithFilters(subject, map[string]interface{}{
+		"series_id": dataset.GetSeriesId(),
+	})
+	if err != nil {
+		log.Printf("Failed to list versions of dataset %s: %v", dataset.GetId(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list dataset versions"})
+		return
+	}
+
+	response := make([]gin.H, len(versions))
+	for i, version := range versions {
+		response[i] = datasetSummary(version)
+	}
+	c.JSON(http.StatusOK, gin.H{"datasets": response, "total": total})
+}
+
+// normalizeTags trims tags and drops repeated ones, rejecting empty tags
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return nil, fmt.Errorf("tags cannot be empty")
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
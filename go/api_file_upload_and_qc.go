@@ -11,12 +11,18 @@
 package datamonkey
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,12 +32,15 @@ import (
 type FileUploadAndQCAPI struct {
 	datasetTracker DatasetTracker
 	sessionService *SessionService
+	URLFetcher     *URLFetcher    // Downloads datasets submitted by URL
+	Uploads        *UploadService // Receives resumable uploads; nil disables them
 }
 
 func NewFileUploadAndQCAPI(datasetTracker DatasetTracker, sessionService *SessionService) *FileUploadAndQCAPI {
 	return &FileUploadAndQCAPI{
 		datasetTracker: datasetTracker,
 		sessionService: sessionService,
+		URLFetcher:     NewURLFetcher(DefaultURLFetchConfig()),
 	}
 }
 
@@ -49,34 +58,64 @@ func (api *FileUploadAndQCAPI) GetDatasetsList(c *gin.Context) {
 		}
 	}
 
+	// Only the latest version of each dataset is listed unless all_versions=true
+	filters := map[string]interface{}{
+		"latest": c.Query("all_versions") != "true",
+	}
+	if datasetType := c.Query("type"); datasetType != "" {
+		filters["type"] = datasetType
+	}
+	if tags := c.QueryArray("tag"); len(tags) > 0 {
+		filters["tags"] = tags
+	}
+	for _, param := range []string{"limit", "offset"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeInvalidData(c, param, fmt.Sprintf("%s must be a non-negative integer", param))
+			return
+		}
+		filters[param] = n
+	}
+
 	// List datasets for the authenticated user
-	datasets, err := api.datasetTracker.ListByUser(userToken)
+	datasets, total, err := api.datasetTracker.ListByUserWithFilters(userToken, filters)
 	if err != nil {
 		log.Printf("Failed to list datasets for user: %v", err)
 		c.JSON(500, gin.H{"error": "Failed to list datasets"})
 		return
 	}
 
-	if len(datasets) == 0 {
-		log.Printf("No datasets found")
-		c.JSON(200, gin.H{"datasets": []interface{}{}})
-		return
-	}
-
 	// Convert datasets to response format
 	response := make([]gin.H, len(datasets))
 	for i, ds := range datasets {
-		response[i] = gin.H{
-			"id":          ds.GetId(),
-			"name":        ds.GetMetadata().Name,
-			"type":        ds.GetMetadata().Type,
-			"description": ds.GetMetadata().Description,
-			"created":     ds.GetMetadata().Created,
-			"updated":     ds.GetMetadata().Updated,
-		}
+		response[i] = datasetSummary(ds)
 	}
 
-	c.JSON(200, gin.H{"datasets": response})
+	c.JSON(200, gin.H{"datasets": response, "total": total})
+}
+
+// datasetSummary returns the metadata of a dataset as listed by the API
+func datasetSummary(dataset DatasetInterface) gin.H {
+	metadata := dataset.GetMetadata()
+	tags := metadata.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return gin.H{
+		"id":          dataset.GetId(),
+		"name":        metadata.Name,
+		"type":        metadata.Type,
+		"description": metadata.Description,
+		"tags":        tags,
+		"series_id":   dataset.GetSeriesId(),
+		"version":     dataset.GetVersion(),
+		"created":     metadata.Created,
+		"updated":     metadata.Updated,
+	}
 }
 
 // PostDataset handles the uploading of datasets to Datamonkey.
@@ -91,8 +130,7 @@ func (api *FileUploadAndQCAPI) PostDataset(c *gin.Context) {
 		var err error
 		subject, err = api.sessionService.GetOrCreateSubject(c)
 		if err != nil {
-			log.Printf("Error with session: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create or validate session"})
+			writeSessionError(c, err)
 			return
 		}
 	}
@@ -114,6 +152,8 @@ func (api *FileUploadAndQCAPI) PostDataset(c *gin.Context) {
 			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to create temporary file: %v", err)})
 			return
 		}
+		defer os.Remove(tempFile.Name())
+		defer tempFile.Close()
 		file.File = tempFile
 
 		// Open the uploaded file
@@ -143,12 +183,16 @@ func (api *FileUploadAndQCAPI) PostDataset(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	// Check the tags; a new version of a dataset takes the metadata it doesn't set from the previous version
+	if _, ok := api.completeMeta(c, subject, &meta); !ok {
+		return
+	}
 	file.Meta = meta
 
 	log.Printf("Processing file with name %s", file.Meta.Name)
 
 	// Validate presence of required metadata fields
-	if (file.Meta == DatasetMeta{}) || file.Meta.Name == "" {
+	if file.Meta.Name == "" {
 		c.JSON(400, gin.H{"error": "File name is required"})
 		return
 	}
@@ -178,74 +222,209 @@ func (api *FileUploadAndQCAPI) PostDataset(c *gin.Context) {
 		}
 	}
 
-	if file.Url != "" {
-		if _, err := os.Stat(file.Url); err != nil {
-			c.JSON(400, gin.H{"error": "URL is not valid"})
-			return
-		}
-	}
-
-	// Read the file content first
-	var content []byte
+	// Hash the received file; it is checked from disk and moved into place once stored
+	var sourcePath, contentHash string
 	if file.File != nil {
-		log.Printf("Reading file %s", file.Meta.Name)
+		log.Printf("Hashing file %s", file.Meta.Name)
 		_, _ = file.File.Seek(0, 0)
-		content, err = io.ReadAll(file.File)
-		if err != nil {
+		digest := sha256.New()
+		if _, err := io.Copy(digest, file.File); err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
+		sourcePath, contentHash = file.File.Name(), hex.EncodeToString(digest.Sum(nil))
 	} else {
 		log.Printf("Downloading file %s from url %s", file.Meta.Name, file.Url)
-		resp, err := http.Get(file.Url)
+		fetched, err := api.URLFetcher.Fetch(c.Request.Context(), file.Url, "")
 		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			log.Printf("Error downloading %s: %v", file.Url, err)
+			c.JSON(urlFetchStatus(err), gin.H{"error": fmt.Sprintf("Failed to download dataset: %v", err)})
 			return
 		}
-		defer resp.Body.Close()
-		content, err = io.ReadAll(resp.Body)
-		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+		defer os.Remove(fetched.Path)
+		log.Printf("Downloaded %d bytes (sha256 %s, compression %q) from %s", fetched.Size, fetched.SHA256, fetched.Compression, file.Url)
+
+		if fetched.Size == 0 {
+			c.JSON(400, gin.H{"error": "File size is 0"})
 			return
 		}
+		sourcePath, contentHash = fetched.Path, fetched.SHA256
 	}
 
-	// Create the dataset with the content
-	dataset := NewBaseDataset(DatasetMetadata{
-		Name:        file.Meta.Name,
-		Description: file.Meta.Description,
-		Type:        file.Meta.Type,
-		Created:     time.Now(),
-		Updated:     time.Now(),
-	}, content)
+	api.storeDataset(c, subject, file.Meta, sourcePath, contentHash)
+}
 
+// findUploadedDataset returns the caller's dataset with the given content, or nil if they have none
+func (api *FileUploadAndQCAPI) findUploadedDataset(subject string, contentHash string) DatasetInterface {
+	id := contentHash
+	if subject != "" {
+		id = UserDatasetID(contentHash, subject)
+	}
+	dataset, err := api.datasetTracker.GetByUser(id, subject)
+	if err != nil {
+		return nil
+	}
+	return dataset
+}
+
+// writeUploadedDataset answers an upload of content the caller already has as a dataset
+func writeUploadedDataset(c *gin.Context, dataset DatasetInterface) {
+	response := gin.H{"status": "Dataset already uploaded", "id": dataset.GetId(), "existing": true}
+	if stats := dataset.GetAlignmentStats(); stats != nil {
+		response["alignment"] = stats
+	}
+	if stats := dataset.GetTreeStats(); stats != nil {
+		response["tree"] = stats
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// recordDataset stores a dataset for its owner and moves the file holding its content
+// (sourcePath) into the blob store
+func (api *FileUploadAndQCAPI) recordDataset(subject string, dataset *BaseDataset, sourcePath string) error {
 	// Store the dataset in the tracker with owner FIRST
 	// This updates the dataset ID to be user-specific
 	if subject != "" {
 		if err := api.datasetTracker.StoreWithUser(dataset, subject); err != nil {
-			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to store dataset: %v", err)})
-			return
+			return err
 		}
 	} else {
 		// Fallback to Store without owner if no session service
 		if err := api.datasetTracker.Store(dataset); err != nil {
-			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to store dataset: %v", err)})
-			return
+			return err
+		}
+	}
+
+	// Move the received file into the blob store, keyed by content hash; content that
+	// another user already uploaded is stored only once
+	// IMPORTANT: This must happen AFTER the dataset is stored, whose reference keeps the blob
+	if _, err := api.datasetTracker.StoreContent(dataset.GetContentHash(), sourcePath); err != nil {
+		if deleteErr := api.datasetTracker.Delete(dataset.GetId()); deleteErr != nil {
+			log.Printf("Failed to remove dataset %s without content: %v", dataset.GetId(), deleteErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// completeMeta checks the tags of an uploaded dataset and fills in the metadata a new
+// version of the dataset meta.VersionOf leaves unset from that dataset, which the caller
+// must own. It returns the previous version, or nil when meta is not a new version, and
+// writes the error response and returns false if meta is invalid.
+func (api *FileUploadAndQCAPI) completeMeta(c *gin.Context, subject string, meta *DatasetMeta) (DatasetInterface, bool) {
+	var previous DatasetInterface
+	if meta.VersionOf != "" {
+		var ok bool
+		previous, ok = api.getOwnedDataset(c, meta.VersionOf, subject)
+		if !ok {
+			return nil, false
 		}
+		inheritMeta(meta, previous.GetMetadata())
 	}
 
-	// Now use the dataset ID (user-specific hash) as the filename
-	// IMPORTANT: This must happen AFTER StoreWithUser which updates the ID
-	filename := fmt.Sprintf("%s/%s", api.datasetTracker.GetDatasetDir(), dataset.GetId())
+	tags, err := normalizeTags(meta.Tags)
+	if err != nil {
+		writeInvalidData(c, "tags", err.Error())
+		return nil, false
+	}
+	meta.Tags = tags
+	return previous, true
+}
+
+// inheritMeta fills in the metadata a new version leaves unset from the previous version
+func inheritMeta(meta *DatasetMeta, metadata DatasetMetadata) {
+	if meta.Name == "" {
+		meta.Name = metadata.Name
+	}
+	if meta.Description == "" {
+		meta.Description = metadata.Description
+	}
+	if meta.Type == "" {
+		meta.Type = metadata.Type
+	}
+	if meta.Tags == nil {
+		meta.Tags = metadata.Tags
+	}
+}
+
+// storeDataset checks an uploaded dataset, records it for the user and moves the file it
+// was received into (sourcePath) into the dataset blob store, then writes the 201 response.
+// Content the caller already uploaded gets a 200 response with the existing dataset instead.
+// contentHash is the SHA-256 of the file, computed as it was received; the file is checked
+// as a stream rather than read into memory.
+func (api *FileUploadAndQCAPI) storeDataset(c *gin.Context, subject string, meta DatasetMeta, sourcePath string, contentHash string) {
+	if existing := api.findUploadedDataset(subject, contentHash); existing != nil {
+		log.Printf("Dataset %s was already uploaded", existing.GetId())
+		writeUploadedDataset(c, existing)
+		return
+	}
+
+	// The previous version may have been deleted since an upload was started
+	previous, ok := api.completeMeta(c, subject, &meta)
+	if !ok {
+		return
+	}
+
+	// Parse alignments and reject malformed ones before storing anything
+	alignmentStats, err := CheckAlignmentFile(meta.Type, sourcePath)
+	if err != nil {
+		detail := InvalidDataErrorErrorsInner{Field: "file", Message: err.Error()}
+		var alignmentErr *AlignmentError
+		if errors.As(err, &alignmentErr) {
+			detail.Message = alignmentErr.Message
+			detail.Line = int32(alignmentErr.Line)
+		}
+		c.JSON(http.StatusUnprocessableEntity, InvalidDataError{Errors: []InvalidDataErrorErrorsInner{detail}})
+		return
+	}
 
-	// Write the file to disk with the correct user-specific ID
-	err = os.WriteFile(filename, content, 0644)
+	// Parse trees so their taxa and branch labels can be checked when jobs start
+	treeStats, err := CheckTreeFile(meta.Type, sourcePath)
 	if err != nil {
+		detail := InvalidDataErrorErrorsInner{Field: "file", Message: err.Error()}
+		var treeErr *TreeError
+		if errors.As(err, &treeErr) {
+			detail.Message = treeErr.Message
+			detail.Line = int32(treeErr.Line)
+		}
+		c.JSON(http.StatusUnprocessableEntity, InvalidDataError{Errors: []InvalidDataErrorErrorsInner{detail}})
+		return
+	}
+
+	// Create the dataset; its content stays in the file until moved into the blob store
+	metadata := DatasetMetadata{
+		Name:        meta.Name,
+		Description: meta.Description,
+		Type:        meta.Type,
+		Tags:        meta.Tags,
+		Created:     time.Now(),
+		Updated:     time.Now(),
+	}
+	dataset := newBaseDatasetWithHash(metadata, nil, contentHash)
+	dataset.Alignment = alignmentStats
+	dataset.Tree = treeStats
+	if previous != nil {
+		dataset.SeriesId = previous.GetSeriesId()
+	}
+
+	if err := api.recordDataset(subject, dataset, sourcePath); err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(201, gin.H{"status": "File uploaded successfully", "id": dataset.GetId()})
+	response := gin.H{
+		"status":    "File uploaded successfully",
+		"id":        dataset.GetId(),
+		"series_id": dataset.GetSeriesId(),
+		"version":   dataset.GetVersion(),
+	}
+	if alignmentStats != nil {
+		response["alignment"] = alignmentStats
+	}
+	if treeStats != nil {
+		response["tree"] = treeStats
+	}
+	c.JSON(201, response)
 }
 
 // GetDatasetById retrieves a specific dataset by ID
@@ -281,20 +460,20 @@ func (api *FileUploadAndQCAPI) GetDatasetById(c *gin.Context) {
 	}
 
 	// Return dataset metadata and optionally content
-	metadata := dataset.GetMetadata()
-	response := gin.H{
-		"id":          dataset.GetId(),
-		"name":        metadata.Name,
-		"type":        metadata.Type,
-		"description": metadata.Description,
-		"created":     metadata.Created,
-		"updated":     metadata.Updated,
+	response := datasetSummary(dataset)
+	if stats := dataset.GetAlignmentStats(); stats != nil {
+		response["alignment"] = stats
+	}
+	if stats := dataset.GetTreeStats(); stats != nil {
+		response["tree"] = stats
+	}
+	if lineage := dataset.GetLineage(); lineage != nil {
+		response["lineage"] = lineage
 	}
 
 	// Include content if requested via query parameter
 	if c.Query("include_content") == "true" {
-		datasetPath := fmt.Sprintf("%s/%s", api.datasetTracker.GetDatasetDir(), dataset.GetId())
-		content, err := os.ReadFile(datasetPath)
+		content, err := ReadBlob(api.datasetTracker.GetBlobStore(), dataset.GetContentHash())
 		if err == nil {
 			response["content"] = string(content)
 		}
@@ -303,6 +482,105 @@ func (api *FileUploadAndQCAPI) GetDatasetById(c *gin.Context) {
 	c.JSON(200, response)
 }
 
+// moveFile renames src to dst, copying instead when they are on different filesystems
+func moveFile(src string, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// urlFetchStatus maps a URL download error to the status code returned to the client
+func urlFetchStatus(err error) int {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrURLNotAllowed):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrURLTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrBadArchive):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// GetDatasetBranches lists the branches and label groups of a tree dataset that
+// branch selections (branches, test_branches, branch_sets) can refer to
+// GET /api/v1/datasets/:datasetId/branches
+func (api *FileUploadAndQCAPI) GetDatasetBranches(c *gin.Context) {
+	// Require valid token for accessing datasets
+	var userToken string
+	if api.sessionService != nil {
+		var err error
+		userToken, err = api.sessionService.GetSubject(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - valid token required to access datasets"})
+			return
+		}
+	}
+
+	datasetId := c.Param("datasetId")
+
+	// Get dataset and verify ownership
+	dataset, err := api.datasetTracker.GetByUser(datasetId, userToken)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dataset not found"})
+		} else {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden - you don't have access to this dataset"})
+		}
+		return
+	}
+
+	content, err := ReadBlob(api.datasetTracker.GetBlobStore(), dataset.GetContentHash())
+	if err != nil {
+		log.Printf("Failed to read dataset %s: %v", dataset.GetId(), err)
+		c.JSON(500, gin.H{"error": "Failed to read dataset"})
+		return
+	}
+
+	if DetectTreeFormat(content) == "" {
+		c.JSON(http.StatusUnprocessableEntity, InvalidDataError{Errors: []InvalidDataErrorErrorsInner{{
+			Field:   "datasetId",
+			Message: "dataset does not contain a tree",
+		}}})
+		return
+	}
+	tree, err := ParseTree(content)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, InvalidDataError{Errors: []InvalidDataErrorErrorsInner{{
+			Field:   "datasetId",
+			Message: err.Error(),
+		}}})
+		return
+	}
+
+	branches := tree.ListBranches()
+	branches.DatasetId = dataset.GetId()
+	c.JSON(http.StatusOK, branches)
+}
+
 // DeleteDataset deletes a dataset by ID
 // DELETE /api/v1/datasets/:datasetId
 func (api *FileUploadAndQCAPI) DeleteDataset(c *gin.Context) {
@@ -335,15 +613,7 @@ func (api *FileUploadAndQCAPI) DeleteDataset(c *gin.Context) {
 		return
 	}
 
-	// Delete dataset file from disk
-	datasetPath := fmt.Sprintf("%s/%s", api.datasetTracker.GetDatasetDir(), datasetId)
-	if err := os.Remove(datasetPath); err != nil && !os.IsNotExist(err) {
-		log.Printf("Failed to delete dataset file %s: %v", datasetPath, err)
-		c.JSON(500, gin.H{"error": "Failed to delete dataset file"})
-		return
-	}
-
-	// Remove from tracker
+	// Remove from tracker; its content is deleted once no other dataset or job uses it
 	if err := api.datasetTracker.DeleteByUser(datasetId, userToken); err != nil {
 		log.Printf("Failed to delete dataset %s from tracker: %v", datasetId, err)
 		c.JSON(500, gin.H{"error": "Failed to delete dataset from tracker"})
@@ -8,10 +8,10 @@ package datamonkey
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
+	"net/http"
 	"strings"
 	"unicode"
 
@@ -26,8 +26,19 @@ type HyPhyBaseAPI struct {
 	DatasetTracker DatasetTracker
 	JobTracker     JobTracker
 	SessionService *SessionService
+	// ResourceProfiles sizes scheduler requests per method; nil leaves scheduler defaults
+	ResourceProfiles *ResourceProfileConfig
+	// Events receives a submitted event for every new job; may be nil
+	Events *JobEventBus
+	// HyPhyVersion is part of every job's identity, so upgrading HyPhy gives new job IDs
+	HyPhyVersion string
+	// Results holds job output files once they are published; nil reads them from BasePath
+	Results BlobStore
 }
 
+// ErrJobAccessDenied is returned when the caller does not own the job a request names
+var ErrJobAccessDenied = errors.New("user does not have access to this job")
+
 // TODO: BasePath is where output and log files are stored, may need to split into multiple directories
 // NewHyPhyBaseAPI creates a new HyPhyBaseAPI instance
 func NewHyPhyBaseAPI(basePath, hyPhyPath string, scheduler SchedulerInterface, datasetTracker DatasetTracker, jobTracker JobTracker, sessionService ...*SessionService) HyPhyBaseAPI {
@@ -49,8 +60,7 @@ func NewHyPhyBaseAPI(basePath, hyPhyPath string, scheduler SchedulerInterface, d
 // getJobResults is a utility function to get job results by job ID
 func (api *HyPhyBaseAPI) getJobResults(jobId string, method *HyPhyMethod, status JobStatusValue) (interface{}, error) {
 	// Read results
-	outputPath := method.GetOutputPath(jobId)
-	results, err := os.ReadFile(outputPath)
+	results, err := readJobOutput(api.Results, method.GetOutputPath(jobId))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read results: %v", err)
 	}
@@ -75,24 +85,58 @@ func (api *HyPhyBaseAPI) getJobResults(jobId string, method *HyPhyMethod, status
 	}, nil
 }
 
+// applyTrackedStatus prefers a failed status recorded by the job monitor (e.g. results that
+// failed validation) over a scheduler status of complete
+func (api *HyPhyBaseAPI) applyTrackedStatus(jobId string, status JobStatusValue) JobStatusValue {
+	if status != JobStatusComplete || api.JobTracker == nil {
+		return status
+	}
+	_, _, _, trackedStatus, err := api.JobTracker.GetJobMetadata(jobId)
+	if err == nil && JobStatusValue(trackedStatus) == JobStatusFailed {
+		return JobStatusFailed
+	}
+	return status
+}
+
 // HandleGetJob handles retrieving job status and results for any HyPhy method
 func (api *HyPhyBaseAPI) HandleGetJob(c *gin.Context, request HyPhyRequest, methodType HyPhyMethodType) (interface{}, error) {
 	// Create HyPhyMethod instance with explicit method type
 	method := NewHyPhyMethod(request, api.BasePath, api.HyPhyPath, methodType, api.DatasetTracker.GetDatasetDir())
 
 	// Create job instance
-	job := NewHyPhyJob(request, method, api.Scheduler)
+	job, err := api.newJob(request, method, methodType)
+	if err != nil {
+		return nil, err
+	}
 
 	// Get job status
 	status, err := job.GetStatus()
+	if err != nil && isJobNotFound(err) {
+		// Jobs started before job identities were introduced are found by their command hash
+		legacyJob := NewHyPhyJob(request, method, api.Scheduler)
+		if legacyStatus, legacyErr := legacyJob.GetStatus(); legacyErr == nil {
+			log.Printf("Resolved job %s by its legacy ID", legacyJob.GetId())
+			job, status, err = legacyJob, legacyStatus, nil
+		}
+	}
 	if err != nil {
 		// Check if this is a "not found" error
-		if strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "does not exist") {
+		if isJobNotFound(err) {
 			return nil, fmt.Errorf("job not found")
 		}
 		return nil, fmt.Errorf("failed to get job status: %v", err)
 	}
 
+	// The request names the job only through its body, so ownership is checked here
+	// rather than by the route policy
+	if api.SessionService != nil {
+		if _, err := api.SessionService.CheckJobAccess(c, job.GetId(), api.JobTracker); err != nil {
+			log.Printf("Denied access to job %s: %v", job.GetId(), err)
+			return nil, ErrJobAccessDenied
+		}
+	}
+	status = api.applyTrackedStatus(job.GetId(), status)
+
 	// Handle different job statuses
 	if status == JobStatusFailed {
 		return nil, fmt.Errorf("job failed")
@@ -105,6 +149,42 @@ func (api *HyPhyBaseAPI) HandleGetJob(c *gin.Context, request HyPhyRequest, meth
 	return api.getJobResults(job.GetId(), method, status)
 }
 
+// newJob creates the job for a request, identified by its method, parameters,
+// dataset contents and the HyPhy version
+func (api *HyPhyBaseAPI) newJob(request HyPhyRequest, method *HyPhyMethod, methodType HyPhyMethodType) (*HyPhyJob, error) {
+	var alignmentHash, treeHash string
+	if alignmentID := request.GetAlignment(); alignmentID != "" {
+		dataset, err := api.DatasetTracker.Get(alignmentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dataset: %v", err)
+		}
+		alignmentHash = dataset.GetContentHash()
+	}
+	if request.IsTreeSet() {
+		dataset, err := api.DatasetTracker.Get(request.GetTree())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tree dataset: %v", err)
+		}
+		treeHash = dataset.GetContentHash()
+	}
+
+	identity, err := NewJobIdentity(methodType, request, alignmentHash, treeHash, api.HyPhyVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify job: %v", err)
+	}
+
+	job := NewHyPhyJob(request, method, api.Scheduler)
+	job.Id = identity.ID()
+	job.OutputPath = method.GetOutputPath(job.Id)
+	job.LogPath = method.GetLogPath(job.Id)
+	return job, nil
+}
+
+// isJobNotFound reports whether a scheduler status error means the job does not exist
+func isJobNotFound(err error) bool {
+	return strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "does not exist")
+}
+
 // HandleGetJobById handles retrieving job status and results for any HyPhy method by job ID
 func (api *HyPhyBaseAPI) HandleGetJobById(jobId string, methodType HyPhyMethodType) (interface{}, error) {
 	// Create HyPhyMethod instance with explicit method type
@@ -121,11 +201,12 @@ func (api *HyPhyBaseAPI) HandleGetJobById(jobId string, methodType HyPhyMethodTy
 	status, err := job.GetStatus()
 	if err != nil {
 		// Check if this is a "not found" error
-		if strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "does not exist") {
+		if isJobNotFound(err) {
 			return nil, fmt.Errorf("job not found")
 		}
 		return nil, fmt.Errorf("failed to get job status: %v", err)
 	}
+	status = api.applyTrackedStatus(job.GetId(), status)
 
 	// Handle different job statuses
 	if status == JobStatusFailed {
@@ -139,8 +220,27 @@ func (api *HyPhyBaseAPI) HandleGetJobById(jobId string, methodType HyPhyMethodTy
 	return api.getJobResults(jobId, method, status)
 }
 
-// HandleStartJob handles starting a new job for any HyPhy method
+// HandleStartJob handles starting a new job for any HyPhy method. A request identical to
+// an existing job's returns that job whatever its status; reruns resubmit failed jobs.
 func (api *HyPhyBaseAPI) HandleStartJob(c *gin.Context, request HyPhyRequest, methodType HyPhyMethodType) (interface{}, error) {
+	return api.startJob(c, request, methodType, false)
+}
+
+// HandleRerunJob starts a job like HandleStartJob, but submits an identical failed or
+// cancelled job again
+func (api *HyPhyBaseAPI) HandleRerunJob(c *gin.Context, request HyPhyRequest, methodType HyPhyMethodType) (interface{}, error) {
+	return api.startJob(c, request, methodType, true)
+}
+
+// startJob validates a request and submits its job unless the job already exists.
+// With resubmit, failed and cancelled jobs are submitted again.
+func (api *HyPhyBaseAPI) startJob(c *gin.Context, request HyPhyRequest, methodType HyPhyMethodType, resubmit bool) (interface{}, error) {
+	// Check the parameters against the spec before touching any dataset, so every bad
+	// field is reported at once
+	if err := ValidateParameters(request); err != nil {
+		return nil, err
+	}
+
 	// Validate required parameters
 	alignmentID := request.GetAlignment()
 	if alignmentID == "" && methodType != MethodSLATKIN {
@@ -153,11 +253,9 @@ func (api *HyPhyBaseAPI) HandleStartJob(c *gin.Context, request HyPhyRequest, me
 		return nil, fmt.Errorf("failed to get dataset: %v", err)
 	}
 
-	// TODO: why do we need the content here? just pass the dataset name to the cmd
-	// Load the dataset content from the file system
-	// The dataset content is not stored in the tracker, so we need to load it from the file
-	datasetPath := filepath.Join(api.DatasetTracker.GetDatasetDir(), dataset.GetId())
-	content, err := os.ReadFile(datasetPath)
+	// Load the dataset content from the blob store to validate it and size the job
+	// The dataset content is not stored in the tracker, so we need to load it from the store
+	content, err := ReadBlob(api.DatasetTracker.GetBlobStore(), dataset.GetContentHash())
 	if err != nil {
 		return nil, fmt.Errorf("failed to read dataset content: %v", err)
 	}
@@ -179,21 +277,56 @@ func (api *HyPhyBaseAPI) HandleStartJob(c *gin.Context, request HyPhyRequest, me
 
 	// Validate method parameters
 	if err := method.ValidateInput(dataset); err != nil {
-		return nil, fmt.Errorf("invalid method parameters: %v", err)
+		return nil, fmt.Errorf("invalid method parameters: %w", err)
+	}
+
+	// Check the tree against the alignment and the branch selections against the tree;
+	// mismatches otherwise only fail inside HyPhy
+	if err := api.checkTree(request, content); err != nil {
+		return nil, err
 	}
 
 	// Create job instance
-	job := NewHyPhyJob(request, method, api.Scheduler)
+	job, err := api.newJob(request, method, methodType)
+	if err != nil {
+		return nil, err
+	}
 
-	// Check if job already exists
+	// Check if job already exists; only reruns submit failed and cancelled jobs again
 	status, err := job.GetStatus()
 	if err == nil {
-		// Job exists, return its status
-		return map[string]interface{}{
-			"job_id": job.GetId(),
-			"status": status,
-		}, nil
+		status = api.applyTrackedStatus(job.GetId(), status)
+		if !resubmit || (status != JobStatusFailed && status != JobStatusCancelled) {
+			// Job exists, return its status
+			return map[string]interface{}{
+				"job_id": job.GetId(),
+				"status": status,
+			}, nil
+		}
+		log.Printf("Resubmitting %s job %s", status, job.GetId())
+	}
+
+	// Size the scheduler request from the method's resource profile and the alignment dimensions
+	if api.ResourceProfiles != nil {
+		// Content that is not an alignment, such as a tree, gets the profile's base resources
+		sequences, sites := 0, 0
+		if stats, err := CheckAlignment("", content); err == nil && stats != nil {
+			sequences, sites = int(stats.SequenceCount), int(stats.AlignmentLength)
+		}
+		resources := api.ResourceProfiles.Resolve(methodType, sequences, sites)
+		if job.Metadata == nil {
+			job.Metadata = make(map[string]interface{})
+		}
+		job.Metadata[JobResourcesMetadataKey] = resources
+		log.Printf("Resolved resources for job %s (%d sequences x %d sites): %+v", job.GetId(), sequences, sites, resources)
+	}
+
+	// Find where HyPhy reads the datasets; jobs download them from remote stores themselves
+	staged, err := api.stageDatasets(request)
+	if err != nil {
+		return nil, err
 	}
+	method.StagedDatasets = staged
 
 	// Submit job
 	if err := api.Scheduler.Submit(job); err != nil {
@@ -227,6 +360,28 @@ func (api *HyPhyBaseAPI) HandleStartJob(c *gin.Context, request HyPhyRequest, me
 		}
 	}
 
+	// Record the parameters and command line so the job can be inspected and rerun
+	if api.JobTracker != nil {
+		requestJSON, err := CanonicalRequestJSON(request)
+		if err != nil {
+			log.Printf("Warning: Failed to serialize request for job %s: %v", job.GetId(), err)
+		}
+		command := SubmittedCommand(job.BaseJob)
+		if err := api.JobTracker.StoreJobRequest(job.GetId(), requestJSON, command.String()); err != nil {
+			log.Printf("Warning: Failed to store request for job %s: %v", job.GetId(), err)
+		}
+	}
+
+	if api.Events != nil {
+		api.Events.Publish(&JobEvent{
+			Type:       JobEventSubmitted,
+			JobID:      job.GetId(),
+			MethodType: methodType,
+			Status:     JobStatusPending,
+			Job:        job,
+		})
+	}
+
 	// Return job ID and initial status
 	return map[string]interface{}{
 		"job_id": job.GetId(),
@@ -234,6 +389,98 @@ func (api *HyPhyBaseAPI) HandleStartJob(c *gin.Context, request HyPhyRequest, me
 	}, nil
 }
 
+// stageDatasets returns how the job gets the request's alignment and tree from the
+// blob store onto its compute node, by dataset ID
+func (api *HyPhyBaseAPI) stageDatasets(request HyPhyRequest) (map[string]StagedBlob, error) {
+	ids := []string{request.GetAlignment()}
+	if request.IsTreeSet() {
+		ids = append(ids, request.GetTree())
+	}
+
+	staged := make(map[string]StagedBlob)
+	store := api.DatasetTracker.GetBlobStore()
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		dataset, err := api.DatasetTracker.Get(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dataset %s: %v", id, err)
+		}
+		blob, err := store.Stage(dataset.GetContentHash())
+		if err != nil {
+			return nil, fmt.Errorf("failed to stage dataset %s: %v", id, err)
+		}
+		staged[id] = blob
+	}
+	return staged, nil
+}
+
+// checkTree checks that the request's tree matches the alignment and that its branch
+// selections resolve against the tree. Without a tree dataset, selections are checked
+// against a tree embedded in a NEXUS alignment, if there is one.
+func (api *HyPhyBaseAPI) checkTree(request HyPhyRequest, alignmentContent []byte) error {
+	isAlignment := DetectAlignmentFormat(alignmentContent) != ""
+
+	var tree *Tree
+	if request.IsTreeSet() {
+		treeDataset, err := api.DatasetTracker.Get(request.GetTree())
+		if err != nil {
+			return fmt.Errorf("failed to get tree dataset: %v", err)
+		}
+		treeContent, err := ReadBlob(api.DatasetTracker.GetBlobStore(), treeDataset.GetContentHash())
+		if err != nil {
+			return fmt.Errorf("failed to read tree dataset: %v", err)
+		}
+		if tree, err = ParseTree(treeContent); err != nil {
+			return fmt.Errorf("invalid tree: %v", err)
+		}
+		if isAlignment {
+			alignment, err := ParseAlignment(alignmentContent)
+			if err != nil {
+				return fmt.Errorf("invalid alignment: %v", err)
+			}
+			if err := CheckTreeAlignment(tree, alignment); err != nil {
+				return err
+			}
+		}
+	} else if isAlignment && DetectTreeFormat(alignmentContent) == TreeFormatNEXUS {
+		embedded, err := ParseTree(alignmentContent)
+		if err != nil {
+			return fmt.Errorf("invalid tree in alignment: %v", err)
+		}
+		tree = embedded
+	}
+
+	if tree == nil {
+		return nil
+	}
+	return CheckBranchSelections(tree, request)
+}
+
+// invalidDataError is implemented by validation errors that carry per-field diagnostics
+type invalidDataError interface {
+	InvalidData() InvalidDataError
+}
+
+// writeValidationError responds 400 with per-field diagnostics when err carries them,
+// and reports whether it did
+func writeValidationError(c *gin.Context, err error) bool {
+	var detailed invalidDataError
+	if errors.As(err, &detailed) {
+		c.JSON(http.StatusBadRequest, detailed.InvalidData())
+		return true
+	}
+	return false
+}
+
+// requestBody returns the body a handler bound with ShouldBindBodyWith
+func requestBody(c *gin.Context) []byte {
+	body, _ := c.Get(gin.BodyBytesKey)
+	data, _ := body.([]byte)
+	return data
+}
+
 // cleanJSONString attempts to clean a JSON string that might have invalid characters
 func cleanJSONString(input string) string {
 	// Replace any non-printable characters with spaces
@@ -0,0 +1,195 @@
+package datamonkey
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobProgress is the payload of a progress event: one line of the job's log
+type JobProgress struct {
+	JobID string `json:"job_id"`
+	Line  string `json:"line"`
+}
+
+// logTailer incrementally reads complete lines appended to a log file
+type logTailer struct {
+	path    string
+	offset  int64
+	partial []byte
+}
+
+// readLines returns lines appended since the last call. HyPhy redraws progress with
+// carriage returns, so both \r and \n end a line. Unterminated text is held back unless flush is set.
+func (t *logTailer) readLines(flush bool) []string {
+	if t.path == "" {
+		return nil
+	}
+
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		return nil
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil
+	}
+	t.offset += int64(len(data))
+
+	data = append(t.partial, data...)
+	end := bytes.LastIndexAny(data, "\r\n")
+	if flush {
+		end = len(data) - 1
+	}
+	if end < 0 {
+		t.partial = data
+		return nil
+	}
+	t.partial = append([]byte(nil), data[end+1:]...)
+
+	var lines []string
+	for _, line := range strings.FieldsFunc(string(data[:end+1]), func(r rune) bool { return r == '\r' || r == '\n' }) {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// isTerminalStatus reports whether a job in this status will not change again
+func isTerminalStatus(status JobStatusValue) bool {
+	return status == JobStatusComplete || status == JobStatusFailed || status == JobStatusCancelled
+}
+
+// GetJobEvents streams job status transitions and log progress as Server-Sent Events
+// GET /api/v1/jobs/:jobId/events
+func (api *JobsAPI) GetJobEvents(c *gin.Context) {
+	jobID := c.Param("jobId")
+
+	if jobID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Job ID is required"})
+		return
+	}
+
+	if api.JobTracker == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Job tracker not available"})
+		return
+	}
+
+	// Check job access (EventSource clients can pass the token as ?user_token=)
+	if api.SessionService != nil {
+		if _, err := api.SessionService.CheckJobAccess(c, jobID, api.JobTracker); err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+				return
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden - you do not have access to this job"})
+			return
+		}
+	}
+
+	// Watch before reading the current status so a transition made in between is not missed
+	var events <-chan JobEvent
+	if api.Events != nil {
+		watch, stop := api.Events.Watch(jobID)
+		defer stop()
+		events = watch
+	}
+
+	_, _, methodType, status, err := api.JobTracker.GetJobMetadata(jobID)
+	if err != nil {
+		log.Printf("Job %s not found: %v", jobID, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	// Locate the job's log file
+	tailer := &logTailer{}
+	if api.MethodFactory != nil && methodType != "" {
+		if method, err := api.MethodFactory(HyPhyMethodType(methodType)); err == nil {
+			if hyPhyMethod, ok := method.(*HyPhyMethod); ok {
+				tailer.path = hyPhyMethod.GetLogPath(jobID)
+			}
+		}
+	}
+
+	interval := api.ProgressInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	sendProgress := func(flush bool) {
+		for _, line := range tailer.readLines(flush) {
+			c.SSEvent("progress", JobProgress{JobID: jobID, Line: line})
+		}
+	}
+
+	// Start with the current status and the log so far
+	currentType, ok := jobEventTypeForStatus(JobStatusValue(status))
+	if !ok {
+		currentType = JobEventSubmitted
+	}
+	c.SSEvent("status", JobEvent{
+		Type:       currentType,
+		JobID:      jobID,
+		MethodType: HyPhyMethodType(methodType),
+		Status:     JobStatusValue(status),
+		Timestamp:  time.Now(),
+	})
+	sendProgress(isTerminalStatus(JobStatusValue(status)))
+	c.Writer.Flush()
+
+	if isTerminalStatus(JobStatusValue(status)) {
+		return
+	}
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event := <-events:
+			// The current status already reflects a transition made before it was read
+			if event.Status == JobStatusValue(status) {
+				continue
+			}
+			status = string(event.Status)
+
+			// Send log output preceding the transition first
+			sendProgress(isTerminalStatus(event.Status))
+			c.SSEvent("status", event)
+			c.Writer.Flush()
+			if isTerminalStatus(event.Status) {
+				return
+			}
+		case <-ticker.C:
+			sendProgress(false)
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			if _, err := c.Writer.WriteString(": keep-alive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package datamonkey
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetJobResults returns the results of a completed job of any method
+// GET /api/v1/jobs/:jobId/results?format=raw|parsed
+func (api *JobsAPI) GetJobResults(c *gin.Context) {
+	jobID := c.Param("jobId")
+
+	if jobID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Job ID is required"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "parsed")
+	if format != "parsed" && format != "raw" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be 'raw' or 'parsed'"})
+		return
+	}
+
+	completed, ok := api.loadCompletedJob(c, jobID)
+	if !ok {
+		return
+	}
+
+	// Raw results are HyPhy's JSON output as written
+	if format == "raw" {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", completed.raw)
+		return
+	}
+
+	parsed, err := completed.job.GetMethod().ParseResult(completed.output)
+	if err != nil {
+		log.Printf("Error parsing results for job %s: %v", jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse job results"})
+		return
+	}
+
+	// ParseResult wraps the result in a placeholder envelope; keep only the result
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+	}
+	data, err := json.Marshal(parsed)
+	if err == nil {
+		err = json.Unmarshal(data, &envelope)
+	}
+	if err != nil {
+		log.Printf("Error serializing results for job %s: %v", jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize job results"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"job_id": jobID,
+		"method": string(completed.methodType),
+		"status": completed.status,
+		"result": envelope.Result,
+	})
+}
+
+// completedJob is a completed job with its results output
+type completedJob struct {
+	methodType HyPhyMethodType
+	status     string
+	job        *HyPhyJob
+	raw        []byte // The output file as written
+	output     string // The output cleaned up for parsing
+}
+
+// loadCompletedJob checks access to a completed job and reads its results output.
+// On failure it writes the error response and returns false.
+func (api *JobsAPI) loadCompletedJob(c *gin.Context, jobID string) (*completedJob, bool) {
+	if api.JobTracker == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Job tracker not available"})
+		return nil, false
+	}
+
+	// Check job access
+	if api.SessionService != nil {
+		if _, err := api.SessionService.CheckJobAccess(c, jobID, api.JobTracker); err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+				return nil, false
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden - you do not have access to this job"})
+			return nil, false
+		}
+	}
+
+	_, _, methodType, status, err := api.JobTracker.GetJobMetadata(jobID)
+	if err != nil {
+		log.Printf("Job %s not found: %v", jobID, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return nil, false
+	}
+	if methodType == "" || api.MethodFactory == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Job method is not known"})
+		return nil, false
+	}
+
+	if JobStatusValue(status) != JobStatusComplete {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job is not complete", "job_id": jobID, "status": status})
+		return nil, false
+	}
+
+	job, err := api.rebuildJob(jobID, HyPhyMethodType(methodType))
+	if err != nil {
+		log.Printf("Error rebuilding job %s: %v", jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild job"})
+		return nil, false
+	}
+
+	output, err := readJobOutput(api.Results, job.GetOutputPath())
+	if err != nil {
+		log.Printf("Error reading results for job %s: %v", jobID, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job results not found", "job_id": jobID})
+		return nil, false
+	}
+
+	cleaned := cleanJSONString(string(output))
+	if cleaned == "" {
+		cleaned = string(output)
+	}
+	return &completedJob{methodType: HyPhyMethodType(methodType), status: status, job: job, raw: output, output: cleaned}, true
+}
+
+// GetJobSiteResults returns a job's per-site estimates as a table
+// GET /api/v1/jobs/:jobId/results/sites?format=csv|tsv|json|parquet
+func (api *JobsAPI) GetJobSiteResults(c *gin.Context) {
+	api.writeResultTable(c, "sites", BuildSiteTable)
+}
+
+// GetJobBranchResults returns a job's per-branch attributes as a table
+// GET /api/v1/jobs/:jobId/results/branches?format=csv|tsv|json|parquet
+func (api *JobsAPI) GetJobBranchResults(c *gin.Context) {
+	api.writeResultTable(c, "branches", BuildBranchTable)
+}
+
+// writeResultTable flattens a completed job's results with build and writes the table in the requested format
+func (api *JobsAPI) writeResultTable(c *gin.Context, name string, build func(HyPhyMethodType, []byte) (*ResultTable, error)) {
+	jobID := c.Param("jobId")
+
+	if jobID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Job ID is required"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "tsv" && format != "json" && format != "parquet" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be one of 'csv', 'tsv', 'json' or 'parquet'"})
+		return
+	}
+
+	completed, ok := api.loadCompletedJob(c, jobID)
+	if !ok {
+		return
+	}
+
+	table, err := build(completed.methodType, []byte(completed.output))
+	if errors.Is(err, ErrResultTableNotSupported) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s results have no %s table", completed.methodType, name)})
+		return
+	}
+	if err != nil {
+		log.Printf("Error building %s table for job %s: %v", name, jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to flatten job results"})
+		return
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, gin.H{
+			"job_id":  jobID,
+			"method":  string(completed.methodType),
+			"columns": table.Columns,
+			"rows":    table.Rows,
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+		err = table.WriteDelimited(&buf, ',')
+	case "tsv":
+		contentType = "text/tab-separated-values; charset=utf-8"
+		err = table.WriteDelimited(&buf, '\t')
+	case "parquet":
+		contentType = "application/vnd.apache.parquet"
+		err = table.WriteParquet(&buf)
+	}
+	if err != nil {
+		log.Printf("Error writing %s table for job %s: %v", name, jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write job results"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s_%s.%s", jobID, name, format)))
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
@@ -1,9 +1,14 @@
 package datamonkey
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,6 +18,19 @@ type JobsAPI struct {
 	JobTracker     JobTracker
 	SessionService *SessionService
 	Scheduler      SchedulerInterface
+	// Events supplies live status transitions for job event streams; may be nil
+	Events *JobEventBus
+	// MethodFactory rebuilds a job's method to locate its output and log files
+	MethodFactory func(HyPhyMethodType) (ComputeMethodInterface, error)
+	// JobLauncher starts reruns. The method type is passed explicitly, so it is a base API
+	// of its own rather than one borrowed from a method's API.
+	JobLauncher *HyPhyBaseAPI
+	// VisualizationTracker is used to remove a job's visualizations when it is purged; may be nil
+	VisualizationTracker VisualizationTracker
+	// ProgressInterval is how often job logs are polled for progress (default 1s)
+	ProgressInterval time.Duration
+	// Results holds published job output and logs; nil reads them where HyPhy wrote them
+	Results BlobStore
 }
 
 // NewJobsAPI creates a new JobsAPI instance
@@ -106,6 +124,22 @@ func (api *JobsAPI) GetJobById(c *gin.Context) {
 		return
 	}
 
+	// Check job access
+	if api.SessionService != nil {
+		if _, err := api.SessionService.GetSubject(c); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - valid token required"})
+			return
+		}
+		if _, err := api.SessionService.CheckJobAccess(c, jobID, api.JobTracker); err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+				return
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden - you do not have access to this job"})
+			return
+		}
+	}
+
 	// Check if job exists by trying to get scheduler job ID
 	_, err := api.JobTracker.GetSchedulerJobID(jobID)
 	if err != nil {
@@ -140,14 +174,36 @@ func (api *JobsAPI) GetJobById(c *gin.Context) {
 		log.Printf("Could not retrieve metadata for job %s: %v", jobID, err)
 	}
 
+	// Include the dataset versions the job ran on
+	alignmentVersion, treeVersion, err := api.JobTracker.GetJobDatasetVersions(jobID)
+	if err == nil {
+		jobStatus.AlignmentVersion = int32(alignmentVersion)
+		jobStatus.TreeVersion = int32(treeVersion)
+	} else {
+		log.Printf("Could not retrieve dataset versions for job %s: %v", jobID, err)
+	}
+
+	// Include the parameters and command line the job was started with
+	requestJSON, command, err := api.JobTracker.GetJobRequest(jobID)
+	if err == nil {
+		if requestJSON != "" {
+			if err := json.Unmarshal([]byte(requestJSON), &jobStatus.Request); err != nil {
+				log.Printf("Stored request for job %s is invalid: %v", jobID, err)
+			}
+		}
+		jobStatus.Command = command
+	} else {
+		log.Printf("Could not retrieve request for job %s: %v", jobID, err)
+	}
+
 	// NOTE: We do NOT include UserToken in the response for security reasons
 	// The user token should never be exposed in API responses
 
 	c.JSON(http.StatusOK, jobStatus)
 }
 
-// DeleteJob deletes a job by ID
-// DELETE /api/v1/jobs/:jobId
+// DeleteJob cancels a job by ID, or deletes it with its files when purge=true
+// DELETE /api/v1/jobs/:jobId?purge=true
 func (api *JobsAPI) DeleteJob(c *gin.Context) {
 	jobID := c.Param("jobId")
 
@@ -156,26 +212,15 @@ func (api *JobsAPI) DeleteJob(c *gin.Context) {
 		return
 	}
 
-	// Get user_token from header for authentication
-	userToken := c.GetHeader("user_token")
-	if userToken == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user_token header is required"})
-		return
-	}
-
 	// Validate user token
 	if api.SessionService == nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "User token validator not available"})
 		return
 	}
 
-	subject, err := api.SessionService.GetOrCreateSubject(c)
+	subject, err := api.SessionService.GetSubject(c)
 	if err != nil {
-		if strings.Contains(err.Error(), "missing user token") || strings.Contains(err.Error(), "invalid user token") {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - " + err.Error()})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Authentication error: " + err.Error()})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - " + err.Error()})
 		return
 	}
 
@@ -204,20 +249,270 @@ func (api *JobsAPI) DeleteJob(c *gin.Context) {
 		return
 	}
 
-	// Try to cancel the job if it's running
-	// Note: We can't easily cancel the job through the scheduler interface
-	// because it requires a JobInterface object, not just an ID.
-	// The scheduler will handle cleanup of cancelled/deleted jobs.
-	// For now, we just remove the mapping from the tracker.
-	// TODO: Implement proper job cancellation by reconstructing JobInterface from tracker data
+	_, _, methodType, status, err := api.JobTracker.GetJobMetadata(jobID)
+	if err != nil {
+		log.Printf("Error getting metadata for job %s: %v", jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve job"})
+		return
+	}
+
+	job, err := api.rebuildJob(jobID, HyPhyMethodType(methodType))
+	if err != nil {
+		log.Printf("Error rebuilding job %s: %v", jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild job"})
+		return
+	}
+
+	// Cancel the job if it is still pending or running
+	currentStatus := JobStatusValue(status)
+	if !isTerminalStatus(currentStatus) {
+		if api.Scheduler == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Scheduler not available"})
+			return
+		}
+
+		newStatus := JobStatusCancelled
+		if err := api.Scheduler.Cancel(job); err != nil {
+			// The job may have finished since the monitor last polled it
+			realtimeStatus, statusErr := api.Scheduler.GetStatus(job)
+			if statusErr != nil || !isTerminalStatus(realtimeStatus) {
+				log.Printf("Failed to cancel job %s: %v", jobID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job"})
+				return
+			}
+			log.Printf("Job %s already %s, nothing to cancel", jobID, realtimeStatus)
+			newStatus = realtimeStatus
+		}
+
+		newStatus = api.Events.PublishTransition(job, HyPhyMethodType(methodType), currentStatus, newStatus)
+		if err := api.JobTracker.UpdateJobStatusByUser(jobID, subject, string(newStatus)); err != nil {
+			log.Printf("Failed to update status for job %s: %v", jobID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update job status"})
+			return
+		}
+		log.Printf("Job %s %s by user %s", jobID, newStatus, subject)
+	}
+
+	if c.Query("purge") != "true" {
+		c.Status(http.StatusNoContent) // 204 No Content
+		return
+	}
+
+	// Purge the job's files and visualizations, then the job itself
+	api.purgeJobArtifacts(job, subject)
 
-	// Remove from tracker
 	if err := api.JobTracker.DeleteJobMappingByUser(jobID, subject); err != nil {
 		log.Printf("Failed to delete job %s from tracker: %v", jobID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete job from tracker"})
 		return
 	}
 
-	log.Printf("Job %s deleted successfully by user %s", jobID, subject)
+	log.Printf("Job %s purged successfully by user %s", jobID, subject)
 	c.Status(http.StatusNoContent) // 204 No Content
 }
+
+// rebuildJob reconstructs a job from the tracker, the same way the JobStatusMonitor does
+func (api *JobsAPI) rebuildJob(jobID string, methodType HyPhyMethodType) (*HyPhyJob, error) {
+	schedulerJobID, err := api.JobTracker.GetSchedulerJobID(jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &HyPhyJob{
+		BaseJob: &BaseJob{
+			Id:        jobID,
+			Scheduler: api.Scheduler,
+		},
+		SchedulerJobID: schedulerJobID,
+	}
+
+	if api.MethodFactory != nil && methodType != "" {
+		method, err := api.MethodFactory(methodType)
+		if err != nil {
+			return nil, err
+		}
+		job.Method = method
+		if hyPhyMethod, ok := method.(*HyPhyMethod); ok {
+			job.OutputPath = hyPhyMethod.GetOutputPath(jobID)
+			job.LogPath = hyPhyMethod.GetLogPath(jobID)
+		}
+	}
+
+	return job, nil
+}
+
+// purgeJobArtifacts removes a job's output, log and visualizations.
+// Failures are logged so that the job itself can still be deleted.
+func (api *JobsAPI) purgeJobArtifacts(job *HyPhyJob, subject string) {
+	for _, path := range []string{job.OutputPath, job.LogPath} {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: failed to remove %s for job %s: %v", path, job.Id, err)
+		}
+		if api.Results != nil {
+			if err := api.Results.Delete(filepath.Base(path)); err != nil {
+				log.Printf("Warning: failed to remove published %s for job %s: %v", filepath.Base(path), job.Id, err)
+			}
+		}
+	}
+
+	if api.VisualizationTracker == nil {
+		return
+	}
+	visualizations, err := api.VisualizationTracker.ListByJob(job.Id, subject)
+	if err != nil {
+		log.Printf("Warning: failed to list visualizations for job %s: %v", job.Id, err)
+		return
+	}
+	for _, viz := range visualizations {
+		if err := api.VisualizationTracker.Delete(viz.VizId, subject); err != nil {
+			log.Printf("Warning: failed to delete visualization %s for job %s: %v", viz.VizId, job.Id, err)
+		}
+	}
+}
+
+// RerunJob starts a new job from a previous job's parameters, with optional overrides
+// POST /api/v1/jobs/:jobId/rerun
+func (api *JobsAPI) RerunJob(c *gin.Context) {
+	jobID := c.Param("jobId")
+
+	if jobID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Job ID is required"})
+		return
+	}
+
+	if api.JobTracker == nil || api.JobLauncher == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Job tracker not available"})
+		return
+	}
+
+	// Require valid token - reruns start jobs like the method endpoints do
+	var subject string
+	if api.SessionService != nil {
+		var err error
+		subject, err = api.SessionService.GetSubject(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - valid token required to start jobs"})
+			return
+		}
+
+		if _, err := api.SessionService.CheckJobAccess(c, jobID, api.JobTracker); err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+				return
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden - you do not have access to this job"})
+			return
+		}
+	}
+
+	_, _, methodType, _, err := api.JobTracker.GetJobMetadata(jobID)
+	if err != nil {
+		log.Printf("Job %s not found: %v", jobID, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	requestJSON, _, err := api.JobTracker.GetJobRequest(jobID)
+	if err != nil {
+		log.Printf("Error getting request for job %s: %v", jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve job parameters"})
+		return
+	}
+	if requestJSON == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job parameters were not recorded, so the job cannot be rerun"})
+		return
+	}
+
+	// Apply overrides on top of the stored parameters; null removes a parameter
+	var overrides map[string]interface{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&overrides); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse parameter overrides"})
+			return
+		}
+	}
+
+	params, err := mergeRequestOverrides(requestJSON, overrides)
+	if err != nil {
+		log.Printf("Error merging overrides for job %s: %v", jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply parameter overrides"})
+		return
+	}
+
+	request, err := DecodeMethodRequest(HyPhyMethodType(methodType), params)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if writeValidationError(c, ValidateParametersJSON(request, params)) {
+		return
+	}
+
+	adapted, err := AdaptRequest(request)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to adapt request: %v", err)})
+		return
+	}
+
+	// Overrides may point at other datasets, so check access again
+	if api.SessionService != nil && api.JobLauncher.DatasetTracker != nil {
+		for _, datasetID := range []string{adapted.GetAlignment(), adapted.GetTree()} {
+			if datasetID == "" {
+				continue
+			}
+			if _, err := api.SessionService.CheckDatasetAccess(c, datasetID, api.JobLauncher.DatasetTracker); err != nil {
+				if strings.Contains(err.Error(), "not found") {
+					c.JSON(http.StatusNotFound, gin.H{"error": "Dataset not found: " + datasetID})
+					return
+				}
+				c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden - You don't have access to dataset " + datasetID})
+				return
+			}
+		}
+	}
+
+	result, err := api.JobLauncher.HandleRerunJob(c, adapted, HyPhyMethodType(methodType))
+	if err != nil {
+		if writeValidationError(c, err) {
+			return
+		}
+		if strings.Contains(err.Error(), "parameter is required") || strings.Contains(err.Error(), "invalid") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if resultMap, ok := result.(map[string]interface{}); ok {
+		resultMap["rerun_of"] = jobID
+	}
+
+	log.Printf("Job %s rerun by user %s", jobID, subject)
+	c.JSON(http.StatusOK, result)
+}
+
+// mergeRequestOverrides applies top-level parameter overrides to stored request JSON.
+// A null override removes the parameter; the user token cannot be overridden.
+func mergeRequestOverrides(requestJSON string, overrides map[string]interface{}) ([]byte, error) {
+	var params map[string]interface{}
+	if err := json.Unmarshal([]byte(requestJSON), &params); err != nil {
+		return nil, fmt.Errorf("stored request is invalid: %v", err)
+	}
+
+	for key, value := range overrides {
+		if key == "user_token" {
+			continue
+		}
+		if value == nil {
+			delete(params, key)
+			continue
+		}
+		params[key] = value
+	}
+
+	return json.Marshal(params)
+}
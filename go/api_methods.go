@@ -260,8 +260,9 @@ func extractParameters(requestType interface{}) []MethodsListMethodsInnerParamet
 			paramType = "array"
 		}
 
-		// Check if required (fields without omitempty are typically required)
-		required := !strings.Contains(jsonTag, "omitempty")
+		// Required fields, enums, ranges and patterns come from the same constraints
+		// ValidateParameters enforces
+		constraints := fieldConstraints(field)
 
 		// Get default value from tag if available
 		defaultValue := field.Tag.Get("default")
@@ -270,8 +271,12 @@ func extractParameters(requestType interface{}) []MethodsListMethodsInnerParamet
 			Name:        jsonName,
 			Description: description,
 			Type:        paramType,
-			Required:    required,
+			Required:    constraints.Required,
 			Default:     defaultValue,
+			Enum:        constraints.Enum,
+			Minimum:     constraints.Minimum,
+			Maximum:     constraints.Maximum,
+			Pattern:     constraints.Pattern,
 		})
 	}
 
@@ -12,12 +12,14 @@ package datamonkey
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type SLACAPI struct {
@@ -102,7 +104,9 @@ func (api *SLACAPI) GetSLACJob(c *gin.Context) {
 
 	result, err := api.HandleGetJob(c, adapted, MethodSLAC)
 	if err != nil {
-		if err.Error() == "job is not complete" {
+		if errors.Is(err, ErrJobAccessDenied) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden - you do not have access to this job"})
+		} else if err.Error() == "job is not complete" {
 			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -116,19 +120,6 @@ func (api *SLACAPI) GetSLACJob(c *gin.Context) {
 	// Get the job ID from the result map (note: key is "jobId" not "job_id")
 	jobId := resultMap["jobId"].(string)
 
-	// Check job access now that we have the job ID
-	if api.SessionService != nil {
-		_, err := api.SessionService.CheckJobAccess(c, jobId, api.JobTracker)
-		if err != nil {
-			if strings.Contains(err.Error(), "missing user token") || strings.Contains(err.Error(), "invalid user token") {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - " + err.Error()})
-				return
-			}
-			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden - You don't have access to this job"})
-			return
-		}
-	}
-
 	// Get the raw results
 	rawResults := resultMap["results"].(json.RawMessage)
 
@@ -222,11 +213,16 @@ func (api *SLACAPI) StartSLACJob(c *gin.Context) {
 	}
 
 	var request SlacRequest
-	if err := c.BindJSON(&request); err != nil {
+	if err := c.ShouldBindBodyWith(&request, binding.JSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse job configuration"})
 		return
 	}
 
+	// Check the parameters against the spec before looking up any dataset
+	if writeValidationError(c, ValidateParametersJSON(&request, requestBody(c))) {
+		return
+	}
+
 	// Set the subject in the request for job tracking
 	if subject != "" {
 		request.UserToken = subject
@@ -266,6 +262,9 @@ func (api *SLACAPI) StartSLACJob(c *gin.Context) {
 
 	result, err := api.HandleStartJob(c, adapted, MethodSLAC)
 	if err != nil {
+		if writeValidationError(c, err) {
+			return
+		}
 		if err.Error() == "authentication token required" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		} else if strings.Contains(err.Error(), "parameter is required") || strings.Contains(err.Error(), "invalid") {
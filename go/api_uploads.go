@@ -0,0 +1,244 @@
+package datamonkey
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Resumable uploads follow the tus protocol (https://tus.io): the client creates an
+// upload with its total length, sends the bytes in PATCH requests that each state the
+// offset they start at, asks HEAD for the offset to resume from after a failure, and
+// finally turns the complete upload into a dataset.
+
+// tusResumable is the tus protocol version the upload endpoints speak
+const tusResumable = "1.0.0"
+
+// offsetContentType is the content type tus requires for PATCH bodies
+const offsetContentType = "application/offset+octet-stream"
+
+// setUploadHeaders sets the tus headers describing an upload's progress
+func setUploadHeaders(c *gin.Context, upload *UploadRecord) {
+	c.Header("Tus-Resumable", tusResumable)
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "no-store")
+}
+
+// uploadStatus maps an upload error to the status code returned to the client
+func uploadStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUploadTooLarge), errors.Is(err, ErrUploadQuota):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUploadOffset), errors.Is(err, ErrUploadIncomplete):
+		return http.StatusConflict
+	case errors.Is(err, ErrUploadBusy):
+		return http.StatusLocked
+	case errors.Is(err, ErrUploadHashMismatch):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// getOwnedUpload loads an upload and checks that the caller owns it, writing the error response if not
+func (api *FileUploadAndQCAPI) getOwnedUpload(c *gin.Context) (*UploadRecord, string, bool) {
+	if api.Uploads == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Resumable uploads are not enabled"})
+		return nil, "", false
+	}
+
+	var subject string
+	if api.sessionService != nil {
+		var err error
+		subject, err = api.sessionService.GetSubject(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - valid token required to access uploads"})
+			return nil, "", false
+		}
+	}
+
+	upload, err := api.Uploads.Tracker.Get(c.Param("uploadId"))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return nil, "", false
+	}
+	if upload.UserID != subject {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden - you don't have access to this upload"})
+		return nil, "", false
+	}
+	return upload, subject, true
+}
+
+// CreateUpload starts a resumable upload, or answers with the caller's existing dataset
+// when the declared SHA-256 matches content they already uploaded
+// POST /api/v1/uploads
+func (api *FileUploadAndQCAPI) CreateUpload(c *gin.Context) {
+	if api.Uploads == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Resumable uploads are not enabled"})
+		return
+	}
+
+	// Get or create user session - automatically adds X-Session-Token header if new
+	var subject string
+	if api.sessionService != nil {
+		var err error
+		subject, err = api.sessionService.GetOrCreateSubject(c)
+		if err != nil {
+			writeSessionError(c, err)
+			return
+		}
+	}
+
+	var request CreateUploadRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := api.completeMeta(c, subject, &request.Meta); !ok {
+		return
+	}
+	if request.Meta.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File name is required"})
+		return
+	}
+	if request.Meta.Type == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File type is required"})
+		return
+	}
+	if request.Length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload length must be positive"})
+		return
+	}
+
+	// A client that declares the hash of content it already uploaded needn't send it again
+	if request.Sha256 != "" {
+		if existing := api.findUploadedDataset(subject, strings.ToLower(request.Sha256)); existing != nil {
+			writeUploadedDataset(c, existing)
+			return
+		}
+	}
+
+	upload, err := api.Uploads.Create(subject, request.Meta, request.Length, strings.ToLower(request.Sha256))
+	if err != nil {
+		c.JSON(uploadStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Created upload %s (%d bytes) for %s", upload.Id, upload.Length, request.Meta.Name)
+	setUploadHeaders(c, upload)
+	c.Header("Location", "/api/v1/uploads/"+upload.Id)
+	c.JSON(http.StatusCreated, api.Uploads.ToUpload(upload))
+}
+
+// GetUpload returns the progress of an upload; HEAD requests get only the tus headers
+// GET/HEAD /api/v1/uploads/:uploadId
+func (api *FileUploadAndQCAPI) GetUpload(c *gin.Context) {
+	upload, _, ok := api.getOwnedUpload(c)
+	if !ok {
+		return
+	}
+
+	setUploadHeaders(c, upload)
+	if c.Request.Method == http.MethodHead {
+		c.Status(http.StatusOK)
+		return
+	}
+	c.JSON(http.StatusOK, api.Uploads.ToUpload(upload))
+}
+
+// PatchUpload appends a chunk to an upload at the offset given in the Upload-Offset header
+// PATCH /api/v1/uploads/:uploadId
+func (api *FileUploadAndQCAPI) PatchUpload(c *gin.Context) {
+	upload, _, ok := api.getOwnedUpload(c)
+	if !ok {
+		return
+	}
+
+	if c.ContentType() != offsetContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": fmt.Sprintf("Content-Type must be %s", offsetContentType)})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset header must be a non-negative integer"})
+		return
+	}
+
+	updated, err := api.Uploads.Append(upload.Id, offset, c.Request.Body)
+	if updated != nil {
+		setUploadHeaders(c, updated)
+	}
+	if err != nil {
+		if updated != nil && uploadStatus(err) == http.StatusInternalServerError {
+			// The connection dropped mid-chunk; what arrived is kept for the client to resume
+			log.Printf("Upload %s interrupted at %d bytes: %v", upload.Id, updated.Offset, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "offset": updated.Offset})
+			return
+		}
+		c.JSON(uploadStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// FinalizeUpload turns a complete upload into a dataset, with the same checks as PostDataset
+// POST /api/v1/uploads/:uploadId/finalize
+func (api *FileUploadAndQCAPI) FinalizeUpload(c *gin.Context) {
+	upload, subject, ok := api.getOwnedUpload(c)
+	if !ok {
+		return
+	}
+	if !api.Uploads.acquire(upload.Id) {
+		c.JSON(http.StatusLocked, gin.H{"error": ErrUploadBusy.Error()})
+		return
+	}
+	defer api.Uploads.release(upload.Id)
+
+	contentHash, err := api.Uploads.Complete(upload)
+	if err != nil {
+		setUploadHeaders(c, upload)
+		c.JSON(uploadStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	path := api.Uploads.PartPath(upload.Id)
+	api.storeDataset(c, subject, upload.Meta, path, contentHash)
+	if status := c.Writer.Status(); status == http.StatusCreated || status == http.StatusOK {
+		// The content was moved into the blob store, or was already there
+		if err := api.Uploads.Discard(upload.Id); err != nil {
+			log.Printf("Failed to delete finalized upload %s: %v", upload.Id, err)
+		}
+	}
+}
+
+// DeleteUpload abandons an upload and removes the bytes received so far
+// DELETE /api/v1/uploads/:uploadId
+func (api *FileUploadAndQCAPI) DeleteUpload(c *gin.Context) {
+	upload, _, ok := api.getOwnedUpload(c)
+	if !ok {
+		return
+	}
+	if !api.Uploads.acquire(upload.Id) {
+		c.JSON(http.StatusLocked, gin.H{"error": ErrUploadBusy.Error()})
+		return
+	}
+	defer api.Uploads.release(upload.Id)
+
+	if err := api.Uploads.Discard(upload.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Tus-Resumable", tusResumable)
+	c.Status(http.StatusNoContent)
+}
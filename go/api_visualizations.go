@@ -189,17 +189,17 @@ func (api *VisualizationsAPI) GetVisualization(c *gin.Context) {
 		return
 	}
 
-	// Check if user_token is provided for private visualizations
-	userToken := c.GetHeader("user_token")
-	if userToken != "" && api.SessionService != nil {
+	// Verify ownership
+	if api.SessionService != nil {
 		subject, err := api.SessionService.GetSubject(c)
-		if err == nil {
-			// Verify ownership
-			owner, err := api.VizTracker.GetOwner(vizID)
-			if err == nil && owner != subject {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - you do not own this visualization"})
-				return
-			}
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - valid token required"})
+			return
+		}
+		owner, err := api.VizTracker.GetOwner(vizID)
+		if err == nil && owner != subject {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden - you do not own this visualization"})
+			return
 		}
 	}
 
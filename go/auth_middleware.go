@@ -0,0 +1,211 @@
+package datamonkey
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessLevel is who may call a route
+type AccessLevel int
+
+const (
+	// AccessPublic routes need no token. Handlers that use one, like logout, check it themselves.
+	AccessPublic AccessLevel = iota
+	// AccessSession routes need a valid token
+	AccessSession
+	// AccessNewSession routes start an anonymous session for callers without a valid token
+	AccessNewSession
+	// AccessOwner routes need a valid token whose subject owns the resource the route names
+	AccessOwner
+)
+
+// String returns the name of an access level
+func (a AccessLevel) String() string {
+	switch a {
+	case AccessPublic:
+		return "public"
+	case AccessSession:
+		return "session"
+	case AccessNewSession:
+		return "new-session"
+	case AccessOwner:
+		return "owner"
+	default:
+		return fmt.Sprintf("AccessLevel(%d)", int(a))
+	}
+}
+
+// ResourceKind is a kind of resource that belongs to a subject
+type ResourceKind string
+
+// Resources that AccessOwner routes can name
+const (
+	ResourceJob           ResourceKind = "job"
+	ResourceDataset       ResourceKind = "dataset"
+	ResourceConversation  ResourceKind = "conversation"
+	ResourceVisualization ResourceKind = "visualization"
+	ResourceUpload        ResourceKind = "upload"
+)
+
+// RoutePolicy declares the access a route needs
+type RoutePolicy struct {
+	Access   AccessLevel
+	Resource ResourceKind // Kind of resource an AccessOwner route names
+	Param    string       // Path parameter, or else query parameter, holding the resource ID
+	Write    bool         // The route changes the resource, so public resources are closed to it
+}
+
+// OwnerLookup returns the subject that owns a resource, or "" for a public resource
+// anyone may use
+type OwnerLookup func(id string) (string, error)
+
+// Authorizer enforces route policies in front of the handlers. It resolves the subject
+// of each request once and stores it in the context, where SessionService.GetSubject
+// and GetOrCreateSubject return it without validating the token again.
+type Authorizer struct {
+	SessionService *SessionService
+	Owners         map[ResourceKind]OwnerLookup
+}
+
+// NewAuthorizer creates a new Authorizer instance. Trackers may be nil, in which case
+// ownership of their resources is left to the handlers.
+func NewAuthorizer(sessionService *SessionService, jobTracker JobTracker, datasetTracker DatasetTracker, conversationTracker ConversationTracker, vizTracker VisualizationTracker, uploadTracker UploadTracker) *Authorizer {
+	owners := map[ResourceKind]OwnerLookup{}
+	if jobTracker != nil {
+		owners[ResourceJob] = jobTracker.GetJobOwner
+	}
+	if datasetTracker != nil {
+		owners[ResourceDataset] = func(id string) (string, error) {
+			owner, err := datasetTracker.GetOwner(id)
+			// Datasets without an owner are public, as in GetByUser
+			if errors.Is(err, ErrDatasetNoOwner) {
+				return "", nil
+			}
+			return owner, err
+		}
+	}
+	if conversationTracker != nil {
+		owners[ResourceConversation] = conversationTracker.GetConversationOwner
+	}
+	if vizTracker != nil {
+		owners[ResourceVisualization] = vizTracker.GetOwner
+	}
+	if uploadTracker != nil {
+		owners[ResourceUpload] = func(id string) (string, error) {
+			upload, err := uploadTracker.Get(id)
+			if err != nil {
+				return "", err
+			}
+			return upload.UserID, nil
+		}
+	}
+
+	return &Authorizer{
+		SessionService: sessionService,
+		Owners:         owners,
+	}
+}
+
+// Middleware returns the handler that enforces a policy before a route's handler runs
+func (a *Authorizer) Middleware(policy RoutePolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Without a session service there are no tokens, as in the handlers
+		if a.SessionService == nil || policy.Access == AccessPublic {
+			return
+		}
+
+		var subject string
+		var err error
+		if policy.Access == AccessNewSession {
+			subject, err = a.SessionService.GetOrCreateSubject(c)
+			if err != nil {
+				writeSessionError(c, err)
+				return
+			}
+		} else {
+			subject, err = a.SessionService.GetSubject(c)
+			if errors.Is(err, ErrAccessTokenExpired) {
+				writeSessionError(c, err)
+				return
+			}
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - valid token required"})
+				return
+			}
+		}
+
+		if policy.Access == AccessOwner {
+			a.checkOwner(c, policy, subject)
+		}
+	}
+}
+
+// writeSessionError responds to a request whose session could not be resolved. An
+// expired access token gets a 401 pointing at the refresh endpoint.
+func writeSessionError(c *gin.Context, err error) {
+	if errors.Is(err, ErrAccessTokenExpired) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - access token has expired; renew it with POST /api/v1/auth/refresh"})
+		return
+	}
+	log.Printf("Error with session: %v", err)
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create or validate session"})
+}
+
+// checkOwner aborts the request unless the subject owns the resource the route names.
+// A missing ID is left to the handler, which reports it as a bad request.
+func (a *Authorizer) checkOwner(c *gin.Context, policy RoutePolicy, subject string) {
+	id := c.Param(policy.Param)
+	if id == "" {
+		id = c.Query(policy.Param)
+	}
+	if id == "" {
+		return
+	}
+
+	lookup := a.Owners[policy.Resource]
+	if lookup == nil {
+		log.Printf("Warning: No owner lookup for %s resources, skipping ownership check", policy.Resource)
+		return
+	}
+	owner, err := lookup(id)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("%s not found", resourceTitle(policy.Resource))})
+			return
+		}
+		if strings.Contains(err.Error(), "not supported") {
+			log.Printf("Warning: User tracking not supported for %s resources, allowing access to %s", policy.Resource, id)
+			return
+		}
+		if strings.Contains(err.Error(), "failed to") {
+			log.Printf("Error checking owner of %s %s: %v", policy.Resource, id, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to check %s ownership", policy.Resource)})
+			return
+		}
+		// Other resources without an owner belong to no one, so no subject may use them
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Forbidden - you do not have access to this %s", policy.Resource)})
+		return
+	}
+	// Anyone may read a public resource, but no one may change it
+	if owner == "" && policy.Write {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Forbidden - public %ss cannot be changed", policy.Resource)})
+		return
+	}
+	if owner != "" && owner != subject {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Forbidden - you do not have access to this %s", policy.Resource)})
+	}
+}
+
+// resourceTitle returns the name of a resource kind for the start of a message
+func resourceTitle(kind ResourceKind) string {
+	name := string(kind)
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
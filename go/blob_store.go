@@ -0,0 +1,227 @@
+package datamonkey
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrBlobNotFound is returned when a key has no blob in the store
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobInfo describes a stored blob
+type BlobInfo struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// BlobStore stores dataset and result files by key, independent of where they live.
+// Keys are slash-separated relative paths, e.g. a dataset ID or a result file name.
+type BlobStore interface {
+	// Put stores the content read from r under key, replacing any existing blob
+	Put(key string, r io.Reader) (BlobInfo, error)
+
+	// Get opens a blob for reading; the caller closes it
+	Get(key string) (io.ReadCloser, error)
+
+	// Stat describes a blob, returning ErrBlobNotFound if it does not exist
+	Stat(key string) (BlobInfo, error)
+
+	// Delete removes a blob; deleting a missing blob is not an error
+	Delete(key string) error
+
+	// Stage returns where a job reads the blob on its compute node, and for remote
+	// stores the URL the job downloads it from first. Remote stores return
+	// ErrBlobNotFound if the blob does not exist, rather than failing the job later.
+	Stage(key string) (StagedBlob, error)
+}
+
+// StagedBlob is a blob as a job sees it on its compute node
+type StagedBlob struct {
+	Path string // Where the job reads the blob
+	URL  string // Where the job downloads the blob to Path before it runs; empty if Path is already readable
+}
+
+// ReadBlob reads a whole blob into memory
+func ReadBlob(store BlobStore, key string) ([]byte, error) {
+	r, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// moveIntoStore stores the file at path under key and removes it. Filesystem stores
+// rename the file into place instead of copying it.
+func moveIntoStore(store BlobStore, key string, path string) error {
+	if fs, ok := store.(*FileBlobStore); ok {
+		dst, err := fs.path(key)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		return moveFile(path, dst)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := store.Put(key, f); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// validBlobKey reports whether key names a blob below the store's root
+func validBlobKey(key string) bool {
+	local := filepath.FromSlash(key)
+	return filepath.IsLocal(local) && filepath.Clean(local) != "."
+}
+
+// moveBlob moves a blob to a new key within a store
+func moveBlob(store BlobStore, from string, to string) error {
+	if fs, ok := store.(*FileBlobStore); ok {
+		src, err := fs.path(from)
+		if err != nil {
+			return err
+		}
+		return moveIntoStore(fs, to, src)
+	}
+
+	r, err := store.Get(from)
+	if err != nil {
+		return err
+	}
+	_, err = store.Put(to, r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	return store.Delete(from)
+}
+
+// FileBlobStore is a BlobStore on the local (or a shared) filesystem
+type FileBlobStore struct {
+	Root string
+}
+
+// NewFileBlobStore creates a new FileBlobStore rooted at a directory
+func NewFileBlobStore(root string) *FileBlobStore {
+	return &FileBlobStore{Root: root}
+}
+
+// path maps a key to a file under the root, rejecting keys that would escape it
+func (s *FileBlobStore) path(key string) (string, error) {
+	if !validBlobKey(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.Root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the blob to a temporary file and renames it into place, so readers never see a partial blob
+func (s *FileBlobStore) Put(key string, r io.Reader) (BlobInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return BlobInfo{}, fmt.Errorf("failed to create blob directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".blob-*")
+	if err != nil {
+		return BlobInfo{}, fmt.Errorf("failed to create blob: %v", err)
+	}
+	size, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return BlobInfo{}, fmt.Errorf("failed to write blob %s: %v", key, err)
+	}
+	return BlobInfo{Key: key, Size: size, Modified: time.Now()}, nil
+}
+
+// Get opens the blob's file
+func (s *FileBlobStore) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+	return f, err
+}
+
+// Stat describes the blob's file
+func (s *FileBlobStore) Stat(key string) (BlobInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return BlobInfo{}, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Key: key, Size: info.Size(), Modified: info.ModTime()}, nil
+}
+
+// Delete removes the blob's file
+func (s *FileBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete blob %s: %v", key, err)
+	}
+	return nil
+}
+
+// Stage returns the blob's file, which jobs read from the shared filesystem
+func (s *FileBlobStore) Stage(key string) (StagedBlob, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return StagedBlob{}, err
+	}
+	return StagedBlob{Path: path}, nil
+}
+
+// jobOutputStore returns the store holding a job output file and its key there. Without a
+// configured result store, outputs are read from the directory HyPhy wrote them to.
+func jobOutputStore(results BlobStore, outputPath string) (BlobStore, string) {
+	if results == nil {
+		return NewFileBlobStore(filepath.Dir(outputPath)), filepath.Base(outputPath)
+	}
+	return results, filepath.Base(outputPath)
+}
+
+// readJobOutput reads a job output file, such as its results JSON, from the result store
+func readJobOutput(results BlobStore, outputPath string) ([]byte, error) {
+	store, key := jobOutputStore(results, outputPath)
+	return ReadBlob(store, key)
+}
+
+// Ensure FileBlobStore implements BlobStore interface
+var _ BlobStore = (*FileBlobStore)(nil)
@@ -0,0 +1,326 @@
+package datamonkey
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config holds the settings for an S3-compatible object store such as AWS S3 or MinIO
+type S3Config struct {
+	Endpoint        string // Base URL, e.g. https://s3.us-east-1.amazonaws.com or http://minio:9000
+	Region          string // Signing region; MinIO accepts us-east-1
+	Bucket          string
+	Prefix          string // Prepended to every key, e.g. "datasets/"
+	AccessKeyID     string
+	SecretAccessKey string
+	StagingDir      string        // Directory on the compute nodes jobs download blobs to before HyPhy runs
+	PresignExpiry   time.Duration // How long the download URLs handed to jobs stay valid; at most 7 days
+}
+
+// maxPresignExpiry is the longest validity AWS Signature Version 4 allows a presigned URL
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// S3BlobStore is a BlobStore backed by an S3-compatible object store. Requests use
+// path-style URLs and AWS Signature Version 4, which MinIO and AWS both accept.
+type S3BlobStore struct {
+	Config S3Config
+	Client *http.Client
+	now    func() time.Time
+}
+
+// NewS3BlobStore creates a new S3BlobStore
+func NewS3BlobStore(config S3Config) (*S3BlobStore, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, fmt.Errorf("S3 endpoint and bucket are required")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.PresignExpiry <= 0 || config.PresignExpiry > maxPresignExpiry {
+		config.PresignExpiry = maxPresignExpiry
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+
+	return &S3BlobStore{
+		Config: config,
+		Client: &http.Client{Timeout: 10 * time.Minute},
+		now:    time.Now,
+	}, nil
+}
+
+// objectPath returns the request path of the object stored under key
+func (s *S3BlobStore) objectPath(key string) (string, error) {
+	if !validBlobKey(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return "/" + s.Config.Bucket + "/" + s.Config.Prefix + key, nil
+}
+
+// do sends a signed request for key; body may be nil
+func (s *S3BlobStore) do(method string, key string, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	path, err := s.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, s.Config.Endpoint+s3EscapePath(path), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, payloadHash)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s %s failed: %v", method, key, err)
+	}
+	return resp, nil
+}
+
+// s3Error reads an unsuccessful response into an error
+func s3Error(method string, key string, resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("S3 %s %s failed: %s %s", method, key, resp.Status, strings.TrimSpace(string(detail)))
+}
+
+// Put uploads the blob. The content is spooled to a temporary file first, since
+// S3 needs its length and SHA-256 before the upload starts.
+func (s *S3BlobStore) Put(key string, r io.Reader) (BlobInfo, error) {
+	spool, err := os.CreateTemp("", "blob-*")
+	if err != nil {
+		return BlobInfo{}, fmt.Errorf("failed to spool blob: %v", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	digest := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, digest), r)
+	if err != nil {
+		return BlobInfo{}, fmt.Errorf("failed to spool blob: %v", err)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return BlobInfo{}, fmt.Errorf("failed to spool blob: %v", err)
+	}
+
+	resp, err := s.do(http.MethodPut, key, spool, size, hex.EncodeToString(digest.Sum(nil)))
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return BlobInfo{}, s3Error(http.MethodPut, key, resp)
+	}
+	resp.Body.Close()
+	return BlobInfo{Key: key, Size: size, Modified: s.now()}, nil
+}
+
+// Get downloads the blob
+func (s *S3BlobStore) Get(key string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, key, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error(http.MethodGet, key, resp)
+	}
+	return resp.Body, nil
+}
+
+// Stat describes the blob from a HEAD request
+func (s *S3BlobStore) Stat(key string) (BlobInfo, error) {
+	resp, err := s.do(http.MethodHead, key, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return BlobInfo{}, s3Error(http.MethodHead, key, resp)
+	}
+	resp.Body.Close()
+
+	info := BlobInfo{Key: key, Size: resp.ContentLength}
+	if size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil {
+		info.Size = size
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.Modified = modified
+	}
+	return info, nil
+}
+
+// Delete removes the blob
+func (s *S3BlobStore) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	// S3 answers 204 whether or not the object existed; some stand-ins answer 404
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(http.MethodDelete, key, resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Stage checks that the blob exists and returns a presigned URL the job downloads it
+// from into the staging directory on its compute node, so the API host never copies it
+func (s *S3BlobStore) Stage(key string) (StagedBlob, error) {
+	if s.Config.StagingDir == "" {
+		return StagedBlob{}, fmt.Errorf("S3 staging directory is not configured")
+	}
+	if _, err := s.Stat(key); err != nil {
+		return StagedBlob{}, err
+	}
+	downloadURL, err := s.PresignGet(key, s.Config.PresignExpiry)
+	if err != nil {
+		return StagedBlob{}, err
+	}
+	return StagedBlob{
+		Path: path.Join(s.Config.StagingDir, key),
+		URL:  downloadURL,
+	}, nil
+}
+
+// PresignGet returns a URL anyone can download the blob from until it expires, signed
+// in the query string as AWS Signature Version 4 allows
+func (s *S3BlobStore) PresignGet(key string, expiry time.Duration) (string, error) {
+	objectPath, err := s.objectPath(key)
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := url.Parse(s.Config.Endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid S3 endpoint: %v", err)
+	}
+	escapedPath := s3EscapePath(endpoint.Path + objectPath)
+	if s.Config.AccessKeyID == "" {
+		return s.Config.Endpoint + s3EscapePath(objectPath), nil
+	}
+
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	scope := day + "/" + s.Config.Region + "/s3/aws4_request"
+
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.Config.AccessKeyID + "/" + scope},
+		"X-Amz-Date":          {amzDate},
+		"X-Amz-Expires":       {strconv.Itoa(int(expiry.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	// S3 wants spaces in the query encoded as %20
+	canonicalQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		escapedPath,
+		canonicalQuery,
+		"host:" + endpoint.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	signature := hex.EncodeToString(hmacSHA256(s.signingKey(day), stringToSign))
+
+	return endpoint.Scheme + "://" + endpoint.Host + escapedPath + "?" + canonicalQuery + "&X-Amz-Signature=" + signature, nil
+}
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds AWS Signature Version 4 headers to a request
+func (s *S3BlobStore) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.Config.AccessKeyID == "" {
+		return
+	}
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := day + "/" + s.Config.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signature := hex.EncodeToString(hmacSHA256(s.signingKey(day), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.Config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// signingKey derives the Signature Version 4 key for a day
+func (s *S3BlobStore) signingKey(day string) []byte {
+	key := hmacSHA256([]byte("AWS4"+s.Config.SecretAccessKey), day)
+	key = hmacSHA256(key, s.Config.Region)
+	key = hmacSHA256(key, "s3")
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath percent-encodes every byte of a path except unreserved characters and
+// slashes, as S3 signing requires
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Ensure S3BlobStore implements BlobStore interface
+var _ BlobStore = (*S3BlobStore)(nil)
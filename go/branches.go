@@ -0,0 +1,279 @@
+package datamonkey
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Branch selection keywords HyPhy accepts in place of branch names
+const (
+	BranchesAll       = "All"
+	BranchesInternal  = "Internal"
+	BranchesLeaves    = "Leaves"
+	BranchesUnlabeled = "Unlabeled branches"
+)
+
+// BranchSelectionKeywords lists the keywords every tree supports
+var BranchSelectionKeywords = []string{BranchesAll, BranchesInternal, BranchesLeaves, BranchesUnlabeled}
+
+// branchSelectionFields are the request fields that select branches of the tree.
+// Branches is a list for most methods and a comma-separated string for BGM.
+var branchSelectionFields = []string{"Branches", "TestBranches", "ReferenceBranches", "BranchSets"}
+
+// isBranchSelectionField reports whether a request field selects branches of the tree
+func isBranchSelectionField(name string) bool {
+	for _, field := range branchSelectionFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hyPhyBranches writes a branch selection as the value of a HyPhy branch option, which
+// names label groups without the braces the API accepts around them
+func hyPhyBranches(selection []string) string {
+	items := make([]string, len(selection))
+	for i, item := range selection {
+		item = strings.TrimSpace(item)
+		if strings.HasPrefix(item, "{") && strings.HasSuffix(item, "}") {
+			item = strings.TrimSpace(item[1 : len(item)-1])
+		}
+		items[i] = item
+	}
+	return strings.Join(items, ",")
+}
+
+// Branches returns every node except the root, each the end of one branch, in preorder
+func (t *Tree) Branches() []*TreeNode {
+	var branches []*TreeNode
+	t.Root.walk(func(node *TreeNode) {
+		if node != t.Root {
+			branches = append(branches, node)
+		}
+	})
+	return branches
+}
+
+// ResolveBranches returns the branches selected by a list of branch names, HyPhy
+// {label} groups (written with or without braces) and keywords. Unknown and empty
+// items are returned separately so they can all be reported.
+func (t *Tree) ResolveBranches(selection []string) ([]*TreeNode, []string) {
+	labels := map[string]bool{}
+	for _, label := range t.BranchLabels() {
+		labels[label] = true
+	}
+
+	selected := map[*TreeNode]bool{}
+	var unknown []string
+	for _, item := range selection {
+		item = strings.TrimSpace(item)
+		var match func(*TreeNode) bool
+		switch {
+		case item == "":
+			unknown = append(unknown, item)
+			continue
+		case strings.EqualFold(item, BranchesAll):
+			match = func(*TreeNode) bool { return true }
+		case strings.EqualFold(item, BranchesInternal):
+			match = func(node *TreeNode) bool { return !node.IsLeaf() }
+		case strings.EqualFold(item, BranchesLeaves):
+			match = func(node *TreeNode) bool { return node.IsLeaf() }
+		case strings.EqualFold(item, BranchesUnlabeled):
+			match = func(node *TreeNode) bool { return node.Label == "" }
+		case strings.HasPrefix(item, "{") && strings.HasSuffix(item, "}"):
+			label := strings.TrimSpace(item[1 : len(item)-1])
+			if !labels[label] {
+				unknown = append(unknown, item)
+				continue
+			}
+			match = func(node *TreeNode) bool { return node.Label == label }
+		default:
+			found := false
+			for _, node := range t.Branches() {
+				if node.Name == item {
+					selected[node] = true
+					found = true
+				}
+			}
+			if found {
+				continue
+			}
+			if !labels[item] {
+				unknown = append(unknown, item)
+				continue
+			}
+			match = func(node *TreeNode) bool { return node.Label == item }
+		}
+		for _, node := range t.Branches() {
+			if match(node) {
+				selected[node] = true
+			}
+		}
+	}
+
+	var branches []*TreeNode
+	for _, node := range t.Branches() {
+		if selected[node] {
+			branches = append(branches, node)
+		}
+	}
+	return branches, unknown
+}
+
+// BranchSelectionError lists every branch selection that does not resolve against the tree
+type BranchSelectionError struct {
+	Problems []InvalidDataErrorErrorsInner
+}
+
+func (e *BranchSelectionError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = fmt.Sprintf("%s: %s", problem.Field, problem.Message)
+	}
+	return fmt.Sprintf("invalid branch selection: %s", strings.Join(messages, "; "))
+}
+
+// InvalidData returns the problems in the shape of the API's InvalidDataError
+func (e *BranchSelectionError) InvalidData() InvalidDataError {
+	return InvalidDataError{Errors: e.Problems}
+}
+
+// branchSelection is the branches a request field selects
+type branchSelection struct {
+	field string
+	items []string
+}
+
+// requestBranchSelections returns the non-empty branch selections of a request, keyed by JSON field name
+func requestBranchSelections(request interface{}) []branchSelection {
+	if hyPhyReq, ok := request.(HyPhyRequest); ok {
+		request = originalRequest(hyPhyReq)
+	}
+	value := reflect.ValueOf(request)
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var selections []branchSelection
+	for _, name := range branchSelectionFields {
+		structField, ok := value.Type().FieldByName(name)
+		if !ok {
+			continue
+		}
+		field := value.FieldByIndex(structField.Index)
+		selection := branchSelection{field: strings.Split(structField.Tag.Get("json"), ",")[0]}
+		switch {
+		case field.Kind() == reflect.String && field.String() != "":
+			selection.items = strings.Split(field.String(), ",")
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+			for i := 0; i < field.Len(); i++ {
+				selection.items = append(selection.items, field.Index(i).String())
+			}
+		}
+		if len(selection.items) > 0 {
+			selections = append(selections, selection)
+		}
+	}
+	return selections
+}
+
+// CheckBranchSelections resolves each branch selection of a request against the tree and
+// reports unknown branches, unknown labels and selections that match no branch
+func CheckBranchSelections(tree *Tree, request interface{}) error {
+	var problems []InvalidDataErrorErrorsInner
+	for _, selection := range requestBranchSelections(request) {
+		branches, unknown := tree.ResolveBranches(selection.items)
+		if containsString(unknown, "") {
+			problems = append(problems, InvalidDataErrorErrorsInner{
+				Field:   selection.field,
+				Message: fmt.Sprintf("%s has an empty selection; remove it or name a branch, {label} group or keyword", quotedList(selection.items)),
+			})
+			continue
+		}
+		if len(unknown) > 0 {
+			problems = append(problems, InvalidDataErrorErrorsInner{
+				Field: selection.field,
+				Message: fmt.Sprintf("%s not in the tree; choose branch names, {label} groups (%s) or one of %s",
+					quotedList(unknown), strings.Join(labelGroups(tree), ", "), strings.Join(BranchSelectionKeywords, ", ")),
+			})
+			continue
+		}
+		if len(branches) == 0 {
+			problems = append(problems, InvalidDataErrorErrorsInner{
+				Field:   selection.field,
+				Message: fmt.Sprintf("%s selects no branches of the tree", quotedList(selection.items)),
+			})
+		}
+	}
+
+	if len(problems) > 0 {
+		return &BranchSelectionError{Problems: problems}
+	}
+	return nil
+}
+
+// labelGroups returns the tree's branch labels written as HyPhy {label} groups, or "none"
+func labelGroups(tree *Tree) []string {
+	labels := tree.BranchLabels()
+	if len(labels) == 0 {
+		return []string{"none"}
+	}
+	groups := make([]string, len(labels))
+	for i, label := range labels {
+		groups[i] = "{" + label + "}"
+	}
+	return groups
+}
+
+// ListBranches describes the selectable branches and label groups of a tree
+func (t *Tree) ListBranches() DatasetBranches {
+	list := DatasetBranches{
+		Rooted:   t.Rooted,
+		Keywords: BranchSelectionKeywords,
+		Branches: []TreeBranch{},
+		Labels:   []BranchLabelGroup{},
+	}
+
+	members := map[string][]string{}
+	for _, node := range t.Branches() {
+		if node.Label != "" && node.Name != "" {
+			members[node.Label] = append(members[node.Label], node.Name)
+		}
+		if node.Name == "" {
+			continue
+		}
+		branch := TreeBranch{Name: node.Name, Leaf: node.IsLeaf(), Label: node.Label}
+		if node.HasLength {
+			length := node.Length
+			branch.Length = &length
+		}
+		list.Branches = append(list.Branches, branch)
+	}
+
+	for _, label := range t.BranchLabels() {
+		count := 0
+		for _, node := range t.Branches() {
+			if node.Label == label {
+				count++
+			}
+		}
+		names := append([]string{}, members[label]...)
+		sort.Strings(names)
+		list.Labels = append(list.Labels, BranchLabelGroup{
+			Label:       label,
+			Selector:    "{" + label + "}",
+			BranchCount: int32(count),
+			Branches:    names,
+		})
+	}
+	return list
+}
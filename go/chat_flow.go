@@ -126,6 +126,18 @@ type GetDatasetDetailsOutput struct {
 	Error       string `json:"error,omitempty"`
 }
 
+// ListTreeBranchesInput represents the input for listing the branches of a tree dataset
+type ListTreeBranchesInput struct {
+	UserToken string `json:"user_token,omitempty" jsonschema:"description=User authentication token"`
+	DatasetID string `json:"dataset_id" jsonschema:"description=ID of the tree dataset"`
+}
+
+// ListTreeBranchesOutput represents the output for listing the branches of a tree dataset
+type ListTreeBranchesOutput struct {
+	Branches DatasetBranches `json:"branches"`
+	Error    string          `json:"error,omitempty"`
+}
+
 // GetJobByIdInput represents the input for getting a job by ID
 type GetJobByIdInput struct {
 	UserToken string `json:"user_token,omitempty" jsonschema:"description=User authentication token (optional for public jobs)"`
@@ -470,6 +482,52 @@ func (c *GenkitClient) ChatFlow() (any, error) {
 				}, nil
 			})
 
+		// Define a tool for listing the branches of a tree, so branch selections use real names and labels
+		listTreeBranchesTool := genkit.DefineTool[ListTreeBranchesInput, ListTreeBranchesOutput](c.Genkit, "listTreeBranches",
+			"List the branch names, HyPhy {label} groups and keywords of a tree dataset that can be used for branches, test_branches or branch_sets",
+			func(ctx *ai.ToolContext, input ListTreeBranchesInput) (ListTreeBranchesOutput, error) {
+				if input.DatasetID == "" {
+					return ListTreeBranchesOutput{Error: "dataset_id is required"}, nil
+				}
+
+				client := &http.Client{}
+				url := fmt.Sprintf("%s/api/v1/datasets/%s/branches", baseURL, input.DatasetID)
+				req, err := http.NewRequest("GET", url, nil)
+				if err != nil {
+					return ListTreeBranchesOutput{Error: fmt.Sprintf("failed to create request: %v", err)}, nil
+				}
+
+				// Add user token header if provided
+				if input.UserToken != "" {
+					req.Header.Set("user_token", input.UserToken)
+				}
+
+				resp, err := client.Do(req)
+				if err != nil {
+					return ListTreeBranchesOutput{Error: fmt.Sprintf("failed to send request: %v", err)}, nil
+				}
+				defer resp.Body.Close()
+
+				if resp.StatusCode == http.StatusNotFound {
+					return ListTreeBranchesOutput{Error: "dataset not found"}, nil
+				}
+
+				if resp.StatusCode == http.StatusUnprocessableEntity {
+					return ListTreeBranchesOutput{Error: "dataset is not a valid tree"}, nil
+				}
+
+				if resp.StatusCode != http.StatusOK {
+					return ListTreeBranchesOutput{Error: fmt.Sprintf("unexpected status code: %d", resp.StatusCode)}, nil
+				}
+
+				var result DatasetBranches
+				if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+					return ListTreeBranchesOutput{Error: fmt.Sprintf("failed to parse response: %v", err)}, nil
+				}
+
+				return ListTreeBranchesOutput{Branches: result}, nil
+			})
+
 		// Define a tool for getting a job by ID
 		getJobByIdTool := genkit.DefineTool[GetJobByIdInput, GetJobByIdOutput](c.Genkit, "getJobById",
 			"Get detailed information about a specific job by ID",
@@ -921,6 +979,7 @@ func (c *GenkitClient) ChatFlow() (any, error) {
 					listDatasetsTool,
 					checkDatasetExistsTool,
 					getDatasetDetailsTool,
+					listTreeBranchesTool,
 					getAvailableMethodsTool,
 					getJobResultsTool,
 					getJobByIdTool,
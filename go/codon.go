@@ -0,0 +1,219 @@
+package datamonkey
+
+import (
+	"fmt"
+	"strings"
+)
+
+// codonMethods are the HyPhy analyses that fit codon models and so need an in-frame
+// nucleotide alignment without stop codons
+var codonMethods = map[HyPhyMethodType]bool{
+	MethodFEL:         true,
+	MethodMEME:        true,
+	MethodBUSTED:      true,
+	MethodABSREL:      true,
+	MethodSLAC:        true,
+	MethodFUBAR:       true,
+	MethodRELAX:       true,
+	MethodCONTRASTFEL: true,
+	MethodMULTIHIT:    true,
+}
+
+// IsCodonMethod reports whether the method fits a codon model
+func IsCodonMethod(methodType HyPhyMethodType) bool {
+	return codonMethods[methodType]
+}
+
+// maxReportedStopCodons caps the stop codons listed in a validation error so a
+// badly framed alignment does not produce a response with thousands of entries
+const maxReportedStopCodons = 20
+
+// universalCode is the standard genetic code for codons ordered TTT, TTC, TTA, ..., GGG
+const universalCode = "FFLLSSSSYY**CC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG"
+
+// geneticCodeChanges are the codons each genetic code translates differently from the universal code
+var geneticCodeChanges = map[GeneticCode]map[string]byte{
+	UNIVERSAL:             {},
+	VERTEBRATE_MT_DNA:     {"AGA": '*', "AGG": '*', "ATA": 'M', "TGA": 'W'},
+	YEAST_MT_DNA:          {"ATA": 'M', "CTT": 'T', "CTC": 'T', "CTA": 'T', "CTG": 'T', "TGA": 'W'},
+	MOLD_PROTOZOAN_MT_DNA: {"TGA": 'W'},
+	INVERTEBRATE_MT_DNA:   {"AGA": 'S', "AGG": 'S', "ATA": 'M', "TGA": 'W'},
+	CILIATE_NUCLEAR:       {"TAA": 'Q', "TAG": 'Q'},
+	ECHINODERM_MT_DNA:     {"AAA": 'N', "AGA": 'S', "AGG": 'S', "TGA": 'W'},
+	EUPLTOID_NUCLEAR:      {"TGA": 'C'},
+	ALT__YEAST_NUCLEAR:    {"CTG": 'S'},
+	ASCIDIAN_MT_DNA:       {"AGA": 'G', "AGG": 'G', "ATA": 'M', "TGA": 'W'},
+	FLATWORM_MT_DNA:       {"AAA": 'N', "AGA": 'S', "AGG": 'S', "TAA": 'Y', "TGA": 'W'},
+	BLEPHARISMA_NUCLEAR:   {"TAG": 'Q'},
+}
+
+// codonIndex returns the position of an unambiguous codon in universalCode, or -1
+func codonIndex(codon string) int {
+	index := 0
+	for i := 0; i < 3; i++ {
+		n := strings.IndexByte("TCAG", codon[i])
+		if n < 0 {
+			return -1
+		}
+		index = index*4 + n
+	}
+	return index
+}
+
+// TranslateCodon returns the amino acid a codon encodes under a genetic code, '*' for stop
+// codons, 'X' for codons with ambiguous or missing characters and '-' for gap codons.
+// An empty genetic code means the universal code.
+func TranslateCodon(code GeneticCode, codon string) (byte, error) {
+	if code == "" {
+		code = UNIVERSAL
+	}
+	changes, ok := geneticCodeChanges[code]
+	if !ok {
+		return 0, fmt.Errorf("unknown genetic code %q", code)
+	}
+	if len(codon) != 3 {
+		return 0, fmt.Errorf("codon %q must have 3 characters", codon)
+	}
+
+	normalized := make([]byte, 3)
+	gaps := 0
+	for i := 0; i < 3; i++ {
+		c := upper(codon[i])
+		if c == 'U' {
+			c = 'T'
+		}
+		if c == '-' || c == '.' || c == '~' {
+			gaps++
+		}
+		normalized[i] = c
+	}
+	if gaps == 3 {
+		return '-', nil
+	}
+	if aa, ok := changes[string(normalized)]; ok {
+		return aa, nil
+	}
+	index := codonIndex(string(normalized))
+	if index < 0 {
+		return 'X', nil
+	}
+	return universalCode[index], nil
+}
+
+// CodonValidationError lists every problem that stops an alignment from being analyzed with a codon model
+type CodonValidationError struct {
+	Method   HyPhyMethodType
+	Problems []InvalidDataErrorErrorsInner
+}
+
+func (e *CodonValidationError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.Message
+	}
+	return fmt.Sprintf("invalid codon alignment for %s analysis: %s", e.Method, strings.Join(messages, "; "))
+}
+
+// InvalidData returns the problems in the shape of the API's InvalidDataError
+func (e *CodonValidationError) InvalidData() InvalidDataError {
+	return InvalidDataError{Errors: e.Problems}
+}
+
+// StopCodon is an in-frame stop codon found in an alignment
+type StopCodon struct {
+	Sequence string
+	// Triplet is the codon as written in the alignment
+	Triplet string
+	// Codon is the 1-based codon position; Site is the 1-based position of its first nucleotide
+	Codon int
+	Site  int
+	Line  int
+}
+
+// FindStopCodons returns the in-frame stop codons of every sequence under a genetic code.
+// Codons containing gaps or ambiguous characters are never stop codons.
+func (a *Alignment) FindStopCodons(code GeneticCode) ([]StopCodon, error) {
+	var stops []StopCodon
+	for _, sequence := range a.Sequences {
+		for site := 0; site+3 <= len(sequence.Data); site += 3 {
+			aa, err := TranslateCodon(code, sequence.Data[site:site+3])
+			if err != nil {
+				return nil, err
+			}
+			if aa == '*' {
+				stops = append(stops, StopCodon{
+					Sequence: sequence.Name,
+					Triplet:  strings.ToUpper(sequence.Data[site : site+3]),
+					Codon:    site/3 + 1,
+					Site:     site + 1,
+					Line:     sequence.Line,
+				})
+			}
+		}
+	}
+	return stops, nil
+}
+
+// ValidateCodons checks that an alignment can be analyzed by a codon model under a
+// genetic code: it must hold nucleotides, have a length that is a multiple of 3 and
+// contain no in-frame stop codons. All problems are reported together.
+func (a *Alignment) ValidateCodons(methodType HyPhyMethodType, code GeneticCode) error {
+	if code == "" {
+		code = UNIVERSAL
+	}
+	if _, ok := geneticCodeChanges[code]; !ok {
+		return &CodonValidationError{Method: methodType, Problems: []InvalidDataErrorErrorsInner{{
+			Field:   "genetic_code",
+			Message: fmt.Sprintf("unknown genetic code %q", code),
+		}}}
+	}
+
+	stats := a.Stats()
+	if stats.Alphabet != "nucleotide" {
+		return &CodonValidationError{Method: methodType, Problems: []InvalidDataErrorErrorsInner{{
+			Field: "alignment",
+			Message: fmt.Sprintf("alignment looks like protein data; %s fits a codon model and needs an in-frame nucleotide alignment",
+				methodType),
+		}}}
+	}
+
+	var problems []InvalidDataErrorErrorsInner
+	if stats.AlignmentLength%3 != 0 {
+		problems = append(problems, InvalidDataErrorErrorsInner{
+			Field: "alignment",
+			Message: fmt.Sprintf("alignment length %d is not a multiple of 3 (%d extra site(s)); trim it to whole codons",
+				stats.AlignmentLength, stats.AlignmentLength%3),
+		})
+	}
+
+	stops, err := a.FindStopCodons(code)
+	if err != nil {
+		return err
+	}
+	for i, stop := range stops {
+		if i == maxReportedStopCodons {
+			problems = append(problems, InvalidDataErrorErrorsInner{
+				Field:   "alignment",
+				Message: fmt.Sprintf("%d more stop codon(s) not listed", len(stops)-maxReportedStopCodons),
+			})
+			break
+		}
+		problems = append(problems, InvalidDataErrorErrorsInner{
+			Field: "alignment",
+			Message: fmt.Sprintf("sequence %q has a stop codon %s at codon %d (site %d) under the %s genetic code",
+				stop.Sequence, stop.Triplet, stop.Codon, stop.Site, code),
+			Line: int32(stop.Line),
+		})
+	}
+	if len(stops) > 0 {
+		problems = append(problems, InvalidDataErrorErrorsInner{
+			Field:   "alignment",
+			Message: "remove or mask stop codons (e.g. replace with ---), or check the reading frame and genetic_code",
+		})
+	}
+
+	if len(problems) > 0 {
+		return &CodonValidationError{Method: methodType, Problems: problems}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package datamonkey
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Command is a program invocation as an argument vector and extra environment
+// variables, so that user-supplied values such as branch names stay single arguments.
+// Schedulers that go through a shell render it with String, which quotes every word.
+type Command struct {
+	Args []string          // The program followed by its arguments
+	Env  map[string]string // Variables set for the program on top of the scheduler's environment
+
+	// Fetch lists blobs the job downloads onto its compute node before the program runs
+	Fetch []StagedBlob
+}
+
+// NewCommand returns a command running a program with arguments
+func NewCommand(args ...string) Command {
+	return Command{Args: args}
+}
+
+// With returns a copy of the command with more arguments appended
+func (c Command) With(args ...string) Command {
+	combined := make([]string, 0, len(c.Args)+len(args))
+	combined = append(combined, c.Args...)
+	c.Args = append(combined, args...)
+	return c
+}
+
+// IsEmpty reports whether the command has no program
+func (c Command) IsEmpty() bool {
+	return len(c.Args) == 0 || c.Args[0] == ""
+}
+
+// Environ returns the command's environment variables as sorted NAME=value pairs
+func (c Command) Environ() []string {
+	environ := make([]string, 0, len(c.Env))
+	for name, value := range c.Env {
+		environ = append(environ, name+"="+value)
+	}
+	sort.Strings(environ)
+	return environ
+}
+
+// String renders the command as a POSIX shell command line, preceded by its
+// environment variable assignments. Every word is quoted when it needs to be, so the
+// shell runs the program with exactly Args and Env.
+func (c Command) String() string {
+	words := make([]string, 0, len(c.Env)+len(c.Args))
+	for _, pair := range c.Environ() {
+		name, value, _ := strings.Cut(pair, "=")
+		words = append(words, name+"="+ShellQuote(value))
+	}
+	for _, arg := range c.Args {
+		words = append(words, ShellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// Script renders the command as a shell command line that first downloads the blobs in
+// Fetch, then runs the program if every download succeeded. Downloads go to a temporary
+// name and are renamed into place, so jobs sharing a node never read a partial blob,
+// and blobs already on the node are not downloaded again.
+func (c Command) Script() string {
+	steps := make([]string, 0, len(c.Fetch)+1)
+	for _, blob := range c.Fetch {
+		if blob.URL == "" {
+			continue
+		}
+		target := ShellQuote(blob.Path)
+		partial := target + ".$$"
+		steps = append(steps, fmt.Sprintf("{ test -s %s || { mkdir -p %s && curl -fsSL --retry 3 -o %s %s && mv -f %s %s; }; }",
+			target, ShellQuote(path.Dir(blob.Path)), partial, ShellQuote(blob.URL), partial, target))
+	}
+	steps = append(steps, c.String())
+	return strings.Join(steps, " && ")
+}
+
+// ShellQuote quotes a word for a POSIX shell. Words made only of characters the shell
+// gives no meaning to are returned unchanged; others are wrapped in single quotes,
+// inside which only the single quote itself needs escaping.
+func ShellQuote(word string) string {
+	if word == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range word {
+		if !isShellSafe(r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// isShellSafe reports whether a character can appear unquoted in a shell word
+func isShellSafe(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("-_./,:@%+", r)
+}
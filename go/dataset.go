@@ -12,6 +12,7 @@ type DatasetMetadata struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Type        string    `json:"type"`
+	Tags        []string  `json:"tags,omitempty"`
 	Created     time.Time `json:"created"`
 	Updated     time.Time `json:"updated"`
 }
@@ -22,6 +23,11 @@ type DatasetInterface interface {
 	GetMetadata() DatasetMetadata
 	Validate() error
 	GetContentHash() string
+	GetAlignmentStats() *AlignmentStats
+	GetTreeStats() *TreeStats
+	GetLineage() *DatasetLineage
+	GetSeriesId() string
+	GetVersion() int
 }
 
 // BaseDataset provides common dataset implementation
@@ -30,22 +36,38 @@ type BaseDataset struct {
 	Id          string          `json:"id"`
 	ContentHash string          `json:"content_hash"`
 	Content     []byte          `json:"-"` // Raw content not serialized
+	// Alignment holds QC statistics when the dataset is an alignment
+	Alignment *AlignmentStats `json:"alignment,omitempty"`
+	// Tree holds the tree summary when the dataset is a tree
+	Tree *TreeStats `json:"tree,omitempty"`
+	// Lineage records the parent and operations of a derived dataset
+	Lineage *DatasetLineage `json:"lineage,omitempty"`
+	// SeriesId identifies the logical dataset this is a version of: the ID of its first
+	// version. It is empty for a new dataset, which starts its own series.
+	SeriesId string `json:"series_id,omitempty"`
+	// Version numbers the versions of a series from 1; it is assigned when stored
+	Version int `json:"version,omitempty"`
 }
 
 // NewBaseDataset creates a new BaseDataset with given metadata and content
 func NewBaseDataset(metadata DatasetMetadata, content []byte) *BaseDataset {
+	contentHash := sha256.Sum256(content)
+	return newBaseDatasetWithHash(metadata, content, hex.EncodeToString(contentHash[:]))
+}
+
+// newBaseDatasetWithHash creates a BaseDataset whose content hash was computed while it was received
+func newBaseDatasetWithHash(metadata DatasetMetadata, content []byte, contentHash string) *BaseDataset {
 	now := time.Now()
 	if metadata.Created.IsZero() {
 		metadata.Created = now
 	}
 	metadata.Updated = now
 
-	contentHash := sha256.Sum256(content)
 	return &BaseDataset{
 		Metadata:    metadata,
 		Content:     content,
-		ContentHash: hex.EncodeToString(contentHash[:]),
-		Id:          hex.EncodeToString(contentHash[:]), // Using content hash as ID
+		ContentHash: contentHash,
+		Id:          contentHash, // Using content hash as ID
 	}
 }
 
@@ -64,6 +86,34 @@ func (d *BaseDataset) GetContentHash() string {
 	return d.ContentHash
 }
 
+// GetAlignmentStats returns the alignment QC statistics, or nil if the dataset is not an alignment
+func (d *BaseDataset) GetAlignmentStats() *AlignmentStats {
+	return d.Alignment
+}
+
+// GetTreeStats returns the tree summary, or nil if the dataset is not a tree
+func (d *BaseDataset) GetTreeStats() *TreeStats {
+	return d.Tree
+}
+
+// GetLineage returns how the dataset was derived, or nil if it was uploaded
+func (d *BaseDataset) GetLineage() *DatasetLineage {
+	return d.Lineage
+}
+
+// GetSeriesId returns the ID of the logical dataset this is a version of
+func (d *BaseDataset) GetSeriesId() string {
+	if d.SeriesId == "" {
+		return d.Id
+	}
+	return d.SeriesId
+}
+
+// GetVersion returns the version number of the dataset within its series
+func (d *BaseDataset) GetVersion() int {
+	return d.Version
+}
+
 // Validate performs basic validation of the dataset
 func (d *BaseDataset) Validate() error {
 	if d.Metadata.Name == "" {
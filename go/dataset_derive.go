@@ -0,0 +1,337 @@
+package datamonkey
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Dataset derivation operations
+const (
+	OperationKeep      = "keep"
+	OperationDrop      = "drop"
+	OperationSlice     = "slice"
+	OperationStripGaps = "strip_gaps"
+	OperationDedupe    = "dedupe"
+	OperationTranslate = "translate"
+)
+
+// DerivationError reports an operation that cannot be applied to a dataset
+type DerivationError struct {
+	Problems []InvalidDataErrorErrorsInner
+}
+
+func (e *DerivationError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.Message
+	}
+	return fmt.Sprintf("cannot derive dataset: %s", strings.Join(messages, "; "))
+}
+
+// InvalidData returns the problems in the shape of the API's InvalidDataError
+func (e *DerivationError) InvalidData() InvalidDataError {
+	return InvalidDataError{Errors: e.Problems}
+}
+
+// derivationErrorf reports a problem with the operation at index
+func derivationErrorf(index int, format string, args ...interface{}) *DerivationError {
+	return &DerivationError{Problems: []InvalidDataErrorErrorsInner{{
+		Field:   fmt.Sprintf("operations[%d]", index),
+		Message: fmt.Sprintf(format, args...),
+	}}}
+}
+
+// DeriveAlignment applies operations to an alignment in order and returns the result.
+// The input alignment is not modified.
+func DeriveAlignment(alignment *Alignment, operations []DatasetOperation) (*Alignment, error) {
+	if len(operations) == 0 {
+		return nil, &DerivationError{Problems: []InvalidDataErrorErrorsInner{{Field: "operations", Message: "at least one operation is required"}}}
+	}
+
+	derived := &Alignment{Format: AlignmentFormatFASTA, Sequences: append([]AlignmentSequence(nil), alignment.Sequences...)}
+	for i, operation := range operations {
+		var err error
+		switch operation.Op {
+		case OperationKeep, OperationDrop:
+			var selected map[string]bool
+			selected, err = selectSequences(derived.Sequences, operation, i)
+			if err == nil {
+				derived.Sequences = filterSequences(derived.Sequences, selected, operation.Op == OperationKeep)
+			}
+		case OperationSlice:
+			err = derived.slice(operation, i)
+		case OperationStripGaps:
+			derived.stripGapColumns(operation.Codon)
+		case OperationDedupe:
+			derived.dedupe()
+		case OperationTranslate:
+			err = derived.translate(operation.GeneticCode, i)
+		default:
+			err = derivationErrorf(i, "unknown operation %q, expected keep, drop, slice, strip_gaps, dedupe or translate", operation.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(derived.Sequences) == 0 {
+			return nil, derivationErrorf(i, "%s leaves no sequences", operation.Op)
+		}
+		if len(derived.Sequences[0].Data) == 0 {
+			return nil, derivationErrorf(i, "%s leaves no sites", operation.Op)
+		}
+	}
+	return derived, nil
+}
+
+// selectSequences returns the names a keep or drop operation selects, by name or pattern.
+// Listed names that are not in the alignment are an error.
+func selectSequences(sequences []AlignmentSequence, operation DatasetOperation, index int) (map[string]bool, error) {
+	if len(operation.Names) == 0 && operation.Pattern == "" {
+		return nil, derivationErrorf(index, "%s needs names or a pattern", operation.Op)
+	}
+	var pattern *regexp.Regexp
+	if operation.Pattern != "" {
+		var err error
+		pattern, err = regexp.Compile(operation.Pattern)
+		if err != nil {
+			return nil, derivationErrorf(index, "invalid pattern: %v", err)
+		}
+	}
+
+	present := make(map[string]bool, len(sequences))
+	selected := make(map[string]bool)
+	for _, sequence := range sequences {
+		present[sequence.Name] = true
+		if pattern != nil && pattern.MatchString(sequence.Name) {
+			selected[sequence.Name] = true
+		}
+	}
+	var unknown []string
+	for _, name := range operation.Names {
+		if !present[name] {
+			unknown = append(unknown, name)
+		}
+		selected[name] = true
+	}
+	if len(unknown) > 0 {
+		return nil, derivationErrorf(index, "%d names are not sequences in the dataset: %s", len(unknown), quotedList(unknown))
+	}
+	return selected, nil
+}
+
+// filterSequences keeps the selected sequences, or the unselected ones when keep is false
+func filterSequences(sequences []AlignmentSequence, selected map[string]bool, keep bool) []AlignmentSequence {
+	var kept []AlignmentSequence
+	for _, sequence := range sequences {
+		if selected[sequence.Name] == keep {
+			kept = append(kept, sequence)
+		}
+	}
+	return kept
+}
+
+// slice keeps a range of sites, or of codons when the operation is codon-aware
+func (a *Alignment) slice(operation DatasetOperation, index int) error {
+	length := len(a.Sequences[0].Data)
+	unit, units := "site", length
+	if operation.Codon {
+		if length%3 != 0 {
+			return derivationErrorf(index, "alignment length %d is not a multiple of 3, so it cannot be sliced by codon", length)
+		}
+		unit, units = "codon", length/3
+	}
+
+	start, end := int(operation.Start), int(operation.End)
+	if start == 0 {
+		start = 1
+	}
+	if end == 0 {
+		end = units
+	}
+	if start < 1 || end > units || start > end {
+		return derivationErrorf(index, "%s range %d-%d is outside the alignment's %d %ss", unit, start, end, units, unit)
+	}
+
+	from, to := start-1, end
+	if operation.Codon {
+		from, to = from*3, to*3
+	}
+	for i := range a.Sequences {
+		a.Sequences[i].Data = a.Sequences[i].Data[from:to]
+	}
+	return nil
+}
+
+// isGap reports whether an alignment character is a gap
+func isGap(c byte) bool {
+	return c == '-' || c == '.' || c == '~'
+}
+
+// stripGapColumns removes the columns that are gaps in every sequence. Codon-aware
+// stripping removes whole codons that are gaps in every sequence, keeping the frame.
+func (a *Alignment) stripGapColumns(codon bool) {
+	width := 1
+	if codon {
+		width = 3
+	}
+	length := len(a.Sequences[0].Data)
+
+	var keep []int
+	for column := 0; column < length; column += width {
+		end := column + width
+		if end > length {
+			end = length
+		}
+		gapOnly := true
+		for _, sequence := range a.Sequences {
+			for site := column; site < end && gapOnly; site++ {
+				gapOnly = isGap(sequence.Data[site])
+			}
+			if !gapOnly {
+				break
+			}
+		}
+		if !gapOnly {
+			for site := column; site < end; site++ {
+				keep = append(keep, site)
+			}
+		}
+	}
+	if len(keep) == length {
+		return
+	}
+
+	for i := range a.Sequences {
+		data := make([]byte, len(keep))
+		for j, site := range keep {
+			data[j] = a.Sequences[i].Data[site]
+		}
+		a.Sequences[i].Data = string(data)
+	}
+}
+
+// dedupe removes sequences identical, ignoring case, to an earlier sequence
+func (a *Alignment) dedupe() {
+	seen := make(map[string]bool)
+	var kept []AlignmentSequence
+	for _, sequence := range a.Sequences {
+		key := strings.ToUpper(sequence.Data)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, sequence)
+	}
+	a.Sequences = kept
+}
+
+// translate replaces in-frame nucleotide sequences with their protein translation
+func (a *Alignment) translate(code GeneticCode, index int) error {
+	if code == "" {
+		code = UNIVERSAL
+	}
+	if _, ok := geneticCodeChanges[code]; !ok {
+		return derivationErrorf(index, "unknown genetic code %q", code)
+	}
+	if stats := a.Stats(); stats.Alphabet != "nucleotide" {
+		return derivationErrorf(index, "only nucleotide alignments can be translated")
+	}
+	if length := len(a.Sequences[0].Data); length%3 != 0 {
+		return derivationErrorf(index, "alignment length %d is not a multiple of 3; slice it to whole codons first", length)
+	}
+
+	for i, sequence := range a.Sequences {
+		protein := make([]byte, len(sequence.Data)/3)
+		for site := 0; site < len(sequence.Data); site += 3 {
+			aa, err := TranslateCodon(code, sequence.Data[site:site+3])
+			if err != nil {
+				return derivationErrorf(index, "%v", err)
+			}
+			protein[site/3] = aa
+		}
+		a.Sequences[i].Data = string(protein)
+	}
+	return nil
+}
+
+// DeriveTree applies keep and drop operations to the leaves of a tree and prunes it to
+// the leaves that remain. Other operations only apply to alignments.
+func DeriveTree(tree *Tree, operations []DatasetOperation) (*Tree, error) {
+	if len(operations) == 0 {
+		return nil, &DerivationError{Problems: []InvalidDataErrorErrorsInner{{Field: "operations", Message: "at least one operation is required"}}}
+	}
+
+	var leaves []AlignmentSequence
+	for _, leaf := range tree.Leaves() {
+		leaves = append(leaves, AlignmentSequence{Name: leaf})
+	}
+	for i, operation := range operations {
+		if operation.Op != OperationKeep && operation.Op != OperationDrop {
+			return nil, derivationErrorf(i, "%s does not apply to trees; only keep and drop do", operation.Op)
+		}
+		selected, err := selectSequences(leaves, operation, i)
+		if err != nil {
+			return nil, err
+		}
+		leaves = filterSequences(leaves, selected, operation.Op == OperationKeep)
+	}
+
+	keep := make([]string, len(leaves))
+	for i, leaf := range leaves {
+		keep[i] = leaf.Name
+	}
+	pruned, err := PruneTree(tree, keep)
+	if err != nil {
+		return nil, derivationErrorf(len(operations)-1, "%v", err)
+	}
+	return pruned, nil
+}
+
+// PruneTree returns a copy of the tree with only the named leaves. Internal nodes left
+// with a single child, including the root, are removed and their branch lengths joined.
+func PruneTree(tree *Tree, keep []string) (*Tree, error) {
+	names := make(map[string]bool, len(keep))
+	for _, name := range keep {
+		names[name] = true
+	}
+	root := pruneNode(tree.Root, names)
+	if root == nil {
+		return nil, fmt.Errorf("no tree leaves are kept")
+	}
+	if root.IsLeaf() {
+		return nil, fmt.Errorf("a tree needs at least two leaves")
+	}
+	root.Length, root.HasLength = 0, false
+	return &Tree{Format: TreeFormatNewick, Root: root, Rooted: tree.Rooted && len(root.Children) == 2}, nil
+}
+
+// pruneNode copies the subtree below node with only the named leaves, or returns nil if none remain
+func pruneNode(node *TreeNode, names map[string]bool) *TreeNode {
+	if node.IsLeaf() {
+		if !names[node.Name] {
+			return nil
+		}
+		copied := *node
+		return &copied
+	}
+
+	var children []*TreeNode
+	for _, child := range node.Children {
+		if pruned := pruneNode(child, names); pruned != nil {
+			children = append(children, pruned)
+		}
+	}
+	switch len(children) {
+	case 0:
+		return nil
+	case 1:
+		// Join this branch to the only remaining child's
+		child := children[0]
+		child.Length += node.Length
+		child.HasLength = child.HasLength || node.HasLength
+		return child
+	}
+	copied := *node
+	copied.Children = children
+	return &copied
+}
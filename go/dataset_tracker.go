@@ -5,12 +5,19 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// ErrDatasetNoOwner is returned by GetOwner for a dataset stored without an owner.
+// Such datasets are public.
+var ErrDatasetNoOwner = errors.New("dataset has no owner")
+
 // DatasetTracker defines the interface for tracking datasets
 type DatasetTracker interface {
 	// Store stores a dataset in the tracker
@@ -31,11 +38,18 @@ type DatasetTracker interface {
 	// DeleteAll completely removes the tracker file
 	DeleteAll() error
 
-	// GetDatasetDir returns the directory where datasets are stored
-	// TODO: some day if this isnt in a typical file-based storage system, we should change this name
-	// for now i figure even if the tracker uses a db, it still needs to know where the datasets are stored
+	// GetDatasetDir returns the local directory new dataset files are written to before
+	// they are stored. With a filesystem blob store HyPhy reads datasets from it; with a
+	// remote store jobs download them as GetBlobStore().Stage describes.
 	GetDatasetDir() string
 
+	// GetBlobStore returns the store holding dataset contents, keyed by content hash
+	GetBlobStore() BlobStore
+
+	// StoreContent moves the file at sourcePath into the blob store as the content with the
+	// given hash, unless that content is already stored; it reports whether it was
+	StoreContent(contentHash string, sourcePath string) (bool, error)
+
 	// StoreWithUser stores a dataset with user ownership
 	StoreWithUser(dataset DatasetInterface, userID string) error
 
@@ -45,13 +59,17 @@ type DatasetTracker interface {
 	// ListByUser returns all datasets owned by a specific user
 	ListByUser(userID string) ([]DatasetInterface, error)
 
+	// ListByUserWithFilters returns a page of a user's datasets matching the filters,
+	// and the number of datasets matching them in total
+	ListByUserWithFilters(userID string, filters map[string]interface{}) ([]DatasetInterface, int, error)
+
 	// DeleteByUser removes a dataset only if owned by the user
 	DeleteByUser(id string, userID string) error
 
 	// UpdateByUser updates a dataset only if owned by the user
 	UpdateByUser(id string, userID string, updates map[string]interface{}) error
 
-	// GetOwner retrieves the user ID that owns a dataset
+	// GetOwner retrieves the user ID that owns a dataset, or ErrDatasetNoOwner for a public one
 	GetOwner(id string) (string, error)
 }
 
@@ -59,21 +77,160 @@ type DatasetTracker interface {
 type SQLiteDatasetTracker struct {
 	db      *sql.DB
 	dataDir string
+	store   BlobStore
+	// blobMu keeps blobs from being collected while content is being stored
+	blobMu sync.Mutex
 }
 
-// NewSQLiteDatasetTracker creates a new SQLiteDatasetTracker instance using the unified database
+// NewSQLiteDatasetTracker creates a new SQLiteDatasetTracker instance using the unified database,
+// storing dataset contents as files in dataDir
 func NewSQLiteDatasetTracker(db *sql.DB, dataDir string) *SQLiteDatasetTracker {
+	return NewSQLiteDatasetTrackerWithBlobStore(db, NewFileBlobStore(dataDir), dataDir)
+}
+
+// NewSQLiteDatasetTrackerWithBlobStore creates a new SQLiteDatasetTracker instance storing dataset
+// contents in store; new dataset files are written to dataDir before they are stored
+func NewSQLiteDatasetTrackerWithBlobStore(db *sql.DB, store BlobStore, dataDir string) *SQLiteDatasetTracker {
 	return &SQLiteDatasetTracker{
 		db:      db,
 		dataDir: dataDir,
+		store:   store,
 	}
 }
 
-// GetDatasetDir returns the directory where datasets are stored
+// GetDatasetDir returns the local directory new dataset files are written to
 func (t *SQLiteDatasetTracker) GetDatasetDir() string {
 	return t.dataDir
 }
 
+// GetBlobStore returns the store holding dataset contents
+func (t *SQLiteDatasetTracker) GetBlobStore() BlobStore {
+	return t.store
+}
+
+// StoreContent moves a dataset's content into the blob store, unless that content is already stored
+func (t *SQLiteDatasetTracker) StoreContent(contentHash string, sourcePath string) (bool, error) {
+	t.blobMu.Lock()
+	defer t.blobMu.Unlock()
+
+	if _, err := t.store.Stat(contentHash); err == nil {
+		return true, nil
+	} else if !errors.Is(err, ErrBlobNotFound) {
+		return false, err
+	}
+	return false, moveIntoStore(t.store, contentHash, sourcePath)
+}
+
+// CollectBlobs deletes stored contents that no dataset or job refers to any more
+func (t *SQLiteDatasetTracker) CollectBlobs() (int, error) {
+	rows, err := t.db.Query(`SELECT content_hash FROM blobs WHERE ref_count <= 0`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list unreferenced blobs: %v", err)
+	}
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan blob: %v", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating blobs: %v", err)
+	}
+
+	count := 0
+	for _, hash := range hashes {
+		collected, err := t.collectBlob(hash)
+		if err != nil {
+			log.Printf("Failed to delete blob %s: %v", hash, err)
+			continue
+		}
+		if collected {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// collectBlob deletes a blob if it is still unreferenced
+func (t *SQLiteDatasetTracker) collectBlob(hash string) (bool, error) {
+	t.blobMu.Lock()
+	defer t.blobMu.Unlock()
+
+	result, err := t.db.Exec(`DELETE FROM blobs WHERE content_hash = ? AND ref_count <= 0`, hash)
+	if err != nil {
+		return false, err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return false, err
+	}
+	return true, t.store.Delete(hash)
+}
+
+// StartBlobCleanup starts a background goroutine to delete unreferenced blobs, such as
+// those of deleted jobs or of datasets removed along with expired sessions
+func (t *SQLiteDatasetTracker) StartBlobCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			count, err := t.CollectBlobs()
+			if err != nil {
+				log.Printf("Error cleaning up dataset blobs: %v", err)
+			} else if count > 0 {
+				log.Printf("Cleaned up %d unreferenced dataset blobs", count)
+			}
+		}
+	}()
+
+	log.Printf("Started dataset blob cleanup task (interval: %v)", interval)
+}
+
+// MigrateLegacyBlobs moves dataset contents stored under their dataset IDs, as they were
+// before contents were shared between datasets, to their content hashes
+func (t *SQLiteDatasetTracker) MigrateLegacyBlobs() (int, error) {
+	rows, err := t.db.Query(`SELECT id, content_hash FROM datasets WHERE id != content_hash`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list datasets: %v", err)
+	}
+	legacy := make(map[string]string)
+	for rows.Next() {
+		var id, hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan dataset: %v", err)
+		}
+		legacy[id] = hash
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating datasets: %v", err)
+	}
+
+	t.blobMu.Lock()
+	defer t.blobMu.Unlock()
+
+	count := 0
+	for id, hash := range legacy {
+		if _, err := t.store.Stat(id); err != nil {
+			continue
+		}
+		if _, err := t.store.Stat(hash); errors.Is(err, ErrBlobNotFound) {
+			if err := moveBlob(t.store, id, hash); err != nil {
+				return count, fmt.Errorf("failed to move dataset %s: %v", id, err)
+			}
+		} else if err := t.store.Delete(id); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
 // Store stores a dataset in the tracker
 func (t *SQLiteDatasetTracker) Store(dataset DatasetInterface) error {
 	metadata := dataset.GetMetadata()
@@ -85,8 +242,9 @@ func (t *SQLiteDatasetTracker) Store(dataset DatasetInterface) error {
 	query := `
 	INSERT INTO datasets (
 		id, metadata_name, metadata_description, metadata_type,
-		metadata_created, metadata_updated, content_hash, data_json
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		metadata_created, metadata_updated, content_hash, data_json,
+		metadata_tags, series_id, version
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ` + nextVersionQuery + `)
 	`
 
 	_, err = t.db.Exec(query,
@@ -98,21 +256,69 @@ func (t *SQLiteDatasetTracker) Store(dataset DatasetInterface) error {
 		metadata.Updated.Unix(),
 		dataset.GetContentHash(),
 		string(dataJSON),
+		marshalTags(metadata.Tags),
+		dataset.GetSeriesId(),
+		dataset.GetSeriesId(),
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to store dataset: %v", err)
 	}
 
+	return t.assignVersion(dataset)
+}
+
+// nextVersionQuery numbers a new dataset after the latest version of its series. It
+// runs within the INSERT so concurrent uploads cannot take the same number.
+const nextVersionQuery = `(SELECT COALESCE(MAX(version), 0) + 1 FROM datasets WHERE series_id = ?)`
+
+// assignVersion copies the series and version number the database assigned into a stored dataset
+func (t *SQLiteDatasetTracker) assignVersion(dataset DatasetInterface) error {
+	baseDataset, ok := dataset.(*BaseDataset)
+	if !ok {
+		return nil
+	}
+	var seriesID sql.NullString
+	err := t.db.QueryRow(`SELECT series_id, version FROM datasets WHERE id = ?`, baseDataset.Id).Scan(&seriesID, &baseDataset.Version)
+	if err != nil {
+		return fmt.Errorf("failed to get dataset version: %v", err)
+	}
+	baseDataset.SeriesId = seriesID.String
 	return nil
 }
 
+// marshalTags encodes tags for the metadata_tags column
+func marshalTags(tags []string) string {
+	if len(tags) == 0 {
+		return "[]"
+	}
+	tagsJSON, _ := json.Marshal(tags)
+	return string(tagsJSON)
+}
+
+// scanDataset decodes a dataset from its data_json, series_id and version columns
+func scanDataset(scanner interface{ Scan(...interface{}) error }) (*BaseDataset, error) {
+	var dataJSON string
+	var seriesID sql.NullString
+	var version int
+	if err := scanner.Scan(&dataJSON, &seriesID, &version); err != nil {
+		return nil, err
+	}
+
+	var dataset BaseDataset
+	if err := json.Unmarshal([]byte(dataJSON), &dataset); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal dataset: %v", err)
+	}
+	dataset.SeriesId = seriesID.String
+	dataset.Version = version
+	return &dataset, nil
+}
+
 // Get retrieves a dataset by ID
 func (t *SQLiteDatasetTracker) Get(id string) (DatasetInterface, error) {
-	query := `SELECT data_json FROM datasets WHERE id = ?`
+	query := `SELECT data_json, series_id, version FROM datasets WHERE id = ?`
 
-	var dataJSON string
-	err := t.db.QueryRow(query, id).Scan(&dataJSON)
+	dataset, err := scanDataset(t.db.QueryRow(query, id))
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("dataset not found: %s", id)
 	}
@@ -120,17 +326,12 @@ func (t *SQLiteDatasetTracker) Get(id string) (DatasetInterface, error) {
 		return nil, fmt.Errorf("failed to get dataset: %v", err)
 	}
 
-	var dataset BaseDataset
-	if err := json.Unmarshal([]byte(dataJSON), &dataset); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal dataset: %v", err)
-	}
-
-	return &dataset, nil
+	return dataset, nil
 }
 
 // List returns all tracked datasets
 func (t *SQLiteDatasetTracker) List() ([]DatasetInterface, error) {
-	query := `SELECT data_json FROM datasets ORDER BY metadata_created DESC`
+	query := `SELECT data_json, series_id, version FROM datasets ORDER BY metadata_created DESC`
 
 	rows, err := t.db.Query(query)
 	if err != nil {
@@ -140,17 +341,12 @@ func (t *SQLiteDatasetTracker) List() ([]DatasetInterface, error) {
 
 	var datasets []DatasetInterface
 	for rows.Next() {
-		var dataJSON string
-		if err := rows.Scan(&dataJSON); err != nil {
+		dataset, err := scanDataset(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan dataset: %v", err)
 		}
 
-		var dataset BaseDataset
-		if err := json.Unmarshal([]byte(dataJSON), &dataset); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal dataset: %v", err)
-		}
-
-		datasets = append(datasets, &dataset)
+		datasets = append(datasets, dataset)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -160,8 +356,17 @@ func (t *SQLiteDatasetTracker) List() ([]DatasetInterface, error) {
 	return datasets, nil
 }
 
-// Delete removes a dataset from the tracker
+// Delete removes a dataset from the tracker, and its content once nothing else uses it
 func (t *SQLiteDatasetTracker) Delete(id string) error {
+	var contentHash string
+	err := t.db.QueryRow(`SELECT content_hash FROM datasets WHERE id = ?`, id).Scan(&contentHash)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("dataset not found: %s", id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get dataset: %v", err)
+	}
+
 	query := `DELETE FROM datasets WHERE id = ?`
 
 	result, err := t.db.Exec(query, id)
@@ -178,6 +383,11 @@ func (t *SQLiteDatasetTracker) Delete(id string) error {
 		return fmt.Errorf("dataset not found: %s", id)
 	}
 
+	// Jobs on this content keep it until they are deleted too
+	if _, err := t.collectBlob(contentHash); err != nil {
+		log.Printf("Failed to delete content of dataset %s: %v", id, err)
+	}
+
 	return nil
 }
 
@@ -225,7 +435,8 @@ func (t *SQLiteDatasetTracker) Update(id string, updates map[string]interface{})
 		metadata_created = ?,
 		metadata_updated = ?,
 		content_hash = ?,
-		data_json = ?
+		data_json = ?,
+		metadata_tags = ?
 	WHERE id = ?
 	`
 
@@ -237,6 +448,7 @@ func (t *SQLiteDatasetTracker) Update(id string, updates map[string]interface{})
 		updated.Metadata.Updated.Unix(),
 		updated.ContentHash,
 		string(updatedJSON),
+		marshalTags(updated.Metadata.Tags),
 		id,
 	)
 
@@ -247,6 +459,13 @@ func (t *SQLiteDatasetTracker) Update(id string, updates map[string]interface{})
 	return nil
 }
 
+// UserDatasetID returns the ID of a user's dataset with the given content; each user has at
+// most one dataset per content, while the content itself is stored once for all users
+func UserDatasetID(contentHash string, userID string) string {
+	userSpecificHash := sha256.Sum256([]byte(contentHash + userID))
+	return hex.EncodeToString(userSpecificHash[:])
+}
+
 // StoreWithUser stores a dataset with user ownership
 func (t *SQLiteDatasetTracker) StoreWithUser(dataset DatasetInterface, userID string) error {
 	// Generate a user-specific ID by hashing content + userID
@@ -254,9 +473,7 @@ func (t *SQLiteDatasetTracker) StoreWithUser(dataset DatasetInterface, userID st
 	contentHash := dataset.GetContentHash()
 
 	// Create user-specific ID
-	combined := contentHash + userID
-	userSpecificHash := sha256.Sum256([]byte(combined))
-	userSpecificID := hex.EncodeToString(userSpecificHash[:])
+	userSpecificID := UserDatasetID(contentHash, userID)
 
 	// Check if this user already has this dataset
 	existingQuery := `SELECT id FROM datasets WHERE id = ?`
@@ -268,7 +485,7 @@ func (t *SQLiteDatasetTracker) StoreWithUser(dataset DatasetInterface, userID st
 		if baseDataset, ok := dataset.(*BaseDataset); ok {
 			baseDataset.Id = userSpecificID
 		}
-		return nil
+		return t.assignVersion(dataset)
 	}
 	if err != sql.ErrNoRows {
 		// Real error (not just "no rows found")
@@ -291,8 +508,9 @@ func (t *SQLiteDatasetTracker) StoreWithUser(dataset DatasetInterface, userID st
 
 	query := `
 	INSERT INTO datasets (id, metadata_name, metadata_description, metadata_type, 
-	                      metadata_created, metadata_updated, content_hash, data_json, user_id)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	                      metadata_created, metadata_updated, content_hash, data_json, user_id,
+	                      metadata_tags, series_id, version)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ` + nextVersionQuery + `)
 	`
 
 	_, err = t.db.Exec(query,
@@ -305,13 +523,16 @@ func (t *SQLiteDatasetTracker) StoreWithUser(dataset DatasetInterface, userID st
 		contentHash, // Store original content hash
 		string(dataJSON),
 		sql.NullString{String: userID, Valid: userID != ""},
+		marshalTags(metadata.Tags),
+		dataset.GetSeriesId(),
+		dataset.GetSeriesId(),
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to store dataset: %v", err)
 	}
 
-	return nil
+	return t.assignVersion(dataset)
 }
 
 // GetByUser retrieves a dataset by ID and verifies user ownership
@@ -339,48 +560,76 @@ func (t *SQLiteDatasetTracker) GetByUser(id string, userID string) (DatasetInter
 
 // ListByUser returns all datasets owned by a specific user
 func (t *SQLiteDatasetTracker) ListByUser(userID string) ([]DatasetInterface, error) {
-	query := `
-	SELECT id, metadata_name, metadata_description, metadata_type, 
-	       metadata_created, metadata_updated, content_hash, data_json
-	FROM datasets
-	WHERE user_id = ?
-	ORDER BY metadata_created DESC
-	`
+	datasets, _, err := t.ListByUserWithFilters(userID, nil)
+	return datasets, err
+}
+
+// ListByUserWithFilters returns a page of a user's datasets, newest first, and the number
+// of datasets matching the filters in total.
+// Supported filters: type, tags ([]string, all must match), series_id, latest (bool,
+// only the latest version of each series), limit, offset
+func (t *SQLiteDatasetTracker) ListByUserWithFilters(userID string, filters map[string]interface{}) ([]DatasetInterface, int, error) {
+	where := ` WHERE user_id = ?`
+	args := []interface{}{userID}
+
+	if datasetType, ok := filters["type"].(string); ok && datasetType != "" {
+		where += ` AND metadata_type = ?`
+		args = append(args, datasetType)
+	}
+
+	if tags, ok := filters["tags"].([]string); ok {
+		for _, tag := range tags {
+			where += ` AND EXISTS (SELECT 1 FROM json_each(datasets.metadata_tags) WHERE json_each.value = ?)`
+			args = append(args, tag)
+		}
+	}
+
+	if seriesID, ok := filters["series_id"].(string); ok && seriesID != "" {
+		where += ` AND series_id = ?`
+		args = append(args, seriesID)
+	}
 
-	rows, err := t.db.Query(query, userID)
+	if latest, ok := filters["latest"].(bool); ok && latest {
+		where += ` AND version = (SELECT MAX(latest.version) FROM datasets latest WHERE latest.series_id = datasets.series_id)`
+	}
+
+	var total int
+	if err := t.db.QueryRow(`SELECT COUNT(*) FROM datasets`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count datasets: %v", err)
+	}
+
+	query := `SELECT data_json, series_id, version FROM datasets` + where + ` ORDER BY metadata_created DESC, version DESC, id`
+
+	// Add limit and offset if specified; a negative limit means no limit in SQLite
+	limit, _ := filters["limit"].(int)
+	offset, _ := filters["offset"].(int)
+	if limit > 0 || offset > 0 {
+		if limit <= 0 {
+			limit = -1
+		}
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
+
+	rows, err := t.db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query datasets: %v", err)
+		return nil, 0, fmt.Errorf("failed to query datasets: %v", err)
 	}
 	defer rows.Close()
 
 	var datasets []DatasetInterface
 	for rows.Next() {
-		var dataset BaseDataset
-		var dataJSON string
-		var created, updated int64
-
-		err := rows.Scan(
-			&dataset.Id,
-			&dataset.Metadata.Name,
-			&dataset.Metadata.Description,
-			&dataset.Metadata.Type,
-			&created,
-			&updated,
-			&dataset.ContentHash,
-			&dataJSON,
-		)
+		dataset, err := scanDataset(rows)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan dataset: %v", err)
+			return nil, 0, fmt.Errorf("failed to scan dataset: %v", err)
 		}
-
-		// Convert Unix timestamps to time.Time
-		dataset.Metadata.Created = time.Unix(created, 0)
-		dataset.Metadata.Updated = time.Unix(updated, 0)
-
-		datasets = append(datasets, &dataset)
+		datasets = append(datasets, dataset)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating datasets: %v", err)
 	}
 
-	return datasets, nil
+	return datasets, total, nil
 }
 
 // DeleteByUser removes a dataset only if owned by the user
@@ -443,7 +692,7 @@ func (t *SQLiteDatasetTracker) GetOwner(id string) (string, error) {
 	}
 
 	if !ownerID.Valid {
-		return "", fmt.Errorf("dataset has no owner")
+		return "", ErrDatasetNoOwner
 	}
 
 	return ownerID.String, nil
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -34,7 +35,10 @@ type HyPhyMethod struct {
 	HyPhyPath  string
 	MethodType HyPhyMethodType
 	Request    interface{}
-	DataDir    string
+	// DataDir is where datasets are staged for HyPhy (see DatasetTracker.GetDatasetDir)
+	DataDir string
+	// StagedDatasets holds each staged dataset by ID; unlisted datasets are read from DataDir
+	StagedDatasets map[string]StagedBlob
 }
 
 // NewHyPhyMethod creates a new HyPhyMethod instance
@@ -48,47 +52,55 @@ func NewHyPhyMethod(request interface{}, basePath, hyPhyPath string, methodType
 	}
 }
 
-// getCommandArg converts a field value to a command line argument
-func getCommandArg(field reflect.StructField, value reflect.Value) string {
+// datasetPath returns the local path of a staged dataset
+func (m *HyPhyMethod) datasetPath(id string) string {
+	if staged, ok := m.StagedDatasets[id]; ok {
+		return staged.Path
+	}
+	return filepath.Join(m.DataDir, id)
+}
+
+// getCommandArgs converts a field value to command line arguments
+func getCommandArgs(field reflect.StructField, value reflect.Value, rawBranches bool) []string {
 	// Skip alignment and tree fields as they're handled separately
 	if field.Name == "Alignment" || field.Name == "Tree" {
-		return ""
+		return nil
 	}
 
 	// Get json tag name for the argument
 	tag := field.Tag.Get("json")
 	if tag == "" {
-		return ""
+		return nil
 	}
-	argName := strings.Split(tag, ",")[0]
+	argName := "--" + strings.Split(tag, ",")[0]
 
 	// Handle different types
 	switch value.Kind() {
 	case reflect.Bool:
 		// For boolean fields, convert to "Yes"/"No" for HyPhy compatibility
 		if value.Bool() {
-			return fmt.Sprintf(" --%s Yes", argName)
+			return []string{argName, "Yes"}
 		} else {
-			return fmt.Sprintf(" --%s No", argName)
+			return []string{argName, "No"}
 		}
 	case reflect.String:
 		if str := value.String(); str != "" {
-			return fmt.Sprintf(" --%s %s", argName, str)
+			return []string{argName, str}
 		}
 		// Handle GeneticCode type
 		if field.Type == reflect.TypeOf(GeneticCode("")) {
 			if !value.IsZero() {
 				// Convert GeneticCode to string
-				return fmt.Sprintf(" --code %v", value.Interface())
+				return []string{"--code", fmt.Sprint(value.Interface())}
 			}
 		}
 	case reflect.Int, reflect.Int32, reflect.Int64:
 		if num := value.Int(); num > 0 {
-			return fmt.Sprintf(" --%s %d", argName, num)
+			return []string{argName, fmt.Sprintf("%d", num)}
 		}
 	case reflect.Float32, reflect.Float64:
 		if num := value.Float(); num > 0 {
-			return fmt.Sprintf(" --%s %f", argName, num)
+			return []string{argName, fmt.Sprintf("%f", num)}
 		}
 	case reflect.Slice:
 		if value.Len() > 0 {
@@ -98,23 +110,34 @@ func getCommandArg(field reflect.StructField, value reflect.Value) string {
 				for i := 0; i < value.Len(); i++ {
 					items = append(items, value.Index(i).String())
 				}
-				return fmt.Sprintf(" --%s '%s'", argName, strings.Join(items, ","))
+				if !rawBranches && isBranchSelectionField(field.Name) {
+					return []string{argName, hyPhyBranches(items)}
+				}
+				return []string{argName, strings.Join(items, ",")}
 			}
 		}
 	case reflect.Struct:
 		// Handle other structs
 		if !value.IsZero() {
 			// Convert struct to string using the field name
-			return fmt.Sprintf(" --%s %v", argName, value.Interface())
+			return []string{argName, fmt.Sprint(value.Interface())}
 		}
 	}
-	return ""
+	return nil
 }
 
-// GetCommand returns the command to run the HyPhy analysis
-func (m *HyPhyMethod) GetCommand() string {
+// GetCommand returns the command to run the HyPhy analysis. Every parameter value is
+// a separate argument, however it is rendered for a scheduler.
+func (m *HyPhyMethod) GetCommand() Command {
+	return m.command(false)
+}
+
+// command builds the HyPhy command. rawBranches passes branch selections through as
+// requested, {label} groups included, as commands were built before HyPhy was given
+// label names.
+func (m *HyPhyMethod) command(rawBranches bool) Command {
 	// Start with the base command
-	cmd := fmt.Sprintf("%s %s", m.HyPhyPath, m.MethodType)
+	cmd := NewCommand(m.HyPhyPath, string(m.MethodType))
 
 	// Get the alignment from the request (if it exists)
 	var alignment string
@@ -131,8 +154,7 @@ func (m *HyPhyMethod) GetCommand() string {
 
 	// Add alignment parameter if we have one (Slatkin doesn't use alignment)
 	if alignment != "" {
-		alignmentPath := filepath.Join(m.DataDir, alignment)
-		cmd += fmt.Sprintf(" --alignment %s", alignmentPath)
+		cmd.Args = append(cmd.Args, "--alignment", m.datasetPath(alignment))
 	}
 
 	// Check if the request implements HyPhyRequest
@@ -140,81 +162,83 @@ func (m *HyPhyMethod) GetCommand() string {
 		// Add tree parameter only if it was explicitly set
 		if hyPhyReq.IsTreeSet() {
 			tree := hyPhyReq.GetTree()
-			cmd += fmt.Sprintf(" --tree %s", filepath.Join(m.DataDir, tree))
+			cmd.Args = append(cmd.Args, "--tree", m.datasetPath(tree))
 		}
 
 		// Handle genetic code parameter
 		if hyPhyReq.IsGeneticCodeSet() {
 			geneticCode := hyPhyReq.GetGeneticCode()
-			cmd += fmt.Sprintf(" --code %s", geneticCode)
+			cmd.Args = append(cmd.Args, "--code", geneticCode)
 		}
 
 		// Add branches parameter only if it was explicitly set
 		if hyPhyReq.IsBranchesSet() {
 			branches := hyPhyReq.GetBranches()
-			if len(branches) > 0 {
-				cmd += fmt.Sprintf(" --branches %s", strings.Join(branches, ","))
+			if len(branches) > 0 && rawBranches {
+				cmd.Args = append(cmd.Args, "--branches", strings.Join(branches, ","))
+			} else if len(branches) > 0 {
+				cmd.Args = append(cmd.Args, "--branches", hyPhyBranches(branches))
 			}
 		}
 
 		// Add CI parameter only if it was explicitly set
 		if hyPhyReq.IsCISet() {
 			ci := hyPhyReq.GetCI()
-			cmd += fmt.Sprintf(" --ci %v", ci)
+			cmd.Args = append(cmd.Args, "--ci", fmt.Sprint(ci))
 		}
 
 		// Add SRV parameter only if it was explicitly set
 		if hyPhyReq.IsSRVSet() {
 			srv := hyPhyReq.GetSRV()
-			cmd += fmt.Sprintf(" --srv %v", srv)
+			cmd.Args = append(cmd.Args, "--srv", fmt.Sprint(srv))
 		}
 
 		// Add resample parameter only if it was explicitly set
 		if hyPhyReq.IsResampleSet() {
 			resample := hyPhyReq.GetResample()
-			cmd += fmt.Sprintf(" --resample %v", resample)
+			cmd.Args = append(cmd.Args, "--resample", fmt.Sprint(resample))
 		}
 
 		// Handle multiple-hits parameter
 		if hyPhyReq.IsMultipleHitsSet() {
 			multipleHits := hyPhyReq.GetMultipleHits()
-			cmd += fmt.Sprintf(" --multiple-hits %s", multipleHits)
+			cmd.Args = append(cmd.Args, "--multiple-hits", multipleHits)
 		}
 
 		// Add site-multihit parameter only if it was explicitly set
 		if hyPhyReq.IsSiteMultihitSet() {
 			siteMultihit := hyPhyReq.GetSiteMultihit()
-			cmd += fmt.Sprintf(" --site-multihit %s", siteMultihit)
+			cmd.Args = append(cmd.Args, "--site-multihit", siteMultihit)
 		}
 
 		// Add rates parameter only if it was explicitly set
 		if hyPhyReq.IsRatesSet() {
 			rates := hyPhyReq.GetRates()
-			cmd += fmt.Sprintf(" --rates %v", rates)
+			cmd.Args = append(cmd.Args, "--rates", fmt.Sprint(rates))
 		}
 
 		// Add syn-rates parameter only if it was explicitly set
 		if hyPhyReq.IsSynRatesSet() {
 			synRates := hyPhyReq.GetSynRates()
-			cmd += fmt.Sprintf(" --syn-rates %v", synRates)
+			cmd.Args = append(cmd.Args, "--syn-rates", fmt.Sprint(synRates))
 		}
 
 		// Add grid-size parameter only if it was explicitly set
 		if hyPhyReq.IsGridSizeSet() {
 			gridSize := hyPhyReq.GetGridSize()
-			cmd += fmt.Sprintf(" --grid-size %v", gridSize)
+			cmd.Args = append(cmd.Args, "--grid-size", fmt.Sprint(gridSize))
 		}
 
 		// Add starting-points parameter only if it was explicitly set
 		if hyPhyReq.IsStartingPointsSet() {
 			startingPoints := hyPhyReq.GetStartingPoints()
-			cmd += fmt.Sprintf(" --starting-points %v", startingPoints)
+			cmd.Args = append(cmd.Args, "--starting-points", fmt.Sprint(startingPoints))
 		}
 
 		// Add error-sink parameter only if it was explicitly set
 		if hyPhyReq.IsErrorSinkSet() {
 			errorSink := hyPhyReq.GetErrorSink()
-			cmd += fmt.Sprintf(" --error-sink %s", errorSink)
+			cmd.Args = append(cmd.Args, "--error-sink", errorSink)
 		}
 	} else {
 		// Use reflection to iterate over request fields
@@ -224,7 +248,7 @@ func (m *HyPhyMethod) GetCommand() string {
 		// Handle tree field separately (like alignment)
 		treeField := reqValue.FieldByName("Tree")
 		if treeField.IsValid() && treeField.String() != "" {
-			cmd += fmt.Sprintf(" --tree %s", filepath.Join(m.DataDir, treeField.String()))
+			cmd.Args = append(cmd.Args, "--tree", m.datasetPath(treeField.String()))
 		}
 
 		for i := 0; i < reqType.NumField(); i++ {
@@ -232,21 +256,63 @@ func (m *HyPhyMethod) GetCommand() string {
 			value := reqValue.Field(i)
 
 			// Skip alignment field as it's handled separately
-			// (Tree is also skipped in getCommandArg and handled above)
+			// (Tree is also skipped in getCommandArgs and handled above)
 			if field.Name == "Alignment" {
 				continue
 			}
 
-			// Add argument if field has a value
-			if arg := getCommandArg(field, value); arg != "" {
-				cmd += arg
-			}
+			// Add arguments if field has a value
+			cmd.Args = append(cmd.Args, getCommandArgs(field, value, rawBranches)...)
+		}
+	}
+
+	// Datasets in a remote store are downloaded onto the compute node first
+	ids := make([]string, 0, len(m.StagedDatasets))
+	for id := range m.StagedDatasets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if staged := m.StagedDatasets[id]; staged.URL != "" {
+			cmd.Fetch = append(cmd.Fetch, staged)
 		}
 	}
 
 	return cmd
 }
 
+// LegacyCommandLine renders the command the way it was written out before commands were
+// built as argv: arguments joined by spaces, unquoted except for the list parameters of
+// requests that are not HyPhyRequests, which were wrapped in single quotes. Jobs started
+// back then are identified by its hash (see LegacyJobID), so it must not change.
+func (m *HyPhyMethod) LegacyCommandLine() string {
+	args := m.command(true).Args
+
+	quoted := map[string]bool{}
+	if _, ok := m.Request.(HyPhyRequest); !ok {
+		reqType := reflect.TypeOf(m.Request)
+		if reqType.Kind() == reflect.Ptr {
+			reqType = reqType.Elem()
+		}
+		for i := 0; i < reqType.NumField(); i++ {
+			field := reqType.Field(i)
+			tag := field.Tag.Get("json")
+			if tag != "" && field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.String {
+				quoted["--"+strings.Split(tag, ",")[0]] = true
+			}
+		}
+	}
+
+	words := make([]string, len(args))
+	for i, arg := range args {
+		if i > 0 && quoted[args[i-1]] {
+			arg = "'" + arg + "'"
+		}
+		words[i] = arg
+	}
+	return strings.Join(words, " ")
+}
+
 // ParseResult parses the JSON output from HyPhy into the appropriate result struct
 func (m *HyPhyMethod) ParseResult(output string) (interface{}, error) {
 	switch m.MethodType {
@@ -411,79 +477,56 @@ func (m *HyPhyMethod) ParseResult(output string) (interface{}, error) {
 
 // ValidateInput validates the input dataset and method-specific parameters
 func (m *HyPhyMethod) ValidateInput(dataset DatasetInterface) error {
+	if err := ValidateParameters(m.Request); err != nil {
+		return err
+	}
+
 	metadata := dataset.GetMetadata()
 	if metadata.Type != "fasta" && metadata.Type != "nexus" && metadata.Type != "fas" {
 		return fmt.Errorf("invalid dataset type for %s analysis: %s. Expected 'fasta' or 'nexus'",
 			m.MethodType, metadata.Type)
 	}
 
-	// TODO: validate using reflection. if the request implements HyPhyRequest,
-	// look for any parameter that any hyphy method might have and if it exists,
-	// validate it
-	// TODO: do we need to validate the dataset? or is validated elsewhere?
+	// Codon models need an in-frame nucleotide alignment; catch problems now rather
+	// than when HyPhy fails on the cluster
+	if IsCodonMethod(m.MethodType) {
+		if err := m.validateCodons(dataset); err != nil {
+			return err
+		}
+	}
 
-	// Check if the request implements HyPhyRequest
-	if _, ok := m.Request.(HyPhyRequest); ok {
-		// For HyPhyRequest, we can't validate additional parameters
-		// We'll assume they're valid for now
+	return nil
+}
+
+// validateCodons checks the dataset's alignment against the request's genetic code.
+// Datasets without alignment content are left to the dataset type check.
+func (m *HyPhyMethod) validateCodons(dataset DatasetInterface) error {
+	baseDataset, ok := dataset.(*BaseDataset)
+	if !ok || DetectAlignmentFormat(baseDataset.Content) == "" {
 		return nil
 	}
+	alignment, err := ParseAlignment(baseDataset.Content)
+	if err != nil {
+		return fmt.Errorf("invalid alignment for %s analysis: %v", m.MethodType, err)
+	}
+	return alignment.ValidateCodons(m.MethodType, m.geneticCode())
+}
 
-	switch req := m.Request.(type) {
-	case *FelRequest:
-		if req.Resample < 0 {
-			return fmt.Errorf("resample value must be non-negative")
-		}
-
-	case *BustedRequest:
-		if req.Rates < 0 {
-			return fmt.Errorf("rates must be non-negative")
-		}
-		if req.SynRates < 0 {
-			return fmt.Errorf("syn-rates must be non-negative")
-		}
-		if req.GridSize < 0 {
-			return fmt.Errorf("grid-size must be non-negative")
-		}
-		if req.StartingPoints < 0 {
-			return fmt.Errorf("starting-points must be non-negative")
+// geneticCode returns the genetic code requested, or "" for the default
+func (m *HyPhyMethod) geneticCode() GeneticCode {
+	if hyPhyReq, ok := m.Request.(HyPhyRequest); ok {
+		return GeneticCode(hyPhyReq.GetGeneticCode())
+	}
+	reqValue := reflect.ValueOf(m.Request)
+	if reqValue.Kind() == reflect.Ptr && !reqValue.IsNil() {
+		reqValue = reqValue.Elem()
+	}
+	if reqValue.Kind() == reflect.Struct {
+		if field := reqValue.FieldByName("GeneticCode"); field.IsValid() && field.Kind() == reflect.String {
+			return GeneticCode(field.String())
 		}
-
-	case *SlacRequest:
-		// Basic validation for SLAC
-
-	case *MultihitRequest:
-		// Basic validation for MULTI-HIT
-
-	case *GardRequest:
-		// Basic validation for GARD
-
-	case *MemeRequest:
-		// Basic validation for MEME
-
-	case *FubarRequest:
-		// Basic validation for FUBAR
-
-	case *ContrastFelRequest:
-		// Basic validation for CONTRAST-FEL
-
-	case *RelaxRequest:
-		// Basic validation for RELAX
-
-	case *BgmRequest:
-		// Basic validation for BGM
-
-	case *NrmRequest:
-		// Basic validation for NRM
-
-	case *FadeRequest:
-		// Basic validation for FADE
-
-	case *SlatkinRequest:
-		// Basic validation for SLATKIN
 	}
-
-	return nil
+	return ""
 }
 
 // GetOutputPath returns the path where results should be stored
@@ -1,6 +1,8 @@
 package datamonkey
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"log"
 	"reflect"
@@ -107,6 +109,8 @@ type requestAdapter struct {
 	startingPointsSet bool
 	errorSink         string
 	errorSinkSet      bool
+	// original is the method-specific request the adapter was built from
+	original interface{}
 }
 
 func (r *requestAdapter) GetAlignment() string {
@@ -234,7 +238,7 @@ func AdaptRequest(req interface{}) (HyPhyRequest, error) {
 	}
 
 	// Create a new adapter
-	adapter := &requestAdapter{}
+	adapter := &requestAdapter{original: req}
 
 	// Get the value of req
 	v := reflect.ValueOf(req).Elem()
@@ -348,3 +352,90 @@ func AdaptRequest(req interface{}) (HyPhyRequest, error) {
 
 	return adapter, nil
 }
+
+// originalRequest returns the method-specific request behind a HyPhyRequest,
+// or nil for an adapter built without one
+func originalRequest(request HyPhyRequest) interface{} {
+	if adapter, ok := request.(*requestAdapter); ok {
+		return adapter.original
+	}
+	return request
+}
+
+// CanonicalRequestJSON serializes the method-specific request behind a HyPhyRequest
+// with sorted keys and without the user token
+func CanonicalRequestJSON(request HyPhyRequest) (string, error) {
+	original := originalRequest(request)
+	if original == nil {
+		return "", fmt.Errorf("request has no original parameters")
+	}
+
+	data, err := json.Marshal(original)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize request: %v", err)
+	}
+
+	// Round-trip through a map so keys are sorted
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", fmt.Errorf("failed to serialize request: %v", err)
+	}
+	delete(fields, "user_token")
+
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize request: %v", err)
+	}
+	return string(canonical), nil
+}
+
+// NewMethodRequest returns an empty method-specific request for a method type
+func NewMethodRequest(methodType HyPhyMethodType) (interface{}, error) {
+	switch methodType {
+	case MethodFEL:
+		return &FelRequest{}, nil
+	case MethodBUSTED:
+		return &BustedRequest{}, nil
+	case MethodABSREL:
+		return &AbsrelRequest{}, nil
+	case MethodSLAC:
+		return &SlacRequest{}, nil
+	case MethodMULTIHIT:
+		return &MultihitRequest{}, nil
+	case MethodGARD:
+		return &GardRequest{}, nil
+	case MethodMEME:
+		return &MemeRequest{}, nil
+	case MethodFUBAR:
+		return &FubarRequest{}, nil
+	case MethodCONTRASTFEL:
+		return &ContrastFelRequest{}, nil
+	case MethodRELAX:
+		return &RelaxRequest{}, nil
+	case MethodBGM:
+		return &BgmRequest{}, nil
+	case MethodNRM:
+		return &NrmRequest{}, nil
+	case MethodFADE:
+		return &FadeRequest{}, nil
+	case MethodSLATKIN:
+		return &SlatkinRequest{}, nil
+	}
+	return nil, fmt.Errorf("unsupported method type: %s", methodType)
+}
+
+// DecodeMethodRequest decodes request JSON into the method-specific request type,
+// rejecting parameters the method does not accept
+func DecodeMethodRequest(methodType HyPhyMethodType, data []byte) (interface{}, error) {
+	request, err := NewMethodRequest(methodType)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(request); err != nil {
+		return nil, fmt.Errorf("invalid %s parameters: %v", methodType, err)
+	}
+	return request, nil
+}
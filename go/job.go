@@ -1,8 +1,6 @@
 package datamonkey
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"time"
 )
@@ -48,7 +46,9 @@ type SchedulerInterface interface {
 
 // ComputeMethodInterface defines method-specific operations
 type ComputeMethodInterface interface {
-	GetCommand() string
+	// GetCommand returns the program and arguments a job runs, which schedulers
+	// append --output to
+	GetCommand() Command
 	ValidateInput(dataset DatasetInterface) error
 	ParseResult(output string) (interface{}, error)
 }
@@ -101,7 +101,7 @@ func (j *BaseJob) Validate() error {
 
 	// Check for empty command
 	command := j.Method.GetCommand()
-	if command == "" {
+	if command.IsEmpty() {
 		return fmt.Errorf("job command cannot be empty")
 	}
 
@@ -111,11 +111,10 @@ func (j *BaseJob) Validate() error {
 // NewBaseJob creates a new BaseJob instance
 func NewBaseJob(alignmentId string, treeId string, scheduler SchedulerInterface, method ComputeMethodInterface) *BaseJob {
 	now := time.Now()
-	cmd := method.GetCommand()
-	cmdHash := sha256.Sum256([]byte(cmd))
 
+	// Callers with access to dataset content replace this with a JobIdentity ID
 	return &BaseJob{
-		Id:          hex.EncodeToString(cmdHash[:]),
+		Id:          LegacyJobID(method),
 		AlignmentId: alignmentId,
 		TreeId:      treeId,
 		Scheduler:   scheduler,
@@ -0,0 +1,304 @@
+package datamonkey
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// JobEventType identifies a job lifecycle transition
+type JobEventType string
+
+const (
+	JobEventSubmitted JobEventType = "submitted"
+	JobEventStarted   JobEventType = "started"
+	JobEventCompleted JobEventType = "completed"
+	JobEventFailed    JobEventType = "failed"
+	JobEventCancelled JobEventType = "cancelled"
+)
+
+// JobEvent describes a change in a job's lifecycle
+type JobEvent struct {
+	Type           JobEventType    `json:"type"`
+	JobID          string          `json:"job_id"`
+	MethodType     HyPhyMethodType `json:"method_type,omitempty"`
+	PreviousStatus JobStatusValue  `json:"previous_status,omitempty"`
+	Status         JobStatusValue  `json:"status"`
+	Error          string          `json:"error,omitempty"`
+	Timestamp      time.Time       `json:"timestamp"`
+	Job            JobInterface    `json:"-"`
+}
+
+// JobEventSubscriber receives job lifecycle events.
+// Returning an error from a completed event marks the job as failed.
+type JobEventSubscriber interface {
+	HandleJobEvent(event *JobEvent) error
+}
+
+// JobEventSubscriberFunc adapts a function to a JobEventSubscriber
+type JobEventSubscriberFunc func(event *JobEvent) error
+
+// HandleJobEvent calls f(event)
+func (f JobEventSubscriberFunc) HandleJobEvent(event *JobEvent) error {
+	return f(event)
+}
+
+// JobEventBus dispatches job lifecycle events to registered subscribers
+type JobEventBus struct {
+	mu          sync.RWMutex
+	subscribers []JobEventSubscriber
+	watchers    map[string]map[chan JobEvent]struct{}
+}
+
+// NewJobEventBus creates a new JobEventBus instance
+func NewJobEventBus() *JobEventBus {
+	return &JobEventBus{
+		watchers: make(map[string]map[chan JobEvent]struct{}),
+	}
+}
+
+// Subscribe registers a subscriber; subscribers are called in registration order
+func (b *JobEventBus) Subscribe(subscriber JobEventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, subscriber)
+}
+
+// Watch returns a channel receiving the final form of every event published for a job,
+// and a function that stops the watch. Events are dropped if the watcher falls behind.
+func (b *JobEventBus) Watch(jobID string) (<-chan JobEvent, func()) {
+	ch := make(chan JobEvent, 16)
+
+	b.mu.Lock()
+	if b.watchers[jobID] == nil {
+		b.watchers[jobID] = make(map[chan JobEvent]struct{})
+	}
+	b.watchers[jobID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.watchers[jobID], ch)
+			if len(b.watchers[jobID]) == 0 {
+				delete(b.watchers, jobID)
+			}
+		})
+	}
+	return ch, stop
+}
+
+// Publish delivers an event to all subscribers synchronously.
+// If a subscriber rejects a completed event, the event is turned into a failed event
+// before being delivered to the remaining subscribers.
+func (b *JobEventBus) Publish(event *JobEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	b.mu.RLock()
+	subscribers := append([]JobEventSubscriber(nil), b.subscribers...)
+	b.mu.RUnlock()
+
+	for _, subscriber := range subscribers {
+		if err := subscriber.HandleJobEvent(event); err != nil {
+			if event.Type == JobEventCompleted {
+				log.Printf("Job %s rejected on completion: %v", event.JobID, err)
+				event.Type = JobEventFailed
+				event.Status = JobStatusFailed
+				event.Error = err.Error()
+				continue
+			}
+			log.Printf("Error handling %s event for job %s: %v", event.Type, event.JobID, err)
+		}
+	}
+
+	// Notify watchers once subscribers have settled the event
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.watchers[event.JobID] {
+		select {
+		case ch <- *event:
+		default:
+			log.Printf("Dropping %s event for slow watcher of job %s", event.Type, event.JobID)
+		}
+	}
+}
+
+// PublishTransition publishes the event for a job moving from previous to status and
+// returns the status to record, which subscribers may downgrade from complete to failed.
+// A nil bus publishes nothing.
+func (b *JobEventBus) PublishTransition(job JobInterface, methodType HyPhyMethodType, previous JobStatusValue, status JobStatusValue) JobStatusValue {
+	eventType, ok := jobEventTypeForStatus(status)
+	if !ok || b == nil {
+		return status
+	}
+
+	event := &JobEvent{
+		Type:           eventType,
+		JobID:          job.GetId(),
+		MethodType:     methodType,
+		PreviousStatus: previous,
+		Status:         status,
+		Job:            job,
+	}
+	b.Publish(event)
+
+	return event.Status
+}
+
+// jobEventTypeForStatus maps a new job status to the event it represents
+func jobEventTypeForStatus(status JobStatusValue) (JobEventType, bool) {
+	switch status {
+	case JobStatusRunning:
+		return JobEventStarted, true
+	case JobStatusComplete:
+		return JobEventCompleted, true
+	case JobStatusFailed:
+		return JobEventFailed, true
+	case JobStatusCancelled:
+		return JobEventCancelled, true
+	}
+	return "", false
+}
+
+// ResultPublisher is a JobEventSubscriber that copies the output and log of finished jobs
+// from where HyPhy wrote them into the result store. Subscribe it before ResultValidator.
+type ResultPublisher struct {
+	Results BlobStore
+}
+
+// NewResultPublisher creates a new ResultPublisher instance
+func NewResultPublisher(results BlobStore) *ResultPublisher {
+	return &ResultPublisher{Results: results}
+}
+
+// HandleJobEvent publishes the files of completed and failed jobs; a completed job whose
+// results cannot be published is rejected
+func (p *ResultPublisher) HandleJobEvent(event *JobEvent) error {
+	if (event.Type != JobEventCompleted && event.Type != JobEventFailed) || event.Job == nil || p.Results == nil {
+		return nil
+	}
+
+	for _, path := range []string{event.Job.GetOutputPath(), event.Job.GetLogPath()} {
+		if path == "" {
+			continue
+		}
+		if err := p.publish(path); err != nil {
+			if path == event.Job.GetOutputPath() && event.Type == JobEventCompleted {
+				return fmt.Errorf("failed to publish results: %v", err)
+			}
+			log.Printf("Warning: failed to publish %s for job %s: %v", path, event.JobID, err)
+		}
+	}
+	return nil
+}
+
+// publish stores one job file under its base name, unless the store already keeps it in place
+func (p *ResultPublisher) publish(path string) error {
+	store, key := jobOutputStore(p.Results, path)
+	if fs, ok := store.(*FileBlobStore); ok {
+		if stored, err := fs.path(key); err == nil && stored == filepath.Clean(path) {
+			return nil
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		// Jobs that fail early may not have written an output file
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	_, err = store.Put(key, f)
+	return err
+}
+
+// ResultValidator is a JobEventSubscriber that checks completed jobs produced parseable results
+type ResultValidator struct {
+	// Results is where published job output is read from; nil reads the job's output path
+	Results BlobStore
+}
+
+// NewResultValidator creates a new ResultValidator instance
+func NewResultValidator() *ResultValidator {
+	return &ResultValidator{}
+}
+
+// HandleJobEvent validates the output of completed jobs with the method's ParseResult
+func (v *ResultValidator) HandleJobEvent(event *JobEvent) error {
+	if event.Type != JobEventCompleted || event.Job == nil {
+		return nil
+	}
+
+	err := v.validate(event.Job)
+	if err != nil {
+		appendJobLog(event.Job.GetLogPath(), fmt.Sprintf("Result validation failed: %v", err))
+	}
+	return err
+}
+
+// validate reads and parses a job's output file
+func (v *ResultValidator) validate(job JobInterface) error {
+	method := job.GetMethod()
+	if method == nil {
+		return nil
+	}
+
+	outputPath := job.GetOutputPath()
+	if outputPath == "" {
+		return fmt.Errorf("job has no output path")
+	}
+
+	output, err := readJobOutput(v.Results, outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read results: %v", err)
+	}
+	if len(output) == 0 {
+		return fmt.Errorf("results file is empty")
+	}
+
+	if _, err := method.ParseResult(string(output)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// JobEventLogger is a JobEventSubscriber that logs every lifecycle event
+type JobEventLogger struct{}
+
+// NewJobEventLogger creates a new JobEventLogger instance
+func NewJobEventLogger() *JobEventLogger {
+	return &JobEventLogger{}
+}
+
+// HandleJobEvent logs the event
+func (l *JobEventLogger) HandleJobEvent(event *JobEvent) error {
+	if event.Error != "" {
+		log.Printf("Job %s %s (%s -> %s): %s", event.JobID, event.Type, event.PreviousStatus, event.Status, event.Error)
+	} else {
+		log.Printf("Job %s %s (%s -> %s)", event.JobID, event.Type, event.PreviousStatus, event.Status)
+	}
+	return nil
+}
+
+// appendJobLog appends a line to a job's log file, ignoring jobs without a log
+func appendJobLog(logPath string, message string) {
+	if logPath == "" {
+		return
+	}
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Warning: failed to write to job log %s: %v", logPath, err)
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, message)
+}
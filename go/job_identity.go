@@ -0,0 +1,151 @@
+package datamonkey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// jobIdentityVersion is bumped whenever the fields that make up a job identity change
+const jobIdentityVersion = 3
+
+// JobIdentity holds everything that determines a job's results.
+// Requests with the same identity are the same analysis and share a job ID.
+type JobIdentity struct {
+	Version       int             `json:"version"`
+	MethodType    HyPhyMethodType `json:"method"`
+	Parameters    json.RawMessage `json:"parameters"`
+	AlignmentHash string          `json:"alignment_hash,omitempty"`
+	TreeHash      string          `json:"tree_hash,omitempty"`
+	HyPhyVersion  string          `json:"hyphy_version"`
+}
+
+// NewJobIdentity builds the identity of a request from its identity parameters,
+// the content hashes of its datasets and the HyPhy version
+func NewJobIdentity(methodType HyPhyMethodType, request HyPhyRequest, alignmentHash, treeHash, hyPhyVersion string) (JobIdentity, error) {
+	parameters, err := identityParameters(request)
+	if err != nil {
+		return JobIdentity{}, err
+	}
+
+	return JobIdentity{
+		Version:       jobIdentityVersion,
+		MethodType:    methodType,
+		Parameters:    json.RawMessage(parameters),
+		AlignmentHash: alignmentHash,
+		TreeHash:      treeHash,
+		HyPhyVersion:  hyPhyVersion,
+	}, nil
+}
+
+// identityParameters serializes the parameters of a request that determine its results.
+// The dataset IDs are left out since they depend on who uploaded the data and the content
+// hashes already cover it, and parameters set to their spec default are left out so an
+// explicit default hashes the same as an unset parameter.
+func identityParameters(request HyPhyRequest) (string, error) {
+	canonical, err := CanonicalRequestJSON(request)
+	if err != nil {
+		return "", err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(canonical), &fields); err != nil {
+		return "", fmt.Errorf("failed to serialize request: %v", err)
+	}
+	delete(fields, "alignment")
+	delete(fields, "tree")
+	for name, defaultValue := range requestDefaults(originalRequest(request)) {
+		if value, ok := fields[name]; ok && isDefaultValue(value, defaultValue) {
+			delete(fields, name)
+		}
+	}
+
+	parameters, err := json.Marshal(fields)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize request: %v", err)
+	}
+	return string(parameters), nil
+}
+
+// requestDefaults returns the default tags of a request's fields by JSON name
+func requestDefaults(request interface{}) map[string]string {
+	defaults := map[string]string{}
+	t := reflect.TypeOf(request)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return defaults
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		defaultValue, ok := field.Tag.Lookup("default")
+		if !ok {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		defaults[name] = defaultValue
+	}
+	return defaults
+}
+
+// isDefaultValue reports whether a decoded JSON value equals a default tag
+func isDefaultValue(value interface{}, defaultValue string) bool {
+	switch v := value.(type) {
+	case string:
+		return v == defaultValue
+	case float64:
+		parsed, err := strconv.ParseFloat(defaultValue, 64)
+		return err == nil && v == parsed
+	case bool:
+		parsed, err := strconv.ParseBool(defaultValue)
+		return err == nil && v == parsed
+	}
+	return false
+}
+
+// ID returns the job ID, a sha256 over the identity's fields one per line.
+// None of the fields can contain a newline: parameters are compact JSON and the version is a single line.
+func (i JobIdentity) ID() string {
+	fields := []string{
+		fmt.Sprintf("v%d", i.Version),
+		string(i.MethodType),
+		string(i.Parameters),
+		i.AlignmentHash,
+		i.TreeHash,
+		i.HyPhyVersion,
+	}
+	hash := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(hash[:])
+}
+
+// LegacyJobID returns the ID jobs were given before job identities: a sha256 of the
+// command line as it was rendered then
+func LegacyJobID(method ComputeMethodInterface) string {
+	commandLine := strings.Join(method.GetCommand().Args, " ")
+	if hyPhyMethod, ok := method.(*HyPhyMethod); ok {
+		commandLine = hyPhyMethod.LegacyCommandLine()
+	}
+	hash := sha256.Sum256([]byte(commandLine))
+	return hex.EncodeToString(hash[:])
+}
+
+// DetectHyPhyVersion runs `hyphy --version` and returns the first line it prints
+func DetectHyPhyVersion(hyPhyPath string) (string, error) {
+	output, err := exec.Command(hyPhyPath, "--version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s --version: %v, output: %s", hyPhyPath, err, string(output))
+	}
+
+	version := strings.TrimSpace(strings.SplitN(strings.TrimSpace(string(output)), "\n", 2)[0])
+	if version == "" {
+		return "", fmt.Errorf("%s --version printed nothing", hyPhyPath)
+	}
+	return version, nil
+}
@@ -11,6 +11,7 @@ type JobStatusMonitor struct {
 	Scheduler     SchedulerInterface
 	MethodFactory func(HyPhyMethodType) (ComputeMethodInterface, error)
 	Interval      time.Duration
+	Events        *JobEventBus
 	stopChan      chan struct{}
 }
 
@@ -21,6 +22,7 @@ func NewJobStatusMonitor(jobTracker JobTracker, scheduler SchedulerInterface, me
 		Scheduler:     scheduler,
 		MethodFactory: methodFactory,
 		Interval:      interval,
+		Events:        NewJobEventBus(),
 		stopChan:      make(chan struct{}),
 	}
 }
@@ -81,6 +83,10 @@ func (m *JobStatusMonitor) checkJobStatuses() {
 			},
 			SchedulerJobID: jobInfo.SchedulerJobID,
 		}
+		if hyPhyMethod, ok := method.(*HyPhyMethod); ok {
+			job.OutputPath = hyPhyMethod.GetOutputPath(jobInfo.ID)
+			job.LogPath = hyPhyMethod.GetLogPath(jobInfo.ID)
+		}
 
 		// Get the real-time status from the scheduler
 		realtimeStatus, err := m.Scheduler.GetStatus(job)
@@ -89,12 +95,19 @@ func (m *JobStatusMonitor) checkJobStatuses() {
 			continue
 		}
 
-		// If the status has changed, update the database
+		// If the status has changed, notify subscribers and update the database
 		if realtimeStatus != jobInfo.Status {
-			log.Printf("Updating status for job %s from %s to %s", jobInfo.ID, jobInfo.Status, realtimeStatus)
-			if err := m.JobTracker.UpdateJobStatus(jobInfo.ID, string(realtimeStatus)); err != nil {
+			newStatus := m.publishTransition(job, jobInfo, realtimeStatus)
+			log.Printf("Updating status for job %s from %s to %s", jobInfo.ID, jobInfo.Status, newStatus)
+			if err := m.JobTracker.UpdateJobStatus(jobInfo.ID, string(newStatus)); err != nil {
 				log.Printf("Error updating status for job %s: %v", jobInfo.ID, err)
 			}
 		}
 	}
 }
+
+// publishTransition publishes the lifecycle event for a status change and returns
+// the status to record, which subscribers may downgrade from complete to failed.
+func (m *JobStatusMonitor) publishTransition(job JobInterface, jobInfo JobInfo, status JobStatusValue) JobStatusValue {
+	return m.Events.PublishTransition(job, jobInfo.MethodType, jobInfo.Status, status)
+}
@@ -49,6 +49,16 @@ type JobTracker interface {
 
 	// ListJobsByStatus retrieves all jobs that have one of the given statuses
 	ListJobsByStatus(statuses []JobStatusValue) ([]JobInfo, error)
+
+	// StoreJobRequest stores the canonical request JSON and command line of a job
+	StoreJobRequest(jobID string, requestJSON string, command string) error
+
+	// GetJobRequest retrieves the canonical request JSON and command line of a job
+	GetJobRequest(jobID string) (requestJSON string, command string, err error)
+
+	// GetJobDatasetVersions retrieves the versions of the alignment and tree a job ran on,
+	// or 0 for a dataset the job has none of
+	GetJobDatasetVersions(jobID string) (alignmentVersion int, treeVersion int, err error)
 }
 
 // SQLiteJobTracker implements JobTracker using the unified SQLite database
@@ -252,12 +262,29 @@ func (t *SQLiteJobTracker) ListJobsByUser(userID string) ([]string, error) {
 
 // StoreJobMetadata stores additional metadata about a job
 func (t *SQLiteJobTracker) StoreJobMetadata(jobID string, alignmentID string, treeID string, methodType string, status string) error {
-	query := `UPDATE jobs SET alignment_id = ?, tree_id = ?, method_type = ?, status = ?, updated_at = strftime('%s', 'now') WHERE job_id = ?`
+	// The content hashes of the inputs keep their blobs stored while the job exists,
+	// even if the datasets are deleted. Their versions record exactly what the job ran on.
+	query := `
+	UPDATE jobs SET
+		alignment_id = ?, tree_id = ?, method_type = ?, status = ?,
+		alignment_hash = (SELECT content_hash FROM datasets WHERE id = ?),
+		tree_hash = (SELECT content_hash FROM datasets WHERE id = ?),
+		alignment_version = (SELECT version FROM datasets WHERE id = ?),
+		tree_version = (SELECT version FROM datasets WHERE id = ?),
+		updated_at = strftime('%s', 'now')
+	WHERE job_id = ?
+	`
+	alignment := sql.NullString{String: alignmentID, Valid: alignmentID != ""}
+	tree := sql.NullString{String: treeID, Valid: treeID != ""}
 	_, err := t.db.Exec(query,
-		sql.NullString{String: alignmentID, Valid: alignmentID != ""},
-		sql.NullString{String: treeID, Valid: treeID != ""},
+		alignment,
+		tree,
 		sql.NullString{String: methodType, Valid: methodType != ""},
 		sql.NullString{String: status, Valid: status != ""},
+		alignment,
+		tree,
+		alignment,
+		tree,
 		jobID)
 	if err != nil {
 		return fmt.Errorf("failed to store job metadata: %v", err)
@@ -367,6 +394,61 @@ func (t *SQLiteJobTracker) ListJobsWithFilters(filters map[string]interface{}) (
 	return jobIDs, nil
 }
 
+// StoreJobRequest stores the canonical request JSON and command line of a job
+func (t *SQLiteJobTracker) StoreJobRequest(jobID string, requestJSON string, command string) error {
+	query := `UPDATE jobs SET request_json = ?, command = ?, updated_at = strftime('%s', 'now') WHERE job_id = ?`
+	result, err := t.db.Exec(query,
+		sql.NullString{String: requestJSON, Valid: requestJSON != ""},
+		sql.NullString{String: command, Valid: command != ""},
+		jobID)
+	if err != nil {
+		return fmt.Errorf("failed to store job request: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("job ID not found in tracker")
+	}
+
+	return nil
+}
+
+// GetJobRequest retrieves the canonical request JSON and command line of a job
+func (t *SQLiteJobTracker) GetJobRequest(jobID string) (string, string, error) {
+	query := `SELECT request_json, command FROM jobs WHERE job_id = ?`
+
+	var requestJSON, command sql.NullString
+	err := t.db.QueryRow(query, jobID).Scan(&requestJSON, &command)
+	if err == sql.ErrNoRows {
+		return "", "", fmt.Errorf("job ID not found in tracker")
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get job request: %v", err)
+	}
+
+	return requestJSON.String, command.String, nil
+}
+
+// GetJobDatasetVersions retrieves the versions of the alignment and tree a job ran on
+func (t *SQLiteJobTracker) GetJobDatasetVersions(jobID string) (int, int, error) {
+	query := `SELECT alignment_version, tree_version FROM jobs WHERE job_id = ?`
+
+	var alignmentVersion, treeVersion sql.NullInt64
+	err := t.db.QueryRow(query, jobID).Scan(&alignmentVersion, &treeVersion)
+	if err == sql.ErrNoRows {
+		return 0, 0, fmt.Errorf("job ID not found in tracker")
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get job dataset versions: %v", err)
+	}
+
+	return int(alignmentVersion.Int64), int(treeVersion.Int64), nil
+}
+
 // GetJobMetadata retrieves metadata for a specific job
 func (t *SQLiteJobTracker) GetJobMetadata(jobID string) (string, string, string, string, error) {
 	query := `SELECT alignment_id, tree_id, method_type, status FROM jobs WHERE job_id = ?`
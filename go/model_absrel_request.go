@@ -20,10 +20,10 @@ type AbsrelRequest struct {
 	Tree string `json:"tree,omitempty" validate:"regexp=^[a-zA-Z0-9]+$"`
 
 	// Include synonymous rate variation in the model
-	Srv string `json:"srv,omitempty"`
+	Srv string `json:"srv,omitempty" validate:"enum=Yes|No" default:"Yes"`
 
 	// Specify handling of multiple nucleotide substitutions
-	MultipleHits string `json:"multiple_hits,omitempty"`
+	MultipleHits string `json:"multiple_hits,omitempty" validate:"enum=None|Double|Double+Triple" default:"None"`
 
 	GeneticCode GeneticCode `json:"genetic_code,omitempty"`
 
@@ -31,5 +31,5 @@ type AbsrelRequest struct {
 	Branches []string `json:"branches,omitempty"`
 
 	// Bag of little bootstrap alignment resampling rate
-	Blb float32 `json:"blb,omitempty"`
+	Blb float32 `json:"blb,omitempty" validate:"minimum=0,maximum=1" default:"1"`
 }
@@ -0,0 +1,19 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// AccountCredentials - Email address and password of an account
+type AccountCredentials struct {
+	Email string `json:"email"`
+
+	// At least 8 characters
+	Password string `json:"password"`
+}
@@ -0,0 +1,38 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// AlignmentStats - Quality control statistics of an uploaded alignment
+type AlignmentStats struct {
+
+	// Detected alignment format (fasta, nexus, phylip or clustal)
+	Format string `json:"format,omitempty"`
+
+	// Detected alphabet (nucleotide or protein)
+	Alphabet string `json:"alphabet,omitempty"`
+
+	SequenceCount int32 `json:"sequence_count"`
+
+	// Number of columns in the alignment
+	AlignmentLength int32 `json:"alignment_length"`
+
+	// Fraction of all characters that are gaps
+	GapFraction float64 `json:"gap_fraction"`
+
+	// Total number of ambiguous characters
+	AmbiguousCount int32 `json:"ambiguous_count"`
+
+	// Number of each ambiguous character, e.g. {\"N\": 12, \"R\": 1}
+	AmbiguousCharacters map[string]int32 `json:"ambiguous_characters"`
+
+	// Sequence names that appear more than once
+	DuplicateNames []string `json:"duplicate_names"`
+}
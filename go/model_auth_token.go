@@ -0,0 +1,33 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// AuthToken - A token identifying a session or a login to an account
+type AuthToken struct {
+
+	// Token to send as a Bearer token, the user_token header or the user_token query parameter
+	AccessToken string `json:"access_token"`
+
+	// Always Bearer
+	TokenType string `json:"token_type"`
+
+	// Seconds until the token expires
+	ExpiresIn int64 `json:"expires_in"`
+
+	// Single-use token for /auth/refresh, which returns a new access token and refresh token
+	RefreshToken string `json:"refresh_token,omitempty"`
+
+	// Subject that owns the caller's datasets, jobs and conversations
+	Subject string `json:"subject"`
+
+	// ID of the account the token is for; absent for anonymous sessions
+	AccountId string `json:"account_id,omitempty"`
+}
@@ -20,25 +20,25 @@ type BgmRequest struct {
 	Tree string `json:"tree,omitempty" validate:"regexp=^[a-zA-Z0-9]+$"`
 
 	// Specify branches to test
-	Branches string `json:"branches,omitempty"`
+	Branches string `json:"branches,omitempty" default:"All"`
 
 	GeneticCode GeneticCode `json:"genetic_code,omitempty"`
 
 	// The type of data being analyzed
-	DataType string `json:"data_type,omitempty"`
+	DataType string `json:"data_type,omitempty" validate:"enum=nucleotide|amino-acid|codon" default:"codon"`
 
 	// Number of MCMC steps to sample
-	Steps int32 `json:"steps,omitempty"`
+	Steps int32 `json:"steps,omitempty" default:"100000"`
 
 	// Number of MCMC steps to discard as burn-in
-	BurnIn int32 `json:"burn_in,omitempty"`
+	BurnIn int32 `json:"burn_in,omitempty" default:"10000"`
 
 	// Number of samples to extract from the chain
-	Samples int32 `json:"samples,omitempty"`
+	Samples int32 `json:"samples,omitempty" default:"100"`
 
 	// Maximum number of parents allowed per node
-	MaxParents int32 `json:"max_parents,omitempty"`
+	MaxParents int32 `json:"max_parents,omitempty" default:"1"`
 
 	// Minimum number of substitutions per site to include in the analysis
-	MinSubs int32 `json:"min_subs,omitempty"`
+	MinSubs int32 `json:"min_subs,omitempty" default:"1"`
 }
@@ -0,0 +1,25 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// BranchLabelGroup - Branches sharing a HyPhy {label} annotation
+type BranchLabelGroup struct {
+	Label string `json:"label"`
+
+	// Value to pass in a branch selection, e.g. {Foreground}
+	Selector string `json:"selector"`
+
+	// Number of branches with the label, including unnamed internal branches
+	BranchCount int32 `json:"branch_count"`
+
+	// Names of the named branches with the label
+	Branches []string `json:"branches"`
+}
@@ -20,10 +20,10 @@ type BustedRequest struct {
 	Tree string `json:"tree,omitempty" validate:"regexp=^[a-zA-Z0-9]+$"`
 
 	// Include synonymous rate variation in the model
-	Srv string `json:"srv,omitempty"`
+	Srv string `json:"srv,omitempty" validate:"enum=Yes|No|branch-site" default:"Yes"`
 
 	// Specify handling of multiple nucleotide substitutions
-	MultipleHits string `json:"multiple_hits,omitempty"`
+	MultipleHits string `json:"multiple_hits,omitempty" validate:"enum=None|Double|Double+Triple" default:"None"`
 
 	GeneticCode GeneticCode `json:"genetic_code,omitempty"`
 
@@ -31,17 +31,17 @@ type BustedRequest struct {
 	Branches []string `json:"branches,omitempty"`
 
 	// The number omega rate classes to include in the model
-	Rates int32 `json:"rates,omitempty"`
+	Rates int32 `json:"rates,omitempty" validate:"minimum=1,maximum=10" default:"3"`
 
 	// The number synonymous rate classes to include in the model
-	SynRates int32 `json:"syn_rates,omitempty"`
+	SynRates int32 `json:"syn_rates,omitempty" validate:"minimum=1,maximum=10" default:"3"`
 
 	// The number of points in the initial distributional guess for likelihood fitting
-	GridSize int32 `json:"grid_size,omitempty"`
+	GridSize int32 `json:"grid_size,omitempty" validate:"minimum=1" default:"250"`
 
 	// The number of initial random guesses to seed rate values optimization
-	StartingPoints int32 `json:"starting_points,omitempty"`
+	StartingPoints int32 `json:"starting_points,omitempty" validate:"minimum=1" default:"1"`
 
 	// An advanced experimental setting; include a rate class to capture misalignment artifacts
-	ErrorSink string `json:"error_sink,omitempty"`
+	ErrorSink string `json:"error_sink,omitempty" validate:"enum=Yes|No" default:"No"`
 }
@@ -0,0 +1,18 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// ClaimRequest - Names the anonymous session whose resources move to the caller's account
+type ClaimRequest struct {
+
+	// Token of the anonymous session
+	SessionToken string `json:"session_token"`
+}
@@ -0,0 +1,24 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// ClaimResult - Numbers of each kind of resource moved to the account
+type ClaimResult struct {
+	Datasets int32 `json:"datasets"`
+
+	Jobs int32 `json:"jobs"`
+
+	Conversations int32 `json:"conversations"`
+
+	Visualizations int32 `json:"visualizations"`
+
+	Uploads int32 `json:"uploads"`
+}
@@ -15,25 +15,25 @@ type ContrastFelRequest struct {
 	// Token identifying the user who is starting the job
 	UserToken string `json:"user_token,omitempty"`
 
-	Alignment string `json:"alignment" validate:"regexp=^[a-zA-Z0-9]+$"`
+	Alignment string `json:"alignment" validate:"required,regexp=^[a-zA-Z0-9]+$"`
 
-	Tree string `json:"tree" validate:"regexp=^[a-zA-Z0-9]+$"`
+	Tree string `json:"tree" validate:"required,regexp=^[a-zA-Z0-9]+$"`
 
 	// Array of branch sets to be used for comparison (e.g., \"Source\" and \"Test\" groups)
-	BranchSets []string `json:"branch_sets"`
+	BranchSets []string `json:"branch_sets" validate:"required"`
 
 	// Which genetic code should be used
-	GeneticCode string `json:"genetic_code,omitempty"`
+	GeneticCode string `json:"genetic_code,omitempty" default:"Universal"`
 
 	// Include synonymous rate variation in the model (\"Yes\" or \"No\")
-	Srv string `json:"srv,omitempty"`
+	Srv string `json:"srv,omitempty" validate:"enum=Yes|No" default:"Yes"`
 
 	// Perform permutation significance tests (\"Yes\" or \"No\")
-	Permutations string `json:"permutations,omitempty"`
+	Permutations string `json:"permutations,omitempty" validate:"enum=Yes|No" default:"Yes"`
 
 	// Significance value for site tests
-	PValue float32 `json:"p_value,omitempty"`
+	PValue float32 `json:"p_value,omitempty" validate:"minimum=0,maximum=1" default:"0.05"`
 
 	// Significance value for False Discovery Rate reporting
-	QValue float32 `json:"q_value,omitempty"`
+	QValue float32 `json:"q_value,omitempty" validate:"minimum=0,maximum=1" default:"0.2"`
 }
@@ -0,0 +1,22 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// CreateUploadRequest - Starts a resumable upload
+type CreateUploadRequest struct {
+	Meta DatasetMeta `json:"meta"`
+
+	// Total size of the file in bytes
+	Length int64 `json:"length"`
+
+	// Optional SHA-256 of the whole file, checked when the upload is finalized
+	Sha256 string `json:"sha256,omitempty"`
+}
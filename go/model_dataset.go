@@ -21,6 +21,9 @@ type Dataset struct {
 
 	Type string `json:"type,omitempty"`
 
+	// Labels for finding the dataset
+	Tags []string `json:"tags,omitempty"`
+
 	Id string `json:"id,omitempty" validate:"regexp=^[a-zA-Z0-9]+$"`
 
 	// Token identifying the user who owns this dataset
@@ -30,6 +33,18 @@ type Dataset struct {
 
 	Updated time.Time `json:"updated,omitempty"`
 
+	// ID of the first version of the logical dataset this dataset is a version of
+	SeriesId string `json:"series_id,omitempty"`
+
+	// Version of the logical dataset, numbered from 1
+	Version int32 `json:"version,omitempty"`
+
 	// The actual dataset content (sequence data). Only included when `include_content=true` query parameter is used. For FASTA files, this will be the raw FASTA format text.
 	Content string `json:"content,omitempty"`
+
+	Alignment *AlignmentStats `json:"alignment,omitempty"`
+
+	Tree *TreeStats `json:"tree,omitempty"`
+
+	Lineage *DatasetLineage `json:"lineage,omitempty"`
 }
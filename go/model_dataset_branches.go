@@ -0,0 +1,27 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// DatasetBranches - The branches of a tree dataset that analyses can select
+type DatasetBranches struct {
+	DatasetId string `json:"dataset_id"`
+
+	Rooted bool `json:"rooted"`
+
+	// Keywords that select groups of branches in any tree
+	Keywords []string `json:"keywords"`
+
+	// Named branches of the tree, in preorder
+	Branches []TreeBranch `json:"branches"`
+
+	// HyPhy {label} groups annotated in the tree
+	Labels []BranchLabelGroup `json:"labels"`
+}
@@ -0,0 +1,21 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// DatasetLineage - How a derived dataset was made from its parent
+type DatasetLineage struct {
+
+	// ID of the dataset this one was derived from
+	ParentId string `json:"parent_id"`
+
+	// Operations applied to the parent, in order
+	Operations []DatasetOperation `json:"operations"`
+}
@@ -16,4 +16,10 @@ type DatasetMeta struct {
 	Description string `json:"description,omitempty"`
 
 	Type string `json:"type,omitempty"`
+
+	// Labels for finding the dataset
+	Tags []string `json:"tags,omitempty"`
+
+	// The ID of a dataset this one is a new version of
+	VersionOf string `json:"version_of,omitempty"`
 }
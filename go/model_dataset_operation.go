@@ -0,0 +1,35 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// DatasetOperation - One step of a dataset derivation
+type DatasetOperation struct {
+
+	// keep, drop, slice, strip_gaps, dedupe or translate
+	Op string `json:"op"`
+
+	// Sequence names selected by keep and drop
+	Names []string `json:"names,omitempty"`
+
+	// Regular expression selecting sequences by name for keep and drop
+	Pattern string `json:"pattern,omitempty"`
+
+	// First position kept by slice, 1-based
+	Start int32 `json:"start,omitempty"`
+
+	// Last position kept by slice, inclusive; 0 keeps through the end
+	End int32 `json:"end,omitempty"`
+
+	// Whether slice positions count codons and strip_gaps removes whole gap codons
+	Codon bool `json:"codon,omitempty"`
+
+	GeneticCode GeneticCode `json:"genetic_code,omitempty"`
+}
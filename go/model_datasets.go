@@ -12,4 +12,7 @@ package datamonkey
 
 type Datasets struct {
 	Datasets []Dataset `json:"datasets,omitempty"`
+
+	// Number of datasets matching the filters, across all pages
+	Total int32 `json:"total,omitempty"`
 }
@@ -0,0 +1,26 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// DeriveDatasetRequest - Operations deriving a new dataset from an existing one
+type DeriveDatasetRequest struct {
+
+	// Name of the new dataset; defaults to the parent's name with \" (derived)\"
+	Name string `json:"name,omitempty"`
+
+	Description string `json:"description,omitempty"`
+
+	// Operations applied in order
+	Operations []DatasetOperation `json:"operations"`
+
+	// Tree dataset to prune to the sequences of the derived alignment
+	TreeId string `json:"tree_id,omitempty"`
+}
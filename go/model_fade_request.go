@@ -20,5 +20,5 @@ type FadeRequest struct {
 	Tree string `json:"tree,omitempty" validate:"regexp=^[a-zA-Z0-9]+$"`
 
 	// Bayes Factor threshold for determining significant sites (default 100)
-	BayesFactorThreshold int32 `json:"bayes_factor_threshold,omitempty"`
+	BayesFactorThreshold int32 `json:"bayes_factor_threshold,omitempty" validate:"minimum=1,maximum=1000" default:"100"`
 }
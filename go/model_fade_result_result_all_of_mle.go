@@ -17,5 +17,5 @@ type FadeResultResultAllOfMle struct {
 	Headers [][]string `json:"headers,omitempty"`
 
 	// MLE content organized by amino acid and partition
-	Content map[string]map[string][][]float32 `json:"content,omitempty"`
+	Content map[string]map[string][][]float64 `json:"content,omitempty"`
 }
@@ -20,19 +20,19 @@ type FelRequest struct {
 	Tree string `json:"tree,omitempty" validate:"regexp=^[a-zA-Z0-9]+$"`
 
 	// Compute confidence intervals for estimated rates
-	Ci string `json:"ci,omitempty"`
+	Ci string `json:"ci,omitempty" validate:"enum=Yes|No" default:"No"`
 
 	// Include synonymous rate variation in the model
-	Srv string `json:"srv,omitempty"`
+	Srv string `json:"srv,omitempty" validate:"enum=Yes|No" default:"Yes"`
 
 	// Number of bootstrap resamples
-	Resample float32 `json:"resample,omitempty"`
+	Resample float32 `json:"resample,omitempty" validate:"minimum=0" default:"0"`
 
 	// Specify handling of multiple nucleotide substitutions
-	MultipleHits string `json:"multiple_hits,omitempty"`
+	MultipleHits string `json:"multiple_hits,omitempty" validate:"enum=None|Double|Double+Triple" default:"None"`
 
 	// Specify whether to estimate multiple hit rates for each site
-	SiteMultihit string `json:"site_multihit,omitempty"`
+	SiteMultihit string `json:"site_multihit,omitempty" validate:"enum=Estimate|Global" default:"Estimate"`
 
 	GeneticCode GeneticCode `json:"genetic_code,omitempty"`
 
@@ -13,5 +13,5 @@ package datamonkey
 type FelResultResultAllOfMle struct {
 	Headers [][]string `json:"headers,omitempty"`
 
-	Content map[string][][]float32 `json:"content,omitempty"`
+	Content map[string][][]float64 `json:"content,omitempty"`
 }
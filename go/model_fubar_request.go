@@ -15,16 +15,16 @@ type FubarRequest struct {
 	// Token identifying the user who is starting the job
 	UserToken string `json:"user_token,omitempty"`
 
-	Alignment string `json:"alignment" validate:"regexp=^[a-zA-Z0-9]+$"`
+	Alignment string `json:"alignment" validate:"required,regexp=^[a-zA-Z0-9]+$"`
 
-	Tree string `json:"tree" validate:"regexp=^[a-zA-Z0-9]+$"`
+	Tree string `json:"tree" validate:"required,regexp=^[a-zA-Z0-9]+$"`
 
 	// Which genetic code should be used
-	GeneticCode string `json:"genetic_code,omitempty"`
+	GeneticCode string `json:"genetic_code,omitempty" default:"Universal"`
 
 	// Number of grid points for the Bayesian analysis (must be between 5 and 50)
-	GridPoints int32 `json:"grid_points,omitempty"`
+	GridPoints int32 `json:"grid_points,omitempty" validate:"minimum=5,maximum=50" default:"20"`
 
 	// Concentration parameter for the Dirichlet prior in the Bayesian estimation
-	ConcentrationParameter float32 `json:"concentration_parameter,omitempty"`
+	ConcentrationParameter float32 `json:"concentration_parameter,omitempty" validate:"minimum=0.001,maximum=1" default:"0.5"`
 }
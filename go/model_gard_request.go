@@ -20,17 +20,17 @@ type GardRequest struct {
 	GeneticCode GeneticCode `json:"genetic_code,omitempty"`
 
 	// The type of data being analyzed
-	DataType string `json:"data_type,omitempty"`
+	DataType string `json:"data_type,omitempty" validate:"enum=Nucleotide|Protein" default:"Nucleotide"`
 
 	// The optimization mode
-	RunMode string `json:"run_mode,omitempty"`
+	RunMode string `json:"run_mode,omitempty" validate:"enum=Normal|Faster" default:"Normal"`
 
 	// Specifies the model for rate variation among sites
-	SiteToSiteVariation string `json:"site_to_site_variation,omitempty"`
+	SiteToSiteVariation string `json:"site_to_site_variation,omitempty" validate:"enum=None|General Discrete|Beta-Gamma" default:"None"`
 
 	// The number of discrete rate classes for rate variation
-	RateClasses int32 `json:"rate_classes,omitempty"`
+	RateClasses int32 `json:"rate_classes,omitempty" default:"2"`
 
 	// The substitution model to use
-	Model string `json:"model,omitempty"`
+	Model string `json:"model,omitempty" default:"JTT"`
 }
@@ -17,4 +17,7 @@ type InvalidDataErrorErrorsInner struct {
 
 	// The error message
 	Message string `json:"message,omitempty"`
+
+	// The line of the uploaded file that caused the error
+	Line int32 `json:"line,omitempty"`
 }
@@ -17,10 +17,22 @@ type JobStatus struct {
 
 	TreeId string `json:"tree_id,omitempty" validate:"regexp=^[a-zA-Z0-9]+$"`
 
+	// The version of the alignment dataset the job ran on
+	AlignmentVersion int32 `json:"alignment_version,omitempty"`
+
+	// The version of the tree dataset the job ran on
+	TreeVersion int32 `json:"tree_version,omitempty"`
+
 	Status string `json:"status,omitempty"`
 
 	ErrorMessage string `json:"error_message,omitempty"`
 
 	// The HyPhy method used for this job
 	Method string `json:"method,omitempty"`
+
+	// The parameters the job was started with
+	Request map[string]interface{} `json:"request,omitempty"`
+
+	// The HyPhy command line the job runs
+	Command string `json:"command,omitempty"`
 }
@@ -0,0 +1,39 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// JsonWebKey - Public key that tokens are verified with, in RFC 7517 form
+type JsonWebKey struct {
+
+	// Key type: RSA or OKP
+	Kty string `json:"kty"`
+
+	// Key ID, matching the kid header of the tokens it signed
+	Kid string `json:"kid"`
+
+	// Signing algorithm: RS256 or EdDSA
+	Alg string `json:"alg"`
+
+	// Always sig
+	Use string `json:"use"`
+
+	// RSA modulus, base64url-encoded
+	N string `json:"n,omitempty"`
+
+	// RSA exponent, base64url-encoded
+	E string `json:"e,omitempty"`
+
+	// Curve of an OKP key: Ed25519
+	Crv string `json:"crv,omitempty"`
+
+	// Ed25519 public key, base64url-encoded
+	X string `json:"x,omitempty"`
+}
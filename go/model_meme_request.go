@@ -22,17 +22,17 @@ type MemeRequest struct {
 	GeneticCode GeneticCode `json:"genetic_code,omitempty"`
 
 	// Specify handling of multiple nucleotide substitutions
-	MultipleHits string `json:"multiple_hits,omitempty"`
+	MultipleHits string `json:"multiple_hits,omitempty" validate:"enum=None|Double|Double+Triple" default:"None"`
 
 	// Specify whether to estimate multiple hit rates for each site
-	SiteMultihit string `json:"site_multihit,omitempty"`
+	SiteMultihit string `json:"site_multihit,omitempty" validate:"enum=Estimate|Global" default:"Estimate"`
 
 	// Number of different categories of non-synonymous rates
-	Rates int32 `json:"rates,omitempty"`
+	Rates int32 `json:"rates,omitempty" default:"2"`
 
 	// Number of bootstrapping replicates
-	Resample float32 `json:"resample,omitempty"`
+	Resample float32 `json:"resample,omitempty" validate:"minimum=0" default:"0"`
 
 	// Option to impute likely character states for missing data
-	ImputeStates string `json:"impute_states,omitempty"`
+	ImputeStates string `json:"impute_states,omitempty" validate:"enum=Yes|No" default:"No"`
 }
@@ -26,4 +26,16 @@ type MethodsListMethodsInnerParametersInner struct {
 
 	// Default value of the parameter (if any)
 	Default string `json:"default,omitempty"`
+
+	// The values the parameter may take (if restricted)
+	Enum []string `json:"enum,omitempty"`
+
+	// The smallest value a numeric parameter may take (if bounded)
+	Minimum *float64 `json:"minimum,omitempty"`
+
+	// The largest value a numeric parameter may take (if bounded)
+	Maximum *float64 `json:"maximum,omitempty"`
+
+	// Regular expression a string parameter must match (if any)
+	Pattern string `json:"pattern,omitempty"`
 }
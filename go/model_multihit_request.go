@@ -16,14 +16,14 @@ type MultihitRequest struct {
 	// Token identifying the user who is starting the job
 	UserToken string `json:"user_token,omitempty"`
 
-	Alignment string `json:"alignment" validate:"regexp=^[a-zA-Z0-9]+$"`
+	Alignment string `json:"alignment" validate:"required,regexp=^[a-zA-Z0-9]+$"`
 
 	// The genetic code to use for the analysis
-	GeneticCode string `json:"genetic_code"`
+	GeneticCode string `json:"genetic_code" validate:"required" default:"Universal"`
 
 	// Toggle for accounting synonymous triple-hit substitutions
-	TripleIslands string `json:"triple_islands,omitempty"`
+	TripleIslands string `json:"triple_islands,omitempty" validate:"enum=Yes|No" default:"No"`
 
 	// Number of rate classes to use
-	RateClasses int32 `json:"rate_classes,omitempty"`
+	RateClasses int32 `json:"rate_classes,omitempty" validate:"minimum=1,maximum=10" default:"3"`
 }
@@ -22,5 +22,5 @@ type NrmRequest struct {
 	GeneticCode GeneticCode `json:"genetic_code,omitempty"`
 
 	// Save NRM+F model fit to a file
-	SaveFit bool `json:"save_fit,omitempty"`
+	SaveFit bool `json:"save_fit,omitempty" default:"false"`
 }
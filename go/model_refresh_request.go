@@ -0,0 +1,18 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// RefreshRequest - Exchanges a refresh token for new tokens
+type RefreshRequest struct {
+
+	// Refresh token from the last sign-in or refresh; it can only be used once
+	RefreshToken string `json:"refresh_token"`
+}
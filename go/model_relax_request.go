@@ -28,11 +28,11 @@ type RelaxRequest struct {
 	ReferenceBranches []string `json:"reference_branches,omitempty"`
 
 	// Type of analysis to run (All for descriptive models and RELAX test, Minimal for only the RELAX test)
-	Models string `json:"models,omitempty"`
+	Models string `json:"models,omitempty" validate:"enum=All|Minimal" default:"All"`
 
 	// Number of omega rate classes
-	Rates int32 `json:"rates,omitempty"`
+	Rates int32 `json:"rates,omitempty" default:"3"`
 
 	// Specify whether to handle zero-length branches
-	KillZeroLengths string `json:"kill_zero_lengths,omitempty"`
+	KillZeroLengths string `json:"kill_zero_lengths,omitempty" validate:"enum=Yes|No" default:"No"`
 }
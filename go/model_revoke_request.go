@@ -0,0 +1,18 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// RevokeRequest - Revokes an access token or refresh token
+type RevokeRequest struct {
+
+	// Access token, or refresh token whose whole rotation chain is revoked
+	Token string `json:"token"`
+}
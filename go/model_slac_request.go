@@ -26,8 +26,8 @@ type SlacRequest struct {
 	Branches []string `json:"branches,omitempty"`
 
 	// Number of samples for ancestral reconstruction uncertainty
-	Samples int32 `json:"samples,omitempty"`
+	Samples int32 `json:"samples,omitempty" validate:"minimum=1" default:"100"`
 
 	// Threshold for statistical significance
-	Pvalue float32 `json:"pvalue,omitempty"`
+	Pvalue float32 `json:"pvalue,omitempty" validate:"minimum=0,maximum=1" default:"0.1"`
 }
@@ -18,17 +18,17 @@ type SlatkinRequest struct {
 	Tree string `json:"tree,omitempty" validate:"regexp=^[a-zA-Z0-9]+$"`
 
 	// The number of compartments/groups to test
-	Groups int32 `json:"groups,omitempty"`
+	Groups int32 `json:"groups,omitempty" validate:"minimum=2,maximum=100" default:"2"`
 
 	// Array of compartment definitions
 	CompartmentDefinitions []SlatkinRequestCompartmentDefinitionsInner `json:"compartment_definitions,omitempty"`
 
 	// The number of bootstrap replicates
-	Replicates int32 `json:"replicates,omitempty"`
+	Replicates int32 `json:"replicates,omitempty" validate:"minimum=1,maximum=1e+06" default:"1000"`
 
 	// Probability of branch selection for structured permutation [0-1]; 0 = classical Slatkin-Maddison, 1 = fully structured
-	Weight float32 `json:"weight,omitempty"`
+	Weight float32 `json:"weight,omitempty" validate:"minimum=0,maximum=1" default:"0.2"`
 
 	// Whether to use bootstrap weights to respect well-supported clades
-	UseBootstrap bool `json:"use_bootstrap,omitempty"`
+	UseBootstrap bool `json:"use_bootstrap,omitempty" default:"true"`
 }
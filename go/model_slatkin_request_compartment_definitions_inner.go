@@ -13,8 +13,8 @@ package datamonkey
 type SlatkinRequestCompartmentDefinitionsInner struct {
 
 	// Description for sequences in this compartment
-	Description string `json:"description"`
+	Description string `json:"description" validate:"required"`
 
 	// Regular expression to select the branches in this compartment
-	Regexp string `json:"regexp"`
+	Regexp string `json:"regexp" validate:"required"`
 }
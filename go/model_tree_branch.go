@@ -0,0 +1,24 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// TreeBranch - A named branch of a tree
+type TreeBranch struct {
+	Name string `json:"name"`
+
+	// Whether the branch leads to a leaf
+	Leaf bool `json:"leaf"`
+
+	// HyPhy branch label, e.g. Foreground for name{Foreground}
+	Label string `json:"label,omitempty"`
+
+	Length *float64 `json:"length,omitempty"`
+}
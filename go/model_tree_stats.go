@@ -0,0 +1,36 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// TreeStats - Summary of an uploaded phylogenetic tree
+type TreeStats struct {
+
+	// Detected tree format (newick or nexus)
+	Format string `json:"format,omitempty"`
+
+	// Number of leaves (taxa) in the tree
+	LeafCount int32 `json:"leaf_count"`
+
+	// Number of internal nodes, including the root
+	InternalNodeCount int32 `json:"internal_node_count"`
+
+	// Whether the tree is rooted, from a [&R]/[&U] comment or a bifurcating root
+	Rooted bool `json:"rooted"`
+
+	// Distinct HyPhy branch labels, e.g. Foreground for name{Foreground}
+	BranchLabels []string `json:"branch_labels"`
+
+	// Node names that appear more than once
+	DuplicateNames []string `json:"duplicate_names"`
+
+	// Number of multifurcating nodes: internal nodes with more than two children, or a root with more than three. HyPhy accepts them; the count is reported for QC.
+	PolytomyCount int32 `json:"polytomy_count"`
+}
@@ -0,0 +1,24 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+// UpdateDatasetRequest - Changes the metadata of a dataset; omitted fields are left unchanged
+type UpdateDatasetRequest struct {
+
+	// New name of the dataset
+	Name *string `json:"name,omitempty"`
+
+	// New description of the dataset
+	Description *string `json:"description,omitempty"`
+
+	// Tags replacing the dataset's tags
+	Tags *[]string `json:"tags,omitempty"`
+}
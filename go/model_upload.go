@@ -0,0 +1,37 @@
+/*
+ * Datamonkey API
+ *
+ * Datamonkey is a free public server for comparative analysis of sequence alignments using state-of-the-art statistical models. <br> This is the OpenAPI definition for the Datamonkey API.
+ *
+ * API version: 1.2.0
+ * Contact: spond@temple.edu
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package datamonkey
+
+import "time"
+
+// Upload - A resumable upload in progress
+type Upload struct {
+	Id string `json:"id"`
+
+	Meta DatasetMeta `json:"meta"`
+
+	// Total size of the upload in bytes
+	Length int64 `json:"length"`
+
+	// Number of bytes received so far; the next PATCH must start here
+	Offset int64 `json:"offset"`
+
+	// Whether every byte has been received and the upload can be finalized
+	Complete bool `json:"complete"`
+
+	// SHA-256 of the content, once the upload is complete
+	Sha256 string `json:"sha256,omitempty"`
+
+	Created time.Time `json:"created"`
+
+	// When the upload is discarded if no more data arrives
+	Expires time.Time `json:"expires"`
+}
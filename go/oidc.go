@@ -0,0 +1,246 @@
+package datamonkey
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCConfig holds configuration for signing in to accounts with an OpenID Connect provider
+type OIDCConfig struct {
+	IssuerURL    string   // Issuer URL; its /.well-known/openid-configuration is fetched on first use
+	ClientID     string   // Client ID registered with the provider
+	ClientSecret string   // Client secret registered with the provider
+	RedirectURL  string   // URL of the /auth/oidc/callback endpoint, as registered with the provider
+	Scopes       []string // Scopes to request; defaults to openid
+}
+
+// OIDCIdentity is the identity an ID token asserts
+type OIDCIdentity struct {
+	Issuer  string
+	Subject string
+}
+
+// oidcDiscovery is the part of a provider's discovery document used for the code flow
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider signs users in with the authorization code flow. The discovery document
+// and signing keys are fetched lazily and cached; the keys are fetched again when an ID
+// token names a key ID that is not cached, so provider key rotation is picked up.
+type OIDCProvider struct {
+	Config OIDCConfig
+	Client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+// NewOIDCProvider creates a new OIDCProvider instance
+func NewOIDCProvider(config OIDCConfig) *OIDCProvider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid"}
+	}
+	return &OIDCProvider{
+		Config: config,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// getJSON fetches a JSON document from the provider
+func (p *OIDCProvider) getJSON(url string, target interface{}) error {
+	resp, err := p.Client.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %s: status %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return fmt.Errorf("failed to decode %s: %v", url, err)
+	}
+	return nil
+}
+
+// discover returns the provider's discovery document, fetching it on first use
+func (p *OIDCProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	wellKnown := strings.TrimSuffix(p.Config.IssuerURL, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(wellKnown, &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != p.Config.IssuerURL {
+		return nil, fmt.Errorf("provider issuer %q does not match %q", discovery.Issuer, p.Config.IssuerURL)
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// signingKey returns the provider's RSA key with a key ID, fetching the keys again if it is not cached
+func (p *OIDCProvider) signingKey(kid string) (*rsa.PublicKey, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	p.keys = keys
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("signing key not found: %s", kid)
+	}
+	return key, nil
+}
+
+// NewPKCEVerifier returns a random PKCE code verifier (RFC 7636)
+func NewPKCEVerifier() (string, error) {
+	verifier := make([]byte, 32)
+	if _, err := rand.Read(verifier); err != nil {
+		return "", fmt.Errorf("failed to generate code verifier: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(verifier), nil
+}
+
+// PKCEChallenge returns the S256 code challenge of a code verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL of the provider's sign-in page, which redirects back to
+// RedirectURL with a code and the state. The code can only be exchanged with the
+// verifier whose S256 challenge is passed here.
+func (p *OIDCProvider) AuthCodeURL(state string, nonce string, codeChallenge string) (string, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Config.ClientID},
+		"redirect_uri":          {p.Config.RedirectURL},
+		"scope":                 {strings.Join(p.Config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades an authorization code and its PKCE code verifier for the provider's ID token
+func (p *OIDCProvider) Exchange(code string, codeVerifier string) (string, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.Client.PostForm(discovery.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURL},
+		"client_id":     {p.Config.ClientID},
+		"client_secret": {p.Config.ClientSecret},
+		"code_verifier": {codeVerifier},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange code: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to exchange code: status %d", resp.StatusCode)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %v", err)
+	}
+	if token.IDToken == "" {
+		return "", fmt.Errorf("token response has no id_token")
+	}
+	return token.IDToken, nil
+}
+
+// VerifyIDToken checks an ID token's signature, issuer, audience, expiry and nonce,
+// and returns the identity it asserts
+func (p *OIDCProvider) VerifyIDToken(rawIDToken string, nonce string) (*OIDCIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(kid)
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(p.Config.IssuerURL),
+		jwt.WithAudience(p.Config.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, fmt.Errorf("invalid ID token: nonce does not match")
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("invalid ID token: missing subject")
+	}
+	return &OIDCIdentity{Issuer: p.Config.IssuerURL, Subject: subject}, nil
+}
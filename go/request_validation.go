@@ -0,0 +1,242 @@
+package datamonkey
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ParameterConstraints are the constraints the OpenAPI spec places on a request field.
+// The generated request models carry them in validate tags, e.g.
+// `validate:"required,enum=Yes|No"` or `validate:"minimum=1,maximum=10"`.
+type ParameterConstraints struct {
+	Required bool
+	Enum     []string
+	Minimum  *float64
+	Maximum  *float64
+	Pattern  string
+}
+
+// typeEnums are the enums of named types that request fields refer to by $ref
+var typeEnums = map[reflect.Type][]string{
+	reflect.TypeOf(GeneticCode("")): {
+		string(UNIVERSAL), string(VERTEBRATE_MT_DNA), string(YEAST_MT_DNA), string(MOLD_PROTOZOAN_MT_DNA),
+		string(INVERTEBRATE_MT_DNA), string(CILIATE_NUCLEAR), string(ECHINODERM_MT_DNA), string(EUPLTOID_NUCLEAR),
+		string(ALT__YEAST_NUCLEAR), string(ASCIDIAN_MT_DNA), string(FLATWORM_MT_DNA), string(BLEPHARISMA_NUCLEAR),
+	},
+}
+
+// fieldConstraints parses the constraints of a request field from its validate tag
+// and type. The regexp key takes the rest of the tag, since patterns may contain commas.
+func fieldConstraints(field reflect.StructField) ParameterConstraints {
+	constraints := ParameterConstraints{Enum: typeEnums[field.Type]}
+	tag := field.Tag.Get("validate")
+	for tag != "" {
+		var item string
+		if strings.HasPrefix(tag, "regexp=") {
+			item, tag = tag, ""
+		} else {
+			item, tag, _ = strings.Cut(tag, ",")
+		}
+		key, value, _ := strings.Cut(item, "=")
+		switch key {
+		case "required":
+			constraints.Required = true
+		case "enum":
+			constraints.Enum = strings.Split(value, "|")
+		case "minimum":
+			if bound, err := strconv.ParseFloat(value, 64); err == nil {
+				constraints.Minimum = &bound
+			}
+		case "maximum":
+			if bound, err := strconv.ParseFloat(value, 64); err == nil {
+				constraints.Maximum = &bound
+			}
+		case "regexp":
+			constraints.Pattern = value
+		}
+	}
+	return constraints
+}
+
+// compiledPatterns caches the compiled field patterns by source
+var compiledPatterns sync.Map
+
+// matchPattern reports whether a value matches a field pattern. A pattern that does not
+// compile matches nothing, so a bad tag rejects values instead of letting them through.
+func matchPattern(pattern string, value string) bool {
+	compiled, ok := compiledPatterns.Load(pattern)
+	if !ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Printf("Warning: Invalid parameter pattern %q: %v", pattern, err)
+			re = nil
+		}
+		compiled, _ = compiledPatterns.LoadOrStore(pattern, re)
+	}
+	re := compiled.(*regexp.Regexp)
+	return re != nil && re.MatchString(value)
+}
+
+// ParameterValidationError lists every request field that violates its constraints
+type ParameterValidationError struct {
+	Problems []InvalidDataErrorErrorsInner
+}
+
+func (e *ParameterValidationError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = fmt.Sprintf("%s: %s", problem.Field, problem.Message)
+	}
+	return fmt.Sprintf("invalid parameters: %s", strings.Join(messages, "; "))
+}
+
+// InvalidData returns the problems in the shape of the API's InvalidDataError
+func (e *ParameterValidationError) InvalidData() InvalidDataError {
+	return InvalidDataError{Errors: e.Problems}
+}
+
+// ValidateParameters checks a method request, or the request behind a HyPhyRequest,
+// against the constraints on its fields and reports every violation at once. Zero
+// values are treated as unset, as the request models omit them; use
+// ValidateParametersJSON when the request body is at hand.
+func ValidateParameters(request interface{}) error {
+	return validateParameters(request, nil)
+}
+
+// ValidateParametersJSON checks a method request decoded from body like
+// ValidateParameters, and also checks the zero values the body sets explicitly, so
+// "rates": 0 or "ci": "" are rejected rather than read as unset. A body that is not a
+// JSON object is checked as ValidateParameters would.
+func ValidateParametersJSON(request interface{}, body []byte) error {
+	var present map[string]interface{}
+	if err := json.Unmarshal(body, &present); err != nil {
+		present = nil
+	}
+	return validateParameters(request, present)
+}
+
+// validateParameters checks a request against its constraints. present holds the
+// decoded JSON object the request came from, or nil when it is unknown.
+func validateParameters(request interface{}, present map[string]interface{}) error {
+	if hyPhyReq, ok := request.(HyPhyRequest); ok {
+		request = originalRequest(hyPhyReq)
+	}
+	value := reflect.ValueOf(request)
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var problems []InvalidDataErrorErrorsInner
+	validateFields(value, present, "", &problems)
+	if len(problems) > 0 {
+		return &ParameterValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validateFields appends the constraint violations of a struct's fields, naming
+// them by JSON path under prefix. Zero values are checked only when present, the
+// JSON object the struct was decoded from, sets them to something other than null.
+func validateFields(value reflect.Value, present map[string]interface{}, prefix string, problems *[]InvalidDataErrorErrorsInner) {
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		raw := present[name]
+		name = prefix + name
+		fieldValue := value.Field(i)
+		constraints := fieldConstraints(field)
+		problem := func(format string, args ...interface{}) {
+			*problems = append(*problems, InvalidDataErrorErrorsInner{Field: name, Message: fmt.Sprintf(format, args...)})
+		}
+
+		if fieldValue.IsZero() {
+			if constraints.Required {
+				problem("is required")
+				continue
+			}
+			if raw == nil {
+				continue
+			}
+		}
+
+		switch fieldValue.Kind() {
+		case reflect.String:
+			str := fieldValue.String()
+			if len(constraints.Enum) > 0 && !containsString(constraints.Enum, str) {
+				problem("must be one of %s, got %q", quoteAll(constraints.Enum), str)
+			}
+			if constraints.Pattern != "" && !matchPattern(constraints.Pattern, str) {
+				problem("must match %s, got %q", constraints.Pattern, str)
+			}
+		case reflect.Int, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+			var number float64
+			if fieldValue.CanInt() {
+				number = float64(fieldValue.Int())
+			} else {
+				number = fieldValue.Float()
+			}
+			// Compare float32 values at their own precision, so 0.001 meets a minimum of 0.001
+			if fieldValue.Kind() == reflect.Float32 {
+				number, _ = strconv.ParseFloat(strconv.FormatFloat(number, 'g', -1, 32), 64)
+			}
+			below := constraints.Minimum != nil && number < *constraints.Minimum
+			above := constraints.Maximum != nil && number > *constraints.Maximum
+			if below || above {
+				switch {
+				case constraints.Minimum != nil && constraints.Maximum != nil:
+					problem("must be between %g and %g, got %g", *constraints.Minimum, *constraints.Maximum, number)
+				case below:
+					problem("must be at least %g, got %g", *constraints.Minimum, number)
+				default:
+					problem("must be at most %g, got %g", *constraints.Maximum, number)
+				}
+			}
+		case reflect.Slice:
+			if fieldValue.Type().Elem().Kind() == reflect.Struct {
+				items, _ := raw.([]interface{})
+				for j := 0; j < fieldValue.Len(); j++ {
+					var item map[string]interface{}
+					if j < len(items) {
+						item, _ = items[j].(map[string]interface{})
+					}
+					validateFields(fieldValue.Index(j), item, fmt.Sprintf("%s[%d].", name, j), problems)
+				}
+			}
+		}
+	}
+}
+
+// containsString reports whether a list holds a value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteAll quotes and joins a list of values for an error message
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,373 @@
+package datamonkey
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JobResourcesMetadataKey is the BaseJob.Metadata key holding the resolved JobResources for a job
+const JobResourcesMetadataKey = "resources"
+
+// ResourceTier overrides a profile's resources once an alignment reaches a given size
+type ResourceTier struct {
+	MinCells     int64  `json:"min_cells"`                // Minimum sequence count x site count for this tier
+	Nodes        int    `json:"nodes,omitempty"`          // Number of nodes to request
+	CoresPerNode int    `json:"cores_per_node,omitempty"` // Number of cores (tasks) per node
+	Memory       string `json:"memory,omitempty"`         // Memory per node (e.g., "8G")
+	MaxTime      string `json:"max_time,omitempty"`       // Maximum time (e.g., "24:00:00")
+}
+
+// ResourceProfile describes the resources requested for one HyPhy method
+type ResourceProfile struct {
+	Nodes        int            `json:"nodes,omitempty"`
+	CoresPerNode int            `json:"cores_per_node,omitempty"`
+	Memory       string         `json:"memory,omitempty"`
+	MaxTime      string         `json:"max_time,omitempty"`
+	MPI          bool           `json:"mpi,omitempty"`   // Launch through the MPI build of HyPhy
+	Tiers        []ResourceTier `json:"tiers,omitempty"` // Size-based overrides, applied in order of MinCells
+}
+
+// ResourceProfileConfig holds resource profiles for all HyPhy methods
+type ResourceProfileConfig struct {
+	// MPILauncher is the command prefix used for MPI methods; "{tasks}" is replaced by the total task count
+	MPILauncher string `json:"mpi_launcher,omitempty"`
+	// MPIExecutable replaces the HyPhy executable for MPI methods
+	MPIExecutable string                              `json:"mpi_executable,omitempty"`
+	Default       ResourceProfile                     `json:"default"`
+	Methods       map[HyPhyMethodType]ResourceProfile `json:"methods,omitempty"`
+}
+
+// JobResources holds the resources resolved for a single job
+type JobResources struct {
+	Nodes         int
+	CoresPerNode  int
+	Memory        string
+	MaxTime       string
+	MPI           bool
+	MPILauncher   string
+	MPIExecutable string
+}
+
+// DefaultResourceProfiles returns the built-in resource profiles
+func DefaultResourceProfiles() *ResourceProfileConfig {
+	// Site-level methods share one profile
+	siteLevel := ResourceProfile{
+		Nodes:        1,
+		CoresPerNode: 4,
+		Memory:       "4G",
+		MaxTime:      "12:00:00",
+		Tiers: []ResourceTier{
+			{MinCells: 1000000, CoresPerNode: 8, Memory: "8G", MaxTime: "24:00:00"},
+		},
+	}
+
+	return &ResourceProfileConfig{
+		MPILauncher:   "mpirun -np {tasks}",
+		MPIExecutable: "HYPHYMPI",
+		Default: ResourceProfile{
+			Nodes:        1,
+			CoresPerNode: 1,
+			Memory:       "900M",
+			MaxTime:      "01:00:00",
+		},
+		Methods: map[HyPhyMethodType]ResourceProfile{
+			MethodSLAC:        {Nodes: 1, CoresPerNode: 1, Memory: "2G", MaxTime: "02:00:00"},
+			MethodFEL:         siteLevel,
+			MethodMEME:        siteLevel,
+			MethodFUBAR:       siteLevel,
+			MethodCONTRASTFEL: siteLevel,
+			MethodBUSTED:      siteLevel,
+			MethodABSREL:      siteLevel,
+			MethodRELAX:       siteLevel,
+			MethodMULTIHIT:    siteLevel,
+			MethodGARD: {
+				Nodes:        1,
+				CoresPerNode: 16,
+				Memory:       "16G",
+				MaxTime:      "48:00:00",
+				MPI:          true,
+				Tiers: []ResourceTier{
+					{MinCells: 1000000, Nodes: 2, Memory: "32G", MaxTime: "96:00:00"},
+				},
+			},
+			MethodBGM: {
+				Nodes:        1,
+				CoresPerNode: 8,
+				Memory:       "16G",
+				MaxTime:      "24:00:00",
+				Tiers: []ResourceTier{
+					{MinCells: 1000000, Memory: "32G", MaxTime: "72:00:00"},
+				},
+			},
+		},
+	}
+}
+
+// LoadResourceProfiles loads resource profiles from a JSON file on top of the built-in profiles.
+// Methods listed in the file replace the built-in profile for that method entirely.
+func LoadResourceProfiles(path string) (*ResourceProfileConfig, error) {
+	config := DefaultResourceProfiles()
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read resource profiles: %v", err)
+	}
+
+	var fileConfig ResourceProfileConfig
+	if err := json.Unmarshal(data, &fileConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse resource profiles: %v", err)
+	}
+
+	if fileConfig.MPILauncher != "" {
+		config.MPILauncher = fileConfig.MPILauncher
+	}
+	if fileConfig.MPIExecutable != "" {
+		config.MPIExecutable = fileConfig.MPIExecutable
+	}
+	if fileConfig.Default.Nodes > 0 {
+		config.Default.Nodes = fileConfig.Default.Nodes
+	}
+	if fileConfig.Default.CoresPerNode > 0 {
+		config.Default.CoresPerNode = fileConfig.Default.CoresPerNode
+	}
+	if fileConfig.Default.Memory != "" {
+		config.Default.Memory = fileConfig.Default.Memory
+	}
+	if fileConfig.Default.MaxTime != "" {
+		config.Default.MaxTime = fileConfig.Default.MaxTime
+	}
+	for method, profile := range fileConfig.Methods {
+		config.Methods[method] = profile
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Validate checks that all memory and time values can be understood by the schedulers
+func (c *ResourceProfileConfig) Validate() error {
+	check := func(name string, memory, maxTime string) error {
+		if memory != "" {
+			if _, err := ParseMemoryMB(memory); err != nil {
+				return fmt.Errorf("invalid memory for %s: %v", name, err)
+			}
+		}
+		if maxTime != "" {
+			if _, err := ParseTimeLimitMinutes(maxTime); err != nil {
+				return fmt.Errorf("invalid max_time for %s: %v", name, err)
+			}
+		}
+		return nil
+	}
+
+	if err := check("default", c.Default.Memory, c.Default.MaxTime); err != nil {
+		return err
+	}
+	known := make(map[HyPhyMethodType]bool)
+	for _, def := range GetMethodRegistry().GetMethods() {
+		known[HyPhyMethodType(def.ID)] = true
+	}
+	for method, profile := range c.Methods {
+		if !known[method] {
+			log.Printf("Warning: resource profile configured for unknown method %s", method)
+		}
+		if err := check(string(method), profile.Memory, profile.MaxTime); err != nil {
+			return err
+		}
+		for _, tier := range profile.Tiers {
+			if err := check(fmt.Sprintf("%s tier %d", method, tier.MinCells), tier.Memory, tier.MaxTime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Resolve returns the resources for a method, scaled by alignment size (sequences x sites)
+func (c *ResourceProfileConfig) Resolve(methodType HyPhyMethodType, sequences, sites int) JobResources {
+	resources := JobResources{
+		Nodes:        c.Default.Nodes,
+		CoresPerNode: c.Default.CoresPerNode,
+		Memory:       c.Default.Memory,
+		MaxTime:      c.Default.MaxTime,
+	}
+
+	profile, ok := c.Methods[methodType]
+	if !ok {
+		return resources
+	}
+
+	if profile.Nodes > 0 {
+		resources.Nodes = profile.Nodes
+	}
+	if profile.CoresPerNode > 0 {
+		resources.CoresPerNode = profile.CoresPerNode
+	}
+	if profile.Memory != "" {
+		resources.Memory = profile.Memory
+	}
+	if profile.MaxTime != "" {
+		resources.MaxTime = profile.MaxTime
+	}
+
+	// Apply every tier the alignment has reached, smallest first
+	cells := int64(sequences) * int64(sites)
+	tiers := append([]ResourceTier(nil), profile.Tiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinCells < tiers[j].MinCells })
+	for _, tier := range tiers {
+		if cells < tier.MinCells {
+			break
+		}
+		if tier.Nodes > 0 {
+			resources.Nodes = tier.Nodes
+		}
+		if tier.CoresPerNode > 0 {
+			resources.CoresPerNode = tier.CoresPerNode
+		}
+		if tier.Memory != "" {
+			resources.Memory = tier.Memory
+		}
+		if tier.MaxTime != "" {
+			resources.MaxTime = tier.MaxTime
+		}
+	}
+
+	if profile.MPI {
+		resources.MPI = true
+		resources.MPILauncher = c.MPILauncher
+		resources.MPIExecutable = c.MPIExecutable
+	}
+
+	return resources
+}
+
+// LaunchCommand wraps a HyPhy command for MPI execution when the job requires it.
+// The launcher is configured by the operator and split into arguments at spaces.
+func (r JobResources) LaunchCommand(command Command) Command {
+	if !r.MPI || r.MPILauncher == "" {
+		return command
+	}
+
+	tasks := r.Nodes * r.CoresPerNode
+	if tasks < 1 {
+		tasks = 1
+	}
+	launcher := strings.Fields(strings.ReplaceAll(r.MPILauncher, "{tasks}", strconv.Itoa(tasks)))
+
+	// Swap the HyPhy executable for its MPI build, keeping the arguments
+	args := command.Args
+	if r.MPIExecutable != "" && len(args) > 0 {
+		args = append([]string{r.MPIExecutable}, args[1:]...)
+	}
+
+	wrapped := NewCommand(launcher...).With(args...)
+	wrapped.Env = command.Env
+	wrapped.Fetch = command.Fetch
+	return wrapped
+}
+
+// JobResourcesFromMetadata returns the resolved resources stored in job metadata, if any
+func JobResourcesFromMetadata(metadata map[string]interface{}) (JobResources, bool) {
+	if metadata == nil {
+		return JobResources{}, false
+	}
+	resources, ok := metadata[JobResourcesMetadataKey].(JobResources)
+	return resources, ok
+}
+
+// SubmittedCommand returns the command a cluster scheduler runs for a job: the method's
+// command, launched through MPI if the job's resources require it, writing to the job's output
+func SubmittedCommand(job *BaseJob) Command {
+	command := job.Method.GetCommand()
+	if resources, ok := JobResourcesFromMetadata(job.Metadata); ok {
+		command = resources.LaunchCommand(command)
+	}
+	return command.With("--output", job.GetOutputPath())
+}
+
+// memoryPattern matches Slurm/PBS style memory sizes such as "900M", "4G" or "16gb"
+var memoryPattern = regexp.MustCompile(`^(\d+)\s*([KkMmGgTt]?)[Bb]?$`)
+
+// ParseMemoryMB converts a memory size such as "4G" to megabytes
+func ParseMemoryMB(memory string) (int64, error) {
+	match := memoryPattern.FindStringSubmatch(strings.TrimSpace(memory))
+	if match == nil {
+		return 0, fmt.Errorf("unrecognized memory size %q", memory)
+	}
+
+	value, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unrecognized memory size %q", memory)
+	}
+
+	switch strings.ToUpper(match[2]) {
+	case "K":
+		return (value + 1023) / 1024, nil
+	case "", "M":
+		return value, nil
+	case "G":
+		return value * 1024, nil
+	case "T":
+		return value * 1024 * 1024, nil
+	}
+	return 0, fmt.Errorf("unrecognized memory size %q", memory)
+}
+
+// ParseTimeLimitMinutes converts a Slurm time limit ("MM", "MM:SS", "HH:MM:SS" or "D-HH:MM:SS") to whole minutes
+func ParseTimeLimitMinutes(limit string) (int64, error) {
+	limit = strings.TrimSpace(limit)
+	if limit == "" {
+		return 0, fmt.Errorf("empty time limit")
+	}
+
+	var days int64
+	if dash := strings.Index(limit, "-"); dash >= 0 {
+		d, err := strconv.ParseInt(limit[:dash], 10, 64)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("unrecognized time limit %q", limit)
+		}
+		days = d
+		limit = limit[dash+1:]
+	}
+
+	parts := strings.Split(limit, ":")
+	values := make([]int64, len(parts))
+	for i, part := range parts {
+		v, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("unrecognized time limit %q", limit)
+		}
+		values[i] = v
+	}
+
+	var seconds int64
+	switch {
+	case days > 0 && len(values) == 1:
+		seconds = values[0] * 3600
+	case len(values) == 1:
+		seconds = values[0] * 60
+	case len(values) == 2 && days > 0:
+		seconds = values[0]*3600 + values[1]*60
+	case len(values) == 2:
+		seconds = values[0]*60 + values[1]
+	case len(values) == 3:
+		seconds = values[0]*3600 + values[1]*60 + values[2]
+	default:
+		return 0, fmt.Errorf("unrecognized time limit %q", limit)
+	}
+	seconds += days * 24 * 3600
+
+	return (seconds + 59) / 60, nil
+}
@@ -0,0 +1,276 @@
+package datamonkey
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Parquet format constants, from parquet-format's parquet.thrift
+const (
+	parquetMagic = "PAR1"
+
+	parquetTypeInt64     = 2
+	parquetTypeDouble    = 5
+	parquetTypeByteArray = 6
+
+	parquetRepetitionOptional = 1
+	parquetConvertedTypeUTF8  = 0
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetCodecUncompressed = 0
+	parquetPageTypeData      = 0
+)
+
+// WriteParquet writes the table as an uncompressed Parquet file with a single row group.
+// Every column is optional so missing values are stored as nulls; ints are INT64, floats
+// DOUBLE and strings UTF8 BYTE_ARRAY.
+func (t *ResultTable) WriteParquet(w io.Writer) error {
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+
+	chunks := make([]*thriftStruct, len(t.Columns))
+	var totalSize int64
+	for i, column := range t.Columns {
+		physicalType, values, present, err := t.encodeParquetColumn(i)
+		if err != nil {
+			return err
+		}
+		page := encodeDefinitionLevels(present)
+		page = append(page, values...)
+
+		header := newThriftStruct().
+			i32(1, parquetPageTypeData).
+			i32(2, int32(len(page))).
+			i32(3, int32(len(page))).
+			structField(5, newThriftStruct().
+				i32(1, int32(len(t.Rows))).
+				i32(2, parquetEncodingPlain).
+				i32(3, parquetEncodingRLE).
+				i32(4, parquetEncodingRLE))
+		headerBytes := header.bytes()
+
+		offset := int64(file.Len())
+		file.Write(headerBytes)
+		file.Write(page)
+		size := int64(len(headerBytes) + len(page))
+		totalSize += size
+
+		chunks[i] = newThriftStruct().
+			i64(2, offset).
+			structField(3, newThriftStruct().
+				i32(1, physicalType).
+				i32List(2, []int32{parquetEncodingPlain, parquetEncodingRLE}).
+				stringList(3, []string{column.Name}).
+				i32(4, parquetCodecUncompressed).
+				i64(5, int64(len(t.Rows))).
+				i64(6, size).
+				i64(7, size).
+				i64(9, offset))
+	}
+
+	schema := []*thriftStruct{newThriftStruct().
+		str(4, "schema").
+		i32(5, int32(len(t.Columns)))}
+	for i, column := range t.Columns {
+		physicalType, _ := t.parquetType(i)
+		element := newThriftStruct().
+			i32(1, physicalType).
+			i32(3, parquetRepetitionOptional).
+			str(4, column.Name)
+		if column.Type == ResultColumnString {
+			element.i32(6, parquetConvertedTypeUTF8)
+		}
+		schema = append(schema, element)
+	}
+
+	rowGroup := newThriftStruct().
+		structList(1, chunks).
+		i64(2, totalSize).
+		i64(3, int64(len(t.Rows)))
+
+	metadata := newThriftStruct().
+		i32(1, 1).
+		structList(2, schema).
+		i64(3, int64(len(t.Rows))).
+		structList(4, []*thriftStruct{rowGroup}).
+		str(6, "service-datamonkey").
+		bytes()
+
+	file.Write(metadata)
+	binary.Write(&file, binary.LittleEndian, uint32(len(metadata)))
+	file.WriteString(parquetMagic)
+
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// parquetType returns the physical type a column is stored as
+func (t *ResultTable) parquetType(column int) (int32, error) {
+	switch t.Columns[column].Type {
+	case ResultColumnInt:
+		return parquetTypeInt64, nil
+	case ResultColumnFloat:
+		return parquetTypeDouble, nil
+	case ResultColumnString:
+		return parquetTypeByteArray, nil
+	}
+	return 0, fmt.Errorf("unsupported column type %q for column %s", t.Columns[column].Type, t.Columns[column].Name)
+}
+
+// encodeParquetColumn returns a column's physical type, its non-null values in PLAIN encoding
+// and which rows have a value
+func (t *ResultTable) encodeParquetColumn(column int) (int32, []byte, []bool, error) {
+	physicalType, err := t.parquetType(column)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	columnType := t.Columns[column].Type
+
+	var values bytes.Buffer
+	present := make([]bool, len(t.Rows))
+	for r, row := range t.Rows {
+		switch v := row[column].(type) {
+		case nil:
+			continue
+		case int64:
+			if columnType != ResultColumnInt {
+				return 0, nil, nil, fmt.Errorf("column %s: unexpected int value", t.Columns[column].Name)
+			}
+			binary.Write(&values, binary.LittleEndian, v)
+		case float64:
+			if columnType != ResultColumnFloat {
+				return 0, nil, nil, fmt.Errorf("column %s: unexpected float value", t.Columns[column].Name)
+			}
+			binary.Write(&values, binary.LittleEndian, math.Float64bits(v))
+		case string:
+			if columnType != ResultColumnString {
+				return 0, nil, nil, fmt.Errorf("column %s: unexpected string value", t.Columns[column].Name)
+			}
+			binary.Write(&values, binary.LittleEndian, uint32(len(v)))
+			values.WriteString(v)
+		default:
+			return 0, nil, nil, fmt.Errorf("column %s: unsupported value %v", t.Columns[column].Name, v)
+		}
+		present[r] = true
+	}
+	return physicalType, values.Bytes(), present, nil
+}
+
+// encodeDefinitionLevels encodes one-bit definition levels as a single bit-packed run of the
+// RLE/bit-packing hybrid, prefixed with its length as data page v1 requires
+func encodeDefinitionLevels(present []bool) []byte {
+	groups := (len(present) + 7) / 8
+	run := binary.AppendUvarint(nil, uint64(groups)<<1|1)
+	packed := make([]byte, groups)
+	for i, ok := range present {
+		if ok {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	run = append(run, packed...)
+
+	levels := binary.LittleEndian.AppendUint32(nil, uint32(len(run)))
+	return append(levels, run...)
+}
+
+// thriftStruct builds a struct in the Thrift compact protocol, which Parquet uses for its metadata.
+// Fields must be added in increasing id order.
+type thriftStruct struct {
+	buf    bytes.Buffer
+	lastID int16
+}
+
+// Thrift compact protocol type ids
+const (
+	thriftTypeI32    = 5
+	thriftTypeI64    = 6
+	thriftTypeBinary = 8
+	thriftTypeList   = 9
+	thriftTypeStruct = 12
+)
+
+func newThriftStruct() *thriftStruct {
+	return &thriftStruct{}
+}
+
+func (s *thriftStruct) fieldHeader(id int16, fieldType byte) {
+	if delta := id - s.lastID; delta > 0 && delta <= 15 {
+		s.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		s.buf.WriteByte(fieldType)
+		s.buf.Write(binary.AppendVarint(nil, int64(id)))
+	}
+	s.lastID = id
+}
+
+func (s *thriftStruct) listHeader(size int, elementType byte) {
+	if size < 15 {
+		s.buf.WriteByte(byte(size)<<4 | elementType)
+		return
+	}
+	s.buf.WriteByte(0xf0 | elementType)
+	s.buf.Write(binary.AppendUvarint(nil, uint64(size)))
+}
+
+func (s *thriftStruct) i32(id int16, value int32) *thriftStruct {
+	s.fieldHeader(id, thriftTypeI32)
+	s.buf.Write(binary.AppendVarint(nil, int64(value)))
+	return s
+}
+
+func (s *thriftStruct) i64(id int16, value int64) *thriftStruct {
+	s.fieldHeader(id, thriftTypeI64)
+	s.buf.Write(binary.AppendVarint(nil, value))
+	return s
+}
+
+func (s *thriftStruct) str(id int16, value string) *thriftStruct {
+	s.fieldHeader(id, thriftTypeBinary)
+	s.buf.Write(binary.AppendUvarint(nil, uint64(len(value))))
+	s.buf.WriteString(value)
+	return s
+}
+
+func (s *thriftStruct) structField(id int16, value *thriftStruct) *thriftStruct {
+	s.fieldHeader(id, thriftTypeStruct)
+	s.buf.Write(value.bytes())
+	return s
+}
+
+func (s *thriftStruct) i32List(id int16, values []int32) *thriftStruct {
+	s.fieldHeader(id, thriftTypeList)
+	s.listHeader(len(values), thriftTypeI32)
+	for _, value := range values {
+		s.buf.Write(binary.AppendVarint(nil, int64(value)))
+	}
+	return s
+}
+
+func (s *thriftStruct) stringList(id int16, values []string) *thriftStruct {
+	s.fieldHeader(id, thriftTypeList)
+	s.listHeader(len(values), thriftTypeBinary)
+	for _, value := range values {
+		s.buf.Write(binary.AppendUvarint(nil, uint64(len(value))))
+		s.buf.WriteString(value)
+	}
+	return s
+}
+
+func (s *thriftStruct) structList(id int16, values []*thriftStruct) *thriftStruct {
+	s.fieldHeader(id, thriftTypeList)
+	s.listHeader(len(values), thriftTypeStruct)
+	for _, value := range values {
+		s.buf.Write(value.bytes())
+	}
+	return s
+}
+
+// bytes returns the encoded struct, terminated by a stop field
+func (s *thriftStruct) bytes() []byte {
+	return append(append([]byte(nil), s.buf.Bytes()...), 0)
+}
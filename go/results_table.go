@@ -0,0 +1,532 @@
+package datamonkey
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ResultColumnType is the type of the values in a result table column
+type ResultColumnType string
+
+const (
+	ResultColumnInt    ResultColumnType = "int"
+	ResultColumnFloat  ResultColumnType = "float"
+	ResultColumnString ResultColumnType = "string"
+)
+
+// ResultColumn describes a column of a result table
+type ResultColumn struct {
+	Name string           `json:"name"`
+	Type ResultColumnType `json:"type"`
+}
+
+// ResultTable is a flattened view of per-site or per-branch results.
+// Cells hold int64, float64 or string values, or nil when a value is missing.
+type ResultTable struct {
+	Columns []ResultColumn  `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// ErrResultTableNotSupported is returned when a method's results have no table of the requested kind
+var ErrResultTableNotSupported = errors.New("result table not supported")
+
+// siteTableMethods are the methods whose results have per-site estimates
+var siteTableMethods = map[HyPhyMethodType]bool{
+	MethodFEL:         true,
+	MethodMEME:        true,
+	MethodFUBAR:       true,
+	MethodSLAC:        true,
+	MethodFADE:        true,
+	MethodCONTRASTFEL: true,
+}
+
+// siteTablePValues matches, for each method, the MLE columns holding p-values that get
+// Benjamini-Hochberg q-values. FUBAR and FADE report posterior probabilities instead, and
+// MEME's p- and p+ columns are the weights of its rate classes.
+var siteTablePValues = map[HyPhyMethodType]*regexp.Regexp{
+	MethodFEL:         regexp.MustCompile(`^p-value$`),
+	MethodMEME:        regexp.MustCompile(`^p-value$`),
+	MethodSLAC:        regexp.MustCompile(`^P \[dN/dS [<>] 1\]$`),
+	MethodCONTRASTFEL: regexp.MustCompile(`^P-value `),
+}
+
+// slacMLE is SLAC's MLE section, whose content nests the site rows of each partition
+// under "by-site", with estimates averaged over ancestral reconstructions under "AVERAGED"
+type slacMLE struct {
+	Headers [][]string `json:"headers"`
+	Content map[string]struct {
+		BySite map[string][][]float64 `json:"by-site"`
+	} `json:"content"`
+}
+
+// BuildSiteTable flattens a method's per-site estimates into one row per partition and site.
+// Benjamini-Hochberg q-values are added after each of the method's p-value columns that
+// has no q-value column.
+func BuildSiteTable(methodType HyPhyMethodType, output []byte) (*ResultTable, error) {
+	if !siteTableMethods[methodType] {
+		return nil, ErrResultTableNotSupported
+	}
+
+	var result struct {
+		MLE             json.RawMessage `json:"MLE"`
+		SiteAnnotations json.RawMessage `json:"site annotations"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse %s results: %v", methodType, err)
+	}
+	if len(result.MLE) == 0 {
+		return nil, fmt.Errorf("%s results have no site estimates", methodType)
+	}
+
+	var table *ResultTable
+	var err error
+	switch methodType {
+	case MethodFADE:
+		table, err = buildFadeSiteTable(result.MLE, result.SiteAnnotations)
+	case MethodSLAC:
+		table, err = buildSLACSiteTable(result.MLE)
+	default:
+		table, err = buildMLESiteTable(result.MLE)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to flatten %s results: %v", methodType, err)
+	}
+	if len(table.Rows) == 0 {
+		return nil, fmt.Errorf("%s results have no site estimates", methodType)
+	}
+
+	addQValues(table, siteTablePValues[methodType])
+	return table, nil
+}
+
+// newSiteTable returns a table with the given index columns followed by a float column per MLE header
+func newSiteTable(headers [][]string, index ...ResultColumn) *ResultTable {
+	table := &ResultTable{Columns: index}
+	for _, header := range headers {
+		table.Columns = append(table.Columns, ResultColumn{Name: headerName(header), Type: ResultColumnFloat})
+	}
+	return table
+}
+
+// buildMLESiteTable flattens MLE content of the form {partition: [[value per header] per site]},
+// which FEL, MEME, FUBAR and Contrast-FEL share
+func buildMLESiteTable(data json.RawMessage) (*ResultTable, error) {
+	var mle FelResultResultAllOfMle
+	if err := json.Unmarshal(data, &mle); err != nil {
+		return nil, err
+	}
+
+	table := newSiteTable(mle.Headers,
+		ResultColumn{Name: "partition", Type: ResultColumnInt},
+		ResultColumn{Name: "site", Type: ResultColumnInt},
+	)
+	for _, partition := range sortedPartitions(mle.Content) {
+		appendSiteRows(table, partition, mle.Content[partition], len(mle.Headers))
+	}
+	return table, nil
+}
+
+// buildSLACSiteTable flattens SLAC's averaged by-site rows
+func buildSLACSiteTable(data json.RawMessage) (*ResultTable, error) {
+	var mle slacMLE
+	if err := json.Unmarshal(data, &mle); err != nil {
+		return nil, err
+	}
+
+	table := newSiteTable(mle.Headers,
+		ResultColumn{Name: "partition", Type: ResultColumnInt},
+		ResultColumn{Name: "site", Type: ResultColumnInt},
+	)
+	for _, partition := range sortedPartitions(mle.Content) {
+		rows, ok := mle.Content[partition].BySite["AVERAGED"]
+		if !ok {
+			return nil, fmt.Errorf("partition %s: no averaged site rows found", partition)
+		}
+		appendSiteRows(table, partition, rows, len(mle.Headers))
+	}
+	return table, nil
+}
+
+// appendSiteRows adds a partition's rows of estimates, numbering sites from 1
+func appendSiteRows(table *ResultTable, partition string, rows [][]float64, columns int) {
+	index, _ := strconv.ParseInt(partition, 10, 64)
+	for site, values := range rows {
+		row := []interface{}{index, int64(site + 1)}
+		table.Rows = append(table.Rows, append(row, estimateCells(values, columns)...))
+	}
+}
+
+// buildFadeSiteTable flattens FADE's MLE content of the form {residue: {partition: [[value per header] per site]}}
+// into one row per partition, site and residue, joined with the site annotations
+func buildFadeSiteTable(data json.RawMessage, annotationsJSON json.RawMessage) (*ResultTable, error) {
+	var mle FadeResultResultAllOfMle
+	if err := json.Unmarshal(data, &mle); err != nil {
+		return nil, err
+	}
+
+	table := newSiteTable(mle.Headers,
+		ResultColumn{Name: "partition", Type: ResultColumnInt},
+		ResultColumn{Name: "site", Type: ResultColumnInt},
+		ResultColumn{Name: "residue", Type: ResultColumnString},
+	)
+	annotationNames, annotations := fadeSiteAnnotations(annotationsJSON)
+	for _, name := range annotationNames {
+		table.Columns = append(table.Columns, ResultColumn{Name: name, Type: ResultColumnString})
+	}
+
+	// Regroup by partition so rows are ordered by partition, site, then residue
+	byPartition := make(map[string]map[string][][]float64)
+	residues := make([]string, 0, len(mle.Content))
+	for residue, partitions := range mle.Content {
+		residues = append(residues, residue)
+		for partition, rows := range partitions {
+			if byPartition[partition] == nil {
+				byPartition[partition] = make(map[string][][]float64)
+			}
+			byPartition[partition][residue] = rows
+		}
+	}
+	sort.Strings(residues)
+
+	for _, partition := range sortedPartitions(byPartition) {
+		index, _ := strconv.ParseInt(partition, 10, 64)
+		sites := 0
+		for _, rows := range byPartition[partition] {
+			if len(rows) > sites {
+				sites = len(rows)
+			}
+		}
+		for site := 0; site < sites; site++ {
+			for _, residue := range residues {
+				rows := byPartition[partition][residue]
+				if site >= len(rows) {
+					continue
+				}
+				row := []interface{}{index, int64(site + 1), residue}
+				row = append(row, estimateCells(rows[site], len(mle.Headers))...)
+				for i := range annotationNames {
+					row = append(row, annotationCell(annotations[partition], site, i))
+				}
+				table.Rows = append(table.Rows, row)
+			}
+		}
+	}
+	return table, nil
+}
+
+// fadeSiteAnnotations returns the site annotation column names and values by partition
+func fadeSiteAnnotations(data json.RawMessage) ([]string, map[string][][]string) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	// HyPhy writes the headers as [[name, description], ...], not the object in the model
+	var annotations struct {
+		FadeResultResultAllOfSiteAnnotations
+		Headers json.RawMessage `json:"headers"`
+	}
+	if err := json.Unmarshal(data, &annotations); err != nil || len(annotations.SiteAnnotations) == 0 {
+		return nil, nil
+	}
+
+	var names []string
+	var pairs [][]string
+	if err := json.Unmarshal(annotations.Headers, &pairs); err == nil {
+		for _, pair := range pairs {
+			names = append(names, headerName(pair))
+		}
+	} else {
+		names = []string{"Composition", "Substitutions"}
+	}
+	return names, annotations.SiteAnnotations
+}
+
+func annotationCell(rows [][]string, site, column int) interface{} {
+	if site >= len(rows) || column >= len(rows[site]) {
+		return nil
+	}
+	return rows[site][column]
+}
+
+// estimateCells converts a row of estimates to float cells, one per header
+func estimateCells(values []float64, columns int) []interface{} {
+	cells := make([]interface{}, columns)
+	for i := 0; i < columns && i < len(values); i++ {
+		cells[i] = values[i]
+	}
+	return cells
+}
+
+// headerName returns the name of an MLE header, which HyPhy writes as [name, description]
+func headerName(header []string) string {
+	if len(header) == 0 {
+		return ""
+	}
+	return header[0]
+}
+
+// addQValues adds a Benjamini-Hochberg q-value column after each column matching pValues
+// that has no q-value column of its own
+func addQValues(table *ResultTable, pValues *regexp.Regexp) {
+	if pValues == nil {
+		return
+	}
+	names := map[string]bool{}
+	for _, column := range table.Columns {
+		names[strings.ToLower(column.Name)] = true
+	}
+
+	for i := 0; i < len(table.Columns); i++ {
+		if !pValues.MatchString(table.Columns[i].Name) {
+			continue
+		}
+		name := qValueName(table.Columns[i].Name)
+		if names[strings.ToLower(name)] {
+			continue
+		}
+
+		column := make([]float64, len(table.Rows))
+		for r, row := range table.Rows {
+			column[r] = math.NaN()
+			if p, ok := row[i].(float64); ok {
+				column[r] = p
+			}
+		}
+		qValues := benjaminiHochberg(column)
+
+		table.Columns = insertAt(table.Columns, i+1, ResultColumn{Name: name, Type: ResultColumnFloat})
+		for r, row := range table.Rows {
+			var cell interface{}
+			if !math.IsNaN(qValues[r]) {
+				cell = qValues[r]
+			}
+			table.Rows[r] = insertAt(row, i+1, cell)
+		}
+		i++
+	}
+}
+
+// qValueName names the q-value column of a p-value column: p-value gives q-value and
+// P [dN/dS > 1] gives Q [dN/dS > 1]
+func qValueName(pValue string) string {
+	switch pValue[0] {
+	case 'p':
+		return "q" + pValue[1:]
+	case 'P':
+		return "Q" + pValue[1:]
+	}
+	return "q-value (" + pValue + ")"
+}
+
+// benjaminiHochberg returns the adjusted p-values of pValues; NaN entries are skipped and stay NaN
+func benjaminiHochberg(pValues []float64) []float64 {
+	order := make([]int, 0, len(pValues))
+	for i, p := range pValues {
+		if !math.IsNaN(p) {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool { return pValues[order[a]] < pValues[order[b]] })
+
+	qValues := make([]float64, len(pValues))
+	for i := range qValues {
+		qValues[i] = math.NaN()
+	}
+
+	n := float64(len(order))
+	minimum := 1.0
+	for rank := len(order) - 1; rank >= 0; rank-- {
+		q := pValues[order[rank]] * n / float64(rank+1)
+		if q < minimum {
+			minimum = q
+		}
+		qValues[order[rank]] = minimum
+	}
+	return qValues
+}
+
+func insertAt[T any](values []T, index int, value T) []T {
+	values = append(values, value)
+	copy(values[index+1:], values[index:])
+	values[index] = value
+	return values
+}
+
+// BuildBranchTable flattens a method's branch attributes into one row per partition and branch,
+// with a column for each attribute. Attributes that are not numbers are kept as strings.
+func BuildBranchTable(methodType HyPhyMethodType, output []byte) (*ResultTable, error) {
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse %s results: %v", methodType, err)
+	}
+
+	data, ok := result["branch attributes"]
+	if !ok {
+		data, ok = result["branch_attributes"]
+	}
+	if !ok {
+		return nil, ErrResultTableNotSupported
+	}
+
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return nil, fmt.Errorf("failed to parse %s branch attributes: %v", methodType, err)
+	}
+	// "attributes" describes the attribute columns rather than a partition
+	delete(attributes, "attributes")
+
+	type branchRow struct {
+		partition int64
+		branch    string
+		values    map[string]interface{}
+	}
+	var rows []branchRow
+	columnTypes := make(map[string]ResultColumnType)
+
+	for _, partition := range sortedPartitions(attributes) {
+		var branches map[string]map[string]interface{}
+		if err := json.Unmarshal(attributes[partition], &branches); err != nil {
+			return nil, fmt.Errorf("failed to parse %s branch attributes for partition %s: %v", methodType, partition, err)
+		}
+		index, _ := strconv.ParseInt(partition, 10, 64)
+
+		names := make([]string, 0, len(branches))
+		for name := range branches {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			row := branchRow{partition: index, branch: name, values: make(map[string]interface{})}
+			for attribute, value := range branches[name] {
+				cell, cellType := branchCell(value)
+				row.values[attribute] = cell
+				if _, seen := columnTypes[attribute]; !seen {
+					columnTypes[attribute] = ResultColumnFloat
+				}
+				if cellType == ResultColumnString {
+					columnTypes[attribute] = ResultColumnString
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	attributeNames := make([]string, 0, len(columnTypes))
+	for name := range columnTypes {
+		attributeNames = append(attributeNames, name)
+	}
+	sort.Strings(attributeNames)
+
+	table := &ResultTable{Columns: []ResultColumn{
+		{Name: "partition", Type: ResultColumnInt},
+		{Name: "branch", Type: ResultColumnString},
+	}}
+	for _, name := range attributeNames {
+		table.Columns = append(table.Columns, ResultColumn{Name: name, Type: columnTypes[name]})
+	}
+
+	for _, row := range rows {
+		cells := []interface{}{row.partition, row.branch}
+		for _, name := range attributeNames {
+			cell := row.values[name]
+			if f, ok := cell.(float64); ok && columnTypes[name] == ResultColumnString {
+				cell = strconv.FormatFloat(f, 'g', -1, 64)
+			}
+			cells = append(cells, cell)
+		}
+		table.Rows = append(table.Rows, cells)
+	}
+	return table, nil
+}
+
+// branchCell converts a branch attribute to a cell; nested values are kept as JSON
+func branchCell(value interface{}) (interface{}, ResultColumnType) {
+	switch v := value.(type) {
+	case nil:
+		return nil, ResultColumnFloat
+	case float64:
+		return v, ResultColumnFloat
+	case string:
+		return v, ResultColumnString
+	case bool:
+		return strconv.FormatBool(v), ResultColumnString
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, ResultColumnString
+		}
+		return string(data), ResultColumnString
+	}
+}
+
+// sortedPartitions returns partition keys in numeric order
+func sortedPartitions[T any](partitions map[string]T) []string {
+	keys := make([]string, 0, len(partitions))
+	for key := range partitions {
+		keys = append(keys, key)
+	}
+	sortPartitionKeys(keys)
+	return keys
+}
+
+func sortPartitionKeys(keys []string) {
+	sort.Slice(keys, func(a, b int) bool {
+		x, errX := strconv.Atoi(keys[a])
+		y, errY := strconv.Atoi(keys[b])
+		if errX == nil && errY == nil {
+			return x < y
+		}
+		return keys[a] < keys[b]
+	})
+}
+
+// WriteDelimited writes the table as CSV, or TSV when comma is a tab.
+// Missing values are written as empty fields.
+func (t *ResultTable) WriteDelimited(w io.Writer, comma rune) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+
+	header := make([]string, len(t.Columns))
+	for i, column := range t.Columns {
+		header[i] = column.Name
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(t.Columns))
+	for _, row := range t.Rows {
+		for i, cell := range row {
+			record[i] = formatCell(cell)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatCell(cell interface{}) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return v
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -35,21 +35,34 @@ func NewRouter(handleFunctions ApiHandleFunctions) *gin.Engine {
 
 // NewRouter add routes to existing gin engine.
 func NewRouterWithGinEngine(router *gin.Engine, handleFunctions ApiHandleFunctions) *gin.Engine {
-	for _, route := range getRoutes(handleFunctions) {
+	return RegisterRoutes(router, getRoutes(handleFunctions), handleFunctions.Authorizer)
+}
+
+// RegisterRoutes adds routes to a gin engine. A non-nil authorizer enforces each
+// route's policy before its handler runs.
+func RegisterRoutes(router *gin.Engine, routes []Route, authorizer *Authorizer) *gin.Engine {
+	for _, route := range routes {
 		if route.HandlerFunc == nil {
 			route.HandlerFunc = DefaultHandleFunc
 		}
+		handlers := []gin.HandlerFunc{route.HandlerFunc}
+		if authorizer != nil {
+			policy, _ := RoutePolicyFor(route.Name)
+			handlers = append([]gin.HandlerFunc{authorizer.Middleware(policy)}, handlers...)
+		}
 		switch route.Method {
 		case http.MethodGet:
-			router.GET(route.Pattern, route.HandlerFunc)
+			router.GET(route.Pattern, handlers...)
 		case http.MethodPost:
-			router.POST(route.Pattern, route.HandlerFunc)
+			router.POST(route.Pattern, handlers...)
 		case http.MethodPut:
-			router.PUT(route.Pattern, route.HandlerFunc)
+			router.PUT(route.Pattern, handlers...)
 		case http.MethodPatch:
-			router.PATCH(route.Pattern, route.HandlerFunc)
+			router.PATCH(route.Pattern, handlers...)
 		case http.MethodDelete:
-			router.DELETE(route.Pattern, route.HandlerFunc)
+			router.DELETE(route.Pattern, handlers...)
+		case http.MethodHead:
+			router.HEAD(route.Pattern, handlers...)
 		}
 	}
 
@@ -65,6 +78,8 @@ type ApiHandleFunctions struct {
 
 	// Routes for the ABSRELAPI part of the API
 	ABSRELAPI ABSRELAPI
+	// Routes for the AuthAPI part of the API
+	AuthAPI AuthAPI
 	// Routes for the BGMAPI part of the API
 	BGMAPI BGMAPI
 	// Routes for the BUSTEDAPI part of the API
@@ -103,6 +118,126 @@ type ApiHandleFunctions struct {
 	SLATKINAPI SLATKINAPI
 	// Routes for the VisualizationsAPI part of the API
 	VisualizationsAPI VisualizationsAPI
+
+	// Authorizer enforces the route policies; nil leaves authentication to the handlers
+	Authorizer *Authorizer
+}
+
+// Routes returns the routes of the API
+func Routes(handleFunctions ApiHandleFunctions) []Route {
+	return getRoutes(handleFunctions)
+}
+
+// RoutePolicyFor returns the policy declared for a route. Routes without a policy
+// need a valid token, so a route added without one is not left open.
+func RoutePolicyFor(name string) (RoutePolicy, bool) {
+	policy, ok := routePolicies[name]
+	if !ok {
+		return RoutePolicy{Access: AccessSession}, false
+	}
+	return policy, true
+}
+
+// routePolicies declares the access each route in getRoutes needs
+var routePolicies = map[string]RoutePolicy{
+	// Auth, health and method discovery
+	"GetHealth":      {Access: AccessPublic},
+	"GetMethodsList": {Access: AccessPublic},
+	"IssueToken":     {Access: AccessPublic},
+	"Register":       {Access: AccessPublic},
+	"Login":          {Access: AccessPublic},
+	"Logout":         {Access: AccessSession},
+	"RefreshToken":   {Access: AccessPublic},
+	"RevokeToken":    {Access: AccessPublic},
+	"GetJWKS":        {Access: AccessPublic},
+	"ClaimSession":   {Access: AccessSession},
+	"OIDCLogin":      {Access: AccessPublic},
+	"OIDCCallback":   {Access: AccessPublic},
+
+	// Methods. The POST result routes name the job in the body, so HandleGetJob checks ownership.
+	"StartABSRELJob":        {Access: AccessSession},
+	"GetABSRELJob":          {Access: AccessSession},
+	"GetABSRELJobById":      {Access: AccessOwner, Resource: ResourceJob, Param: "job_id"},
+	"StartBGMJob":           {Access: AccessSession},
+	"GetBGMJob":             {Access: AccessSession},
+	"GetBGMJobById":         {Access: AccessOwner, Resource: ResourceJob, Param: "job_id"},
+	"StartBUSTEDJob":        {Access: AccessSession},
+	"GetBUSTEDJob":          {Access: AccessSession},
+	"GetBUSTEDJobById":      {Access: AccessOwner, Resource: ResourceJob, Param: "job_id"},
+	"StartCONTRASTFELJob":   {Access: AccessSession},
+	"GetCONTRASTFELJob":     {Access: AccessSession},
+	"GetContrastFELJobById": {Access: AccessOwner, Resource: ResourceJob, Param: "job_id"},
+	"StartFadeJob":          {Access: AccessSession},
+	"GetFadeResults":        {Access: AccessSession},
+	"GetFadeResultsById":    {Access: AccessOwner, Resource: ResourceJob, Param: "job_id"},
+	"StartFELJob":           {Access: AccessSession},
+	"GetFELJob":             {Access: AccessSession},
+	"GetFELJobById":         {Access: AccessOwner, Resource: ResourceJob, Param: "job_id"},
+	"StartFUBARJob":         {Access: AccessSession},
+	"GetFUBARJob":           {Access: AccessSession},
+	"GetFUBARJobById":       {Access: AccessOwner, Resource: ResourceJob, Param: "job_id"},
+	"StartGARDJob":          {Access: AccessSession},
+	"GetGARDJob":            {Access: AccessSession},
+	"GetGARDJobById":        {Access: AccessOwner, Resource: ResourceJob, Param: "job_id"},
+	"StartMEMEJob":          {Access: AccessSession},
+	"GetMEMEJob":            {Access: AccessSession},
+	"GetMEMEJobById":        {Access: AccessOwner, Resource: ResourceJob, Param: "job_id"},
+	"StartMULTIHITJob":      {Access: AccessSession},
+	"GetMULTIHITJob":        {Access: AccessSession},
+	"GetMULTIHITJobById":    {Access: AccessOwner, Resource: ResourceJob, Param: "job_id"},
+	"StartNRMJob":           {Access: AccessSession},
+	"GetNRMJob":             {Access: AccessSession},
+	"GetNRMJobById":         {Access: AccessOwner, Resource: ResourceJob, Param: "job_id"},
+	"StartRELAXJob":         {Access: AccessSession},
+	"GetRELAXJob":           {Access: AccessSession},
+	"GetRELAXJobById":       {Access: AccessOwner, Resource: ResourceJob, Param: "job_id"},
+	"StartSLACJob":          {Access: AccessSession},
+	"GetSLACJob":            {Access: AccessSession},
+	"GetSLACJobById":        {Access: AccessOwner, Resource: ResourceJob, Param: "job_id"},
+	"StartSlatkinJob":       {Access: AccessSession},
+	"GetSlatkinResults":     {Access: AccessSession},
+	"GetSlatkinResultsById": {Access: AccessOwner, Resource: ResourceJob, Param: "job_id"},
+
+	// Jobs
+	"GetJobsList":         {Access: AccessSession},
+	"DeleteJob":           {Access: AccessOwner, Resource: ResourceJob, Param: "jobId"},
+	"GetJobById":          {Access: AccessOwner, Resource: ResourceJob, Param: "jobId"},
+	"GetJobEvents":        {Access: AccessOwner, Resource: ResourceJob, Param: "jobId"},
+	"GetJobResults":       {Access: AccessOwner, Resource: ResourceJob, Param: "jobId"},
+	"GetJobSiteResults":   {Access: AccessOwner, Resource: ResourceJob, Param: "jobId"},
+	"GetJobBranchResults": {Access: AccessOwner, Resource: ResourceJob, Param: "jobId"},
+	"RerunJob":            {Access: AccessOwner, Resource: ResourceJob, Param: "jobId"},
+
+	// Datasets and uploads
+	"GetDatasetsList":    {Access: AccessSession},
+	"PostDataset":        {Access: AccessNewSession},
+	"DeleteDataset":      {Access: AccessOwner, Resource: ResourceDataset, Param: "datasetId", Write: true},
+	"GetDatasetById":     {Access: AccessOwner, Resource: ResourceDataset, Param: "datasetId"},
+	"UpdateDataset":      {Access: AccessOwner, Resource: ResourceDataset, Param: "datasetId", Write: true},
+	"GetDatasetBranches": {Access: AccessOwner, Resource: ResourceDataset, Param: "datasetId"},
+	"DeriveDataset":      {Access: AccessOwner, Resource: ResourceDataset, Param: "datasetId"},
+	"GetDatasetVersions": {Access: AccessOwner, Resource: ResourceDataset, Param: "datasetId"},
+	"CreateUpload":       {Access: AccessNewSession},
+	"GetUpload":          {Access: AccessOwner, Resource: ResourceUpload, Param: "uploadId"},
+	"HeadUpload":         {Access: AccessOwner, Resource: ResourceUpload, Param: "uploadId"},
+	"PatchUpload":        {Access: AccessOwner, Resource: ResourceUpload, Param: "uploadId"},
+	"DeleteUpload":       {Access: AccessOwner, Resource: ResourceUpload, Param: "uploadId"},
+	"FinalizeUpload":     {Access: AccessOwner, Resource: ResourceUpload, Param: "uploadId"},
+
+	// Chat
+	"CreateConversation":      {Access: AccessNewSession},
+	"ListUserConversations":   {Access: AccessSession},
+	"DeleteConversation":      {Access: AccessOwner, Resource: ResourceConversation, Param: "conversationId"},
+	"GetConversation":         {Access: AccessOwner, Resource: ResourceConversation, Param: "conversationId"},
+	"GetConversationMessages": {Access: AccessOwner, Resource: ResourceConversation, Param: "conversationId"},
+	"SendConversationMessage": {Access: AccessOwner, Resource: ResourceConversation, Param: "conversationId"},
+
+	// Visualizations
+	"GetVisualizationsList": {Access: AccessSession},
+	"CreateVisualization":   {Access: AccessSession},
+	"DeleteVisualization":   {Access: AccessOwner, Resource: ResourceVisualization, Param: "vizId"},
+	"GetVisualization":      {Access: AccessOwner, Resource: ResourceVisualization, Param: "vizId"},
+	"UpdateVisualization":   {Access: AccessOwner, Resource: ResourceVisualization, Param: "vizId"},
 }
 
 func getRoutes(handleFunctions ApiHandleFunctions) []Route {
@@ -125,6 +260,66 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/v1/methods/absrel-start",
 			handleFunctions.ABSRELAPI.StartABSRELJob,
 		},
+		{
+			"IssueToken",
+			http.MethodPost,
+			"/api/v1/auth/token",
+			handleFunctions.AuthAPI.IssueToken,
+		},
+		{
+			"Register",
+			http.MethodPost,
+			"/api/v1/auth/register",
+			handleFunctions.AuthAPI.Register,
+		},
+		{
+			"Login",
+			http.MethodPost,
+			"/api/v1/auth/login",
+			handleFunctions.AuthAPI.Login,
+		},
+		{
+			"Logout",
+			http.MethodPost,
+			"/api/v1/auth/logout",
+			handleFunctions.AuthAPI.Logout,
+		},
+		{
+			"RefreshToken",
+			http.MethodPost,
+			"/api/v1/auth/refresh",
+			handleFunctions.AuthAPI.RefreshToken,
+		},
+		{
+			"RevokeToken",
+			http.MethodPost,
+			"/api/v1/auth/revoke",
+			handleFunctions.AuthAPI.RevokeToken,
+		},
+		{
+			"GetJWKS",
+			http.MethodGet,
+			"/api/v1/auth/jwks",
+			handleFunctions.AuthAPI.GetJWKS,
+		},
+		{
+			"ClaimSession",
+			http.MethodPost,
+			"/api/v1/auth/claim",
+			handleFunctions.AuthAPI.ClaimSession,
+		},
+		{
+			"OIDCLogin",
+			http.MethodGet,
+			"/api/v1/auth/oidc/login",
+			handleFunctions.AuthAPI.OIDCLogin,
+		},
+		{
+			"OIDCCallback",
+			http.MethodGet,
+			"/api/v1/auth/oidc/callback",
+			handleFunctions.AuthAPI.OIDCCallback,
+		},
 		{
 			"GetBGMJob",
 			http.MethodPost,
@@ -281,6 +476,30 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/v1/datasets/:datasetId",
 			handleFunctions.FileUploadAndQCAPI.GetDatasetById,
 		},
+		{
+			"UpdateDataset",
+			http.MethodPatch,
+			"/api/v1/datasets/:datasetId",
+			handleFunctions.FileUploadAndQCAPI.UpdateDataset,
+		},
+		{
+			"GetDatasetBranches",
+			http.MethodGet,
+			"/api/v1/datasets/:datasetId/branches",
+			handleFunctions.FileUploadAndQCAPI.GetDatasetBranches,
+		},
+		{
+			"DeriveDataset",
+			http.MethodPost,
+			"/api/v1/datasets/:datasetId/derive",
+			handleFunctions.FileUploadAndQCAPI.DeriveDataset,
+		},
+		{
+			"GetDatasetVersions",
+			http.MethodGet,
+			"/api/v1/datasets/:datasetId/versions",
+			handleFunctions.FileUploadAndQCAPI.GetDatasetVersions,
+		},
 		{
 			"GetDatasetsList",
 			http.MethodGet,
@@ -293,6 +512,42 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/v1/datasets",
 			handleFunctions.FileUploadAndQCAPI.PostDataset,
 		},
+		{
+			"CreateUpload",
+			http.MethodPost,
+			"/api/v1/uploads",
+			handleFunctions.FileUploadAndQCAPI.CreateUpload,
+		},
+		{
+			"GetUpload",
+			http.MethodGet,
+			"/api/v1/uploads/:uploadId",
+			handleFunctions.FileUploadAndQCAPI.GetUpload,
+		},
+		{
+			"HeadUpload",
+			http.MethodHead,
+			"/api/v1/uploads/:uploadId",
+			handleFunctions.FileUploadAndQCAPI.GetUpload,
+		},
+		{
+			"PatchUpload",
+			http.MethodPatch,
+			"/api/v1/uploads/:uploadId",
+			handleFunctions.FileUploadAndQCAPI.PatchUpload,
+		},
+		{
+			"DeleteUpload",
+			http.MethodDelete,
+			"/api/v1/uploads/:uploadId",
+			handleFunctions.FileUploadAndQCAPI.DeleteUpload,
+		},
+		{
+			"FinalizeUpload",
+			http.MethodPost,
+			"/api/v1/uploads/:uploadId/finalize",
+			handleFunctions.FileUploadAndQCAPI.FinalizeUpload,
+		},
 		{
 			"GetGARDJob",
 			http.MethodPost,
@@ -329,6 +584,36 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/v1/jobs/:jobId",
 			handleFunctions.JobsAPI.GetJobById,
 		},
+		{
+			"GetJobEvents",
+			http.MethodGet,
+			"/api/v1/jobs/:jobId/events",
+			handleFunctions.JobsAPI.GetJobEvents,
+		},
+		{
+			"GetJobResults",
+			http.MethodGet,
+			"/api/v1/jobs/:jobId/results",
+			handleFunctions.JobsAPI.GetJobResults,
+		},
+		{
+			"GetJobSiteResults",
+			http.MethodGet,
+			"/api/v1/jobs/:jobId/results/sites",
+			handleFunctions.JobsAPI.GetJobSiteResults,
+		},
+		{
+			"GetJobBranchResults",
+			http.MethodGet,
+			"/api/v1/jobs/:jobId/results/branches",
+			handleFunctions.JobsAPI.GetJobBranchResults,
+		},
+		{
+			"RerunJob",
+			http.MethodPost,
+			"/api/v1/jobs/:jobId/rerun",
+			handleFunctions.JobsAPI.RerunJob,
+		},
 		{
 			"GetJobsList",
 			http.MethodGet,
@@ -0,0 +1,461 @@
+package datamonkey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// LocalSchedulerConfig holds configuration for the local process scheduler
+type LocalSchedulerConfig struct {
+	MaxWorkers       int    // Maximum number of jobs running at once (defaults to the number of CPUs)
+	QueueSize        int    // Maximum number of jobs waiting for a worker
+	StateFile        string // Where in-flight jobs are recorded at shutdown
+	RequeueOnRestart bool   // Requeue interrupted jobs on restart instead of marking them failed
+}
+
+// localJob holds the runtime state of a job managed by the LocalScheduler
+type localJob struct {
+	JobID          string         `json:"job_id"`
+	SchedulerJobID string         `json:"scheduler_job_id"`
+	Args           []string       `json:"args"` // Run directly, without a shell
+	Env            []string       `json:"env,omitempty"`
+	Fetch          []StagedBlob   `json:"fetch,omitempty"` // Downloaded before the command runs
+	OutputPath     string         `json:"output_path"`
+	LogPath        string         `json:"log_path"`
+	Status         JobStatusValue `json:"status"`
+	ExitCode       int            `json:"exit_code"`
+	cancel         context.CancelFunc
+}
+
+// LocalScheduler implements SchedulerInterface by running jobs as child processes
+// on the same host as the API, using a bounded pool of workers
+type LocalScheduler struct {
+	Config       LocalSchedulerConfig
+	JobTracker   JobTracker
+	mu           sync.Mutex
+	jobs         map[string]*localJob
+	queue        chan *localJob
+	running      int
+	shuttingDown bool
+	ctx          context.Context
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+}
+
+// NewLocalScheduler creates a new LocalScheduler, recovers jobs interrupted by a
+// previous shutdown and starts the worker pool
+func NewLocalScheduler(config LocalSchedulerConfig, jobTracker JobTracker) *LocalScheduler {
+	if config.MaxWorkers <= 0 {
+		config.MaxWorkers = runtime.NumCPU()
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler := &LocalScheduler{
+		Config:     config,
+		JobTracker: jobTracker,
+		jobs:       make(map[string]*localJob),
+		queue:      make(chan *localJob, config.QueueSize),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	scheduler.recoverInterruptedJobs()
+
+	for i := 0; i < config.MaxWorkers; i++ {
+		scheduler.wg.Add(1)
+		go scheduler.worker()
+	}
+
+	log.Printf("Local scheduler started with %d worker(s)", config.MaxWorkers)
+	return scheduler
+}
+
+// Submit queues a job to be run by the worker pool
+func (s *LocalScheduler) Submit(job JobInterface) error {
+	if err := job.Validate(); err != nil {
+		return err
+	}
+
+	// Check if JobTracker is configured
+	if s.JobTracker == nil {
+		return fmt.Errorf("job tracker is not configured")
+	}
+
+	// append `--output` to cmd, as the Slurm schedulers do
+	cmd := job.GetMethod().GetCommand().With("--output", job.GetOutputPath())
+
+	lj := &localJob{
+		JobID:          job.GetId(),
+		SchedulerJobID: "local-" + uuid.New().String(),
+		Args:           cmd.Args,
+		Env:            cmd.Environ(),
+		Fetch:          cmd.Fetch,
+		OutputPath:     job.GetOutputPath(),
+		LogPath:        job.GetLogPath(),
+		Status:         JobStatusPending,
+	}
+
+	s.mu.Lock()
+	if s.shuttingDown {
+		s.mu.Unlock()
+		return fmt.Errorf("local scheduler is shutting down")
+	}
+	if existing, ok := s.jobs[lj.JobID]; ok && (existing.Status == JobStatusPending || existing.Status == JobStatusRunning) {
+		s.mu.Unlock()
+		return fmt.Errorf("job %s is already queued", lj.JobID)
+	}
+	select {
+	case s.queue <- lj:
+		s.jobs[lj.JobID] = lj
+	default:
+		s.mu.Unlock()
+		return fmt.Errorf("local scheduler queue is full")
+	}
+	s.mu.Unlock()
+
+	// Store the mapping between our job ID and the local job ID
+	if err := s.JobTracker.StoreJobMapping(lj.JobID, lj.SchedulerJobID); err != nil {
+		return fmt.Errorf("failed to store job mapping: %v", err)
+	}
+
+	log.Printf("Queued local job %s (%s)", lj.JobID, lj.SchedulerJobID)
+	return nil
+}
+
+// Cancel cancels a queued or running local job
+func (s *LocalScheduler) Cancel(job JobInterface) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lj, ok := s.jobs[job.GetId()]
+	if !ok {
+		return fmt.Errorf("job not found in local scheduler")
+	}
+
+	switch lj.Status {
+	case JobStatusPending:
+		// The worker skips cancelled jobs when it dequeues them
+		lj.Status = JobStatusCancelled
+	case JobStatusRunning:
+		lj.Status = JobStatusCancelled
+		if lj.cancel != nil {
+			lj.cancel()
+		}
+	default:
+		return fmt.Errorf("job is not active (status: %s)", lj.Status)
+	}
+
+	return nil
+}
+
+// GetStatus gets the current status of a local job
+func (s *LocalScheduler) GetStatus(job JobInterface) (JobStatusValue, error) {
+	s.mu.Lock()
+	lj, ok := s.jobs[job.GetId()]
+	if ok {
+		status := lj.Status
+		s.mu.Unlock()
+		return status, nil
+	}
+	s.mu.Unlock()
+
+	// The job is not known to this process, e.g. it ran before a restart.
+	// If the tracker knows about it, infer the outcome from its output file.
+	if s.JobTracker == nil {
+		return "", fmt.Errorf("job tracker is not configured")
+	}
+	if _, err := s.JobTracker.GetSchedulerJobID(job.GetId()); err != nil {
+		return "", fmt.Errorf("failed to get scheduler job ID: %v", err)
+	}
+	if info, err := os.Stat(job.GetOutputPath()); err == nil && info.Size() > 0 {
+		return JobStatusComplete, nil
+	}
+	return JobStatusFailed, nil
+}
+
+// CheckHealth checks if the local scheduler is operational
+func (s *LocalScheduler) CheckHealth() (bool, string, error) {
+	// Check if JobTracker is configured
+	if s.JobTracker == nil {
+		return false, "JobTracker not configured", fmt.Errorf("job tracker is not configured")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown {
+		return false, "Local scheduler is shutting down", fmt.Errorf("local scheduler is shutting down")
+	}
+
+	return true, fmt.Sprintf("Local scheduler is operational (%d/%d workers busy, %d queued)",
+		s.running, s.Config.MaxWorkers, len(s.queue)), nil
+}
+
+// Shutdown stops the worker pool, terminating any running jobs, and records
+// every job that was still queued or running so it can be recovered on restart
+func (s *LocalScheduler) Shutdown() {
+	s.mu.Lock()
+	if s.shuttingDown {
+		s.mu.Unlock()
+		return
+	}
+	s.shuttingDown = true
+	var inFlight []localJob
+	for _, lj := range s.jobs {
+		if lj.Status == JobStatusPending || lj.Status == JobStatusRunning {
+			inFlight = append(inFlight, *lj)
+		}
+	}
+	s.mu.Unlock()
+
+	s.cancel()
+	s.wg.Wait()
+
+	if err := s.writeState(inFlight); err != nil {
+		log.Printf("Failed to record in-flight local jobs: %v", err)
+	} else if len(inFlight) > 0 {
+		log.Printf("Recorded %d in-flight local job(s) to %s", len(inFlight), s.Config.StateFile)
+	}
+}
+
+// worker takes jobs off the queue until the scheduler shuts down
+func (s *LocalScheduler) worker() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case lj := <-s.queue:
+			s.run(lj)
+		}
+	}
+}
+
+// run executes a single job and records its final status
+func (s *LocalScheduler) run(lj *localJob) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	if lj.Status != JobStatusPending || s.shuttingDown {
+		s.mu.Unlock()
+		return
+	}
+	lj.Status = JobStatusRunning
+	lj.cancel = cancel
+	s.running++
+	s.mu.Unlock()
+
+	status, exitCode := s.execute(ctx, lj)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	lj.cancel = nil
+	lj.ExitCode = exitCode
+	if lj.Status == JobStatusCancelled {
+		log.Printf("Local job %s cancelled", lj.JobID)
+		return
+	}
+	if s.shuttingDown {
+		// Leave the job as running so Shutdown records it as interrupted
+		return
+	}
+	lj.Status = status
+	log.Printf("Local job %s finished with status %s (exit code %d)", lj.JobID, status, exitCode)
+}
+
+// execute runs the job command, writing stdout and stderr to the job's log file,
+// and maps the process exit code to a job status
+func (s *LocalScheduler) execute(ctx context.Context, lj *localJob) (JobStatusValue, int) {
+	if dir := filepath.Dir(lj.LogPath); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Printf("Failed to create log directory for job %s: %v", lj.JobID, err)
+			return JobStatusFailed, -1
+		}
+	}
+	logFile, err := os.OpenFile(lj.LogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Failed to open log file for job %s: %v", lj.JobID, err)
+		return JobStatusFailed, -1
+	}
+	defer logFile.Close()
+
+	for _, blob := range lj.Fetch {
+		if err := fetchStagedBlob(ctx, blob); err != nil {
+			fmt.Fprintf(logFile, "Failed to download %s: %v\n", blob.Path, err)
+			return JobStatusFailed, -1
+		}
+	}
+
+	// Jobs recorded by older versions kept a shell command line instead of arguments
+	if len(lj.Args) == 0 {
+		fmt.Fprintf(logFile, "Job has no command to run\n")
+		return JobStatusFailed, -1
+	}
+
+	// The program runs without a shell, so arguments reach it exactly as given and
+	// cancelling the job kills the program itself
+	cmd := exec.CommandContext(ctx, lj.Args[0], lj.Args[1:]...)
+	if len(lj.Env) > 0 {
+		cmd.Env = append(os.Environ(), lj.Env...)
+	}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
+	err = cmd.Run()
+	if err == nil {
+		return JobStatusComplete, 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		fmt.Fprintf(logFile, "\nJob exited with status %d\n", exitErr.ExitCode())
+		return JobStatusFailed, exitErr.ExitCode()
+	}
+
+	fmt.Fprintf(logFile, "\nFailed to run job: %v\n", err)
+	return JobStatusFailed, -1
+}
+
+// fetchStagedBlob downloads a blob to its staged path, as the job scripts of the cluster
+// schedulers do, unless it is already there
+func fetchStagedBlob(ctx context.Context, blob StagedBlob) error {
+	if blob.URL == "" {
+		return nil
+	}
+	if info, err := os.Stat(blob.Path); err == nil && info.Size() > 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(blob.Path), 0755); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, blob.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+
+	partial, err := os.CreateTemp(filepath.Dir(blob.Path), filepath.Base(blob.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(partial.Name())
+	if _, err := io.Copy(partial, resp.Body); err != nil {
+		partial.Close()
+		return err
+	}
+	if err := partial.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partial.Name(), blob.Path)
+}
+
+// writeState records the given jobs in the state file, removing it if there are none
+func (s *LocalScheduler) writeState(jobs []localJob) error {
+	if s.Config.StateFile == "" {
+		if len(jobs) > 0 {
+			return fmt.Errorf("no state file configured, %d job(s) will not be recovered", len(jobs))
+		}
+		return nil
+	}
+
+	if len(jobs) == 0 {
+		if err := os.Remove(s.Config.StateFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal local scheduler state: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Config.StateFile), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	if err := os.WriteFile(s.Config.StateFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write local scheduler state: %v", err)
+	}
+	return nil
+}
+
+// recoverInterruptedJobs reads jobs recorded by a previous Shutdown and either
+// requeues them or marks them failed, depending on configuration
+func (s *LocalScheduler) recoverInterruptedJobs() {
+	if s.Config.StateFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(s.Config.StateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read local scheduler state: %v", err)
+		}
+		return
+	}
+
+	var jobs []localJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		log.Printf("Failed to parse local scheduler state: %v", err)
+		return
+	}
+
+	for i := range jobs {
+		lj := &jobs[i]
+		if s.Config.RequeueOnRestart && len(s.queue) < cap(s.queue) {
+			lj.Status = JobStatusPending
+			s.jobs[lj.JobID] = lj
+			s.queue <- lj
+			s.updateTrackedStatus(lj.JobID, JobStatusPending)
+			log.Printf("Requeued local job %s interrupted by shutdown", lj.JobID)
+			continue
+		}
+
+		lj.Status = JobStatusFailed
+		s.jobs[lj.JobID] = lj
+		s.updateTrackedStatus(lj.JobID, JobStatusFailed)
+		appendJobLog(lj.LogPath, "\nJob interrupted by scheduler shutdown")
+		log.Printf("Marked local job %s as failed after shutdown", lj.JobID)
+	}
+
+	if err := os.Remove(s.Config.StateFile); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove local scheduler state: %v", err)
+	}
+}
+
+// updateTrackedStatus updates the job status in the tracker, logging failures
+func (s *LocalScheduler) updateTrackedStatus(jobID string, status JobStatusValue) {
+	if s.JobTracker == nil {
+		return
+	}
+	if err := s.JobTracker.UpdateJobStatus(jobID, string(status)); err != nil {
+		log.Printf("Failed to update status for job %s: %v", jobID, err)
+	}
+}
+
+// assert that LocalScheduler implements SchedulerInterface at compile-time rather than run-time
+var _ SchedulerInterface = (*LocalScheduler)(nil)
@@ -51,7 +51,23 @@ func (s *SlurmScheduler) GetJobConfig(job *BaseJob) SlurmJobConfig {
 		MaxTime:       "01:00:00",
 	}
 
-	// Extract configuration from job metadata if available
+	// Apply the resource profile resolved for this job, if any
+	if resources, ok := JobResourcesFromMetadata(job.Metadata); ok {
+		if resources.Nodes > 0 {
+			config.NodeCount = resources.Nodes
+		}
+		if resources.CoresPerNode > 0 {
+			config.CoresPerNode = resources.CoresPerNode
+		}
+		if resources.Memory != "" {
+			config.MemoryPerNode = resources.Memory
+		}
+		if resources.MaxTime != "" {
+			config.MaxTime = resources.MaxTime
+		}
+	}
+
+	// Explicit slurm_* metadata keys take precedence over the profile
 	if job.Metadata != nil {
 		if nodeCount, ok := job.Metadata["slurm_node_count"].(int); ok && nodeCount > 0 {
 			config.NodeCount = nodeCount
@@ -107,11 +123,8 @@ func (s *SlurmScheduler) Submit(job JobInterface) error {
 	// Get job-specific configuration from job metadata or use defaults
 	jobConfig := s.GetJobConfig(baseJob)
 
-	// Get the command from the job method
-	command := baseJob.Method.GetCommand()
-
-	// Add --output parameter for HyPhy
-	command += " --output " + baseJob.GetOutputPath()
+	// Get the command from the job method, launched through MPI if the profile requires it
+	command := SubmittedCommand(baseJob)
 
 	// Submit the job to Slurm
 	cmd := exec.Command("sbatch",
@@ -121,7 +134,7 @@ func (s *SlurmScheduler) Submit(job JobInterface) error {
 		"--mem", jobConfig.MemoryPerNode,
 		"--time", jobConfig.MaxTime,
 		"--output", job.GetLogPath(),
-		"--wrap", command,
+		"--wrap", command.Script(),
 	)
 
 	output, err := cmd.CombinedOutput()
@@ -180,11 +193,7 @@ func (s *SlurmScheduler) Cancel(job JobInterface) error {
 		return fmt.Errorf("failed to cancel job: %v, output: %s", err, string(output))
 	}
 
-	// Delete the job mapping after successful cancellation
-	if err := s.JobTracker.DeleteJobMapping(job.GetId()); err != nil {
-		return fmt.Errorf("failed to delete job mapping: %v", err)
-	}
-
+	// Keep job mapping so the cancelled job can still be looked up
 	return nil
 }
 
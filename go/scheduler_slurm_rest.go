@@ -171,30 +171,67 @@ func (s *SlurmRestScheduler) Submit(job JobInterface) error {
 		return fmt.Errorf("slurm auth token not provided")
 	}
 
+	// Resolve per-job resources; jobs without a profile get a single task on one node
+	resources := JobResources{Nodes: 1, CoresPerNode: 1}
+	var metadata map[string]interface{}
+	if bj, ok := job.(*BaseJob); ok {
+		metadata = bj.Metadata
+	} else if hyPhyJob, ok := job.(*HyPhyJob); ok {
+		metadata = hyPhyJob.Metadata
+	}
+	if profile, ok := JobResourcesFromMetadata(metadata); ok {
+		resources = profile
+	}
+
 	// append `--output` to cmd
 	// TODO: this def works for hyphy, if we add something else, check that it works
-	cmd := job.GetMethod().GetCommand()
-	cmd += " --output " + job.GetOutputPath()
+	cmd := resources.LaunchCommand(job.GetMethod().GetCommand()).With("--output", job.GetOutputPath())
+
+	// The command's own variables are passed with the job's environment; the script
+	// quotes every argument
+	environment := map[string]string{
+		"PATH":            "/bin:/usr/bin/:/usr/local/bin/",
+		"LD_LIBRARY_PATH": "/lib/:/lib64/:/usr/local/lib",
+	}
+	for name, value := range cmd.Env {
+		environment[name] = value
+	}
 
 	// Create Slurm job submission request body
-	slurmReqBody := fmt.Sprintf(`{"job": {
-		"name": "%s",
-		"ntasks": 1,
-		"nodes": 1,
+	jobProperties := map[string]interface{}{
+		"name":                      job.GetId(),
+		"tasks":                     resources.Nodes * resources.CoresPerNode,
+		"tasks_per_node":            resources.CoresPerNode,
+		"nodes":                     resources.Nodes,
 		"current_working_directory": "/root",
-		"standard_input": "/dev/null",
-		"standard_output": "%s",
-		"standard_error": "%s",
-		"environment": {
-			"PATH": "/bin:/usr/bin/:/usr/local/bin/",
-			"LD_LIBRARY_PATH": "/lib/:/lib64/:/usr/local/lib"
+		"standard_input":            "/dev/null",
+		"standard_output":           job.GetLogPath(),
+		"standard_error":            job.GetLogPath(),
+		"environment":               environment,
+	}
+	if resources.Memory != "" {
+		memoryMB, err := ParseMemoryMB(resources.Memory)
+		if err != nil {
+			return fmt.Errorf("invalid job memory: %v", err)
+		}
+		jobProperties["memory_per_node"] = memoryMB
+	}
+	if resources.MaxTime != "" {
+		minutes, err := ParseTimeLimitMinutes(resources.MaxTime)
+		if err != nil {
+			return fmt.Errorf("invalid job time limit: %v", err)
 		}
-	},
-	"script": "#!/bin/bash\n %s"}`,
-		job.GetId(),
-		job.GetLogPath(),
-		job.GetLogPath(),
-		cmd)
+		jobProperties["time_limit"] = minutes
+	}
+
+	slurmReqBytes, err := json.Marshal(map[string]interface{}{
+		"job":    jobProperties,
+		"script": "#!/bin/bash\n" + Command{Args: cmd.Args, Fetch: cmd.Fetch}.Script(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode submit request: %v", err)
+	}
+	slurmReqBody := string(slurmReqBytes)
 
 	// Create and send request
 	log.Printf("Submitting job %s with cmd %s", job.GetId(), cmd)
@@ -368,11 +405,7 @@ func (s *SlurmRestScheduler) Cancel(job JobInterface) error {
 		return fmt.Errorf("job cancellation failed with status: %d, response: %s", cancelResp.StatusCode, string(respBody))
 	}
 
-	// Delete job mapping after successful cancellation
-	if err := s.JobTracker.DeleteJobMapping(job.GetId()); err != nil {
-		return fmt.Errorf("failed to delete job mapping: %v", err)
-	}
-
+	// Keep job mapping so the cancelled job can still be looked up
 	return nil
 }
 
@@ -2,11 +2,16 @@ package datamonkey
 
 import (
 	"fmt"
+	"log"
+	"os"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
 // TorqueConfig holds configuration for Torque scheduler
+// Resource fields are defaults that can be overridden per-job through job metadata
 type TorqueConfig struct {
 	Queue         string
 	NodeCount     int
@@ -16,65 +21,165 @@ type TorqueConfig struct {
 	AccountName   string
 }
 
+// TorqueJobConfig holds per-job configuration for Torque jobs
+type TorqueJobConfig struct {
+	NodeCount     int    // Number of nodes to request
+	CoresPerNode  int    // Number of processors per node
+	MemoryPerNode string // Memory (e.g., "8gb")
+	WallTime      string // Maximum time (e.g., "24:00:00")
+}
+
 // TorqueScheduler implements SchedulerInterface for Torque/PBS
 type TorqueScheduler struct {
-	Config TorqueConfig
+	Config     TorqueConfig
+	JobTracker JobTracker
 }
 
+// qstatFieldPattern matches "key = value" lines in qstat -f output
+var qstatFieldPattern = regexp.MustCompile(`^\s*([A-Za-z_.]+)\s*=\s*(.*)$`)
+
 // NewTorqueScheduler creates a new TorqueScheduler instance
-func NewTorqueScheduler(config TorqueConfig) *TorqueScheduler {
+func NewTorqueScheduler(config TorqueConfig, jobTracker JobTracker) *TorqueScheduler {
+	if config.Queue == "" {
+		log.Println("Warning: No queue specified for Torque scheduler. Jobs will go to the server's default queue.")
+	}
+
 	return &TorqueScheduler{
-		Config: config,
+		Config:     config,
+		JobTracker: jobTracker,
+	}
+}
+
+// GetJobConfig extracts job-specific configuration from job metadata or uses defaults
+func (s *TorqueScheduler) GetJobConfig(job *BaseJob) TorqueJobConfig {
+	// Initialize with scheduler-wide defaults
+	config := TorqueJobConfig{
+		NodeCount:     1,
+		CoresPerNode:  1,
+		MemoryPerNode: "1gb",
+		WallTime:      "01:00:00",
+	}
+	if s.Config.NodeCount > 0 {
+		config.NodeCount = s.Config.NodeCount
+	}
+	if s.Config.CoresPerNode > 0 {
+		config.CoresPerNode = s.Config.CoresPerNode
+	}
+	if s.Config.MemoryPerNode != "" {
+		config.MemoryPerNode = s.Config.MemoryPerNode
+	}
+	if s.Config.WallTime != "" {
+		config.WallTime = s.Config.WallTime
+	}
+
+	// Extract configuration from job metadata if available
+	if job.Metadata != nil {
+		if nodeCount, ok := job.Metadata["torque_node_count"].(int); ok && nodeCount > 0 {
+			config.NodeCount = nodeCount
+		}
+
+		if coresPerNode, ok := job.Metadata["torque_cores_per_node"].(int); ok && coresPerNode > 0 {
+			config.CoresPerNode = coresPerNode
+		}
+
+		if memoryPerNode, ok := job.Metadata["torque_memory_per_node"].(string); ok && memoryPerNode != "" {
+			config.MemoryPerNode = memoryPerNode
+		}
+
+		if wallTime, ok := job.Metadata["torque_wall_time"].(string); ok && wallTime != "" {
+			config.WallTime = wallTime
+		}
+	}
+
+	return config
+}
+
+// buildScript creates the PBS script content for a job
+func (s *TorqueScheduler) buildScript(job *BaseJob, jobConfig TorqueJobConfig) string {
+	var script strings.Builder
+	script.WriteString("#!/bin/bash\n")
+	fmt.Fprintf(&script, "#PBS -N %s\n", ShellQuote(job.GetId()))
+	if s.Config.Queue != "" {
+		fmt.Fprintf(&script, "#PBS -q %s\n", s.Config.Queue)
+	}
+	fmt.Fprintf(&script, "#PBS -l nodes=%d:ppn=%d\n", jobConfig.NodeCount, jobConfig.CoresPerNode)
+	fmt.Fprintf(&script, "#PBS -l mem=%s\n", jobConfig.MemoryPerNode)
+	fmt.Fprintf(&script, "#PBS -l walltime=%s\n", jobConfig.WallTime)
+	fmt.Fprintf(&script, "#PBS -o %s\n", ShellQuote(job.GetLogPath()))
+	script.WriteString("#PBS -j oe\n")
+	if s.Config.AccountName != "" {
+		fmt.Fprintf(&script, "#PBS -A %s\n", s.Config.AccountName)
 	}
+
+	// Download remote datasets onto the node, then run the command with --output
+	// parameter for HyPhy, quoted for the shell
+	fmt.Fprintf(&script, "\n%s\n", job.Method.GetCommand().With("--output", job.GetOutputPath()).Script())
+
+	return script.String()
 }
 
 // Submit submits a job to Torque
 func (s *TorqueScheduler) Submit(job JobInterface) error {
-	// Create PBS script content
-	script := fmt.Sprintf(`#!/bin/bash
-#PBS -N %s
-#PBS -q %s
-#PBS -l nodes=%d:ppn=%d
-#PBS -l mem=%s
-#PBS -l walltime=%s
-#PBS -o %s
-#PBS -j oe
-`,
-		job.GetId(),
-		s.Config.Queue,
-		s.Config.NodeCount,
-		s.Config.CoresPerNode,
-		s.Config.MemoryPerNode,
-		s.Config.WallTime,
-		job.GetLogPath(),
-	)
+	// Ensure we can handle both *BaseJob and *HyPhyJob
+	var baseJob *BaseJob
+	if bj, ok := job.(*BaseJob); ok {
+		baseJob = bj
+	} else if hyPhyJob, ok := job.(*HyPhyJob); ok {
+		baseJob = hyPhyJob.BaseJob
+	} else {
+		return fmt.Errorf("job must be of type *BaseJob or *HyPhyJob")
+	}
 
-	if s.Config.AccountName != "" {
-		script += fmt.Sprintf("#PBS -A %s\n", s.Config.AccountName)
+	// Use the consolidated validation method
+	if err := baseJob.Validate(); err != nil {
+		return err
 	}
 
-	// Add the actual command
-	script += fmt.Sprintf("\n%s\n", job.(*BaseJob).Method.GetCommand())
+	// Check if JobTracker is configured
+	if s.JobTracker == nil {
+		return fmt.Errorf("job tracker is not configured")
+	}
+
+	// Get job-specific configuration from job metadata or use defaults
+	jobConfig := s.GetJobConfig(baseJob)
+	script := s.buildScript(baseJob, jobConfig)
 
-	// Create a temporary script file
-	scriptPath := fmt.Sprintf("/tmp/%s.pbs", job.GetId())
-	cmd := exec.Command("bash", "-c", fmt.Sprintf("echo '%s' > %s", script, scriptPath))
-	if err := cmd.Run(); err != nil {
+	// Write the script to a temporary file
+	scriptFile, err := os.CreateTemp("", "datamonkey-*.pbs")
+	if err != nil {
 		return fmt.Errorf("failed to create PBS script: %v", err)
 	}
+	scriptPath := scriptFile.Name()
+	defer func() {
+		if err := os.Remove(scriptPath); err != nil {
+			// Log the error but don't fail the submission
+			log.Printf("Warning: failed to clean up PBS script: %v", err)
+		}
+	}()
+
+	if _, err := scriptFile.WriteString(script); err != nil {
+		scriptFile.Close()
+		return fmt.Errorf("failed to write PBS script: %v", err)
+	}
+	if err := scriptFile.Close(); err != nil {
+		return fmt.Errorf("failed to write PBS script: %v", err)
+	}
 
 	// Submit the job using qsub
-	cmd = exec.Command("qsub", scriptPath)
-	output, err := cmd.CombinedOutput()
+	output, err := exec.Command("qsub", scriptPath).CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("failed to submit job: %v, output: %s", err, string(output))
 	}
 
-	// Clean up the script file
-	cmd = exec.Command("rm", scriptPath)
-	if err := cmd.Run(); err != nil {
-		// Log the error but don't fail the submission
-		fmt.Printf("Warning: failed to clean up PBS script: %v\n", err)
+	// qsub prints the PBS job ID, e.g. "12345.server.example.org"
+	torqueJobID := strings.TrimSpace(string(output))
+	if torqueJobID == "" || strings.ContainsAny(torqueJobID, " \n") {
+		return fmt.Errorf("unexpected qsub output format: %s", string(output))
+	}
+
+	// Store the mapping between our job ID and the PBS job ID
+	if err := s.JobTracker.StoreJobMapping(job.GetId(), torqueJobID); err != nil {
+		return fmt.Errorf("failed to store job mapping: %v", err)
 	}
 
 	return nil
@@ -82,21 +187,43 @@ func (s *TorqueScheduler) Submit(job JobInterface) error {
 
 // Cancel cancels a running Torque job
 func (s *TorqueScheduler) Cancel(job JobInterface) error {
-	cmd := exec.Command("qdel", job.GetId())
-	output, err := cmd.CombinedOutput()
+	// Check if JobTracker is configured
+	if s.JobTracker == nil {
+		return fmt.Errorf("job tracker is not configured")
+	}
+
+	// Get the PBS job ID from the tracker
+	torqueJobID, err := s.JobTracker.GetSchedulerJobID(job.GetId())
+	if err != nil {
+		return fmt.Errorf("failed to get scheduler job ID: %v", err)
+	}
+
+	// Cancel the job using the PBS job ID
+	output, err := exec.Command("qdel", torqueJobID).CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("failed to cancel job: %v, output: %s", err, string(output))
 	}
 
+	// Keep job mapping so the job can still be queried after cancellation
 	return nil
 }
 
 // GetStatus gets the current status of a Torque job
 func (s *TorqueScheduler) GetStatus(job JobInterface) (JobStatusValue, error) {
-	cmd := exec.Command("qstat", "-f", job.GetId())
-	output, err := cmd.CombinedOutput()
+	// Check if JobTracker is configured
+	if s.JobTracker == nil {
+		return "", fmt.Errorf("job tracker is not configured")
+	}
+
+	// Get the PBS job ID from the tracker
+	torqueJobID, err := s.JobTracker.GetSchedulerJobID(job.GetId())
+	if err != nil {
+		return "", fmt.Errorf("failed to get scheduler job ID: %v", err)
+	}
+
+	output, err := exec.Command("qstat", "-f", torqueJobID).CombinedOutput()
 	if err != nil {
-		// Check if the error is because the job is not found (completed)
+		// Check if the error is because the job is not found (completed and purged)
 		if strings.Contains(string(output), "Unknown Job Id") {
 			// Check job completion status from the output file
 			if s.checkJobSuccess(job) {
@@ -107,52 +234,66 @@ func (s *TorqueScheduler) GetStatus(job JobInterface) (JobStatusValue, error) {
 		return "", fmt.Errorf("failed to get job status: %v, output: %s", err, string(output))
 	}
 
-	outputStr := string(output)
-	if strings.Contains(outputStr, "job_state = Q") {
+	fields := parseQstatFields(string(output))
+
+	switch fields["job_state"] {
+	case "Q", "H", "W", "T":
 		return JobStatusPending, nil
-	} else if strings.Contains(outputStr, "job_state = R") {
+	case "R", "E", "S":
+		// E is "exiting after having run", not an error state
 		return JobStatusRunning, nil
-	} else if strings.Contains(outputStr, "job_state = C") {
+	case "C":
+		// Prefer the exit status reported by Torque when available
+		if exitStatus, ok := fields["exit_status"]; ok {
+			code, err := strconv.Atoi(exitStatus)
+			if err == nil {
+				if code == 0 {
+					return JobStatusComplete, nil
+				}
+				// Torque reports jobs killed by a signal as 256+signal; qdel sends SIGTERM then SIGKILL
+				if code == 256+15 || code == 256+9 {
+					return JobStatusCancelled, nil
+				}
+				return JobStatusFailed, nil
+			}
+		}
 		if s.checkJobSuccess(job) {
 			return JobStatusComplete, nil
 		}
 		return JobStatusFailed, nil
-	} else if strings.Contains(outputStr, "job_state = H") {
-		return JobStatusPending, nil
-	} else if strings.Contains(outputStr, "job_state = E") {
+	default:
 		return JobStatusFailed, nil
 	}
+}
 
-	return JobStatusFailed, nil
+// parseQstatFields extracts "key = value" attributes from qstat -f output
+func parseQstatFields(output string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		if match := qstatFieldPattern.FindStringSubmatch(line); match != nil {
+			fields[strings.ToLower(match[1])] = strings.TrimSpace(match[2])
+		}
+	}
+	return fields
 }
 
-// checkJobSuccess checks if a completed job was successful by examining its exit status
+// checkJobSuccess checks if a finished job was successful by examining its output
 func (s *TorqueScheduler) checkJobSuccess(job JobInterface) bool {
-	// In Torque, the exit status is typically written to the end of the output file
-	cmd := exec.Command("tail", "-n", "1", job.GetLogPath())
-	output, err := cmd.CombinedOutput()
+	// A successful HyPhy run always writes a non-empty results file
+	info, err := os.Stat(job.GetOutputPath())
 	if err != nil {
 		return false
 	}
-
-	// Check for common success indicators in the output
-	outputStr := strings.ToLower(string(output))
-	if strings.Contains(outputStr, "exit status: 0") ||
-		strings.Contains(outputStr, "completed successfully") {
-		return true
-	}
-
-	// Check if the job produced expected output files
-	// This is method-specific and could be enhanced
-	if _, err := exec.Command("test", "-s", job.GetOutputPath()).CombinedOutput(); err != nil {
-		return false
-	}
-
-	return true
+	return info.Size() > 0
 }
 
 // CheckHealth checks if the Torque scheduler is operational
 func (s *TorqueScheduler) CheckHealth() (bool, string, error) {
+	// Check if JobTracker is configured
+	if s.JobTracker == nil {
+		return false, "JobTracker not configured", fmt.Errorf("job tracker is not configured")
+	}
+
 	// Run pbsnodes to check if Torque is operational
 	cmd := exec.Command("pbsnodes", "-a")
 	output, err := cmd.CombinedOutput()
@@ -1,28 +1,48 @@
 package datamonkey
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
+// ErrAccessTokenExpired is returned for an access token past its expiry. Callers get a
+// 401 telling them to refresh it rather than a new session, which would not own their resources.
+var ErrAccessTokenExpired = errors.New("access token has expired")
+
 // TokenConfig holds configuration for JWT token generation
 type TokenConfig struct {
-	KeyPath         string        // Path to the JWT key file
+	KeyPath         string        // Path to a JWT key file, or a directory of key files
+	SigningKeyID    string        // ID of the key to sign with; defaults to the newest key
+	LegacyKeyPath   string        // Key that signed tokens before they had key IDs; verify only
+	LegacyKeyUntil  time.Time     // When the legacy key stops verifying; zero keeps it
 	Username        string        // Username for JWT token
-	ExpirationSecs  int64         // Expiration time in seconds for JWT token
+	ExpirationSecs  int64         // Expiration time in seconds for access tokens
+	RefreshTokenTTL time.Duration // How long a refresh token can be used
 	RefreshInterval time.Duration // How often to refresh the token
 }
 
+// TokenPair is an access token and the refresh token that renews it. RefreshToken
+// is empty when no TokenStore is configured.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int64
+}
+
 // SessionService provides session management and JWT token operations
 type SessionService struct {
 	Config         TokenConfig
 	SessionTracker SessionTracker
+	Accounts       AccountTracker // Optional; when set, account tokens stop validating once their login ends
+	Tokens         TokenStore     // Optional; when set, refresh tokens are issued and tokens can be revoked
+	Keys           *KeyRing
 }
 
 // NewSessionService creates a new SessionService instance
@@ -34,38 +54,63 @@ func NewSessionService(config TokenConfig, sessionTracker SessionTracker) *Sessi
 
 	// Set default JWT expiration if not specified
 	if config.ExpirationSecs == 0 {
-		config.ExpirationSecs = 86400 // Default to 24 hours
+		config.ExpirationSecs = 900 // Default to 15 minutes; refresh tokens renew it
+	}
+
+	// Set default refresh token lifetime if not specified
+	if config.RefreshTokenTTL == 0 {
+		config.RefreshTokenTTL = 30 * 24 * time.Hour
 	}
 
 	return &SessionService{
 		Config:         config,
 		SessionTracker: sessionTracker,
+		Keys:           newConfigKeyRing(config),
 	}
 }
 
+// newConfigKeyRing creates the key ring a token config describes
+func newConfigKeyRing(config TokenConfig) *KeyRing {
+	ring := NewKeyRing(config.KeyPath, config.SigningKeyID)
+	ring.LegacyPath = config.LegacyKeyPath
+	ring.LegacyUntil = config.LegacyKeyUntil
+	return ring
+}
+
+// keyRing returns the key ring, creating it from the config for services built
+// without NewSessionService
+func (s *SessionService) keyRing() *KeyRing {
+	if s.Keys == nil {
+		s.Keys = newConfigKeyRing(s.Config)
+	}
+	return s.Keys
+}
+
 // GenerateToken generates a JWT token
 func (s *SessionService) GenerateToken(claims map[string]interface{}) (string, error) {
 	if s.Config.KeyPath == "" {
 		return "", fmt.Errorf("JWT key path not set")
 	}
 
-	keyData, err := os.ReadFile(s.Config.KeyPath)
+	key, err := s.keyRing().SigningKey()
 	if err != nil {
-		return "", fmt.Errorf("failed to read JWT key file: %v", err)
+		return "", err
 	}
 
 	now := time.Now()
 	jwtClaims := jwt.MapClaims{
 		"iat": now.Unix(),
 		"exp": now.Add(time.Duration(s.Config.ExpirationSecs) * time.Second).Unix(),
+		"jti": uuid.New().String(),
 	}
 
 	for k, v := range claims {
 		jwtClaims[k] = v
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwtClaims)
-	signedToken, err := token.SignedString(keyData)
+	token := jwt.NewWithClaims(key.Method, jwtClaims)
+	token.Header["kid"] = key.ID
+	signedToken, err := token.SignedString(key.SignKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign JWT token: %v", err)
 	}
@@ -83,24 +128,149 @@ func (s *SessionService) GenerateUserToken(userId string) (string, error) {
 	return s.GenerateToken(claims)
 }
 
+// grantClaims returns the access token claims for a refresh grant
+func grantClaims(grant RefreshGrant) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": grant.Subject,
+	}
+	if grant.AccountID != "" {
+		claims["type"] = "user"
+		claims["acct"] = grant.AccountID
+		claims["sid"] = grant.LoginID
+	}
+	return claims
+}
+
+// issueTokens generates an access token for a grant and, when a TokenStore is
+// configured, a refresh token starting a new family
+func (s *SessionService) issueTokens(grant RefreshGrant) (*TokenPair, error) {
+	access, err := s.GenerateToken(grantClaims(grant))
+	if err != nil {
+		return nil, err
+	}
+	pair := &TokenPair{AccessToken: access, ExpiresIn: s.Config.ExpirationSecs}
+	if s.Tokens != nil {
+		grant.ExpiresAt = time.Now().Add(s.Config.RefreshTokenTTL)
+		pair.RefreshToken, err = s.Tokens.CreateRefreshToken(grant)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pair, nil
+}
+
+// GenerateAccountTokens generates tokens for a login to a registered account. Besides
+// the account's subject the access token carries the account ID and the login ID, so
+// that logging out revokes it.
+func (s *SessionService) GenerateAccountTokens(account *Account, loginID string) (*TokenPair, error) {
+	return s.issueTokens(RefreshGrant{
+		Subject:   account.Subject,
+		AccountID: account.Id,
+		LoginID:   loginID,
+	})
+}
+
+// RefreshTokens uses up a refresh token and returns a new access token and the next
+// refresh token for the same identity. Reusing a refresh token revokes every token
+// rotated from it.
+func (s *SessionService) RefreshTokens(refreshToken string) (*TokenPair, *RefreshGrant, error) {
+	if s.Tokens == nil {
+		return nil, nil, fmt.Errorf("refresh tokens not enabled")
+	}
+	next, grant, err := s.Tokens.RotateRefreshToken(refreshToken, time.Now().Add(s.Config.RefreshTokenTTL))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if grant.LoginID != "" && s.Accounts != nil {
+		active, err := s.Accounts.IsLoginActive(grant.LoginID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !active {
+			return nil, nil, ErrRefreshTokenInvalid
+		}
+	}
+
+	access, err := s.GenerateToken(grantClaims(*grant))
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.SessionTracker != nil {
+		s.SessionTracker.UpdateLastSeen(grant.Subject)
+	}
+	return &TokenPair{AccessToken: access, RefreshToken: next, ExpiresIn: s.Config.ExpirationSecs}, grant, nil
+}
+
+// RevokeToken revokes an access token until it expires, or the refresh token family
+// a refresh token belongs to. Tokens that are already invalid are ignored.
+func (s *SessionService) RevokeToken(token string) error {
+	if s.Tokens == nil {
+		return fmt.Errorf("token revocation not enabled")
+	}
+	if strings.Count(token, ".") != 2 {
+		return s.Tokens.RevokeRefreshToken(token)
+	}
+	claims, err := s.ValidateToken(token)
+	if err != nil {
+		return nil
+	}
+	return s.revokeAccessToken(claims)
+}
+
+// revokeAccessToken records the ID of a validated access token as revoked
+func (s *SessionService) revokeAccessToken(claims jwt.MapClaims) error {
+	jti, ok := claims["jti"].(string)
+	if !ok {
+		return fmt.Errorf("token has no ID and cannot be revoked")
+	}
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return fmt.Errorf("token has no expiry and cannot be revoked")
+	}
+	return s.Tokens.RevokeAccessToken(jti, expiresAt.Time)
+}
+
+// EndLogin logs out of an account: it ends the login in the claims, so tokens issued
+// for it stop validating, and revokes its refresh tokens and the access token itself
+func (s *SessionService) EndLogin(claims jwt.MapClaims) error {
+	loginID, ok := claims["sid"].(string)
+	if !ok {
+		return fmt.Errorf("token is not for a login")
+	}
+	if s.Accounts != nil {
+		if err := s.Accounts.EndLogin(loginID); err != nil {
+			return err
+		}
+	}
+	if s.Tokens != nil {
+		if err := s.Tokens.RevokeLoginRefreshTokens(loginID); err != nil {
+			return err
+		}
+		if _, ok := claims["jti"].(string); ok {
+			return s.revokeAccessToken(claims)
+		}
+	}
+	return nil
+}
+
 // ValidateToken validates a JWT token and returns its claims
 func (s *SessionService) ValidateToken(tokenString string) (jwt.MapClaims, error) {
 	if s.Config.KeyPath == "" {
 		return nil, fmt.Errorf("JWT key path not set")
 	}
 
-	keyData, err := os.ReadFile(s.Config.KeyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read JWT key file: %v", err)
+	// Load the keys first so a missing key file is reported as such, not as a parse error
+	if _, err := s.keyRing().SigningKey(); err != nil {
+		return nil, err
 	}
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return keyData, nil
-	})
+	token, err := jwt.Parse(tokenString, s.keyRing().Keyfunc,
+		jwt.WithValidMethods([]string{AlgorithmHS256, AlgorithmRS256, AlgorithmEdDSA}))
 
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrAccessTokenExpired
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %v", err)
 	}
@@ -114,6 +284,28 @@ func (s *SessionService) ValidateToken(tokenString string) (jwt.MapClaims, error
 		return nil, fmt.Errorf("invalid claims")
 	}
 
+	// Account tokens are revoked by ending the login they were issued for
+	if loginID, ok := claims["sid"].(string); ok && s.Accounts != nil {
+		active, err := s.Accounts.IsLoginActive(loginID)
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			return nil, fmt.Errorf("login has ended")
+		}
+	}
+
+	// Any token can be revoked by ID until it expires
+	if jti, ok := claims["jti"].(string); ok && s.Tokens != nil {
+		revoked, err := s.Tokens.IsAccessTokenRevoked(jti)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, fmt.Errorf("token has been revoked")
+		}
+	}
+
 	return claims, nil
 }
 
@@ -141,15 +333,34 @@ func (s *SessionService) ExtractToken(c *gin.Context) string {
 	return ""
 }
 
+// subjectContextKey is where the subject of a request is kept once it is resolved
+const subjectContextKey = "datamonkey.subject"
+
+// SubjectFromContext returns the subject already resolved for a request, e.g. by the
+// Authorizer middleware
+func SubjectFromContext(c *gin.Context) (string, bool) {
+	subject := c.GetString(subjectContextKey)
+	return subject, subject != ""
+}
+
 // GetOrCreateSubject gets subject from token or creates new session
 // This is the main method handlers should call
+// An expired token returns ErrAccessTokenExpired instead of a new session
 func (s *SessionService) GetOrCreateSubject(c *gin.Context) (string, error) {
+	// The subject is resolved once per request
+	if subject, ok := SubjectFromContext(c); ok {
+		return subject, nil
+	}
+
 	// Try to extract token
 	token := s.ExtractToken(c)
 
 	if token != "" {
 		// Validate existing token
 		claims, err := s.ValidateToken(token)
+		if errors.Is(err, ErrAccessTokenExpired) {
+			return "", err
+		}
 		if err != nil {
 			log.Printf("Invalid token: %v, creating new session", err)
 			return s.createNewSession(c)
@@ -161,6 +372,7 @@ func (s *SessionService) GetOrCreateSubject(c *gin.Context) (string, error) {
 			if s.SessionTracker != nil {
 				s.SessionTracker.UpdateLastSeen(sub)
 			}
+			c.Set(subjectContextKey, sub)
 			return sub, nil
 		}
 
@@ -174,51 +386,74 @@ func (s *SessionService) GetOrCreateSubject(c *gin.Context) (string, error) {
 }
 
 // createNewSession creates a new session and returns the subject
-// Also adds the token to response headers
+// Also adds the tokens to response headers
 func (s *SessionService) createNewSession(c *gin.Context) (string, error) {
-	var subject string
+	subject, tokens, err := s.NewSession()
+	if err != nil {
+		return "", err
+	}
 
-	// Create session in tracker
-	if s.SessionTracker != nil {
-		session, err := s.SessionTracker.CreateSession()
-		if err != nil {
-			log.Printf("Failed to create session in tracker: %v", err)
-			return "", fmt.Errorf("failed to create session: %v", err)
-		}
-		subject = session.Subject
+	// Add tokens to response headers
+	c.Header("X-Session-Token", tokens.AccessToken)
+	if tokens.RefreshToken != "" {
+		c.Header("X-Refresh-Token", tokens.RefreshToken)
+		c.Header("Access-Control-Expose-Headers", "X-Session-Token, X-Refresh-Token")
 	} else {
-		return "", fmt.Errorf("session tracker not available")
+		c.Header("Access-Control-Expose-Headers", "X-Session-Token")
 	}
 
-	// Generate JWT token for this session
-	claims := map[string]interface{}{
-		"sub": subject,
+	c.Set(subjectContextKey, subject)
+	log.Printf("Created new session: subject=%s", subject)
+	return subject, nil
+}
+
+// NewSession creates a new anonymous session and returns its subject and tokens
+func (s *SessionService) NewSession() (string, *TokenPair, error) {
+	// Create session in tracker
+	if s.SessionTracker == nil {
+		return "", nil, fmt.Errorf("session tracker not available")
 	}
-	token, err := s.GenerateToken(claims)
+	session, err := s.SessionTracker.CreateSession()
+	if err != nil {
+		log.Printf("Failed to create session in tracker: %v", err)
+		return "", nil, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	// Generate tokens for this session
+	tokens, err := s.issueTokens(RefreshGrant{Subject: session.Subject})
 	if err != nil {
 		log.Printf("Failed to generate token for session: %v", err)
-		return "", fmt.Errorf("failed to generate token: %v", err)
+		return "", nil, fmt.Errorf("failed to generate token: %v", err)
 	}
+	return session.Subject, tokens, nil
+}
 
-	// Add token to response header
-	c.Header("X-Session-Token", token)
-	c.Header("Access-Control-Expose-Headers", "X-Session-Token")
+// GetClaims gets the claims of an existing token (does not create new session)
+// Returns error if no valid token provided
+func (s *SessionService) GetClaims(c *gin.Context) (jwt.MapClaims, error) {
+	token := s.ExtractToken(c)
+	if token == "" {
+		return nil, fmt.Errorf("no token provided")
+	}
 
-	log.Printf("Created new session: subject=%s", subject)
-	return subject, nil
+	claims, err := s.ValidateToken(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+	return claims, nil
 }
 
 // GetSubject gets the subject from an existing token (does not create new session)
 // Returns error if no valid token provided
 func (s *SessionService) GetSubject(c *gin.Context) (string, error) {
-	token := s.ExtractToken(c)
-	if token == "" {
-		return "", fmt.Errorf("no token provided")
+	// The subject is resolved once per request
+	if subject, ok := SubjectFromContext(c); ok {
+		return subject, nil
 	}
 
-	claims, err := s.ValidateToken(token)
+	claims, err := s.GetClaims(c)
 	if err != nil {
-		return "", fmt.Errorf("invalid token: %v", err)
+		return "", err
 	}
 
 	sub, ok := claims["sub"].(string)
@@ -231,6 +466,7 @@ func (s *SessionService) GetSubject(c *gin.Context) (string, error) {
 		s.SessionTracker.UpdateLastSeen(sub)
 	}
 
+	c.Set(subjectContextKey, sub)
 	return sub, nil
 }
 
@@ -352,6 +588,15 @@ func (s *SessionService) StartSessionCleanup(interval time.Duration, maxAge time
 			} else if count > 0 {
 				log.Printf("Cleaned up %d expired sessions", count)
 			}
+
+			if s.Tokens != nil {
+				count, err := s.Tokens.CleanupExpiredTokens()
+				if err != nil {
+					log.Printf("Error cleaning up expired tokens: %v", err)
+				} else if count > 0 {
+					log.Printf("Cleaned up %d expired tokens", count)
+				}
+			}
 		}
 	}()
 
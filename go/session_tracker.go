@@ -165,7 +165,8 @@ func (s *SQLiteSessionTracker) DeleteSession(subject string) error {
 func (s *SQLiteSessionTracker) CleanupExpiredSessions(maxAge time.Duration) (int, error) {
 	cutoffTime := time.Now().Add(-maxAge).Unix()
 
-	query := `DELETE FROM sessions WHERE last_seen < ?`
+	// Sessions owned by accounts never expire
+	query := `DELETE FROM sessions WHERE last_seen < ? AND subject NOT IN (SELECT subject FROM accounts)`
 
 	result, err := s.db.Exec(query, cutoffTime)
 	if err != nil {
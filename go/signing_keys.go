@@ -0,0 +1,390 @@
+package datamonkey
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported token signing algorithms
+const (
+	AlgorithmHS256 = "HS256" // Shared secret; only this service can verify tokens
+	AlgorithmRS256 = "RS256" // RSA key pair; anyone with the JWKS can verify tokens
+	AlgorithmEdDSA = "EdDSA" // Ed25519 key pair; anyone with the JWKS can verify tokens
+)
+
+// SigningKey is a key tokens are signed or verified with. Its ID is the name of the
+// file it was loaded from, without the extension, and is sent as the kid header.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   interface{} // nil for keys that only verify, e.g. a PEM public key
+	VerifyKey interface{}
+	ModTime   time.Time
+}
+
+// LoadSigningKey loads a key file. PEM private keys (PKCS#8 RSA or Ed25519, or PKCS#1
+// RSA) sign with RS256 or EdDSA, PEM public keys only verify, and any other file is an
+// HS256 secret used byte for byte.
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT key file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT key file: %v", err)
+	}
+	key := &SigningKey{
+		ID:      strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		ModTime: info.ModTime(),
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		if len(data) == 0 {
+			return nil, fmt.Errorf("JWT key file is empty: %s", path)
+		}
+		key.Method = jwt.SigningMethodHS256
+		key.SignKey = data
+		key.VerifyKey = data
+		return key, nil
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT key file %s: %v", path, err)
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.SignKey, key.VerifyKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.VerifyKey = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.SignKey, key.VerifyKey = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.VerifyKey = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T in %s", parsed, path)
+	}
+	return key, nil
+}
+
+// GenerateSigningKey writes a new key for an algorithm to a directory, named after
+// the current time so it sorts after older keys, and returns its path
+func GenerateSigningKey(dir string, algorithm string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create key directory: %v", err)
+	}
+	name := fmt.Sprintf("%s-%s", strings.ToLower(algorithm), time.Now().UTC().Format("20060102T150405Z"))
+
+	var data []byte
+	switch algorithm {
+	case AlgorithmHS256:
+		data = make([]byte, 64)
+		if _, err := rand.Read(data); err != nil {
+			return "", fmt.Errorf("failed to generate key: %v", err)
+		}
+		data = []byte(base64.RawStdEncoding.EncodeToString(data))
+		name += ".key"
+	case AlgorithmRS256, AlgorithmEdDSA:
+		var private interface{}
+		var err error
+		if algorithm == AlgorithmRS256 {
+			private, err = rsa.GenerateKey(rand.Reader, 2048)
+		} else {
+			_, private, err = ed25519.GenerateKey(rand.Reader)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to generate key: %v", err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode key: %v", err)
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		name += ".pem"
+	default:
+		return "", fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+
+	// Never overwrite a key, which would invalidate every token it signed
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create key: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		return "", fmt.Errorf("failed to write key: %v", err)
+	}
+	return path, nil
+}
+
+// KeyRing holds the keys tokens are signed and verified with. Path is a key file or a
+// directory of key files. Every key verifies tokens naming it in their kid header; the
+// key named by SigningKeyID, or else the most recently modified key that can sign,
+// signs new tokens. Keys are loaded on first use and reloaded when the files change,
+// checked at most once per ReloadInterval, so a key is rotated by adding a new file and
+// removing the old one once the tokens it signed have expired.
+type KeyRing struct {
+	Path           string
+	SigningKeyID   string
+	ReloadInterval time.Duration
+	// LegacyPath is a key file that signed tokens before they had key IDs, such as the
+	// Slurm key user tokens used to share. It never signs, and only verifies tokens
+	// without a kid header until LegacyUntil, by when every token it signed has expired.
+	LegacyPath  string
+	LegacyUntil time.Time
+
+	mu          sync.Mutex
+	keys        map[string]*SigningKey
+	signing     *SigningKey
+	legacy      *SigningKey
+	fingerprint string
+	checked     time.Time
+}
+
+// NewKeyRing creates a new KeyRing instance
+func NewKeyRing(path string, signingKeyID string) *KeyRing {
+	return &KeyRing{
+		Path:           path,
+		SigningKeyID:   signingKeyID,
+		ReloadInterval: 30 * time.Second,
+	}
+}
+
+// keyFiles lists the key files under Path and a fingerprint of their names, sizes
+// and modification times
+func (r *KeyRing) keyFiles() ([]string, string, error) {
+	info, err := os.Stat(r.Path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read JWT key file: %v", err)
+	}
+	paths := []string{r.Path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(r.Path)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read JWT key directory: %v", err)
+		}
+		paths = paths[:0]
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			paths = append(paths, filepath.Join(r.Path, entry.Name()))
+		}
+	}
+
+	var fingerprint strings.Builder
+	for _, path := range paths {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read JWT key file: %v", err)
+		}
+		fmt.Fprintf(&fingerprint, "%s:%d:%d;", path, fileInfo.Size(), fileInfo.ModTime().UnixNano())
+	}
+	return paths, fingerprint.String(), nil
+}
+
+// load returns the current keys, reloading them if the files changed. Once keys have
+// loaded, a failed reload keeps them and is only logged.
+func (r *KeyRing) load() (map[string]*SigningKey, *SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.keys != nil && time.Since(r.checked) < r.ReloadInterval {
+		return r.keys, r.signing, nil
+	}
+	if err := r.reloadLocked(); err != nil {
+		if r.keys == nil {
+			return nil, nil, err
+		}
+		log.Printf("Failed to reload JWT keys, keeping the current keys: %v", err)
+	}
+	return r.keys, r.signing, nil
+}
+
+// Reload loads the keys again now if the files changed
+func (r *KeyRing) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reloadLocked()
+}
+
+func (r *KeyRing) reloadLocked() error {
+	if r.Path == "" {
+		return fmt.Errorf("JWT key path not set")
+	}
+	r.checked = time.Now()
+	paths, fingerprint, err := r.keyFiles()
+	if err != nil {
+		return err
+	}
+	fingerprint = r.SigningKeyID + ";" + fingerprint
+	if r.LegacyPath != "" {
+		info, err := os.Stat(r.LegacyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read legacy JWT key file: %v", err)
+		}
+		fingerprint += fmt.Sprintf("legacy=%s:%d:%d;", r.LegacyPath, info.Size(), info.ModTime().UnixNano())
+	}
+	if r.keys != nil && fingerprint == r.fingerprint {
+		return nil
+	}
+
+	keys := map[string]*SigningKey{}
+	var signers []*SigningKey
+	for _, path := range paths {
+		key, err := LoadSigningKey(path)
+		if err != nil {
+			return err
+		}
+		if _, exists := keys[key.ID]; exists {
+			return fmt.Errorf("duplicate JWT key ID %q in %s", key.ID, r.Path)
+		}
+		keys[key.ID] = key
+		if key.SignKey != nil {
+			signers = append(signers, key)
+		}
+	}
+
+	var signing *SigningKey
+	if r.SigningKeyID != "" {
+		signing = keys[r.SigningKeyID]
+		if signing == nil || signing.SignKey == nil {
+			return fmt.Errorf("signing key %q not found in %s", r.SigningKeyID, r.Path)
+		}
+	} else {
+		sort.Slice(signers, func(i, j int) bool {
+			if !signers[i].ModTime.Equal(signers[j].ModTime) {
+				return signers[i].ModTime.After(signers[j].ModTime)
+			}
+			return signers[i].ID > signers[j].ID
+		})
+		if len(signers) == 0 {
+			return fmt.Errorf("no JWT signing key found in %s", r.Path)
+		}
+		signing = signers[0]
+	}
+
+	var legacy *SigningKey
+	if r.LegacyPath != "" {
+		legacy, err = LoadSigningKey(r.LegacyPath)
+		if err != nil {
+			return err
+		}
+		legacy.SignKey = nil
+	}
+
+	if r.keys != nil {
+		log.Printf("Reloaded %d JWT keys, signing with %s", len(keys), signing.ID)
+	}
+	r.keys, r.signing, r.legacy, r.fingerprint = keys, signing, legacy, fingerprint
+	return nil
+}
+
+// legacyKey returns the legacy key while it still verifies tokens
+func (r *KeyRing) legacyKey() *SigningKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.legacy == nil || (!r.LegacyUntil.IsZero() && !time.Now().Before(r.LegacyUntil)) {
+		return nil
+	}
+	return r.legacy
+}
+
+// SigningKey returns the key new tokens are signed with
+func (r *KeyRing) SigningKey() (*SigningKey, error) {
+	_, signing, err := r.load()
+	return signing, err
+}
+
+// Keyfunc returns the key to verify a token with: the key named by its kid header, or
+// for tokens issued before keys had IDs, every key using the token's algorithm,
+// including the legacy key
+func (r *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	keys, _, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	alg := token.Method.Alg()
+
+	if kid, ok := token.Header["kid"].(string); ok {
+		key, ok := keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %s", kid)
+		}
+		if key.Method.Alg() != alg {
+			return nil, fmt.Errorf("unexpected signing method: %v", alg)
+		}
+		return key.VerifyKey, nil
+	}
+
+	var set jwt.VerificationKeySet
+	for _, key := range keys {
+		if key.Method.Alg() == alg {
+			set.Keys = append(set.Keys, key.VerifyKey)
+		}
+	}
+	if legacy := r.legacyKey(); legacy != nil && legacy.Method.Alg() == alg {
+		set.Keys = append(set.Keys, legacy.VerifyKey)
+	}
+	if len(set.Keys) == 0 {
+		return nil, fmt.Errorf("unexpected signing method: %v", alg)
+	}
+	return set, nil
+}
+
+// JWKS returns the public keys of the asymmetric keys as a JSON Web Key Set, so other
+// services can verify tokens without a shared secret. HS256 secrets are never published.
+func (r *KeyRing) JWKS() (JsonWebKeySet, error) {
+	keys, _, err := r.load()
+	if err != nil {
+		return JsonWebKeySet{}, err
+	}
+	set := JsonWebKeySet{Keys: []JsonWebKey{}}
+	for _, key := range keys {
+		jwk := JsonWebKey{Kid: key.ID, Alg: key.Method.Alg(), Use: "sig"}
+		switch public := key.VerifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set, nil
+}
@@ -0,0 +1,284 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+// TestParseAlignmentFormats tests that each format parses to the same alignment
+func TestParseAlignmentFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		content string
+	}{
+		{"fasta", sw.AlignmentFormatFASTA, ">human\nATG-CA\nTTA\n>chimp\nATGGCA\nTTN\n"},
+		{"nexus sequential", sw.AlignmentFormatNEXUS, `#NEXUS
+[ exported by hand ]
+BEGIN DATA;
+	DIMENSIONS NTAX=2 NCHAR=9;
+	FORMAT DATATYPE=DNA GAP=- MISSING=?;
+	MATRIX
+	human  ATG-CA
+	       TTA
+	'chimp' ATGGCATTN
+	;
+END;
+`},
+		{"nexus interleaved with matchchar", sw.AlignmentFormatNEXUS, `#NEXUS
+BEGIN CHARACTERS;
+	DIMENSIONS NTAX=2 NCHAR=9;
+	FORMAT DATATYPE=DNA MATCHCHAR=. INTERLEAVE;
+	MATRIX
+	human ATG-CA
+	chimp ...G..
+
+	human TTA
+	chimp ..N
+	;
+END;
+`},
+		{"phylip sequential", sw.AlignmentFormatPHYLIP, " 2 9\nhuman ATG-CATTA\nchimp ATGGCA\nTTN\n"},
+		{"phylip interleaved", sw.AlignmentFormatPHYLIP, "2 9\nhuman ATG-CA\nchimp ATGGCA\n\nTTA\nTTN\n"},
+		{"clustal", sw.AlignmentFormatCLUSTAL, "CLUSTAL W (1.83) multiple sequence alignment\n\nhuman ATG-CA 5\nchimp ATGGCA 6\n      *** **\n\nhuman TTA 8\nchimp TTN 9\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alignment, err := sw.ParseAlignment([]byte(tt.content))
+			if err != nil {
+				t.Fatalf("ParseAlignment failed: %v", err)
+			}
+			if alignment.Format != tt.format {
+				t.Errorf("Expected format %s, got %s", tt.format, alignment.Format)
+			}
+			if len(alignment.Sequences) != 2 {
+				t.Fatalf("Expected 2 sequences, got %d", len(alignment.Sequences))
+			}
+			if s := alignment.Sequences[0]; s.Name != "human" || s.Data != "ATG-CATTA" {
+				t.Errorf("Unexpected first sequence: %+v", s)
+			}
+			if s := alignment.Sequences[1]; s.Name != "chimp" || s.Data != "ATGGCATTN" {
+				t.Errorf("Unexpected second sequence: %+v", s)
+			}
+		})
+	}
+}
+
+// TestParseAlignmentErrors tests that malformed alignments report the offending line
+func TestParseAlignmentErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		line    int
+	}{
+		{"fasta unequal lengths", ">a\nATGATG\n>b\nATGA\n", 3},
+		{"fasta invalid character", ">a\nATGATG\n>b\nATG1TG\n", 4},
+		{"fasta data before header", "\nATG\n>a\nATG\n", 2},
+		{"fasta empty name", ">a\nATG\n>\nATG\n", 3},
+		{"nexus wrong nchar", "#NEXUS\nBEGIN DATA;\nDIMENSIONS NTAX=2 NCHAR=4;\nMATRIX\na ATGA\nb ATG\n;\nEND;\n", 6},
+		{"nexus too many taxa", "#NEXUS\nBEGIN DATA;\nDIMENSIONS NTAX=1 NCHAR=3;\nMATRIX\na ATG\nb ATG\n;\nEND;\n", 6},
+		{"nexus unterminated matrix", "#NEXUS\nBEGIN DATA;\nDIMENSIONS NTAX=1 NCHAR=3;\nMATRIX\na ATG\n", 4},
+		{"phylip short sequence", "2 4\na ATGA\nb ATG\n", 3},
+		{"clustal invalid character", "CLUSTAL W\n\na ATG\nb A#G\n", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sw.ParseAlignment([]byte(tt.content))
+			var alignmentErr *sw.AlignmentError
+			if !errors.As(err, &alignmentErr) {
+				t.Fatalf("Expected an AlignmentError, got %v", err)
+			}
+			if alignmentErr.Line != tt.line {
+				t.Errorf("Expected error on line %d, got %d: %v", tt.line, alignmentErr.Line, err)
+			}
+		})
+	}
+}
+
+// TestAlignmentStats tests the QC statistics of an alignment
+func TestAlignmentStats(t *testing.T) {
+	alignment, err := sw.ParseAlignment([]byte(">a\nATG-CN\n>b\nATGRC-\n>a\nAT--CA\n"))
+	if err != nil {
+		t.Fatalf("ParseAlignment failed: %v", err)
+	}
+	stats := alignment.Stats()
+
+	if stats.SequenceCount != 3 || stats.AlignmentLength != 6 || stats.Alphabet != "nucleotide" {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if stats.GapFraction != 4.0/18.0 {
+		t.Errorf("Expected gap fraction %v, got %v", 4.0/18.0, stats.GapFraction)
+	}
+	if stats.AmbiguousCount != 2 || stats.AmbiguousCharacters["N"] != 1 || stats.AmbiguousCharacters["R"] != 1 {
+		t.Errorf("Unexpected ambiguous characters: %d %v", stats.AmbiguousCount, stats.AmbiguousCharacters)
+	}
+	if len(stats.DuplicateNames) != 1 || stats.DuplicateNames[0] != "a" {
+		t.Errorf("Expected duplicate name a, got %v", stats.DuplicateNames)
+	}
+
+	protein, err := sw.ParseAlignment([]byte(">a\nMKVLX\n>b\nMKILW\n"))
+	if err != nil {
+		t.Fatalf("ParseAlignment failed: %v", err)
+	}
+	if stats := protein.Stats(); stats.Alphabet != "protein" || stats.AmbiguousCharacters["X"] != 1 {
+		t.Errorf("Unexpected protein stats: %+v", stats)
+	}
+}
+
+// TestCheckAlignment tests which uploads are checked as alignments
+func TestCheckAlignment(t *testing.T) {
+	if stats, err := sw.CheckAlignment("newick", []byte("((a,b),c);")); err != nil || stats != nil {
+		t.Errorf("Expected trees to be skipped, got %v, %v", stats, err)
+	}
+	if stats, err := sw.CheckAlignment("nexus", []byte("#NEXUS\nBEGIN TREES;\nTREE t = ((a,b),c);\nEND;\n")); err != nil || stats != nil {
+		t.Errorf("Expected NEXUS tree files to be skipped, got %v, %v", stats, err)
+	}
+	if _, err := sw.CheckAlignment("fasta", []byte("just some text")); err == nil {
+		t.Error("Expected an error for a FASTA upload that is not an alignment")
+	}
+	if stats, err := sw.CheckAlignment("nexus", []byte(">a\nATG\n>b\nATG\n")); err != nil || stats.Format != sw.AlignmentFormatFASTA {
+		t.Errorf("Expected the detected format to be recorded, got %v, %v", stats, err)
+	}
+}
+
+// TestCheckAlignmentFile tests that checking a file as a stream matches checking its content
+func TestCheckAlignmentFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"fasta", ">a\nATG-CN\n>b\nATGRC-\n>a\nAT--CA\n"},
+		{"nexus with matchchar", "#NEXUS\nBEGIN DATA;\nDIMENSIONS NTAX=3 NCHAR=6;\nFORMAT MATCHCHAR=. INTERLEAVE;\nMATRIX\na ATG\nb ..C\nc -.N\n\na CAT\nb ...\nc .R.\n;\nEND;\n"},
+		{"phylip interleaved", "3 6\na ATG\nb ATC\nc ATN\n\nCAT\nCA-\nRAT\n"},
+		{"clustal", "CLUSTAL W\n\na ATG-CA\nb ATGGCN\n"},
+		{"tree", "((a,b),c);"},
+		{"malformed fasta", ">a\nATGATG\n>b\nATGA\n"},
+		{"malformed nexus", "#NEXUS\nBEGIN DATA;\nDIMENSIONS NTAX=2 NCHAR=4;\nMATRIX\na ATGA\nb ATG\n;\nEND;\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "upload")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write upload: %v", err)
+			}
+			want, wantErr := sw.CheckAlignment("fasta", []byte(tt.content))
+			got, err := sw.CheckAlignmentFile("fasta", path)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("CheckAlignmentFile = %+v, CheckAlignment = %+v", got, want)
+			}
+			if fmt.Sprint(err) != fmt.Sprint(wantErr) {
+				t.Errorf("CheckAlignmentFile error = %v, CheckAlignment error = %v", err, wantErr)
+			}
+		})
+	}
+}
+
+// datasetUploadFixture holds a dataset API wired to a test database
+type datasetUploadFixture struct {
+	*apiTestEnv
+	api *sw.FileUploadAndQCAPI
+}
+
+func setupDatasetUploadFixture(t *testing.T) *datasetUploadFixture {
+	t.Helper()
+	f := &datasetUploadFixture{apiTestEnv: setupAPITestEnv(t)}
+	f.api = sw.NewFileUploadAndQCAPI(f.datasets, f.session)
+	f.router.POST("/api/v1/datasets", f.api.PostDataset)
+	f.router.GET("/api/v1/datasets/:datasetId", f.api.GetDatasetById)
+	return f
+}
+
+func (f *datasetUploadFixture) upload(t *testing.T, datasetType string, content string) *httptest.ResponseRecorder {
+	t.Helper()
+	return f.uploadWithMeta(t, `{"name": "upload", "type": "`+datasetType+`"}`, content)
+}
+
+// uploadWithMeta uploads content with the given meta form field
+func (f *datasetUploadFixture) uploadWithMeta(t *testing.T, meta string, content string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "upload")
+	if err != nil {
+		t.Fatalf("CreateFormFile failed: %v", err)
+	}
+	part.Write([]byte(content))
+	writer.WriteField("meta", meta)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/datasets", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("user_token", f.token)
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+// TestPostDatasetRecordsAlignmentStats tests that uploaded alignments are parsed and their stats returned
+func TestPostDatasetRecordsAlignmentStats(t *testing.T) {
+	f := setupDatasetUploadFixture(t)
+
+	w := f.upload(t, "fasta", ">a\nATG-CN\n>b\nATGRCA\n")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/datasets/"+created.ID, nil)
+	req.Header.Set("user_token", f.token)
+	w = httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var dataset sw.Dataset
+	if err := json.Unmarshal(w.Body.Bytes(), &dataset); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if dataset.Alignment == nil {
+		t.Fatal("Expected alignment stats")
+	}
+	if dataset.Alignment.SequenceCount != 2 || dataset.Alignment.AlignmentLength != 6 || dataset.Alignment.AmbiguousCount != 2 {
+		t.Errorf("Unexpected alignment stats: %+v", dataset.Alignment)
+	}
+}
+
+// TestPostDatasetRejectsMalformedAlignment tests the InvalidDataError for malformed alignments
+func TestPostDatasetRejectsMalformedAlignment(t *testing.T) {
+	f := setupDatasetUploadFixture(t)
+
+	w := f.upload(t, "fasta", ">a\nATGATG\n>b\nATGA\n")
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status 422, got %d: %s", w.Code, w.Body.String())
+	}
+	var response sw.InvalidDataError
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(response.Errors) != 1 || response.Errors[0].Field != "file" || response.Errors[0].Line != 3 {
+		t.Errorf("Unexpected errors: %+v", response.Errors)
+	}
+
+	// Trees are not alignments
+	if w := f.upload(t, "newick", "((a,b),c);"); w.Code != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+}
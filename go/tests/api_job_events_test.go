@@ -0,0 +1,258 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+	"github.com/gin-gonic/gin"
+)
+
+// sseEvent is a parsed Server-Sent Event
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readSSEEvents parses events from a stream until it closes, sending them on a channel
+func readSSEEvents(t *testing.T, resp *http.Response) <-chan sseEvent {
+	t.Helper()
+	events := make(chan sseEvent, 100)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var current sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				current.name = strings.TrimPrefix(line, "event:")
+			case strings.HasPrefix(line, "data:"):
+				current.data = strings.TrimPrefix(line, "data:")
+			case line == "" && current.name != "":
+				events <- current
+				current = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+// nextSSEEvent waits for the next event
+func nextSSEEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("Event stream closed unexpectedly")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+	return sseEvent{}
+}
+
+// TestGetJobEventsStream tests status and progress events for a running job
+func TestGetJobEventsStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+	tracker := sw.NewSQLiteJobTracker(db.GetDB())
+
+	jobID := "streamed-job"
+	if err := tracker.StoreJobMapping(jobID, "sched-1"); err != nil {
+		t.Fatalf("StoreJobMapping failed: %v", err)
+	}
+	if err := tracker.StoreJobMetadata(jobID, "", "", string(sw.MethodFEL), string(sw.JobStatusRunning)); err != nil {
+		t.Fatalf("StoreJobMetadata failed: %v", err)
+	}
+
+	logPath := filepath.Join(dir, fmt.Sprintf("%s_%s.log", sw.MethodFEL, jobID))
+	if err := os.WriteFile(logPath, []byte("Loaded alignment\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	bus := sw.NewJobEventBus()
+	jobsAPI := sw.NewJobsAPI(tracker, nil, nil)
+	jobsAPI.Events = bus
+	jobsAPI.ProgressInterval = 10 * time.Millisecond
+	jobsAPI.MethodFactory = func(methodType sw.HyPhyMethodType) (sw.ComputeMethodInterface, error) {
+		return sw.NewHyPhyMethod(nil, dir, "hyphy", methodType, ""), nil
+	}
+
+	router := gin.New()
+	router.GET("/api/v1/jobs/:jobId/events", jobsAPI.GetJobEvents)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/jobs/" + jobID + "/events")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("Expected event stream, got %s", ct)
+	}
+	events := readSSEEvents(t, resp)
+
+	// Current status first, then the existing log
+	event := nextSSEEvent(t, events)
+	var status sw.JobEvent
+	if err := json.Unmarshal([]byte(event.data), &status); err != nil {
+		t.Fatalf("Invalid status payload %q: %v", event.data, err)
+	}
+	if event.name != "status" || status.Status != sw.JobStatusRunning || status.Type != sw.JobEventStarted {
+		t.Errorf("Expected running status event, got %s %s", event.name, event.data)
+	}
+
+	event = nextSSEEvent(t, events)
+	if event.name != "progress" || !strings.Contains(event.data, "Loaded alignment") {
+		t.Errorf("Expected initial log line, got %s %s", event.name, event.data)
+	}
+
+	// HyPhy progress lines redrawn with carriage returns are streamed as they appear
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	fmt.Fprint(f, "Optimizing 10%\rOptimizing 20%\r")
+	f.Close()
+
+	for _, want := range []string{"Optimizing 10%", "Optimizing 20%"} {
+		event = nextSSEEvent(t, events)
+		var progress sw.JobProgress
+		if err := json.Unmarshal([]byte(event.data), &progress); err != nil {
+			t.Fatalf("Invalid progress payload %q: %v", event.data, err)
+		}
+		if event.name != "progress" || progress.Line != want || progress.JobID != jobID {
+			t.Errorf("Expected progress %q, got %s %s", want, event.name, event.data)
+		}
+	}
+
+	// A terminal transition ends the stream
+	bus.Publish(&sw.JobEvent{Type: sw.JobEventCompleted, JobID: jobID, PreviousStatus: sw.JobStatusRunning, Status: sw.JobStatusComplete})
+	event = nextSSEEvent(t, events)
+	if event.name != "status" || !strings.Contains(event.data, `"status":"complete"`) || !strings.Contains(event.data, `"previous_status":"running"`) {
+		t.Errorf("Expected completion event, got %s %s", event.name, event.data)
+	}
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("Expected stream to close after completion")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Timed out waiting for stream to close")
+	}
+}
+
+// TestGetJobEventsFinishedJob tests that finished jobs get their status and full log, then close
+func TestGetJobEventsFinishedJob(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+	tracker := sw.NewSQLiteJobTracker(db.GetDB())
+
+	if err := tracker.StoreJobMapping("done-job", "sched-2"); err != nil {
+		t.Fatalf("StoreJobMapping failed: %v", err)
+	}
+	if err := tracker.StoreJobMetadata("done-job", "", "", string(sw.MethodSLAC), string(sw.JobStatusFailed)); err != nil {
+		t.Fatalf("StoreJobMetadata failed: %v", err)
+	}
+	logPath := filepath.Join(dir, fmt.Sprintf("%s_done-job.log", sw.MethodSLAC))
+	if err := os.WriteFile(logPath, []byte("Error: no tree\nunterminated"), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	jobsAPI := sw.NewJobsAPI(tracker, nil, nil)
+	jobsAPI.MethodFactory = func(methodType sw.HyPhyMethodType) (sw.ComputeMethodInterface, error) {
+		return sw.NewHyPhyMethod(nil, dir, "hyphy", methodType, ""), nil
+	}
+
+	router := gin.New()
+	router.GET("/api/v1/jobs/:jobId/events", jobsAPI.GetJobEvents)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/done-job/events", nil)
+	router.ServeHTTP(w, req)
+
+	body := w.Body.String()
+	for _, want := range []string{`"status":"failed"`, "Error: no tree", "unterminated"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in stream, got:\n%s", want, body)
+		}
+	}
+
+	// Unknown jobs are rejected before streaming
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/jobs/missing/events", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown job, got %d", w.Code)
+	}
+}
+
+// racingJobTracker publishes a transition right after the handler reads the job's status
+type racingJobTracker struct {
+	sw.JobTracker
+	bus *sw.JobEventBus
+}
+
+func (r *racingJobTracker) GetJobMetadata(jobID string) (string, string, string, string, error) {
+	alignmentID, treeID, methodType, status, err := r.JobTracker.GetJobMetadata(jobID)
+	r.bus.Publish(&sw.JobEvent{Type: sw.JobEventCompleted, JobID: jobID, PreviousStatus: sw.JobStatusValue(status), Status: sw.JobStatusComplete})
+	return alignmentID, treeID, methodType, status, err
+}
+
+// TestGetJobEventsTransitionWhileReadingStatus tests that a transition published between
+// subscribing and reading the current status is still streamed
+func TestGetJobEventsTransitionWhileReadingStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+	tracker := sw.NewSQLiteJobTracker(db.GetDB())
+
+	if err := tracker.StoreJobMapping("racing-job", "sched-3"); err != nil {
+		t.Fatalf("StoreJobMapping failed: %v", err)
+	}
+	if err := tracker.StoreJobMetadata("racing-job", "", "", string(sw.MethodFEL), string(sw.JobStatusRunning)); err != nil {
+		t.Fatalf("StoreJobMetadata failed: %v", err)
+	}
+
+	bus := sw.NewJobEventBus()
+	jobsAPI := sw.NewJobsAPI(&racingJobTracker{JobTracker: tracker, bus: bus}, nil, nil)
+	jobsAPI.Events = bus
+
+	router := gin.New()
+	router.GET("/api/v1/jobs/:jobId/events", jobsAPI.GetJobEvents)
+
+	// A missed transition leaves the stream open until the request times out
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/racing-job/events", nil).WithContext(ctx)
+	router.ServeHTTP(w, req)
+
+	if ctx.Err() != nil {
+		t.Fatal("Expected the stream to end with the completion published while reading the status")
+	}
+	body := w.Body.String()
+	for _, want := range []string{`"status":"running"`, `"status":"complete"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in stream, got:\n%s", want, body)
+		}
+	}
+}
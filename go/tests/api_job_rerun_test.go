@@ -0,0 +1,288 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+// submitScheduler is a SchedulerInterface that records submissions in the job tracker
+type submitScheduler struct {
+	tracker   sw.JobTracker
+	statuses  map[string]sw.JobStatusValue
+	submitted []string
+}
+
+func (s *submitScheduler) Submit(job sw.JobInterface) error {
+	s.submitted = append(s.submitted, job.GetId())
+	s.statuses[job.GetId()] = sw.JobStatusPending
+	return s.tracker.StoreJobMapping(job.GetId(), fmt.Sprintf("sched-%d", len(s.submitted)))
+}
+func (s *submitScheduler) Cancel(job sw.JobInterface) error { return nil }
+func (s *submitScheduler) CheckHealth() (bool, string, error) {
+	return true, "ok", nil
+}
+func (s *submitScheduler) GetStatus(job sw.JobInterface) (sw.JobStatusValue, error) {
+	status, ok := s.statuses[job.GetId()]
+	if !ok {
+		return "", fmt.Errorf("job not found")
+	}
+	return status, nil
+}
+
+// rerunFixture holds the FEL and jobs endpoints wired to a test database
+type rerunFixture struct {
+	*apiTestEnv
+	tracker     *sw.SQLiteJobTracker
+	scheduler   *submitScheduler
+	fel         *sw.FELAPI
+	alignmentID string
+}
+
+func setupRerunFixture(t *testing.T) *rerunFixture {
+	t.Helper()
+	f := &rerunFixture{apiTestEnv: setupAPITestEnv(t)}
+	f.tracker = sw.NewSQLiteJobTracker(f.db.GetDB())
+	f.scheduler = &submitScheduler{tracker: f.tracker, statuses: map[string]sw.JobStatusValue{}}
+	f.alignmentID = f.storeDataset(t, "fasta", ">a\nATGATG\n>b\nATGATA\n")
+
+	felAPI := sw.NewFELAPI(filepath.Join(f.dir, "output"), "hyphy", f.scheduler, f.datasets, f.tracker)
+	felAPI.SessionService = f.session
+	f.fel = felAPI
+	jobsAPI := sw.NewJobsAPI(f.tracker, f.session, f.scheduler)
+	launcher := sw.NewHyPhyBaseAPI(filepath.Join(f.dir, "output"), "hyphy", f.scheduler, f.datasets, f.tracker, f.session)
+	jobsAPI.JobLauncher = &launcher
+
+	f.router.POST("/api/v1/methods/fel-start", felAPI.StartFELJob)
+	f.router.GET("/api/v1/jobs/:jobId", jobsAPI.GetJobById)
+	f.router.POST("/api/v1/jobs/:jobId/rerun", jobsAPI.RerunJob)
+	return f
+}
+
+func (f *rerunFixture) getJob(t *testing.T, jobID string) sw.JobStatus {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/"+jobID, nil)
+	req.Header.Set("user_token", f.token)
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for job %s, got %d: %s", jobID, w.Code, w.Body.String())
+	}
+
+	var job sw.JobStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatalf("Failed to decode job: %v", err)
+	}
+	return job
+}
+
+func (f *rerunFixture) startFEL(t *testing.T, params string) string {
+	t.Helper()
+	body := fmt.Sprintf(`{"alignment":%q,%s}`, f.alignmentID, params)
+	code, response := f.post(t, "/api/v1/methods/fel-start", body, f.token)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200 starting FEL, got %d: %v", code, response)
+	}
+	return response["job_id"].(string)
+}
+
+// TestGetJobByIdIncludesRequest tests that job details include the stored parameters and command
+func TestGetJobByIdIncludesRequest(t *testing.T) {
+	f := setupRerunFixture(t)
+	jobID := f.startFEL(t, `"resample":50,"ci":"Yes"`)
+
+	job := f.getJob(t, jobID)
+	if job.Request["alignment"] != f.alignmentID {
+		t.Errorf("Expected request alignment %s, got %v", f.alignmentID, job.Request["alignment"])
+	}
+	if job.Request["resample"] != float64(50) || job.Request["ci"] != "Yes" {
+		t.Errorf("Expected resample and ci in request, got %v", job.Request)
+	}
+	if _, ok := job.Request["user_token"]; ok {
+		t.Error("Stored request must not include the user token")
+	}
+	if !strings.HasPrefix(job.Command, "hyphy fel --alignment ") || !strings.Contains(job.Command, "--resample 50") ||
+		!strings.Contains(job.Command, "--output ") {
+		t.Errorf("Unexpected command: %s", job.Command)
+	}
+}
+
+// TestGetJobByIdIncludesLaunchCommand tests that the stored command is the one submitted,
+// including the MPI launcher of the job's resource profile
+func TestGetJobByIdIncludesLaunchCommand(t *testing.T) {
+	f := setupRerunFixture(t)
+	f.fel.ResourceProfiles = &sw.ResourceProfileConfig{
+		MPILauncher:   "mpirun -np {tasks}",
+		MPIExecutable: "HYPHYMPI",
+		Default:       sw.ResourceProfile{Nodes: 1, CoresPerNode: 4},
+		Methods:       map[sw.HyPhyMethodType]sw.ResourceProfile{sw.MethodFEL: {MPI: true}},
+	}
+	jobID := f.startFEL(t, `"resample":50`)
+
+	job := f.getJob(t, jobID)
+	if !strings.HasPrefix(job.Command, "mpirun -np 4 HYPHYMPI fel --alignment ") || !strings.Contains(job.Command, "--output ") {
+		t.Errorf("Expected the MPI launch command, got: %s", job.Command)
+	}
+}
+
+// TestRerunJobWithOverrides tests that overrides produce a new job with merged parameters
+func TestRerunJobWithOverrides(t *testing.T) {
+	f := setupRerunFixture(t)
+	jobID := f.startFEL(t, `"resample":50,"ci":"Yes"`)
+
+	code, response := f.post(t, "/api/v1/jobs/"+jobID+"/rerun", `{"resample":100,"ci":null}`, f.token)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %v", code, response)
+	}
+	if response["rerun_of"] != jobID {
+		t.Errorf("Expected rerun_of %s, got %v", jobID, response["rerun_of"])
+	}
+
+	rerunID := response["job_id"].(string)
+	if rerunID == jobID {
+		t.Fatal("Expected overrides to produce a new job")
+	}
+
+	rerun := f.getJob(t, rerunID)
+	if rerun.Request["resample"] != float64(100) {
+		t.Errorf("Expected resample override, got %v", rerun.Request["resample"])
+	}
+	if _, ok := rerun.Request["ci"]; ok {
+		t.Errorf("Expected ci to be removed, got %v", rerun.Request["ci"])
+	}
+	if rerun.Request["alignment"] != f.alignmentID || rerun.Method != string(sw.MethodFEL) {
+		t.Errorf("Expected the original alignment and method, got %+v", rerun)
+	}
+}
+
+// TestRerunJobResubmitsFailedJob tests that a failed job rerun without overrides is submitted
+// again, while starting the identical job returns the failed one
+func TestRerunJobResubmitsFailedJob(t *testing.T) {
+	f := setupRerunFixture(t)
+	jobID := f.startFEL(t, `"resample":50`)
+
+	// An unchanged rerun of an active job returns the existing job
+	code, response := f.post(t, "/api/v1/jobs/"+jobID+"/rerun", "", f.token)
+	if code != http.StatusOK || response["job_id"] != jobID {
+		t.Fatalf("Expected the existing job, got %d: %v", code, response)
+	}
+	if len(f.scheduler.submitted) != 1 {
+		t.Fatalf("Expected 1 submission, got %d", len(f.scheduler.submitted))
+	}
+
+	for _, status := range []sw.JobStatusValue{sw.JobStatusCancelled, sw.JobStatusFailed} {
+		f.scheduler.statuses[jobID] = status
+		code, response = f.post(t, "/api/v1/methods/fel-start", fmt.Sprintf(`{"alignment":%q,"resample":50}`, f.alignmentID), f.token)
+		if code != http.StatusOK || response["job_id"] != jobID || response["status"] != string(status) {
+			t.Fatalf("Expected the existing %s job, got %d: %v", status, code, response)
+		}
+		if len(f.scheduler.submitted) != 1 {
+			t.Fatalf("Expected starting the %s job again not to resubmit it, got %d submissions", status, len(f.scheduler.submitted))
+		}
+	}
+
+	code, response = f.post(t, "/api/v1/jobs/"+jobID+"/rerun", "", f.token)
+	if code != http.StatusOK || response["job_id"] != jobID {
+		t.Fatalf("Expected the job to be resubmitted, got %d: %v", code, response)
+	}
+	if len(f.scheduler.submitted) != 2 {
+		t.Errorf("Expected 2 submissions, got %d", len(f.scheduler.submitted))
+	}
+	if status := f.getJob(t, jobID).Status; status != string(sw.JobStatusPending) {
+		t.Errorf("Expected status pending after resubmission, got %s", status)
+	}
+}
+
+// TestRerunJobErrors tests rerun validation and access checks
+func TestRerunJobErrors(t *testing.T) {
+	f := setupRerunFixture(t)
+	jobID := f.startFEL(t, `"resample":50`)
+
+	other, err := f.session.GenerateUserToken("someone-else")
+	if err != nil {
+		t.Fatalf("GenerateUserToken failed: %v", err)
+	}
+
+	// A job without recorded parameters cannot be rerun
+	if err := f.tracker.StoreJobWithUser("legacy-job", "sched-legacy", f.owner); err != nil {
+		t.Fatalf("StoreJobWithUser failed: %v", err)
+	}
+	if err := f.tracker.StoreJobMetadata("legacy-job", f.alignmentID, "", string(sw.MethodFEL), "complete"); err != nil {
+		t.Fatalf("StoreJobMetadata failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		jobID    string
+		body     string
+		token    string
+		wantCode int
+	}{
+		{"unknown parameter", jobID, `{"not_a_fel_option":1}`, f.token, http.StatusBadRequest},
+		{"wrong parameter type", jobID, `{"resample":"many"}`, f.token, http.StatusBadRequest},
+		{"empty enum parameter", jobID, `{"ci":""}`, f.token, http.StatusBadRequest},
+		{"malformed body", jobID, `{"resample":`, f.token, http.StatusBadRequest},
+		{"inaccessible dataset", jobID, `{"alignment":"someoneelsesdataset"}`, f.token, http.StatusNotFound},
+		{"other user", jobID, "", other, http.StatusForbidden},
+		{"missing job", "missing-job", "", f.token, http.StatusNotFound},
+		{"missing token", jobID, "", "", http.StatusUnauthorized},
+		{"no recorded parameters", "legacy-job", "", f.token, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, response := f.post(t, "/api/v1/jobs/"+tt.jobID+"/rerun", tt.body, tt.token)
+			if code != tt.wantCode {
+				t.Errorf("Expected status %d, got %d: %v", tt.wantCode, code, response)
+			}
+		})
+	}
+
+	if len(f.scheduler.submitted) != 1 {
+		t.Errorf("Expected no further submissions, got %d", len(f.scheduler.submitted))
+	}
+}
+
+// TestCanonicalRequestJSON tests that method-specific parameters are kept and the user token dropped
+func TestCanonicalRequestJSON(t *testing.T) {
+	request := &sw.RelaxRequest{
+		UserToken:         "secret",
+		Alignment:         "abc",
+		TestBranches:      []string{"b1", "b2"},
+		ReferenceBranches: []string{"b3"},
+		Models:            "Minimal",
+	}
+	adapted, err := sw.AdaptRequest(request)
+	if err != nil {
+		t.Fatalf("AdaptRequest failed: %v", err)
+	}
+
+	canonical, err := sw.CanonicalRequestJSON(adapted)
+	if err != nil {
+		t.Fatalf("CanonicalRequestJSON failed: %v", err)
+	}
+
+	expected := `{"alignment":"abc","models":"Minimal","reference_branches":["b3"],"test_branches":["b1","b2"]}`
+	if canonical != expected {
+		t.Errorf("Expected %s, got %s", expected, canonical)
+	}
+
+	decoded, err := sw.DecodeMethodRequest(sw.MethodRELAX, []byte(canonical))
+	if err != nil {
+		t.Fatalf("DecodeMethodRequest failed: %v", err)
+	}
+	relax, ok := decoded.(*sw.RelaxRequest)
+	if !ok || len(relax.TestBranches) != 2 || relax.Models != "Minimal" {
+		t.Errorf("Unexpected decoded request: %+v", decoded)
+	}
+
+	if _, err := sw.DecodeMethodRequest("unknown", []byte(canonical)); err == nil {
+		t.Error("Expected an error for an unknown method")
+	}
+}
@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+const felResultsJSON = `{"MLE":{"headers":[["alpha","Synonymous substitution rate at a site"]],"content":{"0":[[1.5]]}}}`
+
+func (f *jobsFixture) results(jobID string, query string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/"+jobID+"/results"+query, nil)
+	req.Header.Set("user_token", token)
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+// addCompleteJob stores a completed FEL job with the given results
+func (f *jobsFixture) addCompleteJob(t *testing.T, jobID string, results string) string {
+	t.Helper()
+	outputPath, _ := f.addJob(t, jobID, sw.JobStatusComplete)
+	if err := os.WriteFile(outputPath, []byte(results), 0644); err != nil {
+		t.Fatalf("Failed to write results: %v", err)
+	}
+	return outputPath
+}
+
+// TestGetJobResultsParsed tests that parsed results are returned in the method's result format
+func TestGetJobResultsParsed(t *testing.T) {
+	f := setupJobsFixture(t)
+	f.addCompleteJob(t, "fel-job", felResultsJSON)
+
+	w := f.results("fel-job", "", f.token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		JobID  string             `json:"job_id"`
+		Method string             `json:"method"`
+		Status string             `json:"status"`
+		Result sw.FelResultResult `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.JobID != "fel-job" || response.Method != string(sw.MethodFEL) || response.Status != string(sw.JobStatusComplete) {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+	if len(response.Result.MLE.Headers) != 1 || response.Result.MLE.Content["0"][0][0] != 1.5 {
+		t.Errorf("Unexpected result: %+v", response.Result.MLE)
+	}
+}
+
+// TestGetJobResultsRaw tests that raw results are HyPhy's output unchanged, including
+// whitespace and characters the parsed format cleans up
+func TestGetJobResultsRaw(t *testing.T) {
+	f := setupJobsFixture(t)
+	output := "\uFEFF" + felResultsJSON + "\n\x00"
+	f.addCompleteJob(t, "fel-job", output)
+
+	w := f.results("fel-job", "?format=raw", f.token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != output {
+		t.Errorf("Expected raw results %q, got %q", output, w.Body.String())
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json; charset=utf-8" {
+		t.Errorf("Expected JSON content type, got %s", contentType)
+	}
+
+	// The parsed format still reads the same output
+	if w := f.results("fel-job", "", f.token); w.Code != http.StatusOK {
+		t.Errorf("Expected parsed results, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestGetJobResultsErrors tests the status codes for jobs without results
+func TestGetJobResultsErrors(t *testing.T) {
+	f := setupJobsFixture(t)
+	f.addCompleteJob(t, "fel-job", felResultsJSON)
+	f.addJob(t, "running-job", sw.JobStatusRunning)
+	missing := f.addCompleteJob(t, "missing-results", felResultsJSON)
+	if err := os.Remove(missing); err != nil {
+		t.Fatalf("Failed to remove results: %v", err)
+	}
+
+	other, err := f.api.SessionService.GenerateUserToken("someone-else")
+	if err != nil {
+		t.Fatalf("GenerateUserToken failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		jobID    string
+		query    string
+		token    string
+		expected int
+	}{
+		{"invalid format", "fel-job", "?format=csv", f.token, http.StatusBadRequest},
+		{"not complete", "running-job", "", f.token, http.StatusConflict},
+		{"missing results", "missing-results", "", f.token, http.StatusNotFound},
+		{"other user", "fel-job", "", other, http.StatusForbidden},
+		{"unknown job", "no-such-job", "", f.token, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := f.results(tt.jobID, tt.query, tt.token); w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	var conflict map[string]interface{}
+	w := f.results("running-job", "", f.token)
+	if err := json.Unmarshal(w.Body.Bytes(), &conflict); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if conflict["status"] != string(sw.JobStatusRunning) {
+		t.Errorf("Expected the current status in the conflict response, got %v", conflict)
+	}
+}
+
+// TestGetJobSiteResults tests the site table endpoint's formats
+func TestGetJobSiteResults(t *testing.T) {
+	f := setupJobsFixture(t)
+	f.addCompleteJob(t, "fel-job", felResultsJSON)
+
+	w := f.results("fel-job", "/sites", f.token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if expected := "partition,site,alpha\n0,1,1.5\n"; w.Body.String() != expected {
+		t.Errorf("Expected CSV %q, got %q", expected, w.Body.String())
+	}
+	if disposition := w.Header().Get("Content-Disposition"); disposition != `attachment; filename="fel-job_sites.csv"` {
+		t.Errorf("Unexpected Content-Disposition: %s", disposition)
+	}
+
+	w = f.results("fel-job", "/sites?format=json", f.token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Columns []sw.ResultColumn `json:"columns"`
+		Rows    [][]interface{}   `json:"rows"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(response.Columns) != 3 || response.Columns[2].Type != sw.ResultColumnFloat || len(response.Rows) != 1 {
+		t.Errorf("Unexpected table: %+v", response)
+	}
+
+	w = f.results("fel-job", "/sites?format=parquet", f.token)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "PAR1") {
+		t.Errorf("Expected a Parquet file, got %d", w.Code)
+	}
+
+	if w := f.results("fel-job", "/sites?format=xlsx", f.token); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestGetJobBranchResults tests the branch table endpoint
+func TestGetJobBranchResults(t *testing.T) {
+	f := setupJobsFixture(t)
+	f.addCompleteJob(t, "fel-job", `{"branch attributes": {"0": {"Human": {"Nucleotide GTR": 0.2}}}}`)
+	f.addCompleteJob(t, "no-branches", felResultsJSON)
+
+	w := f.results("fel-job", "/branches?format=tsv", f.token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if expected := "partition\tbranch\tNucleotide GTR\n0\tHuman\t0.2\n"; w.Body.String() != expected {
+		t.Errorf("Expected TSV %q, got %q", expected, w.Body.String())
+	}
+
+	if w := f.results("no-branches", "/branches", f.token); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
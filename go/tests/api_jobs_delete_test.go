@@ -0,0 +1,239 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+// cancelScheduler is a SchedulerInterface that records cancellations
+type cancelScheduler struct {
+	statusScheduler
+	cancelErr error
+	cancelled []string
+}
+
+func (s *cancelScheduler) Cancel(job sw.JobInterface) error {
+	if s.cancelErr != nil {
+		return s.cancelErr
+	}
+	s.cancelled = append(s.cancelled, job.GetId())
+	return nil
+}
+
+// jobsFixture holds a JobsAPI wired to a test database
+type jobsFixture struct {
+	*apiTestEnv
+	api       *sw.JobsAPI
+	tracker   *sw.SQLiteJobTracker
+	vizs      *sw.SQLiteVisualizationTracker
+	scheduler *cancelScheduler
+	events    *eventRecorder
+}
+
+func setupJobsFixture(t *testing.T) *jobsFixture {
+	t.Helper()
+	f := &jobsFixture{apiTestEnv: setupAPITestEnv(t)}
+	f.tracker = sw.NewSQLiteJobTracker(f.db.GetDB())
+	f.vizs = sw.NewSQLiteVisualizationTracker(f.db.GetDB())
+	f.scheduler = &cancelScheduler{statusScheduler: statusScheduler{statuses: map[string]sw.JobStatusValue{}}}
+	f.events = &eventRecorder{}
+
+	bus := sw.NewJobEventBus()
+	bus.Subscribe(f.events)
+
+	f.api = sw.NewJobsAPI(f.tracker, f.session, f.scheduler)
+	f.api.Events = bus
+	f.api.VisualizationTracker = f.vizs
+	f.api.MethodFactory = func(methodType sw.HyPhyMethodType) (sw.ComputeMethodInterface, error) {
+		return sw.NewHyPhyMethod(nil, f.dir, "hyphy", methodType, ""), nil
+	}
+
+	f.router.DELETE("/api/v1/jobs/:jobId", f.api.DeleteJob)
+	f.router.GET("/api/v1/jobs/:jobId/results", f.api.GetJobResults)
+	f.router.GET("/api/v1/jobs/:jobId/results/sites", f.api.GetJobSiteResults)
+	f.router.GET("/api/v1/jobs/:jobId/results/branches", f.api.GetJobBranchResults)
+	return f
+}
+
+// addJob stores a job owned by the fixture's user, with an output and log file on disk
+func (f *jobsFixture) addJob(t *testing.T, jobID string, status sw.JobStatusValue) (string, string) {
+	t.Helper()
+	if err := f.tracker.StoreJobWithUser(jobID, "sched-"+jobID, f.owner); err != nil {
+		t.Fatalf("StoreJobWithUser failed: %v", err)
+	}
+	if err := f.tracker.StoreJobMetadata(jobID, "", "", string(sw.MethodFEL), string(status)); err != nil {
+		t.Fatalf("StoreJobMetadata failed: %v", err)
+	}
+
+	outputPath := filepath.Join(f.dir, fmt.Sprintf("%s_%s_results.json", sw.MethodFEL, jobID))
+	logPath := filepath.Join(f.dir, fmt.Sprintf("%s_%s.log", sw.MethodFEL, jobID))
+	for _, path := range []string{outputPath, logPath} {
+		if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	return outputPath, logPath
+}
+
+func (f *jobsFixture) delete(jobID string, query string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/jobs/"+jobID+query, nil)
+	req.Header.Set("user_token", token)
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+func (f *jobsFixture) status(t *testing.T, jobID string) string {
+	t.Helper()
+	_, _, _, status, err := f.tracker.GetJobMetadata(jobID)
+	if err != nil {
+		t.Fatalf("GetJobMetadata failed: %v", err)
+	}
+	return status
+}
+
+// TestDeleteJobCancelsRunningJob tests that a running job is cancelled and kept
+func TestDeleteJobCancelsRunningJob(t *testing.T) {
+	f := setupJobsFixture(t)
+	outputPath, logPath := f.addJob(t, "running-job", sw.JobStatusRunning)
+
+	w := f.delete("running-job", "", f.token)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if len(f.scheduler.cancelled) != 1 || f.scheduler.cancelled[0] != "running-job" {
+		t.Errorf("Expected scheduler to cancel running-job, got %v", f.scheduler.cancelled)
+	}
+	if status := f.status(t, "running-job"); status != string(sw.JobStatusCancelled) {
+		t.Errorf("Expected status cancelled, got %s", status)
+	}
+	for _, path := range []string{outputPath, logPath} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept: %v", path, err)
+		}
+	}
+
+	event, ok := f.events.byJob()["running-job"]
+	if !ok {
+		t.Fatal("Expected a cancelled event")
+	}
+	if event.Type != sw.JobEventCancelled || event.PreviousStatus != sw.JobStatusRunning {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+// TestDeleteJobFinishedJob tests that finished jobs are not cancelled or changed
+func TestDeleteJobFinishedJob(t *testing.T) {
+	f := setupJobsFixture(t)
+	f.addJob(t, "done-job", sw.JobStatusComplete)
+
+	w := f.delete("done-job", "", f.token)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(f.scheduler.cancelled) != 0 {
+		t.Errorf("Expected no cancellation, got %v", f.scheduler.cancelled)
+	}
+	if status := f.status(t, "done-job"); status != string(sw.JobStatusComplete) {
+		t.Errorf("Expected status complete, got %s", status)
+	}
+}
+
+// TestDeleteJobCancelRace tests a job that finishes before it can be cancelled
+func TestDeleteJobCancelRace(t *testing.T) {
+	f := setupJobsFixture(t)
+	f.addJob(t, "racing-job", sw.JobStatusRunning)
+	f.scheduler.cancelErr = fmt.Errorf("job is not active")
+	f.scheduler.statuses["racing-job"] = sw.JobStatusFailed
+
+	w := f.delete("racing-job", "", f.token)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if status := f.status(t, "racing-job"); status != string(sw.JobStatusFailed) {
+		t.Errorf("Expected status failed, got %s", status)
+	}
+}
+
+// TestDeleteJobCancelFailure tests that a failed cancellation of an active job is reported
+func TestDeleteJobCancelFailure(t *testing.T) {
+	f := setupJobsFixture(t)
+	f.addJob(t, "stuck-job", sw.JobStatusRunning)
+	f.scheduler.cancelErr = fmt.Errorf("scheduler unavailable")
+	f.scheduler.statuses["stuck-job"] = sw.JobStatusRunning
+
+	w := f.delete("stuck-job", "", f.token)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status 500, got %d: %s", w.Code, w.Body.String())
+	}
+	if status := f.status(t, "stuck-job"); status != string(sw.JobStatusRunning) {
+		t.Errorf("Expected status running, got %s", status)
+	}
+}
+
+// TestDeleteJobPurge tests that purge removes the job, its files and visualizations
+func TestDeleteJobPurge(t *testing.T) {
+	f := setupJobsFixture(t)
+	outputPath, logPath := f.addJob(t, "purged-job", sw.JobStatusRunning)
+
+	viz := &sw.Visualization{
+		VizId: "viz-purged",
+		JobId: "purged-job",
+		Title: "Test Visualization",
+		Spec:  map[string]interface{}{"mark": "bar"},
+	}
+	if err := f.vizs.Create(viz, f.owner); err != nil {
+		t.Fatalf("Create visualization failed: %v", err)
+	}
+
+	w := f.delete("purged-job", "?purge=true", f.token)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if len(f.scheduler.cancelled) != 1 {
+		t.Errorf("Expected the running job to be cancelled before purging, got %v", f.scheduler.cancelled)
+	}
+	if _, err := f.tracker.GetSchedulerJobID("purged-job"); err == nil {
+		t.Error("Expected job to be removed from tracker")
+	}
+	for _, path := range []string{outputPath, logPath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", path, err)
+		}
+	}
+	if _, err := f.vizs.Get("viz-purged"); err == nil {
+		t.Error("Expected visualization to be removed")
+	}
+}
+
+// TestDeleteJobAccess tests ownership and existence checks
+func TestDeleteJobAccess(t *testing.T) {
+	f := setupJobsFixture(t)
+	f.addJob(t, "owned-job", sw.JobStatusRunning)
+
+	other, err := f.api.SessionService.GenerateUserToken("someone-else")
+	if err != nil {
+		t.Fatalf("GenerateUserToken failed: %v", err)
+	}
+
+	if w := f.delete("owned-job", "?purge=true", other); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := f.delete("missing-job", "", f.token); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(f.scheduler.cancelled) != 0 {
+		t.Errorf("Expected no cancellation, got %v", f.scheduler.cancelled)
+	}
+	if status := f.status(t, "owned-job"); status != string(sw.JobStatusRunning) {
+		t.Errorf("Expected status running, got %s", status)
+	}
+}
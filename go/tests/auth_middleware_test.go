@@ -0,0 +1,407 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+	"github.com/gin-gonic/gin"
+)
+
+// routeMatrixFixture holds every API route behind an Authorizer, with stub handlers
+// that report the subject the middleware resolved
+type routeMatrixFixture struct {
+	*apiTestEnv
+	routes     []sw.Route
+	aliceToken string
+	bobToken   string
+	alice      string
+	bob        string
+	ids        map[sw.ResourceKind]string
+	// Resources without an owner: a public dataset and an ownerless job
+	unowned map[sw.ResourceKind]string
+}
+
+// allRoutes returns every route of the API. MethodsAPI is an interface, so it needs a
+// value before its handler can be taken.
+func allRoutes() []sw.Route {
+	return sw.Routes(sw.ApiHandleFunctions{MethodsAPI: sw.NewMethodsAPIService()})
+}
+
+func setupRouteMatrix(t *testing.T) *routeMatrixFixture {
+	t.Helper()
+	f := &routeMatrixFixture{
+		apiTestEnv: setupAPITestEnv(t),
+		ids:        map[sw.ResourceKind]string{},
+		unowned:    map[sw.ResourceKind]string{},
+	}
+	f.alice, f.aliceToken = f.owner, f.token
+	f.bob, f.bobToken = f.newUser(t)
+
+	// Give alice one resource of every kind
+	jobTracker := sw.NewSQLiteJobTracker(f.db.GetDB())
+	if err := jobTracker.StoreJobWithUser("alice-job", "sched-1", f.alice); err != nil {
+		t.Fatalf("StoreJobWithUser failed: %v", err)
+	}
+	f.ids[sw.ResourceJob] = "alice-job"
+
+	dataset := sw.NewBaseDataset(sw.DatasetMetadata{Name: "alignment", Type: "fasta"}, []byte(">a\nATG\n"))
+	if err := f.datasets.StoreWithUser(dataset, f.alice); err != nil {
+		t.Fatalf("StoreWithUser failed: %v", err)
+	}
+	f.ids[sw.ResourceDataset] = dataset.GetId()
+
+	// Datasets stored without an owner are public
+	public := sw.NewBaseDataset(sw.DatasetMetadata{Name: "public", Type: "fasta"}, []byte(">a\nATGATG\n"))
+	if err := f.datasets.Store(public); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	f.unowned[sw.ResourceDataset] = public.GetId()
+	if _, err := f.datasets.GetOwner(public.GetId()); !errors.Is(err, sw.ErrDatasetNoOwner) {
+		t.Fatalf("Expected ErrDatasetNoOwner for a public dataset, got %v", err)
+	}
+	if err := jobTracker.StoreJobMapping("unowned-job", "sched-2"); err != nil {
+		t.Fatalf("StoreJobMapping failed: %v", err)
+	}
+	f.unowned[sw.ResourceJob] = "unowned-job"
+
+	conversationTracker := sw.NewSQLiteConversationTracker(f.db.GetDB())
+	if err := conversationTracker.CreateConversation(&sw.ChatConversation{Id: "alice-conversation"}, f.alice); err != nil {
+		t.Fatalf("CreateConversation failed: %v", err)
+	}
+	f.ids[sw.ResourceConversation] = "alice-conversation"
+
+	vizTracker := sw.NewSQLiteVisualizationTracker(f.db.GetDB())
+	viz := &sw.Visualization{VizId: "alice-viz", JobId: "alice-job", Title: "Sites", Spec: map[string]interface{}{"mark": "bar"}}
+	if err := vizTracker.Create(viz, f.alice); err != nil {
+		t.Fatalf("Create visualization failed: %v", err)
+	}
+	f.ids[sw.ResourceVisualization] = "alice-viz"
+
+	uploadTracker := sw.NewSQLiteUploadTracker(f.db.GetDB())
+	upload := &sw.UploadRecord{UserID: f.alice, Length: 10, Created: time.Now(), Expires: time.Now().Add(time.Hour)}
+	if err := uploadTracker.Create(upload); err != nil {
+		t.Fatalf("Create upload failed: %v", err)
+	}
+	f.ids[sw.ResourceUpload] = upload.Id
+
+	f.routes = allRoutes()
+	for i := range f.routes {
+		f.routes[i].HandlerFunc = func(c *gin.Context) {
+			subject, _ := sw.SubjectFromContext(c)
+			c.JSON(http.StatusOK, gin.H{"subject": subject})
+		}
+	}
+	authorizer := sw.NewAuthorizer(f.session, jobTracker, f.datasets, conversationTracker, vizTracker, uploadTracker)
+	f.router = sw.RegisterRoutes(gin.New(), f.routes, authorizer)
+	return f
+}
+
+// pathParams maps the path parameters of the routes to the resources they name
+var pathParams = map[string]sw.ResourceKind{
+	"jobId":          sw.ResourceJob,
+	"datasetId":      sw.ResourceDataset,
+	"conversationId": sw.ResourceConversation,
+	"vizId":          sw.ResourceVisualization,
+	"uploadId":       sw.ResourceUpload,
+}
+
+// path fills in a route's parameters with alice's resources, naming the route's own
+// resource by id instead if it is set
+func (f *routeMatrixFixture) path(route sw.Route, id string) string {
+	policy, _ := sw.RoutePolicyFor(route.Name)
+	resourceID := func(kind sw.ResourceKind) string {
+		if id != "" && kind == policy.Resource {
+			return id
+		}
+		return f.ids[kind]
+	}
+
+	path := route.Pattern
+	for param, kind := range pathParams {
+		path = strings.ReplaceAll(path, ":"+param, resourceID(kind))
+	}
+	if policy.Access == sw.AccessOwner && !strings.Contains(route.Pattern, ":"+policy.Param) {
+		path += "?" + policy.Param + "=" + resourceID(policy.Resource)
+	}
+	return path
+}
+
+func (f *routeMatrixFixture) do(route sw.Route, path string, token string) (int, string) {
+	req := httptest.NewRequest(route.Method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+
+	var response struct {
+		Subject string `json:"subject"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response.Subject
+}
+
+// TestRoutePolicies tests that every route declares a policy that names its resource
+func TestRoutePolicies(t *testing.T) {
+	for _, route := range allRoutes() {
+		policy, ok := sw.RoutePolicyFor(route.Name)
+		if !ok {
+			t.Errorf("Route %s has no policy", route.Name)
+			continue
+		}
+		if policy.Access != sw.AccessOwner {
+			continue
+		}
+		if policy.Resource == "" || policy.Param == "" {
+			t.Errorf("Owner route %s does not name its resource: %+v", route.Name, policy)
+		}
+		if strings.Contains(route.Pattern, "/:") && !strings.Contains(route.Pattern, ":"+policy.Param) {
+			t.Errorf("Route %s names its resource by %s, which is not in %s", route.Name, policy.Param, route.Pattern)
+		}
+		// Public datasets must not be open to routes that change them
+		changes := route.Method == http.MethodDelete || route.Method == http.MethodPut || route.Method == http.MethodPatch
+		if policy.Resource == sw.ResourceDataset && changes && !policy.Write {
+			t.Errorf("Route %s changes datasets but is not a write route", route.Name)
+		}
+	}
+
+	if policy, ok := sw.RoutePolicyFor("UnknownRoute"); ok || policy.Access != sw.AccessSession {
+		t.Errorf("Expected routes without a policy to need a session, got %s", policy.Access)
+	}
+}
+
+// TestRouteAuthorizationMatrix tests every route without a token, with another
+// user's token and with the owner's token
+func TestRouteAuthorizationMatrix(t *testing.T) {
+	f := setupRouteMatrix(t)
+	expiredToken := expiredAccessToken(t, f.session, f.alice)
+
+	for _, route := range f.routes {
+		policy, _ := sw.RoutePolicyFor(route.Name)
+		path := f.path(route, "")
+		// HEAD responses have no body to report the subject
+		hasBody := route.Method != http.MethodHead
+
+		t.Run(route.Name+"/unauthenticated", func(t *testing.T) {
+			code, subject := f.do(route, path, "")
+			switch policy.Access {
+			case sw.AccessPublic:
+				if code != http.StatusOK {
+					t.Errorf("Expected public route %s %s to be reached, got %d", route.Method, path, code)
+				}
+			case sw.AccessNewSession:
+				if code != http.StatusOK || (hasBody && (subject == "" || subject == f.alice || subject == f.bob)) {
+					t.Errorf("Expected %s %s to start a new session, got %d with subject %q", route.Method, path, code, subject)
+				}
+			default:
+				if code != http.StatusUnauthorized {
+					t.Errorf("Expected 401 for %s %s, got %d", route.Method, path, code)
+				}
+			}
+		})
+
+		t.Run(route.Name+"/wrong-owner", func(t *testing.T) {
+			code, subject := f.do(route, path, f.bobToken)
+			if policy.Access == sw.AccessOwner {
+				if code != http.StatusForbidden {
+					t.Errorf("Expected 403 for %s %s, got %d", route.Method, path, code)
+				}
+				return
+			}
+			if code != http.StatusOK {
+				t.Errorf("Expected %s %s to be reached, got %d", route.Method, path, code)
+			}
+			if hasBody && policy.Access != sw.AccessPublic && subject != f.bob {
+				t.Errorf("Expected subject %s in the context, got %q", f.bob, subject)
+			}
+		})
+
+		t.Run(route.Name+"/owner", func(t *testing.T) {
+			code, subject := f.do(route, path, f.aliceToken)
+			if code != http.StatusOK {
+				t.Errorf("Expected %s %s to be reached, got %d", route.Method, path, code)
+			}
+			if hasBody && policy.Access != sw.AccessPublic && subject != f.alice {
+				t.Errorf("Expected subject %s in the context, got %q", f.alice, subject)
+			}
+		})
+
+		t.Run(route.Name+"/expired", func(t *testing.T) {
+			// An expired token is refreshed, never replaced by a new session
+			code, _ := f.do(route, path, expiredToken)
+			if policy.Access == sw.AccessPublic {
+				if code != http.StatusOK {
+					t.Errorf("Expected public route %s %s to be reached, got %d", route.Method, path, code)
+				}
+			} else if code != http.StatusUnauthorized {
+				t.Errorf("Expected 401 for %s %s, got %d", route.Method, path, code)
+			}
+		})
+
+		if unowned, ok := f.unowned[policy.Resource]; ok && policy.Access == sw.AccessOwner {
+			// Any session may read public datasets, as in GetByUser, but none may change
+			// them; ownerless jobs are open to none
+			t.Run(route.Name+"/unowned", func(t *testing.T) {
+				want := http.StatusForbidden
+				if policy.Resource == sw.ResourceDataset && !policy.Write {
+					want = http.StatusOK
+				}
+				unownedPath := f.path(route, unowned)
+				if code, _ := f.do(route, unownedPath, f.bobToken); code != want {
+					t.Errorf("Expected %d for %s %s, got %d", want, route.Method, unownedPath, code)
+				}
+				if code, _ := f.do(route, unownedPath, ""); code != http.StatusUnauthorized {
+					t.Errorf("Expected 401 without a token for %s %s, got %d", route.Method, unownedPath, code)
+				}
+			})
+		}
+
+		if policy.Access == sw.AccessOwner {
+			t.Run(route.Name+"/missing", func(t *testing.T) {
+				missing := f.path(route, "missing-resource")
+				if code, _ := f.do(route, missing, f.aliceToken); code != http.StatusNotFound {
+					t.Errorf("Expected 404 for %s %s, got %d", route.Method, missing, code)
+				}
+			})
+		}
+	}
+}
+
+// expiredAccessToken returns an access token for a subject that expired a minute ago
+func expiredAccessToken(t *testing.T, service *sw.SessionService, subject string) string {
+	t.Helper()
+	token, err := service.GenerateToken(map[string]interface{}{
+		"sub":  subject,
+		"type": "user",
+		"exp":  time.Now().Add(-time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	return token
+}
+
+// TestExpiredTokenGetsRefreshHint tests that an anonymous user whose access token expired
+// is told to refresh it instead of being given a new subject
+func TestExpiredTokenGetsRefreshHint(t *testing.T) {
+	f := setupRouteMatrix(t)
+	expiredToken := expiredAccessToken(t, f.session, f.alice)
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/datasets", nil)
+	c.Request.Header.Set("Authorization", "Bearer "+expiredToken)
+	if _, err := f.session.GetOrCreateSubject(c); !errors.Is(err, sw.ErrAccessTokenExpired) {
+		t.Errorf("Expected ErrAccessTokenExpired, got %v", err)
+	}
+	if w.Header().Get("X-Session-Token") != "" {
+		t.Error("Expected no new session for an expired token")
+	}
+
+	// Routes that start anonymous sessions answer with a hint to refresh
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/datasets", nil)
+	req.Header.Set("Authorization", "Bearer "+expiredToken)
+	w = httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "/api/v1/auth/refresh") {
+		t.Errorf("Expected 401 with a refresh hint, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Session-Token") != "" {
+		t.Error("Expected no new session token in the response")
+	}
+}
+
+// TestAuthorizerWithoutSessionService tests that routes are left to the handlers
+// when tokens are disabled
+func TestAuthorizerWithoutSessionService(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	routes := allRoutes()
+	for i := range routes {
+		routes[i].HandlerFunc = func(c *gin.Context) { c.Status(http.StatusOK) }
+	}
+	router := sw.RegisterRoutes(gin.New(), routes, sw.NewAuthorizer(nil, nil, nil, nil, nil, nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/any-job", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the handler to be reached, got %d", w.Code)
+	}
+}
+
+// TestJobHandlersCheckOwnership tests the job handlers that used to authenticate
+// differently from the rest of the API
+func TestJobHandlersCheckOwnership(t *testing.T) {
+	f := setupJobStartFixture(t)
+	felAPI := sw.NewFELAPI(t.TempDir(), "hyphy", f.scheduler, f.datasets, f.tracker)
+	felAPI.SessionService = f.session
+	f.router.POST("/api/v1/methods/fel-result", felAPI.GetFELJob)
+	f.router.GET("/api/v1/jobs/:jobId", sw.NewJobsAPI(f.tracker, f.session, f.scheduler).GetJobById)
+
+	code, response := f.post(t, "/api/v1/methods/fel-start", `{"alignment":"`+f.alignmentID+`","resample":50}`, f.token)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200 starting FEL, got %d: %v", code, response)
+	}
+	jobID := response["job_id"].(string)
+	other, err := f.session.GenerateUserToken("someone-else")
+	if err != nil {
+		t.Fatalf("GenerateUserToken failed: %v", err)
+	}
+
+	// Results looked up by request check ownership, not just the token
+	body := `{"alignment":"` + f.alignmentID + `","resample":50}`
+	if code, response := f.post(t, "/api/v1/methods/fel-result", body, other); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another user's FEL job, got %d: %v", code, response)
+	}
+	if code, response := f.post(t, "/api/v1/methods/fel-result", body, f.token); code != http.StatusConflict {
+		t.Errorf("Expected 409 for the owner's pending FEL job, got %d: %v", code, response)
+	}
+
+	// Job details need a token and ownership
+	for _, tt := range []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{other, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/"+jobID, nil)
+		if tt.token != "" {
+			req.Header.Set("user_token", tt.token)
+		}
+		w := httptest.NewRecorder()
+		f.router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("Expected %d for job details, got %d: %s", tt.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+// TestDeleteJobAcceptsBearerToken tests that deleting a job takes a token the same
+// ways as every other route
+func TestDeleteJobAcceptsBearerToken(t *testing.T) {
+	f := setupJobsFixture(t)
+	f.addJob(t, "owned-job", sw.JobStatusComplete)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/jobs/owned-job", nil)
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/v1/jobs/owned-job", nil)
+	req.Header.Set("Authorization", "Bearer "+f.token)
+	w = httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 with a bearer token, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,553 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TestPasswordHash tests hashing and verifying passwords with argon2id
+func TestPasswordHash(t *testing.T) {
+	hash, err := sw.HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$") {
+		t.Errorf("Expected a PHC-format argon2id hash, got %s", hash)
+	}
+	other, _ := sw.HashPassword("correct horse battery")
+	if other == hash {
+		t.Errorf("Expected hashes of the same password to use different salts")
+	}
+
+	if ok, err := sw.VerifyPassword("correct horse battery", hash); err != nil || !ok {
+		t.Errorf("Expected the password to match, got %v, %v", ok, err)
+	}
+	if ok, _ := sw.VerifyPassword("correct horse battery!", hash); ok {
+		t.Errorf("Expected a different password not to match")
+	}
+	if _, err := sw.VerifyPassword("x", "$2a$10$bcrypt"); err == nil {
+		t.Errorf("Expected an error for an unsupported hash")
+	}
+}
+
+// authFixture serves the auth endpoints backed by a test database
+type authFixture struct {
+	*apiTestEnv
+	accounts *sw.SQLiteAccountTracker
+	api      *sw.AuthAPI
+}
+
+func setupAuthFixture(t *testing.T) *authFixture {
+	t.Helper()
+	f := &authFixture{apiTestEnv: setupAPITestEnv(t)}
+	f.accounts = sw.NewSQLiteAccountTracker(f.db.GetDB())
+	f.session.Accounts = f.accounts
+	f.session.Tokens = sw.NewSQLiteTokenStore(f.db.GetDB())
+	f.api = sw.NewAuthAPI(f.session, f.accounts)
+
+	f.router.POST("/api/v1/auth/token", f.api.IssueToken)
+	f.router.POST("/api/v1/auth/register", f.api.Register)
+	f.router.POST("/api/v1/auth/login", f.api.Login)
+	f.router.POST("/api/v1/auth/logout", f.api.Logout)
+	f.router.POST("/api/v1/auth/refresh", f.api.RefreshToken)
+	f.router.POST("/api/v1/auth/revoke", f.api.RevokeToken)
+	f.router.GET("/api/v1/auth/jwks", f.api.GetJWKS)
+	f.router.POST("/api/v1/auth/claim", f.api.ClaimSession)
+	f.router.GET("/api/v1/auth/oidc/login", f.api.OIDCLogin)
+	f.router.GET("/api/v1/auth/oidc/callback", f.api.OIDCCallback)
+	return f
+}
+
+// do sends a request with an optional bearer token and returns the response
+func (f *authFixture) do(t *testing.T, method string, path string, body string, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+// authToken sends a request that must succeed with a status and decodes the token it returns
+func (f *authFixture) authToken(t *testing.T, method string, path string, body string, token string, status int) sw.AuthToken {
+	t.Helper()
+	w := f.do(t, method, path, body, token)
+	if w.Code != status {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, status, w.Code, w.Body.String())
+	}
+	var result sw.AuthToken
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode token: %v", err)
+	}
+	if result.AccessToken == "" || result.TokenType != "Bearer" || result.Subject == "" {
+		t.Fatalf("Expected a bearer token with a subject, got %+v", result)
+	}
+	return result
+}
+
+// refreshBody returns the body of a refresh request
+func refreshBody(refreshToken string) string {
+	return `{"refresh_token":"` + refreshToken + `"}`
+}
+
+// TestIssueToken tests starting an anonymous session through the API
+func TestIssueToken(t *testing.T) {
+	f := setupAuthFixture(t)
+
+	token := f.authToken(t, http.MethodPost, "/api/v1/auth/token", "", "", http.StatusCreated)
+	if token.AccountId != "" {
+		t.Errorf("Expected no account for an anonymous session, got %s", token.AccountId)
+	}
+	claims, err := f.session.ValidateToken(token.AccessToken)
+	if err != nil || claims["sub"] != token.Subject {
+		t.Fatalf("Expected a valid token for subject %s, got %v, %v", token.Subject, claims, err)
+	}
+	if _, err := f.session.SessionTracker.GetSession(token.Subject); err != nil {
+		t.Errorf("Expected the session to be stored: %v", err)
+	}
+}
+
+// TestPasswordAccountLifecycle tests registering, signing in, refreshing and signing out
+func TestPasswordAccountLifecycle(t *testing.T) {
+	f := setupAuthFixture(t)
+	credentials := `{"email":"Alice@Example.org","password":"correct horse battery"}`
+
+	registered := f.authToken(t, http.MethodPost, "/api/v1/auth/register", credentials, "", http.StatusCreated)
+	if registered.AccountId == "" {
+		t.Fatalf("Expected an account ID, got %+v", registered)
+	}
+	if w := f.do(t, http.MethodPost, "/api/v1/auth/register", `{"email":"alice@example.org","password":"another password"}`, ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a registered email, got %d: %s", w.Code, w.Body.String())
+	}
+	w := f.do(t, http.MethodPost, "/api/v1/auth/register", `{"email":"not an email","password":"short"}`, "")
+	var invalid sw.InvalidDataError
+	json.Unmarshal(w.Body.Bytes(), &invalid)
+	if w.Code != http.StatusBadRequest || len(invalid.Errors) != 2 {
+		t.Errorf("Expected 400 listing email and password, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Wrong passwords and unknown emails are rejected alike
+	for _, body := range []string{
+		`{"email":"alice@example.org","password":"wrong password"}`,
+		`{"email":"bob@example.org","password":"correct horse battery"}`,
+	} {
+		if w := f.do(t, http.MethodPost, "/api/v1/auth/login", body, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for %s, got %d", body, w.Code)
+		}
+	}
+
+	login := f.authToken(t, http.MethodPost, "/api/v1/auth/login", credentials, "", http.StatusOK)
+	if login.AccountId != registered.AccountId || login.Subject != registered.Subject {
+		t.Errorf("Expected to sign in to account %s, got %+v", registered.AccountId, login)
+	}
+
+	if login.RefreshToken == "" {
+		t.Fatalf("Expected a refresh token, got %+v", login)
+	}
+	refreshed := f.authToken(t, http.MethodPost, "/api/v1/auth/refresh", refreshBody(login.RefreshToken), "", http.StatusOK)
+	if refreshed.Subject != login.Subject || refreshed.AccountId != login.AccountId {
+		t.Errorf("Expected the refreshed token to keep its identity, got %+v", refreshed)
+	}
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == login.RefreshToken {
+		t.Errorf("Expected the refresh token to rotate, got %+v", refreshed)
+	}
+
+	// Logging out revokes the login's tokens, including refreshed ones, but not other logins
+	if w := f.do(t, http.MethodPost, "/api/v1/auth/logout", "", login.AccessToken); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 from logout, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, token := range []string{login.AccessToken, refreshed.AccessToken} {
+		if _, err := f.session.ValidateToken(token); err == nil {
+			t.Errorf("Expected the token to be revoked after logout")
+		}
+	}
+	if w := f.do(t, http.MethodPost, "/api/v1/auth/refresh", refreshBody(refreshed.RefreshToken), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 refreshing a logged out refresh token, got %d", w.Code)
+	}
+	if _, err := f.session.ValidateToken(registered.AccessToken); err != nil {
+		t.Errorf("Expected the registration login to stay valid: %v", err)
+	}
+
+	anonymous := f.authToken(t, http.MethodPost, "/api/v1/auth/token", "", "", http.StatusCreated)
+	if w := f.do(t, http.MethodPost, "/api/v1/auth/logout", "", anonymous.AccessToken); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 logging out an anonymous token, got %d", w.Code)
+	}
+}
+
+// TestRefreshAndRevokeTokens tests refreshing an anonymous session, detecting reuse of
+// a refresh token and revoking tokens through the API
+func TestRefreshAndRevokeTokens(t *testing.T) {
+	f := setupAuthFixture(t)
+	issued := f.authToken(t, http.MethodPost, "/api/v1/auth/token", "", "", http.StatusCreated)
+	claims, err := f.session.ValidateToken(issued.AccessToken)
+	if err != nil || claims["jti"] == nil {
+		t.Fatalf("Expected a valid token with an ID, got %v, %v", claims, err)
+	}
+
+	refreshed := f.authToken(t, http.MethodPost, "/api/v1/auth/refresh", refreshBody(issued.RefreshToken), "", http.StatusOK)
+	if refreshed.Subject != issued.Subject || refreshed.AccountId != "" {
+		t.Errorf("Expected the anonymous session to be refreshed, got %+v", refreshed)
+	}
+
+	// Replaying the used refresh token revokes the one issued in its place
+	w := f.do(t, http.MethodPost, "/api/v1/auth/refresh", refreshBody(issued.RefreshToken), "")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "already used") {
+		t.Errorf("Expected 401 for a reused refresh token, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := f.do(t, http.MethodPost, "/api/v1/auth/refresh", refreshBody(refreshed.RefreshToken), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 after the refresh token family was revoked, got %d", w.Code)
+	}
+	if w := f.do(t, http.MethodPost, "/api/v1/auth/refresh", `{}`, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a refresh token, got %d", w.Code)
+	}
+
+	// Revoking an access token stops it validating; unknown tokens are accepted
+	if w := f.do(t, http.MethodPost, "/api/v1/auth/revoke", `{"token":"`+refreshed.AccessToken+`"}`, ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from revoke, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := f.session.ValidateToken(refreshed.AccessToken); err == nil {
+		t.Errorf("Expected the revoked access token to be rejected")
+	}
+	if _, err := f.session.ValidateToken(issued.AccessToken); err != nil {
+		t.Errorf("Expected other access tokens to stay valid: %v", err)
+	}
+	if w := f.do(t, http.MethodPost, "/api/v1/auth/revoke", `{"token":"not-a-token"}`, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 revoking an unknown token, got %d", w.Code)
+	}
+
+	// Revoking a refresh token revokes its family
+	other := f.authToken(t, http.MethodPost, "/api/v1/auth/token", "", "", http.StatusCreated)
+	if w := f.do(t, http.MethodPost, "/api/v1/auth/revoke", `{"token":"`+other.RefreshToken+`"}`, ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from revoke, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := f.do(t, http.MethodPost, "/api/v1/auth/refresh", refreshBody(other.RefreshToken), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a revoked refresh token, got %d", w.Code)
+	}
+
+	// HS256 secrets are never published
+	w = f.do(t, http.MethodGet, "/api/v1/auth/jwks", "", "")
+	var set sw.JsonWebKeySet
+	json.Unmarshal(w.Body.Bytes(), &set)
+	if w.Code != http.StatusOK || set.Keys == nil || len(set.Keys) != 0 {
+		t.Errorf("Expected an empty key set for an HS256 key, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestClaimSession tests moving an anonymous session's resources to an account
+func TestClaimSession(t *testing.T) {
+	f := setupAuthFixture(t)
+	account := f.authToken(t, http.MethodPost, "/api/v1/auth/register", `{"email":"alice@example.org","password":"correct horse battery"}`, "", http.StatusCreated)
+	anonymous := f.authToken(t, http.MethodPost, "/api/v1/auth/token", "", "", http.StatusCreated)
+
+	datasets := sw.NewSQLiteDatasetTracker(f.db.GetDB(), t.TempDir())
+	dataset := sw.NewBaseDataset(sw.DatasetMetadata{Name: "alignment", Type: "fasta"}, []byte(">a\nATG\n"))
+	if err := datasets.StoreWithUser(dataset, anonymous.Subject); err != nil {
+		t.Fatalf("StoreWithUser failed: %v", err)
+	}
+	anonymousID := dataset.GetId()
+	// Content both subjects uploaded is merged into the account's dataset
+	shared := []byte(">b\nATGATG\n")
+	if err := datasets.StoreWithUser(sw.NewBaseDataset(sw.DatasetMetadata{Name: "mine", Type: "fasta"}, shared), account.Subject); err != nil {
+		t.Fatalf("StoreWithUser failed: %v", err)
+	}
+	if err := datasets.StoreWithUser(sw.NewBaseDataset(sw.DatasetMetadata{Name: "theirs", Type: "fasta"}, shared), anonymous.Subject); err != nil {
+		t.Fatalf("StoreWithUser failed: %v", err)
+	}
+	conversations := sw.NewSQLiteConversationTracker(f.db.GetDB())
+	if err := conversations.CreateConversation(&sw.ChatConversation{Id: "conv-1"}, anonymous.Subject); err != nil {
+		t.Fatalf("CreateConversation failed: %v", err)
+	}
+	jobs := sw.NewSQLiteJobTracker(f.db.GetDB())
+	if err := jobs.StoreJobWithUser("job-1", "sched-1", anonymous.Subject); err != nil {
+		t.Fatalf("StoreJobWithUser failed: %v", err)
+	}
+	if err := jobs.StoreJobMetadata("job-1", anonymousID, "", "fel", "complete"); err != nil {
+		t.Fatalf("StoreJobMetadata failed: %v", err)
+	}
+	if err := jobs.StoreJobRequest("job-1", `{"alignment":"`+anonymousID+`","branches":"All"}`, "hyphy fel"); err != nil {
+		t.Fatalf("StoreJobRequest failed: %v", err)
+	}
+
+	claim := `{"session_token":"` + anonymous.AccessToken + `"}`
+	if w := f.do(t, http.MethodPost, "/api/v1/auth/claim", claim, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", w.Code)
+	}
+	if w := f.do(t, http.MethodPost, "/api/v1/auth/claim", claim, anonymous.AccessToken); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 claiming with an anonymous token, got %d", w.Code)
+	}
+	for _, body := range []string{
+		`{"session_token":"not-a-token"}`,
+		`{"session_token":"` + account.AccessToken + `"}`,
+	} {
+		if w := f.do(t, http.MethodPost, "/api/v1/auth/claim", body, account.AccessToken); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	w := f.do(t, http.MethodPost, "/api/v1/auth/claim", claim, account.AccessToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from claim, got %d: %s", w.Code, w.Body.String())
+	}
+	var result sw.ClaimResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result != (sw.ClaimResult{Datasets: 2, Jobs: 1, Conversations: 1}) {
+		t.Errorf("Expected two datasets, a job and a conversation to move, got %+v", result)
+	}
+
+	// Claimed datasets get the IDs the account would have given them
+	claimedID := sw.UserDatasetID(dataset.GetContentHash(), account.Subject)
+	if owner, _ := datasets.GetOwner(claimedID); owner != account.Subject {
+		t.Errorf("Expected the dataset to belong to the account, got %s", owner)
+	}
+	if claimed, err := datasets.Get(claimedID); err != nil || claimed.GetId() != claimedID {
+		t.Errorf("Expected the dataset to be stored under %s, got %v", claimedID, err)
+	}
+	if _, err := datasets.Get(anonymousID); err == nil {
+		t.Errorf("Expected the anonymous dataset ID to be gone")
+	}
+	if alignmentID, _, _, _, _ := jobs.GetJobMetadata("job-1"); alignmentID != claimedID {
+		t.Errorf("Expected the job to use the claimed dataset, got %s", alignmentID)
+	}
+	if request, _, _ := jobs.GetJobRequest("job-1"); request != `{"alignment":"`+claimedID+`","branches":"All"}` {
+		t.Errorf("Expected the job request to name the claimed dataset, got %s", request)
+	}
+	owned, _ := datasets.ListByUser(account.Subject)
+	if len(owned) != 2 {
+		t.Errorf("Expected the account to have one dataset per content, got %d", len(owned))
+	}
+
+	// Uploading claimed content again finds the claimed dataset
+	again := sw.NewBaseDataset(sw.DatasetMetadata{Name: "alignment", Type: "fasta"}, []byte(">a\nATG\n"))
+	if err := datasets.StoreWithUser(again, account.Subject); err != nil || again.GetId() != claimedID {
+		t.Errorf("Expected the account's upload to be the claimed dataset, got %s, %v", again.GetId(), err)
+	}
+	if owned, _ := datasets.ListByUser(account.Subject); len(owned) != 2 {
+		t.Errorf("Expected no duplicate dataset, got %d", len(owned))
+	}
+	// The anonymous subject no longer has the content, so its upload is a new dataset
+	again = sw.NewBaseDataset(sw.DatasetMetadata{Name: "alignment", Type: "fasta"}, []byte(">a\nATG\n"))
+	if err := datasets.StoreWithUser(again, anonymous.Subject); err != nil {
+		t.Fatalf("StoreWithUser failed: %v", err)
+	}
+	if owner, _ := datasets.GetOwner(again.GetId()); owner != anonymous.Subject {
+		t.Errorf("Expected the anonymous upload to belong to the anonymous subject, got %s", owner)
+	}
+	if owner, _ := conversations.GetConversationOwner("conv-1"); owner != account.Subject {
+		t.Errorf("Expected the conversation to belong to the account, got %s", owner)
+	}
+
+	// Account sessions outlive the cleanup of idle anonymous sessions. The fixture's own
+	// user is left active so only the two subjects here are idle.
+	if _, err := f.db.GetDB().Exec(`UPDATE sessions SET last_seen = 0 WHERE subject != ?`, f.owner); err != nil {
+		t.Fatalf("Failed to age sessions: %v", err)
+	}
+	if count, err := f.session.SessionTracker.CleanupExpiredSessions(time.Hour); err != nil || count != 1 {
+		t.Errorf("Expected only the anonymous session to be cleaned up, got %d, %v", count, err)
+	}
+	if _, err := datasets.Get(claimedID); err != nil {
+		t.Errorf("Expected the claimed dataset to survive cleanup: %v", err)
+	}
+	if _, err := f.session.ValidateToken(account.AccessToken); err != nil {
+		t.Errorf("Expected the account token to stay valid: %v", err)
+	}
+}
+
+// mockIdP is an OpenID Connect provider serving discovery, signing keys and a token
+// endpoint that answers code "good-code" with an ID token for subject
+type mockIdP struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	clientID  string
+	subject   string
+	nonce     string // Nonce put in the next ID token
+	challenge string // PKCE code challenge the next code was issued for
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	idp := &mockIdP{key: key, clientID: "datamonkey", subject: "alice-at-idp"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test-key",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "good-code" || r.PostFormValue("client_id") != idp.clientID ||
+			sw.PKCEChallenge(r.PostFormValue("code_verifier")) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":   idp.server.URL,
+			"aud":   idp.clientID,
+			"sub":   idp.subject,
+			"nonce": idp.nonce,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(5 * time.Minute).Unix(),
+		})
+		token.Header["kid"] = "test-key"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// TestOIDCLogin tests signing in with OpenID Connect against a mock provider
+func TestOIDCLogin(t *testing.T) {
+	f := setupAuthFixture(t)
+	if w := f.do(t, http.MethodGet, "/api/v1/auth/oidc/login", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 while OIDC is not configured, got %d", w.Code)
+	}
+
+	idp := newMockIdP(t)
+	f.api.OIDC = sw.NewOIDCProvider(sw.OIDCConfig{
+		IssuerURL:   idp.server.URL,
+		ClientID:    idp.clientID,
+		RedirectURL: "http://localhost:9300/api/v1/auth/oidc/callback",
+	})
+
+	// startLogin follows the redirect to the provider and returns the state cookie it sets
+	// and the state and nonce the redirect carries
+	startLogin := func() (*http.Cookie, string, string) {
+		w := f.do(t, http.MethodGet, "/api/v1/auth/oidc/login", "", "")
+		if w.Code != http.StatusFound {
+			t.Fatalf("Expected a redirect to the provider, got %d: %s", w.Code, w.Body.String())
+		}
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil || !strings.HasPrefix(location.String(), idp.server.URL+"/authorize?") {
+			t.Fatalf("Expected a redirect to the authorization endpoint, got %s", w.Header().Get("Location"))
+		}
+		query := location.Query()
+		if query.Get("client_id") != idp.clientID || query.Get("response_type") != "code" {
+			t.Errorf("Unexpected authorization request: %s", location)
+		}
+		if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+			t.Errorf("Expected a PKCE S256 challenge, got %s", location)
+		}
+		idp.challenge = query.Get("code_challenge")
+
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteLaxMode {
+			t.Fatalf("Expected one HttpOnly SameSite=Lax state cookie, got %+v", cookies)
+		}
+		if strings.Contains(cookies[0].Value, query.Get("state")) {
+			t.Errorf("Expected the state cookie to be signed, not the bare state")
+		}
+		return cookies[0], query.Get("state"), query.Get("nonce")
+	}
+	callback := func(cookie *http.Cookie, code string, state string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		f.router.ServeHTTP(w, req)
+		return w
+	}
+
+	cookie, state, nonce := startLogin()
+	idp.nonce = nonce
+	w := callback(cookie, "good-code", state)
+	var first sw.AuthToken
+	json.Unmarshal(w.Body.Bytes(), &first)
+	if w.Code != http.StatusCreated || first.AccountId == "" {
+		t.Fatalf("Expected an account to be created, got %d: %s", w.Code, w.Body.String())
+	}
+	cleared := w.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].MaxAge >= 0 {
+		t.Errorf("Expected the callback to clear the state cookie, got %+v", cleared)
+	}
+
+	cookie, state, nonce = startLogin()
+	idp.nonce = nonce
+	w = callback(cookie, "good-code", state)
+	var second sw.AuthToken
+	json.Unmarshal(w.Body.Bytes(), &second)
+	if w.Code != http.StatusOK || second.AccountId != first.AccountId {
+		t.Errorf("Expected to sign in to account %s again, got %d: %s", first.AccountId, w.Code, w.Body.String())
+	}
+
+	// The ID token must repeat the nonce of the state it answers
+	cookie, state, _ = startLogin()
+	idp.nonce = "replayed"
+	if w := callback(cookie, "good-code", state); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a mismatched nonce, got %d", w.Code)
+	}
+	cookie, state, nonce = startLogin()
+	idp.nonce = nonce
+	if w := callback(cookie, "bad-code", state); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a rejected code, got %d", w.Code)
+	}
+
+	// The callback must come back to the browser that started sign-in, with its state
+	cookie, state, nonce = startLogin()
+	idp.nonce = nonce
+	if w := callback(nil, "good-code", state); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without the state cookie, got %d", w.Code)
+	}
+	otherCookie, _, _ := startLogin()
+	if w := callback(otherCookie, "good-code", state); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a state from another sign-in, got %d", w.Code)
+	}
+	if w := callback(&http.Cookie{Name: cookie.Name, Value: first.AccessToken}, "good-code", state); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a cookie that is not a sign-in state, got %d", w.Code)
+	}
+
+	// The code is only exchanged with the verifier of the challenge it was issued for
+	cookie, state, nonce = startLogin()
+	idp.nonce = nonce
+	startLogin()
+	if w := callback(cookie, "good-code", state); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a code issued for another challenge, got %d", w.Code)
+	}
+
+	// A different identity at the same provider gets its own account
+	cookie, state, nonce = startLogin()
+	idp.nonce, idp.subject = nonce, "bob-at-idp"
+	w = callback(cookie, "good-code", state)
+	var bob sw.AuthToken
+	json.Unmarshal(w.Body.Bytes(), &bob)
+	if w.Code != http.StatusCreated || bob.AccountId == first.AccountId {
+		t.Errorf("Expected a new account for another identity, got %d: %s", w.Code, w.Body.String())
+	}
+}
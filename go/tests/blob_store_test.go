@@ -0,0 +1,380 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+// fakeS3 is a minimal S3-compatible object server standing in for MinIO
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	gets    int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	t.Helper()
+	s3 := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(s3)
+	t.Cleanup(server.Close)
+	return s3, server
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	presigned := r.Method == http.MethodGet && query.Get("X-Amz-Algorithm") == "AWS4-HMAC-SHA256" &&
+		strings.HasPrefix(query.Get("X-Amz-Credential"), "minio/") && query.Get("X-Amz-SignedHeaders") == "host" &&
+		query.Get("X-Amz-Expires") != "" && len(query.Get("X-Amz-Signature")) == 64
+	signed := strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=minio/") && r.Header.Get("x-amz-date") != ""
+	if !presigned && !signed {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := r.URL.Path
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sum := sha256.Sum256(body)
+		if r.Header.Get("x-amz-content-sha256") != hex.EncodeToString(sum[:]) {
+			http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+			return
+		}
+		s.objects[key] = body
+	case http.MethodGet, http.MethodHead:
+		body, ok := s.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		if r.Method == http.MethodGet {
+			s.gets++
+			w.Write(body)
+		}
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *fakeS3) object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, ok := s.objects[key]
+	return body, ok
+}
+
+func newTestS3Store(t *testing.T, endpoint string, prefix string, stagingDir string) *sw.S3BlobStore {
+	t.Helper()
+	store, err := sw.NewS3BlobStore(sw.S3Config{
+		Endpoint:        endpoint,
+		Bucket:          "datamonkey",
+		Prefix:          prefix,
+		AccessKeyID:     "minio",
+		SecretAccessKey: "minio123",
+		StagingDir:      stagingDir,
+	})
+	if err != nil {
+		t.Fatalf("NewS3BlobStore failed: %v", err)
+	}
+	return store
+}
+
+// testBlobStore runs the behaviour shared by every BlobStore
+func testBlobStore(t *testing.T, store sw.BlobStore) {
+	info, err := store.Put("alignment", strings.NewReader(">a\nATG\n"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if info.Size != 7 {
+		t.Errorf("Expected size 7, got %d", info.Size)
+	}
+
+	content, err := sw.ReadBlob(store, "alignment")
+	if err != nil || string(content) != ">a\nATG\n" {
+		t.Errorf("Expected stored content, got %q (%v)", content, err)
+	}
+	if stat, err := store.Stat("alignment"); err != nil || stat.Size != 7 {
+		t.Errorf("Expected Stat size 7, got %+v (%v)", stat, err)
+	}
+
+	// Jobs read the blob at its staged path, after downloading it if there is a URL
+	staged, err := store.Stage("alignment")
+	if err != nil {
+		t.Fatalf("Stage failed: %v", err)
+	}
+	if staged.URL == "" {
+		if local, err := os.ReadFile(staged.Path); err != nil || string(local) != ">a\nATG\n" {
+			t.Errorf("Expected content at the staged path, got %q (%v)", local, err)
+		}
+	} else if body := download(t, staged.URL); body != ">a\nATG\n" {
+		t.Errorf("Expected content at the staged URL, got %q", body)
+	}
+
+	if _, err := store.Get("missing"); !errors.Is(err, sw.ErrBlobNotFound) {
+		t.Errorf("Expected ErrBlobNotFound for a missing blob, got %v", err)
+	}
+	if _, err := store.Stat("missing"); !errors.Is(err, sw.ErrBlobNotFound) {
+		t.Errorf("Expected ErrBlobNotFound from Stat, got %v", err)
+	}
+	for _, key := range []string{"", ".", "../escape", "/etc/passwd"} {
+		if _, err := store.Put(key, strings.NewReader("x")); err == nil {
+			t.Errorf("Expected key %q to be rejected", key)
+		}
+	}
+
+	if err := store.Delete("alignment"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Stat("alignment"); !errors.Is(err, sw.ErrBlobNotFound) {
+		t.Errorf("Expected deleted blob to be gone, got %v", err)
+	}
+	if err := store.Delete("alignment"); err != nil {
+		t.Errorf("Deleting a missing blob should succeed, got %v", err)
+	}
+}
+
+// download fetches a URL without credentials and returns the body
+func download(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to download %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to download %s: %s %s", url, resp.Status, body)
+	}
+	return string(body)
+}
+
+// TestFileBlobStore tests the filesystem blob store
+func TestFileBlobStore(t *testing.T) {
+	dir := t.TempDir()
+	store := sw.NewFileBlobStore(dir)
+	testBlobStore(t, store)
+
+	staged, err := store.Stage("nested/key")
+	if err != nil || staged != (sw.StagedBlob{Path: filepath.Join(dir, "nested", "key")}) {
+		t.Errorf("Expected blobs read in place under the root, got %+v (%v)", staged, err)
+	}
+}
+
+// TestS3BlobStore tests the S3-compatible blob store against a stand-in server
+func TestS3BlobStore(t *testing.T) {
+	s3, server := newFakeS3(t)
+	staging := t.TempDir()
+	store := newTestS3Store(t, server.URL, "datasets/", staging)
+	testBlobStore(t, store)
+
+	if _, err := store.Put("tree file", strings.NewReader("(a,b);")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if body, ok := s3.object("/datamonkey/datasets/tree file"); !ok || string(body) != "(a,b);" {
+		t.Errorf("Expected object under the bucket and prefix, got %q", body)
+	}
+
+	// Jobs download blobs onto their node from a presigned URL, needing no credentials
+	staged, err := store.Stage("tree file")
+	if err != nil {
+		t.Fatalf("Stage failed: %v", err)
+	}
+	if staged.Path != staging+"/tree file" {
+		t.Errorf("Expected blob staged to %s, got %s", staging+"/tree file", staged.Path)
+	}
+	if !strings.HasPrefix(staged.URL, server.URL+"/datamonkey/datasets/tree%20file?") || !strings.Contains(staged.URL, "X-Amz-Expires=604800") {
+		t.Errorf("Expected a presigned URL for the object valid for 7 days, got %s", staged.URL)
+	}
+	if body := download(t, staged.URL); body != "(a,b);" {
+		t.Errorf("Expected the blob from the presigned URL, got %q", body)
+	}
+	if entries, _ := os.ReadDir(staging); len(entries) != 0 {
+		t.Errorf("Expected nothing downloaded to the API host, got %d files", len(entries))
+	}
+	if _, err := store.Stage("missing"); !errors.Is(err, sw.ErrBlobNotFound) {
+		t.Errorf("Expected ErrBlobNotFound staging a missing blob, got %v", err)
+	}
+
+	// The job script downloads the blob before running the program, once per node
+	script := sw.Command{Args: []string{"cat", staged.Path}, Fetch: []sw.StagedBlob{staged}}.Script()
+	gets := s3.gets
+	for i := 0; i < 2; i++ {
+		output, err := exec.Command("sh", "-c", script).CombinedOutput()
+		if err != nil || string(output) != "(a,b);" {
+			t.Fatalf("Expected the script to download and read the blob, got %q (%v)\n%s", output, err, script)
+		}
+	}
+	if s3.gets != gets+1 {
+		t.Errorf("Expected the script to download the blob once, got %d downloads", s3.gets-gets)
+	}
+
+	wrongKey := newTestS3Store(t, server.URL, "datasets/", staging)
+	wrongKey.Config.AccessKeyID = ""
+	if _, err := wrongKey.Get("tree file"); err == nil || errors.Is(err, sw.ErrBlobNotFound) {
+		t.Errorf("Expected unsigned requests to be refused, got %v", err)
+	}
+}
+
+// commandScheduler is a submitScheduler that records the command of each submitted job
+type commandScheduler struct {
+	submitScheduler
+	commands []sw.Command
+}
+
+func (s *commandScheduler) Submit(job sw.JobInterface) error {
+	s.commands = append(s.commands, job.GetMethod().GetCommand())
+	return s.submitScheduler.Submit(job)
+}
+
+// TestStartJobStagesDatasetsFromS3 tests that jobs on datasets kept in S3 download them
+// onto their compute node, rather than the API host staging them
+func TestStartJobStagesDatasetsFromS3(t *testing.T) {
+	s3, server := newFakeS3(t)
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+	owner := createTestSession(t, db)
+	jobTracker := sw.NewSQLiteJobTracker(db.GetDB())
+
+	staging := filepath.Join(dir, "staging")
+	store := newTestS3Store(t, server.URL, "datasets/", staging)
+	datasets := sw.NewSQLiteDatasetTrackerWithBlobStore(db.GetDB(), store, staging)
+
+	dataset := sw.NewBaseDataset(sw.DatasetMetadata{Name: "alignment", Type: "fasta"}, []byte(">a\nATGATG\n>b\nATGATA\n"))
+	if err := datasets.StoreWithUser(dataset, owner); err != nil {
+		t.Fatalf("StoreWithUser failed: %v", err)
+	}
+	if _, err := store.Put(dataset.GetContentHash(), strings.NewReader(string(dataset.Content))); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(staging, dataset.GetContentHash())); !os.IsNotExist(err) {
+		t.Fatal("Expected the dataset to be in S3 only before the job starts")
+	}
+
+	scheduler := &commandScheduler{submitScheduler: submitScheduler{tracker: jobTracker, statuses: map[string]sw.JobStatusValue{}}}
+	api := sw.NewFELAPI(filepath.Join(dir, "output"), "hyphy", scheduler, datasets, jobTracker)
+	adapted, err := sw.AdaptRequest(&sw.FelRequest{Alignment: dataset.GetId()})
+	if err != nil {
+		t.Fatalf("AdaptRequest failed: %v", err)
+	}
+	if _, err := api.HandleStartJob(nil, adapted, sw.MethodFEL); err != nil {
+		t.Fatalf("HandleStartJob failed: %v", err)
+	}
+	if len(scheduler.submitted) != 1 {
+		t.Fatalf("Expected one submission, got %d", len(scheduler.submitted))
+	}
+
+	if _, err := os.Stat(filepath.Join(staging, dataset.GetContentHash())); !os.IsNotExist(err) {
+		t.Error("Expected the API host not to download the dataset")
+	}
+	command := scheduler.commands[0]
+	stagedPath := staging + "/" + dataset.GetContentHash()
+	if len(command.Fetch) != 1 || command.Fetch[0].Path != stagedPath {
+		t.Fatalf("Expected the job to download the alignment to %s, got %+v", stagedPath, command.Fetch)
+	}
+	if body := download(t, command.Fetch[0].URL); body != string(dataset.Content) {
+		t.Errorf("Expected the alignment at the job's download URL, got %q", body)
+	}
+	if !strings.Contains(command.String(), "--alignment "+stagedPath) {
+		t.Errorf("Expected HyPhy to read the downloaded alignment, got %s", command)
+	}
+	if strings.Contains(command.String(), "X-Amz-Signature") {
+		t.Errorf("Expected the recorded command line to leave out the download URL, got %s", command)
+	}
+	if _, ok := s3.object("/datamonkey/datasets/" + dataset.GetContentHash()); !ok {
+		t.Error("Expected the dataset to stay in S3")
+	}
+
+	// Jobs on datasets missing from the store are not submitted
+	if err := store.Delete(dataset.GetContentHash()); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	adapted, _ = sw.AdaptRequest(&sw.FelRequest{Alignment: dataset.GetId(), Resample: 10})
+	if _, err := api.HandleStartJob(nil, adapted, sw.MethodFEL); err == nil {
+		t.Error("Expected a job on a missing dataset to fail")
+	}
+	if len(scheduler.submitted) != 1 {
+		t.Errorf("Expected no further submissions, got %d", len(scheduler.submitted))
+	}
+}
+
+// TestResultPublisher tests that finished jobs' files are published to the result store
+// and validated from there
+func TestResultPublisher(t *testing.T) {
+	s3, server := newFakeS3(t)
+	output := t.TempDir()
+	results := newTestS3Store(t, server.URL, "results/", output)
+
+	outputPath := filepath.Join(output, "fel_job1_results.json")
+	logPath := filepath.Join(output, "fel_job1.log")
+	if err := os.WriteFile(outputPath, []byte("{}"), 0644); err != nil {
+		t.Fatalf("Failed to write output: %v", err)
+	}
+	if err := os.WriteFile(logPath, []byte("done\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	bus := sw.NewJobEventBus()
+	bus.Subscribe(sw.NewResultPublisher(results))
+	validator := sw.NewResultValidator()
+	validator.Results = results
+	bus.Subscribe(validator)
+
+	job := &sw.BaseJob{
+		Id:         "job1",
+		Method:     sw.NewHyPhyMethod(nil, output, "hyphy", sw.MethodFEL, ""),
+		OutputPath: outputPath,
+		LogPath:    logPath,
+	}
+	event := &sw.JobEvent{Type: sw.JobEventCompleted, JobID: "job1", Job: job}
+	bus.Publish(event)
+	if event.Type != sw.JobEventCompleted {
+		t.Fatalf("Expected the job to stay completed, got %s (%s)", event.Type, event.Error)
+	}
+	if body, ok := s3.object("/datamonkey/results/fel_job1_results.json"); !ok || string(body) != "{}" {
+		t.Errorf("Expected published results, got %q", body)
+	}
+	if body, ok := s3.object("/datamonkey/results/fel_job1.log"); !ok || string(body) != "done\n" {
+		t.Errorf("Expected published log, got %q", body)
+	}
+
+	// A completed job without output is rejected
+	missing := &sw.BaseJob{Id: "job2", Method: job.Method, OutputPath: filepath.Join(output, "fel_job2_results.json")}
+	event = &sw.JobEvent{Type: sw.JobEventCompleted, JobID: "job2", Job: missing}
+	bus.Publish(event)
+	if event.Type != sw.JobEventFailed {
+		t.Errorf("Expected a job without results to fail, got %s", event.Type)
+	}
+
+	// A filesystem result store at the output directory leaves files in place
+	local := sw.NewFileBlobStore(output)
+	if err := sw.NewResultPublisher(local).HandleJobEvent(&sw.JobEvent{Type: sw.JobEventCompleted, JobID: "job1", Job: job}); err != nil {
+		t.Errorf("Publishing in place failed: %v", err)
+	}
+	if content, err := sw.ReadBlob(local, "fel_job1_results.json"); err != nil || string(content) != "{}" {
+		t.Errorf("Expected results left in place, got %q (%v)", content, err)
+	}
+}
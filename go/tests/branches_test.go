@@ -0,0 +1,204 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+const labelledTree = "((human{Foreground}:0.1,chimp:0.2)node1{Foreground}:0.05,gorilla{Background}:0.3,orangutan:0.4);"
+
+// TestResolveBranches tests branch names, {label} groups and keywords against a labelled tree
+func TestResolveBranches(t *testing.T) {
+	tree, err := sw.ParseTree([]byte(labelledTree))
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		selection []string
+		want      []string
+		unknown   []string
+	}{
+		{"names", []string{"human", "gorilla"}, []string{"human", "gorilla"}, nil},
+		{"label group", []string{"{Foreground}"}, []string{"node1", "human"}, nil},
+		{"bare label", []string{"Background"}, []string{"gorilla"}, nil},
+		{"keyword", []string{"leaves"}, []string{"human", "chimp", "gorilla", "orangutan"}, nil},
+		{"internal", []string{"Internal"}, []string{"node1"}, nil},
+		{"unlabeled", []string{"Unlabeled branches"}, []string{"chimp", "orangutan"}, nil},
+		{"unknown", []string{"human", "bonobo", "{Test}"}, []string{"human"}, []string{"bonobo", "{Test}"}},
+		{"empty", []string{"human", " ", ""}, []string{"human"}, []string{"", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			branches, unknown := tree.ResolveBranches(tt.selection)
+			var names []string
+			for _, branch := range branches {
+				names = append(names, branch.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("branches = %v, want %v", names, tt.want)
+			}
+			if !reflect.DeepEqual(unknown, tt.unknown) {
+				t.Errorf("unknown = %v, want %v", unknown, tt.unknown)
+			}
+		})
+	}
+}
+
+// TestCheckBranchSelections tests the branch selection fields of each method against a tree
+func TestCheckBranchSelections(t *testing.T) {
+	tree, err := sw.ParseTree([]byte(labelledTree))
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		request  interface{}
+		problems []string
+	}{
+		{"no selection", &sw.BustedRequest{}, nil},
+		{"busted names", &sw.BustedRequest{Branches: []string{"human", "chimp"}}, nil},
+		{"adapted busted", mustAdapt(t, &sw.BustedRequest{Branches: []string{"{Foreground}"}}), nil},
+		{"busted unknown", &sw.BustedRequest{Branches: []string{"bonobo"}}, []string{`branches: "bonobo" not in the tree; choose branch names, {label} groups ({Background}, {Foreground})`}},
+		{
+			"relax test and reference",
+			&sw.RelaxRequest{TestBranches: []string{"{Foreground}"}, ReferenceBranches: []string{"{Reference}"}},
+			[]string{`reference_branches: "{Reference}" not in the tree`},
+		},
+		{"contrast-fel branch sets", &sw.ContrastFelRequest{BranchSets: []string{"Foreground", "Background"}}, nil},
+		{"bgm comma-separated", &sw.BgmRequest{Branches: "human, gorilla,bonobo"}, []string{`branches: "bonobo" not in the tree`}},
+		{"empty selection", &sw.BustedRequest{Branches: []string{""}}, []string{`branches: "" has an empty selection`}},
+		{"bgm empty item", &sw.BgmRequest{Branches: "human,,gorilla"}, []string{`branches: "human", "", "gorilla" has an empty selection`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := sw.CheckBranchSelections(tree, tt.request)
+			if tt.problems == nil {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+			var selectionErr *sw.BranchSelectionError
+			if !errors.As(err, &selectionErr) {
+				t.Fatalf("Expected a BranchSelectionError, got %v", err)
+			}
+			if len(selectionErr.Problems) != len(tt.problems) {
+				t.Fatalf("Expected %d problems, got %+v", len(tt.problems), selectionErr.Problems)
+			}
+			for i, want := range tt.problems {
+				got := selectionErr.Problems[i].Field + ": " + selectionErr.Problems[i].Message
+				if !strings.Contains(got, want) {
+					t.Errorf("Problem %d = %q, should contain %q", i, got, want)
+				}
+			}
+		})
+	}
+
+	// Keywords resolve against unnamed internal branches too
+	unlabeled, _ := sw.ParseTree([]byte("((a,b),c,d);"))
+	if err := sw.CheckBranchSelections(unlabeled, &sw.AbsrelRequest{Branches: []string{"Internal"}}); err != nil {
+		t.Errorf("Expected Internal to select the unnamed internal branch, got %v", err)
+	}
+}
+
+// TestListBranches tests the branch listing returned for a tree dataset
+func TestListBranches(t *testing.T) {
+	tree, err := sw.ParseTree([]byte(labelledTree))
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+
+	list := tree.ListBranches()
+	if len(list.Branches) != 5 {
+		t.Fatalf("Expected 5 named branches, got %+v", list.Branches)
+	}
+	if list.Branches[0].Name != "node1" || list.Branches[0].Leaf || list.Branches[0].Label != "Foreground" {
+		t.Errorf("Unexpected first branch: %+v", list.Branches[0])
+	}
+	if list.Branches[1].Length == nil || *list.Branches[1].Length != 0.1 {
+		t.Errorf("Expected branch length 0.1 for human, got %+v", list.Branches[1])
+	}
+	want := []sw.BranchLabelGroup{
+		{Label: "Background", Selector: "{Background}", BranchCount: 1, Branches: []string{"gorilla"}},
+		{Label: "Foreground", Selector: "{Foreground}", BranchCount: 2, Branches: []string{"human", "node1"}},
+	}
+	if !reflect.DeepEqual(list.Labels, want) {
+		t.Errorf("Labels = %+v, want %+v", list.Labels, want)
+	}
+}
+
+// TestGetDatasetBranches tests the branches endpoint for trees and non-tree datasets
+func TestGetDatasetBranches(t *testing.T) {
+	f := setupJobStartFixture(t)
+	api := sw.NewFileUploadAndQCAPI(f.datasets, f.session)
+	f.router.GET("/api/v1/datasets/:datasetId/branches", api.GetDatasetBranches)
+
+	get := func(datasetID string, token string) (int, []byte) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/datasets/"+datasetID+"/branches", nil)
+		req.Header.Set("user_token", token)
+		w := httptest.NewRecorder()
+		f.router.ServeHTTP(w, req)
+		return w.Code, w.Body.Bytes()
+	}
+
+	treeID := f.storeDataset(t, "newick", labelledTree)
+	code, body := get(treeID, f.token)
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", code, body)
+	}
+	var list sw.DatasetBranches
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatalf("Failed to decode branches: %v", err)
+	}
+	if list.DatasetId != treeID || len(list.Branches) != 5 || len(list.Labels) != 2 || len(list.Keywords) != 4 {
+		t.Errorf("Unexpected branches response: %s", body)
+	}
+
+	if code, body := get(f.alignmentID, f.token); code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an alignment, got %d: %s", code, body)
+	}
+	if code, _ := get("missing", f.token); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing dataset, got %d", code)
+	}
+	if code, _ := get(treeID, ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", code)
+	}
+}
+
+// TestStartJobChecksBranchSelections tests that jobs selecting branches missing from the tree are rejected
+func TestStartJobChecksBranchSelections(t *testing.T) {
+	f := setupJobStartFixture(t)
+	treeID := f.storeDataset(t, "newick", "(a{Foreground}:0.1,b:0.2);")
+
+	code, response := f.post(t, "/api/v1/methods/fel-start",
+		fmt.Sprintf(`{"alignment":%q,"tree":%q,"branches":["{Background}"]}`, f.alignmentID, treeID), f.token)
+	if code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an unknown label, got %d: %v", code, response)
+	}
+	problems, _ := response["errors"].([]interface{})
+	if len(problems) != 1 || !strings.Contains(fmt.Sprint(problems[0]), "{Foreground}") {
+		t.Errorf("Expected the available label groups to be listed, got %v", response)
+	}
+	if len(f.scheduler.submitted) != 0 {
+		t.Errorf("Expected no job to be submitted, got %v", f.scheduler.submitted)
+	}
+
+	code, response = f.post(t, "/api/v1/methods/fel-start",
+		fmt.Sprintf(`{"alignment":%q,"tree":%q,"branches":["{Foreground}"]}`, f.alignmentID, treeID), f.token)
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 for a known label, got %d: %v", code, response)
+	}
+}
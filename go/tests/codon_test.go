@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+// TestTranslateCodon tests translation under the universal and alternative genetic codes
+func TestTranslateCodon(t *testing.T) {
+	tests := []struct {
+		code  sw.GeneticCode
+		codon string
+		want  byte
+	}{
+		{"", "ATG", 'M'},
+		{sw.UNIVERSAL, "tgg", 'W'},
+		{sw.UNIVERSAL, "TGA", '*'},
+		{sw.UNIVERSAL, "UAA", '*'},
+		{sw.VERTEBRATE_MT_DNA, "TGA", 'W'},
+		{sw.VERTEBRATE_MT_DNA, "AGA", '*'},
+		{sw.CILIATE_NUCLEAR, "TAA", 'Q'},
+		{sw.UNIVERSAL, "TNA", 'X'},
+		{sw.UNIVERSAL, "T-A", 'X'},
+		{sw.UNIVERSAL, "---", '-'},
+	}
+
+	for _, tt := range tests {
+		got, err := sw.TranslateCodon(tt.code, tt.codon)
+		if err != nil {
+			t.Fatalf("TranslateCodon(%q, %q) failed: %v", tt.code, tt.codon, err)
+		}
+		if got != tt.want {
+			t.Errorf("TranslateCodon(%q, %q) = %c, want %c", tt.code, tt.codon, got, tt.want)
+		}
+	}
+
+	if _, err := sw.TranslateCodon("Martian", "ATG"); err == nil {
+		t.Error("Expected an error for an unknown genetic code")
+	}
+}
+
+// TestValidateCodons tests the codon checks run before codon-model jobs
+func TestValidateCodons(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		code     sw.GeneticCode
+		problems []string
+	}{
+		{"valid", ">a\nATGAAA---\n>b\nATGAAGTTT\n", "", nil},
+		{"stop codon ignored under mitochondrial code", ">a\nATGTGA\n>b\nATGTGG\n", sw.VERTEBRATE_MT_DNA, nil},
+		{"length not a multiple of 3", ">a\nATGAAAT\n>b\nATGAAGT\n", "", []string{"not a multiple of 3"}},
+		{
+			"stop codons",
+			">a\nATGTAAAAA\n>b\nATGAAATGA\n", sw.UNIVERSAL,
+			[]string{`"a" has a stop codon TAA at codon 2 (site 4)`, `"b" has a stop codon TGA at codon 3 (site 7)`, "remove or mask"},
+		},
+		{"protein", ">a\nMKVLLWQE\n>b\nMKVLIWQE\n", "", []string{"protein data"}},
+		{"unknown genetic code", ">a\nATG\n", "Martian", []string{"unknown genetic code"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alignment, err := sw.ParseAlignment([]byte(tt.content))
+			if err != nil {
+				t.Fatalf("ParseAlignment failed: %v", err)
+			}
+
+			err = alignment.ValidateCodons(sw.MethodFEL, tt.code)
+			if tt.problems == nil {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+
+			var codonErr *sw.CodonValidationError
+			if !errors.As(err, &codonErr) {
+				t.Fatalf("Expected a CodonValidationError, got %v", err)
+			}
+			if len(codonErr.Problems) != len(tt.problems) {
+				t.Fatalf("Expected %d problems, got %+v", len(tt.problems), codonErr.Problems)
+			}
+			for i, want := range tt.problems {
+				if !strings.Contains(codonErr.Problems[i].Message, want) {
+					t.Errorf("Problem %d = %q, should contain %q", i, codonErr.Problems[i].Message, want)
+				}
+			}
+		})
+	}
+}
+
+// TestValidateInputCodonMethods tests that codon methods reject bad alignments and other methods do not
+func TestValidateInputCodonMethods(t *testing.T) {
+	stops := sw.NewBaseDataset(sw.DatasetMetadata{Type: "fasta", Name: "stops"}, []byte(">a\nATGTAA\n>b\nATGAAA\n"))
+
+	tests := []struct {
+		name       string
+		methodType sw.HyPhyMethodType
+		request    interface{}
+		wantErr    bool
+	}{
+		{"FEL", sw.MethodFEL, &sw.FelRequest{Alignment: "stops"}, true},
+		{"adapted MEME", sw.MethodMEME, mustAdapt(t, &sw.MemeRequest{Alignment: "stops"}), true},
+		{"FEL under a code where TAA is not a stop", sw.MethodFEL, &sw.FelRequest{Alignment: "stops", GeneticCode: sw.CILIATE_NUCLEAR}, false},
+		{"GARD", sw.MethodGARD, &sw.GardRequest{Alignment: "stops"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := sw.NewHyPhyMethod(tt.request, "/data", "hyphy", tt.methodType, "/data/uploads")
+			err := method.ValidateInput(stops)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateInput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), "invalid codon alignment") {
+				t.Errorf("ValidateInput() error = %v, should mention the codon alignment", err)
+			}
+		})
+	}
+}
+
+func mustAdapt(t *testing.T, request interface{}) sw.HyPhyRequest {
+	t.Helper()
+	adapted, err := sw.AdaptRequest(request)
+	if err != nil {
+		t.Fatalf("AdaptRequest failed: %v", err)
+	}
+	return adapted
+}
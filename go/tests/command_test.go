@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+// allMethodTypes lists every HyPhy method the API can run
+var allMethodTypes = []sw.HyPhyMethodType{
+	sw.MethodFEL,
+	sw.MethodBUSTED,
+	sw.MethodABSREL,
+	sw.MethodSLAC,
+	sw.MethodMULTIHIT,
+	sw.MethodGARD,
+	sw.MethodMEME,
+	sw.MethodFUBAR,
+	sw.MethodCONTRASTFEL,
+	sw.MethodRELAX,
+	sw.MethodBGM,
+	sw.MethodNRM,
+	sw.MethodFADE,
+	sw.MethodSLATKIN,
+}
+
+// shellArgs runs a command line through /bin/sh and returns the arguments the shell passed on
+func shellArgs(t *testing.T, commandLine string) []string {
+	t.Helper()
+	out, err := exec.Command("/bin/sh", "-c", `f() { printf '%s\0' "$@"; }; f `+commandLine).Output()
+	if err != nil {
+		t.Fatalf("Failed to run %q: %v", commandLine, err)
+	}
+	return strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+}
+
+// TestShellQuote tests quoting words for a POSIX shell
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"", "''"},
+		{"hyphy", "hyphy"},
+		{"/data/uploads/a-b_c.fas", "/data/uploads/a-b_c.fas"},
+		{"Node1,Node2", "Node1,Node2"},
+		{"two words", "'two words'"},
+		{"it's", `'it'\''s'`},
+		{"$(touch x)", "'$(touch x)'"},
+		{"a;b", "'a;b'"},
+		{"line\nbreak", "'line\nbreak'"},
+	}
+	for _, tt := range tests {
+		if got := sw.ShellQuote(tt.word); got != tt.want {
+			t.Errorf("ShellQuote(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+// TestCommandString tests rendering a command with environment variables as a shell command line
+func TestCommandString(t *testing.T) {
+	cmd := sw.Command{
+		Args: []string{"hyphy", "fel", "--branches", "Node 1,Node'2"},
+		Env:  map[string]string{"OMP_NUM_THREADS": "4", "LABEL": "a b"},
+	}
+	want := `LABEL='a b' OMP_NUM_THREADS=4 hyphy fel --branches 'Node 1,Node'\''2'`
+	if got := cmd.String(); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	with := cmd.With("--output", "/data/out.json")
+	if len(cmd.Args) != 4 || len(with.Args) != 6 {
+		t.Errorf("Expected With to leave the original command alone, got %v and %v", cmd.Args, with.Args)
+	}
+	if got := shellArgs(t, sw.NewCommand(with.Args...).String()); !reflect.DeepEqual(got, with.Args) {
+		t.Errorf("Expected the shell to see %q, got %q", with.Args, got)
+	}
+	if !(sw.Command{}).IsEmpty() || with.IsEmpty() {
+		t.Errorf("Expected only the zero command to be empty")
+	}
+}
+
+// setRequestStrings sets every string and string slice field of a request to a value
+func setRequestStrings(request interface{}, value string) {
+	v := reflect.ValueOf(request).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		switch {
+		case field.Kind() == reflect.String:
+			field.SetString(value)
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+			field.Set(reflect.ValueOf([]string{value, "Node1"}).Convert(field.Type()))
+		}
+	}
+}
+
+// FuzzCommandArgs tests that adversarial parameter values stay single arguments for every
+// request type, and that the shell rendering used by the schedulers passes them and
+// environment variable values on unchanged
+func FuzzCommandArgs(f *testing.F) {
+	for _, seed := range []string{
+		"Node1",
+		"",
+		"'",
+		`"`,
+		"two words",
+		"$(touch /tmp/pwned)",
+		"`touch /tmp/pwned`",
+		"; rm -rf /",
+		"a\nb",
+		"${HOME}",
+		"--output=/etc/passwd",
+		"*",
+		"\\",
+		"it's \"quoted\" $x",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, value string) {
+		if strings.ContainsRune(value, 0) {
+			t.Skip("arguments cannot contain NUL")
+		}
+		env := sw.Command{Args: []string{"printenv", "LABEL"}, Env: map[string]string{"LABEL": value}}
+		out, err := exec.Command("/bin/sh", "-c", env.Script()).Output()
+		if err != nil {
+			t.Fatalf("Failed to run %q: %v", env.Script(), err)
+		}
+		if string(out) != value+"\n" {
+			t.Errorf("Expected the shell to set LABEL to %q, got %q", value, out)
+		}
+
+		for _, methodType := range allMethodTypes {
+			request, err := sw.NewMethodRequest(methodType)
+			if err != nil {
+				t.Fatalf("NewMethodRequest(%s) failed: %v", methodType, err)
+			}
+			setRequestStrings(request, value)
+
+			plain := sw.NewHyPhyMethod(request, "/data", "/usr/local/bin/hyphy", methodType, "/data/uploads").GetCommand()
+			benignRequest, _ := sw.NewMethodRequest(methodType)
+			setRequestStrings(benignRequest, "x")
+			benign := sw.NewHyPhyMethod(benignRequest, "/data", "/usr/local/bin/hyphy", methodType, "/data/uploads").GetCommand()
+			if value != "" && len(plain.Args) != len(benign.Args) {
+				t.Errorf("%s: expected %d arguments, got %q", methodType, len(benign.Args), plain.Args)
+			}
+
+			adapted, err := sw.AdaptRequest(request)
+			if err != nil {
+				continue
+			}
+			cmd := sw.NewHyPhyMethod(adapted, "/data", "/usr/local/bin/hyphy", methodType, "/data/uploads").
+				GetCommand().With("--output", "/data/job_results.json")
+			if got := shellArgs(t, cmd.String()); !reflect.DeepEqual(got, cmd.Args) {
+				t.Errorf("%s: expected the shell to see %q, got %q", methodType, cmd.Args, got)
+			}
+		}
+	})
+}
@@ -0,0 +1,240 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+// refCount returns a blob's reference count, or -1 if it has no row
+func refCount(t *testing.T, db *sql.DB, hash string) int {
+	t.Helper()
+	var count int
+	err := db.QueryRow(`SELECT ref_count FROM blobs WHERE content_hash = ?`, hash).Scan(&count)
+	if err == sql.ErrNoRows {
+		return -1
+	}
+	if err != nil {
+		t.Fatalf("Failed to read ref_count: %v", err)
+	}
+	return count
+}
+
+// storeUserDataset stores content as a dataset of the user, the way an upload does
+func storeUserDataset(t *testing.T, tracker *sw.SQLiteDatasetTracker, userID string, content string) sw.DatasetInterface {
+	t.Helper()
+	dataset := sw.NewBaseDataset(sw.DatasetMetadata{Name: "shared", Type: "fasta"}, []byte(content))
+	if err := tracker.StoreWithUser(dataset, userID); err != nil {
+		t.Fatalf("StoreWithUser failed: %v", err)
+	}
+	source := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(source, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write upload: %v", err)
+	}
+	if _, err := tracker.StoreContent(dataset.GetContentHash(), source); err != nil {
+		t.Fatalf("StoreContent failed: %v", err)
+	}
+	return dataset
+}
+
+// TestDatasetContentDeduplicated tests that identical uploads by different users share one blob
+func TestDatasetContentDeduplicated(t *testing.T) {
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+	dataDir := filepath.Join(dir, "datasets")
+	tracker := sw.NewSQLiteDatasetTracker(db.GetDB(), dataDir)
+
+	alice := storeUserDataset(t, tracker, createTestSession(t, db), uploadedAlignment)
+	bob := storeUserDataset(t, tracker, createTestSession(t, db), uploadedAlignment)
+	hash := alice.GetContentHash()
+
+	if alice.GetId() == bob.GetId() || bob.GetContentHash() != hash {
+		t.Fatalf("Expected separate datasets with one content hash, got %s and %s", alice.GetId(), bob.GetId())
+	}
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != hash {
+		t.Errorf("Expected a single blob named by the content hash, got %v", entries)
+	}
+	if count := refCount(t, db.GetDB(), hash); count != 2 {
+		t.Errorf("Expected ref_count 2, got %d", count)
+	}
+
+	// Deleting one dataset keeps the content for the other
+	if err := tracker.Delete(alice.GetId()); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := tracker.GetBlobStore().Stat(hash); err != nil {
+		t.Errorf("Expected the blob to survive while referenced, got %v", err)
+	}
+	if content, err := sw.ReadBlob(tracker.GetBlobStore(), bob.GetContentHash()); err != nil || string(content) != uploadedAlignment {
+		t.Errorf("Expected the remaining dataset to be readable, got %q (%v)", content, err)
+	}
+
+	if err := tracker.Delete(bob.GetId()); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := tracker.GetBlobStore().Stat(hash); !errors.Is(err, sw.ErrBlobNotFound) {
+		t.Errorf("Expected the blob to be deleted with its last dataset, got %v", err)
+	}
+	if count := refCount(t, db.GetDB(), hash); count != -1 {
+		t.Errorf("Expected the blob row to be removed, got ref_count %d", count)
+	}
+}
+
+// TestJobKeepsDatasetContent tests that a job's datasets stay stored after the datasets are deleted
+func TestJobKeepsDatasetContent(t *testing.T) {
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+	tracker := sw.NewSQLiteDatasetTracker(db.GetDB(), filepath.Join(dir, "datasets"))
+	jobTracker := sw.NewSQLiteJobTracker(db.GetDB())
+
+	userID := createTestSession(t, db)
+	dataset := storeUserDataset(t, tracker, userID, uploadedAlignment)
+	hash := dataset.GetContentHash()
+
+	if err := jobTracker.StoreJobWithUser("job-1", "scheduler-1", userID); err != nil {
+		t.Fatalf("StoreJobWithUser failed: %v", err)
+	}
+	if err := jobTracker.StoreJobMetadata("job-1", dataset.GetId(), "", "fel", "pending"); err != nil {
+		t.Fatalf("StoreJobMetadata failed: %v", err)
+	}
+	if count := refCount(t, db.GetDB(), hash); count != 2 {
+		t.Errorf("Expected ref_count 2 with a dataset and a job, got %d", count)
+	}
+
+	if err := tracker.Delete(dataset.GetId()); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := jobTracker.GetSchedulerJobID("job-1"); err != nil {
+		t.Errorf("Expected the job to survive deleting its dataset, got %v", err)
+	}
+	var alignmentID sql.NullString
+	var alignmentHash string
+	if err := db.GetDB().QueryRow(`SELECT alignment_id, alignment_hash FROM jobs WHERE job_id = ?`, "job-1").Scan(&alignmentID, &alignmentHash); err != nil {
+		t.Fatalf("Failed to read job: %v", err)
+	}
+	if alignmentID.Valid || alignmentHash != hash {
+		t.Errorf("Expected the job to keep the content hash without the dataset, got %v %q", alignmentID, alignmentHash)
+	}
+	if _, err := tracker.GetBlobStore().Stat(hash); err != nil {
+		t.Errorf("Expected the job to keep the blob, got %v", err)
+	}
+
+	if err := jobTracker.DeleteJobMapping("job-1"); err != nil {
+		t.Fatalf("DeleteJobMapping failed: %v", err)
+	}
+	collected, err := tracker.CollectBlobs()
+	if err != nil || collected != 1 {
+		t.Fatalf("Expected one blob collected, got %d (%v)", collected, err)
+	}
+	if _, err := tracker.GetBlobStore().Stat(hash); !errors.Is(err, sw.ErrBlobNotFound) {
+		t.Errorf("Expected the blob to be collected, got %v", err)
+	}
+}
+
+// TestUploadExistingDataset tests that uploading content the caller already has returns their dataset
+func TestUploadExistingDataset(t *testing.T) {
+	f := setupDatasetUploadFixture(t)
+	w := f.upload(t, "fasta", uploadedAlignment)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	w = f.upload(t, "fasta", uploadedAlignment)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for a repeated upload, got %d: %s", w.Code, w.Body.String())
+	}
+	var existing struct {
+		ID        string             `json:"id"`
+		Existing  bool               `json:"existing"`
+		Alignment *sw.AlignmentStats `json:"alignment"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &existing)
+	if existing.ID != created.ID || !existing.Existing || existing.Alignment == nil {
+		t.Errorf("Expected the existing dataset %s, got %s", created.ID, w.Body.String())
+	}
+}
+
+// TestCreateUploadExistingDataset tests that a resumable upload is skipped when its hash is already stored
+func TestCreateUploadExistingDataset(t *testing.T) {
+	f := setupUploadFixture(t, sw.UploadConfig{})
+	content := []byte(uploadedAlignment)
+	sum := sha256.Sum256(content)
+	sha := hex.EncodeToString(sum[:])
+
+	id := f.create(t, len(content), sha)
+	if w := f.patch(t, id, 0, bytes.NewReader(content)); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := f.do(t, http.MethodPost, "/api/v1/uploads/"+id+"/finalize", nil, nil); w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 finalizing, got %d: %s", w.Code, w.Body.String())
+	}
+
+	body := `{"meta": {"name": "again", "type": "fasta"}, "length": ` + strconv.Itoa(len(content)) + `, "sha256": "` + sha + `"}`
+	w := f.do(t, http.MethodPost, "/api/v1/uploads", strings.NewReader(body), map[string]string{"Content-Type": "application/json"})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"existing":true`) {
+		t.Errorf("Expected 200 with the existing dataset, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Another user's identical content is not revealed to the intruder
+	w = f.do(t, http.MethodPost, "/api/v1/uploads", strings.NewReader(body), map[string]string{
+		"Content-Type": "application/json",
+		"user_token":   f.intruder,
+	})
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected 201 for another user, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestMigrateLegacyBlobs tests moving contents stored by dataset ID to their content hash
+func TestMigrateLegacyBlobs(t *testing.T) {
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+	dataDir := filepath.Join(dir, "datasets")
+	tracker := sw.NewSQLiteDatasetTracker(db.GetDB(), dataDir)
+
+	alice := sw.NewBaseDataset(sw.DatasetMetadata{Name: "a", Type: "fasta"}, []byte(uploadedAlignment))
+	bob := sw.NewBaseDataset(sw.DatasetMetadata{Name: "b", Type: "fasta"}, []byte(uploadedAlignment))
+	for _, dataset := range []sw.DatasetInterface{alice, bob} {
+		if err := tracker.StoreWithUser(dataset, createTestSession(t, db)); err != nil {
+			t.Fatalf("StoreWithUser failed: %v", err)
+		}
+		if _, err := tracker.GetBlobStore().Put(dataset.GetId(), strings.NewReader(uploadedAlignment)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	moved, err := tracker.MigrateLegacyBlobs()
+	if err != nil || moved != 2 {
+		t.Fatalf("Expected 2 legacy blobs migrated, got %d (%v)", moved, err)
+	}
+	entries, _ := os.ReadDir(dataDir)
+	if len(entries) != 1 || entries[0].Name() != alice.GetContentHash() {
+		t.Errorf("Expected only the content hash blob to remain, got %v", entries)
+	}
+
+	if moved, err := tracker.MigrateLegacyBlobs(); err != nil || moved != 0 {
+		t.Errorf("Expected a second migration to do nothing, got %d (%v)", moved, err)
+	}
+}
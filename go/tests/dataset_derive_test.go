@@ -0,0 +1,252 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+const deriveAlignment = ">human\nATG---AAACCC\n>chimp\nATG---AAGCCC\n>gorilla\nATG---AAACCC\n>outgroup_macaque\nATG---AGACCT\n"
+
+func parseTestAlignment(t *testing.T, content string) *sw.Alignment {
+	t.Helper()
+	alignment, err := sw.ParseAlignment([]byte(content))
+	if err != nil {
+		t.Fatalf("ParseAlignment failed: %v", err)
+	}
+	return alignment
+}
+
+func sequenceNames(alignment *sw.Alignment) string {
+	var names []string
+	for _, sequence := range alignment.Sequences {
+		names = append(names, sequence.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// TestDeriveAlignment tests each derivation operation
+func TestDeriveAlignment(t *testing.T) {
+	alignment := parseTestAlignment(t, deriveAlignment)
+
+	tests := []struct {
+		name       string
+		operations []sw.DatasetOperation
+		names      string
+		data       string // first sequence
+	}{
+		{"keep by name", []sw.DatasetOperation{{Op: "keep", Names: []string{"chimp", "human"}}}, "human,chimp", "ATG---AAACCC"},
+		{"drop by pattern", []sw.DatasetOperation{{Op: "drop", Pattern: "^outgroup_"}}, "human,chimp,gorilla", "ATG---AAACCC"},
+		{"slice sites", []sw.DatasetOperation{{Op: "slice", Start: 7, End: 9}}, "human,chimp,gorilla,outgroup_macaque", "AAA"},
+		{"slice codons", []sw.DatasetOperation{{Op: "slice", Start: 3, Codon: true}}, "human,chimp,gorilla,outgroup_macaque", "AAACCC"},
+		{"strip gaps", []sw.DatasetOperation{{Op: "strip_gaps", Codon: true}}, "human,chimp,gorilla,outgroup_macaque", "ATGAAACCC"},
+		{"dedupe", []sw.DatasetOperation{{Op: "dedupe"}}, "human,chimp,outgroup_macaque", "ATG---AAACCC"},
+		{"translate", []sw.DatasetOperation{{Op: "translate"}}, "human,chimp,gorilla,outgroup_macaque", "M-KP"},
+		{"in order", []sw.DatasetOperation{{Op: "drop", Names: []string{"human"}}, {Op: "strip_gaps"}, {Op: "translate", GeneticCode: sw.VERTEBRATE_MT_DNA}},
+			"chimp,gorilla,outgroup_macaque", "MKP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			derived, err := sw.DeriveAlignment(alignment, tt.operations)
+			if err != nil {
+				t.Fatalf("DeriveAlignment failed: %v", err)
+			}
+			if names := sequenceNames(derived); names != tt.names {
+				t.Errorf("Expected sequences %s, got %s", tt.names, names)
+			}
+			if derived.Sequences[0].Data != tt.data {
+				t.Errorf("Expected %q, got %q", tt.data, derived.Sequences[0].Data)
+			}
+		})
+	}
+
+	// The parent alignment is left as it was
+	if alignment.Sequences[0].Data != "ATG---AAACCC" || len(alignment.Sequences) != 4 {
+		t.Errorf("Expected the parent alignment to be unchanged, got %+v", alignment.Sequences)
+	}
+	if got := string(alignment.FASTA()); got != deriveAlignment {
+		t.Errorf("Expected FASTA output to round-trip, got %q", got)
+	}
+}
+
+// TestDeriveAlignmentErrors tests operations that cannot be applied
+func TestDeriveAlignmentErrors(t *testing.T) {
+	alignment := parseTestAlignment(t, deriveAlignment)
+	protein := parseTestAlignment(t, ">a\nMKLVWQ\n>b\nMKLVWE\n")
+
+	tests := []struct {
+		name       string
+		alignment  *sw.Alignment
+		operations []sw.DatasetOperation
+		field      string
+		message    string
+	}{
+		{"no operations", alignment, nil, "operations", "at least one"},
+		{"unknown operation", alignment, []sw.DatasetOperation{{Op: "shuffle"}}, "operations[0]", "unknown operation"},
+		{"unknown name", alignment, []sw.DatasetOperation{{Op: "keep", Names: []string{"human", "bonobo"}}}, "operations[0]", `"bonobo"`},
+		{"bad pattern", alignment, []sw.DatasetOperation{{Op: "drop", Pattern: "("}}, "operations[0]", "invalid pattern"},
+		{"nothing left", alignment, []sw.DatasetOperation{{Op: "dedupe"}, {Op: "drop", Pattern: "."}}, "operations[1]", "no sequences"},
+		{"slice out of range", alignment, []sw.DatasetOperation{{Op: "slice", Start: 2, End: 5, Codon: true}}, "operations[0]", "outside the alignment's 4 codons"},
+		{"slice out of frame", alignment, []sw.DatasetOperation{{Op: "slice", Start: 2}, {Op: "slice", Codon: true}}, "operations[1]", "not a multiple of 3"},
+		{"translate protein", protein, []sw.DatasetOperation{{Op: "translate"}}, "operations[0]", "only nucleotide"},
+		{"unknown genetic code", alignment, []sw.DatasetOperation{{Op: "translate", GeneticCode: "Martian"}}, "operations[0]", "unknown genetic code"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sw.DeriveAlignment(tt.alignment, tt.operations)
+			var derivationErr *sw.DerivationError
+			if !errors.As(err, &derivationErr) {
+				t.Fatalf("Expected a DerivationError, got %v", err)
+			}
+			problem := derivationErr.InvalidData().Errors[0]
+			if problem.Field != tt.field || !strings.Contains(problem.Message, tt.message) {
+				t.Errorf("Expected %s: %q, got %s: %q", tt.field, tt.message, problem.Field, problem.Message)
+			}
+		})
+	}
+}
+
+// TestPruneTree tests pruning leaves and joining the branches left behind
+func TestPruneTree(t *testing.T) {
+	tree, err := sw.ParseTree([]byte("((human:0.1,chimp:0.2)apes{Foreground}:0.3,('gorilla gorilla':0.4,macaque:0.5):0.6);"))
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+
+	pruned, err := sw.PruneTree(tree, []string{"human", "chimp", "gorilla gorilla"})
+	if err != nil {
+		t.Fatalf("PruneTree failed: %v", err)
+	}
+	if got, want := pruned.Newick(), "((human:0.1,chimp:0.2)apes{Foreground}:0.3,'gorilla gorilla':1);\n"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	// A root left with one child is replaced by it
+	pruned, err = sw.PruneTree(tree, []string{"human", "chimp"})
+	if err != nil {
+		t.Fatalf("PruneTree failed: %v", err)
+	}
+	if got, want := pruned.Newick(), "(human:0.1,chimp:0.2)apes{Foreground};\n"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	if _, err := sw.PruneTree(tree, []string{"human"}); err == nil {
+		t.Error("Expected an error pruning to a single leaf")
+	}
+
+	// Written trees parse back to the same leaves
+	reparsed, err := sw.ParseTree([]byte(tree.Newick()))
+	if err != nil || strings.Join(reparsed.Leaves(), ",") != strings.Join(tree.Leaves(), ",") {
+		t.Errorf("Expected Newick output to round-trip, got %v (%v)", reparsed, err)
+	}
+}
+
+// TestDeriveDatasetEndpoint tests deriving a dataset and its pruned tree through the API
+func TestDeriveDatasetEndpoint(t *testing.T) {
+	f := setupDatasetUploadFixture(t)
+	f.router.POST("/api/v1/datasets/:datasetId/derive", f.api.DeriveDataset)
+
+	uploadID := func(datasetType string, content string) string {
+		w := f.upload(t, datasetType, content)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201 uploading, got %d: %s", w.Code, w.Body.String())
+		}
+		var created struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &created)
+		return created.ID
+	}
+	alignmentID := uploadID("fasta", deriveAlignment)
+	treeID := uploadID("newick", "(((human,chimp),gorilla),outgroup_macaque);")
+
+	derive := func(id string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/datasets/"+id+"/derive", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("user_token", f.token)
+		w := httptest.NewRecorder()
+		f.router.ServeHTTP(w, req)
+		return w
+	}
+	get := func(id string) map[string]interface{} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/datasets/"+id+"?include_content=true", nil)
+		req.Header.Set("user_token", f.token)
+		w := httptest.NewRecorder()
+		f.router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200 getting %s, got %d: %s", id, w.Code, w.Body.String())
+		}
+		var dataset map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &dataset)
+		return dataset
+	}
+
+	body := `{"name": "apes", "operations": [{"op": "drop", "pattern": "^outgroup_"}, {"op": "strip_gaps", "codon": true}], "tree_id": "` + treeID + `"}`
+	w := derive(alignmentID, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var derived struct {
+		ID        string             `json:"id"`
+		TreeID    string             `json:"tree_id"`
+		Alignment *sw.AlignmentStats `json:"alignment"`
+		Lineage   *sw.DatasetLineage `json:"lineage"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &derived)
+	if derived.Alignment == nil || derived.Alignment.SequenceCount != 3 || derived.Alignment.AlignmentLength != 9 {
+		t.Errorf("Expected 3 sequences of 9 sites, got %s", w.Body.String())
+	}
+	if derived.Lineage == nil || derived.Lineage.ParentId != alignmentID || len(derived.Lineage.Operations) != 2 {
+		t.Errorf("Expected the lineage to be recorded, got %s", w.Body.String())
+	}
+
+	dataset := get(derived.ID)
+	if dataset["name"] != "apes" || dataset["type"] != "fasta" || !strings.HasPrefix(dataset["content"].(string), ">human\nATGAAACCC\n") {
+		t.Errorf("Unexpected derived dataset: %v", dataset)
+	}
+	if lineage, ok := dataset["lineage"].(map[string]interface{}); !ok || lineage["parent_id"] != alignmentID {
+		t.Errorf("Expected the stored dataset to keep its lineage, got %v", dataset["lineage"])
+	}
+
+	tree := get(derived.TreeID)
+	if tree["content"] != "((human,chimp),gorilla);\n" {
+		t.Errorf("Expected the tree pruned to the derived sequences, got %v", tree["content"])
+	}
+	if lineage, ok := tree["lineage"].(map[string]interface{}); !ok || lineage["parent_id"] != treeID {
+		t.Errorf("Expected the pruned tree to record its parent, got %v", tree["lineage"])
+	}
+
+	// Deriving the same content again returns the existing dataset
+	w = derive(alignmentID, body)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"id":"`+derived.ID+`"`) {
+		t.Errorf("Expected 200 with the existing dataset, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Trees can be pruned directly
+	w = derive(treeID, `{"operations": [{"op": "keep", "names": ["human", "gorilla"]}]}`)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"leaf_count":2`) {
+		t.Errorf("Expected 201 with a two-leaf tree, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := derive(treeID, `{"operations": [{"op": "translate"}]}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 translating a tree, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Sequences the tree lacks and bad operations are rejected
+	prunedOnly := uploadID("newick", "((human,chimp),gorilla);")
+	if w := derive(alignmentID, `{"operations": [{"op": "dedupe"}], "tree_id": "`+prunedOnly+`"}`); w.Code != http.StatusUnprocessableEntity ||
+		!strings.Contains(w.Body.String(), "outgroup_macaque") {
+		t.Errorf("Expected 422 naming the missing leaf, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := derive(alignmentID, `{"operations": [{"op": "slice", "start": 20}]}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an out-of-range slice, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := derive("missing", `{"operations": [{"op": "dedupe"}]}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing dataset, got %d: %s", w.Code, w.Body.String())
+	}
+}
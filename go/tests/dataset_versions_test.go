@@ -0,0 +1,218 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+const correctedAlignment = ">human\nATGAAACCC\n>chimp\nATGAAGCCC\n"
+
+// TestDatasetVersionsTracked tests that new versions join a series and jobs record the version they ran on
+func TestDatasetVersionsTracked(t *testing.T) {
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+	tracker := sw.NewSQLiteDatasetTracker(db.GetDB(), filepath.Join(dir, "datasets"))
+	jobTracker := sw.NewSQLiteJobTracker(db.GetDB())
+	userID := createTestSession(t, db)
+
+	first := sw.NewBaseDataset(sw.DatasetMetadata{Name: "flu", Type: "fasta", Tags: []string{"flu"}}, []byte(uploadedAlignment))
+	if err := tracker.StoreWithUser(first, userID); err != nil {
+		t.Fatalf("StoreWithUser failed: %v", err)
+	}
+	if first.Version != 1 || first.SeriesId != first.GetId() {
+		t.Fatalf("Expected version 1 starting its own series, got version %d of %q", first.Version, first.SeriesId)
+	}
+
+	second := sw.NewBaseDataset(sw.DatasetMetadata{Name: "flu", Type: "fasta"}, []byte(correctedAlignment))
+	second.SeriesId = first.GetSeriesId()
+	if err := tracker.StoreWithUser(second, userID); err != nil {
+		t.Fatalf("StoreWithUser failed: %v", err)
+	}
+	if second.Version != 2 || second.SeriesId != first.GetId() {
+		t.Fatalf("Expected version 2 of %s, got version %d of %q", first.GetId(), second.Version, second.SeriesId)
+	}
+
+	stored, err := tracker.Get(second.GetId())
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if stored.GetVersion() != 2 || stored.GetSeriesId() != first.GetId() {
+		t.Errorf("Expected the stored dataset to be version 2, got %d of %q", stored.GetVersion(), stored.GetSeriesId())
+	}
+
+	// Jobs keep the version they ran on, whichever is latest later
+	if err := jobTracker.StoreJobWithUser("job-1", "scheduler-1", userID); err != nil {
+		t.Fatalf("StoreJobWithUser failed: %v", err)
+	}
+	if err := jobTracker.StoreJobMetadata("job-1", first.GetId(), "", "fel", "pending"); err != nil {
+		t.Fatalf("StoreJobMetadata failed: %v", err)
+	}
+	alignmentVersion, treeVersion, err := jobTracker.GetJobDatasetVersions("job-1")
+	if err != nil || alignmentVersion != 1 || treeVersion != 0 {
+		t.Errorf("Expected alignment version 1 and no tree, got %d and %d (%v)", alignmentVersion, treeVersion, err)
+	}
+}
+
+// TestListDatasetsWithFilters tests filtering datasets by type, tag, series and version, and paging them
+func TestListDatasetsWithFilters(t *testing.T) {
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+	tracker := sw.NewSQLiteDatasetTracker(db.GetDB(), filepath.Join(dir, "datasets"))
+	userID := createTestSession(t, db)
+
+	store := func(name string, datasetType string, tags []string, seriesID string, content string) sw.DatasetInterface {
+		dataset := sw.NewBaseDataset(sw.DatasetMetadata{Name: name, Type: datasetType, Tags: tags}, []byte(content))
+		dataset.SeriesId = seriesID
+		if err := tracker.StoreWithUser(dataset, userID); err != nil {
+			t.Fatalf("StoreWithUser failed: %v", err)
+		}
+		return dataset
+	}
+	flu := store("flu", "fasta", []string{"flu", "h3n2"}, "", uploadedAlignment)
+	store("flu", "fasta", []string{"flu", "h3n2"}, flu.GetId(), correctedAlignment)
+	store("hiv", "fasta", []string{"hiv"}, "", ">a\nATG\n>b\nATC\n")
+	store("tree", "newick", []string{"flu"}, "", "(a,b);")
+
+	tests := []struct {
+		name    string
+		filters map[string]interface{}
+		count   int
+		total   int
+	}{
+		{"all versions", nil, 4, 4},
+		{"latest", map[string]interface{}{"latest": true}, 3, 3},
+		{"type", map[string]interface{}{"type": "newick"}, 1, 1},
+		{"tag", map[string]interface{}{"tags": []string{"flu"}}, 3, 3},
+		{"every tag", map[string]interface{}{"tags": []string{"flu", "h3n2"}, "latest": true}, 1, 1},
+		{"series", map[string]interface{}{"series_id": flu.GetId()}, 2, 2},
+		{"page", map[string]interface{}{"limit": 2, "offset": 1}, 2, 4},
+		{"offset only", map[string]interface{}{"offset": 3}, 1, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			datasets, total, err := tracker.ListByUserWithFilters(userID, tt.filters)
+			if err != nil {
+				t.Fatalf("ListByUserWithFilters failed: %v", err)
+			}
+			if len(datasets) != tt.count || total != tt.total {
+				t.Errorf("Expected %d of %d datasets, got %d of %d", tt.count, tt.total, len(datasets), total)
+			}
+		})
+	}
+
+	if datasets, _, _ := tracker.ListByUserWithFilters("someone-else", nil); len(datasets) != 0 {
+		t.Errorf("Expected no datasets for another user, got %d", len(datasets))
+	}
+}
+
+// TestDatasetVersionEndpoints tests uploading a new version, updating metadata and listing versions through the API
+func TestDatasetVersionEndpoints(t *testing.T) {
+	f := setupDatasetUploadFixture(t)
+	f.router.GET("/api/v1/datasets", f.api.GetDatasetsList)
+	f.router.PATCH("/api/v1/datasets/:datasetId", f.api.UpdateDataset)
+	f.router.GET("/api/v1/datasets/:datasetId/versions", f.api.GetDatasetVersions)
+
+	do := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("user_token", f.token)
+		w := httptest.NewRecorder()
+		f.router.ServeHTTP(w, req)
+		return w
+	}
+	type dataset struct {
+		ID       string   `json:"id"`
+		Name     string   `json:"name"`
+		Type     string   `json:"type"`
+		Tags     []string `json:"tags"`
+		SeriesID string   `json:"series_id"`
+		Version  int      `json:"version"`
+	}
+	type datasets struct {
+		Datasets []dataset `json:"datasets"`
+		Total    int       `json:"total"`
+	}
+
+	w := f.uploadWithMeta(t, `{"name": "flu", "type": "fasta", "tags": ["flu", " h3n2 ", "flu"]}`, uploadedAlignment)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var first dataset
+	json.Unmarshal(w.Body.Bytes(), &first)
+	if first.Version != 1 || first.SeriesID != first.ID {
+		t.Fatalf("Expected version 1, got %s", w.Body.String())
+	}
+
+	// The corrected upload takes its name, type and tags from the first version
+	w = f.uploadWithMeta(t, `{"version_of": "`+first.ID+`"}`, correctedAlignment)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 for a new version, got %d: %s", w.Code, w.Body.String())
+	}
+	var second dataset
+	json.Unmarshal(w.Body.Bytes(), &second)
+	if second.Version != 2 || second.SeriesID != first.ID {
+		t.Fatalf("Expected version 2 of %s, got %s", first.ID, w.Body.String())
+	}
+	w = do(http.MethodGet, "/api/v1/datasets/"+second.ID, "")
+	json.Unmarshal(w.Body.Bytes(), &second)
+	if second.Name != "flu" || second.Type != "fasta" || len(second.Tags) != 2 || second.Tags[1] != "h3n2" {
+		t.Errorf("Expected metadata inherited from version 1, got %s", w.Body.String())
+	}
+
+	if w := f.uploadWithMeta(t, `{"version_of": "missing"}`, ">a\nATG\n>b\nATC\n"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a new version of a missing dataset, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Only the latest version is listed unless all versions are asked for
+	var list datasets
+	w = do(http.MethodGet, "/api/v1/datasets?tag=flu", "")
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if list.Total != 1 || len(list.Datasets) != 1 || list.Datasets[0].ID != second.ID {
+		t.Errorf("Expected only version 2 listed, got %s", w.Body.String())
+	}
+	w = do(http.MethodGet, "/api/v1/datasets?all_versions=true&limit=1", "")
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if list.Total != 2 || len(list.Datasets) != 1 {
+		t.Errorf("Expected one of two versions, got %s", w.Body.String())
+	}
+	if w := do(http.MethodGet, "/api/v1/datasets?limit=-1", ""); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a negative limit, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = do(http.MethodGet, "/api/v1/datasets/"+first.ID+"/versions", "")
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if w.Code != http.StatusOK || len(list.Datasets) != 2 || list.Datasets[0].Version != 2 {
+		t.Errorf("Expected both versions newest first, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Updating metadata leaves the content and version alone
+	w = do(http.MethodPatch, "/api/v1/datasets/"+first.ID, `{"name": "flu 2019", "tags": ["archived"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 updating, got %d: %s", w.Code, w.Body.String())
+	}
+	var updated dataset
+	json.Unmarshal(w.Body.Bytes(), &updated)
+	if updated.Name != "flu 2019" || len(updated.Tags) != 1 || updated.Tags[0] != "archived" || updated.Version != 1 || updated.Type != "fasta" {
+		t.Errorf("Expected the updated metadata, got %s", w.Body.String())
+	}
+	w = do(http.MethodGet, "/api/v1/datasets?tag=archived&all_versions=true", "")
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if list.Total != 1 || list.Datasets[0].ID != first.ID {
+		t.Errorf("Expected the updated tags to be searchable, got %s", w.Body.String())
+	}
+
+	if w := do(http.MethodPatch, "/api/v1/datasets/"+first.ID, `{"name": " "}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an empty name, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPatch, "/api/v1/datasets/missing", `{"name": "x"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 updating a missing dataset, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -440,7 +440,7 @@ func TestHyPhyMethodEdgeCases(t *testing.T) {
 			sw.MethodFEL,
 			"/data/uploads",
 		)
-		cmd := method.GetCommand()
+		cmd := method.GetCommand().String()
 		// Command is generated but path traversal is in the command
 		// This is a potential security issue that should be addressed
 		if strings.Contains(cmd, "../../") {
@@ -462,7 +462,7 @@ func TestHyPhyMethodEdgeCases(t *testing.T) {
 			sw.MethodFEL,
 			"/data/uploads",
 		)
-		cmd := method.GetCommand()
+		cmd := method.GetCommand().String()
 		// Should handle special characters in branches
 		if !strings.Contains(cmd, "hyphy") {
 			t.Error("Command should be valid")
@@ -480,7 +480,7 @@ func TestHyPhyMethodEdgeCases(t *testing.T) {
 			sw.MethodBUSTED,
 			"/data/uploads",
 		)
-		cmd := method.GetCommand()
+		cmd := method.GetCommand().String()
 		// Negative numbers are included in command (validation should happen elsewhere)
 		if !strings.Contains(cmd, "hyphy") {
 			t.Error("Command should be generated")
@@ -498,7 +498,7 @@ func TestHyPhyMethodEdgeCases(t *testing.T) {
 			sw.MethodBUSTED,
 			"/data/uploads",
 		)
-		cmd := method.GetCommand()
+		cmd := method.GetCommand().String()
 		// Should handle large numbers
 		if !strings.Contains(cmd, "hyphy") {
 			t.Error("Command should be generated")
@@ -97,7 +97,7 @@ func TestHyPhyGetCommandFEL(t *testing.T) {
 				"/data/uploads",
 			)
 
-			cmd := method.GetCommand()
+			cmd := method.GetCommand().String()
 
 			for _, substr := range tt.contains {
 				if !strings.Contains(cmd, substr) {
@@ -179,7 +179,7 @@ func TestHyPhyGetCommandBUSTED(t *testing.T) {
 				"/data/uploads",
 			)
 
-			cmd := method.GetCommand()
+			cmd := method.GetCommand().String()
 
 			for _, substr := range tt.contains {
 				if !strings.Contains(cmd, substr) {
@@ -223,7 +223,7 @@ func TestHyPhyGetCommandMEME(t *testing.T) {
 				"/data/uploads",
 			)
 
-			cmd := method.GetCommand()
+			cmd := method.GetCommand().String()
 
 			for _, substr := range tt.contains {
 				if !strings.Contains(cmd, substr) {
@@ -249,7 +249,7 @@ func TestHyPhyGetCommandSLAC(t *testing.T) {
 		"/data/uploads",
 	)
 
-	cmd := method.GetCommand()
+	cmd := method.GetCommand().String()
 
 	if !strings.Contains(cmd, "slac") {
 		t.Errorf("Command should contain 'slac', got: %s", cmd)
@@ -274,7 +274,7 @@ func TestHyPhyGetCommandABSREL(t *testing.T) {
 		"/data/uploads",
 	)
 
-	cmd := method.GetCommand()
+	cmd := method.GetCommand().String()
 
 	if !strings.Contains(cmd, "absrel") {
 		t.Errorf("Command should contain 'absrel', got: %s", cmd)
@@ -299,7 +299,7 @@ func TestHyPhyGetCommandRELAX(t *testing.T) {
 		"/data/uploads",
 	)
 
-	cmd := method.GetCommand()
+	cmd := method.GetCommand().String()
 
 	if !strings.Contains(cmd, "relax") {
 		t.Errorf("Command should contain 'relax', got: %s", cmd)
@@ -332,7 +332,7 @@ func TestHyPhyGetCommandFUBAR(t *testing.T) {
 				"/data/uploads",
 			)
 
-			cmd := method.GetCommand()
+			cmd := method.GetCommand().String()
 
 			for _, substr := range tt.contains {
 				if !strings.Contains(cmd, substr) {
@@ -357,7 +357,7 @@ func TestHyPhyGetCommandGARD(t *testing.T) {
 		"/data/uploads",
 	)
 
-	cmd := method.GetCommand()
+	cmd := method.GetCommand().String()
 
 	if !strings.Contains(cmd, "gard") {
 		t.Errorf("Command should contain 'gard', got: %s", cmd)
@@ -407,7 +407,7 @@ func TestHyPhyGetCommandPathHandling(t *testing.T) {
 				tt.dataDir,
 			)
 
-			cmd := method.GetCommand()
+			cmd := method.GetCommand().String()
 
 			if !strings.Contains(cmd, tt.wantAlign) {
 				t.Errorf("Command should contain alignment path '%s', got: %s", tt.wantAlign, cmd)
@@ -434,7 +434,7 @@ func TestHyPhyGetCommandEmptyBranches(t *testing.T) {
 		"/data/uploads",
 	)
 
-	cmd := method.GetCommand()
+	cmd := method.GetCommand().String()
 
 	// Empty branches should not add --branches parameter
 	if strings.Contains(cmd, "--branches") {
@@ -483,7 +483,7 @@ func TestHyPhyGetCommandStringParameters(t *testing.T) {
 				"/data/uploads",
 			)
 
-			cmd := method.GetCommand()
+			cmd := method.GetCommand().String()
 
 			if !strings.Contains(cmd, tt.wantCI) {
 				t.Errorf("Command should contain '%s', got: %s", tt.wantCI, cmd)
@@ -524,7 +524,7 @@ func TestHyPhyGetCommandNumericParameters(t *testing.T) {
 				"/data/uploads",
 			)
 
-			cmd := method.GetCommand()
+			cmd := method.GetCommand().String()
 
 			// Command should be generated regardless of numeric values
 			if !strings.Contains(cmd, "busted") {
@@ -549,7 +549,7 @@ func TestHyPhyGetCommandMultipleBranches(t *testing.T) {
 		"/data/uploads",
 	)
 
-	cmd := method.GetCommand()
+	cmd := method.GetCommand().String()
 
 	// Should join branches with commas
 	if !strings.Contains(cmd, "branch1,branch2,branch3,branch4") {
@@ -557,6 +557,26 @@ func TestHyPhyGetCommandMultipleBranches(t *testing.T) {
 	}
 }
 
+// TestHyPhyGetCommandLabelGroups tests that {label} groups reach HyPhy as bare label names
+func TestHyPhyGetCommandLabelGroups(t *testing.T) {
+	requests := map[string]interface{}{
+		"direct":  &sw.FelRequest{Alignment: "aln1", Branches: []string{"{Foreground}", " Node3", "{ Background }"}},
+		"adapted": mustAdapt(t, &sw.FelRequest{Alignment: "aln1", Branches: []string{"{Foreground}", " Node3", "{ Background }"}}),
+	}
+	for name, request := range requests {
+		method := sw.NewHyPhyMethod(request, "/data", "hyphy", sw.MethodFEL, "/data/datasets")
+		args := method.GetCommand().Args
+		for i, arg := range args {
+			if arg == "--branches" && args[i+1] != "Foreground,Node3,Background" {
+				t.Errorf("%s: expected --branches Foreground,Node3,Background, got %q", name, args[i+1])
+			}
+		}
+		if !strings.Contains(strings.Join(args, " "), "--branches") {
+			t.Errorf("%s: expected --branches in %v", name, args)
+		}
+	}
+}
+
 // TestHyPhyGetCommandWithAdapter tests command generation using AdaptRequest (interface path)
 func TestHyPhyGetCommandWithAdapter(t *testing.T) {
 	tests := []struct {
@@ -593,7 +613,7 @@ func TestHyPhyGetCommandWithAdapter(t *testing.T) {
 			}
 
 			method := sw.NewHyPhyMethod(adapted, "/data", "/usr/local/bin/hyphy", tt.methodType, "/data/uploads")
-			cmd := method.GetCommand()
+			cmd := method.GetCommand().String()
 
 			for _, substr := range tt.contains {
 				if !strings.Contains(cmd, substr) {
@@ -622,7 +642,7 @@ func TestHyPhyGetCommandAdapterAllParameters(t *testing.T) {
 	}
 
 	method := sw.NewHyPhyMethod(adapted, "/data", "/usr/local/bin/hyphy", sw.MethodFEL, "/data/uploads")
-	cmd := method.GetCommand()
+	cmd := method.GetCommand().String()
 
 	// Verify all parameters are in the command
 	// Note: When using adapter, genetic code uses --code instead of --genetic_code
@@ -96,7 +96,7 @@ func TestGetCommandBasic(t *testing.T) {
 				"/data/uploads",
 			)
 
-			cmd := method.GetCommand()
+			cmd := method.GetCommand().String()
 
 			// Check that all expected substrings are present
 			for _, substr := range tt.wantSubstr {
@@ -148,7 +148,7 @@ func TestGetCommandAllMethods(t *testing.T) {
 				"/data/uploads",
 			)
 
-			cmd := method.GetCommand()
+			cmd := method.GetCommand().String()
 
 			// Verify basic structure
 			if !strings.Contains(cmd, "/usr/local/bin/hyphy") {
@@ -296,7 +296,7 @@ func TestValidateInput(t *testing.T) {
 		{
 			name:       "Valid FASTA dataset",
 			methodType: sw.MethodFEL,
-			request:    &sw.FelRequest{Alignment: "test.fas"},
+			request:    &sw.FelRequest{Alignment: "testfas"},
 			dataset: sw.NewBaseDataset(
 				sw.DatasetMetadata{Type: "fasta", Name: "test"},
 				[]byte("test content"),
@@ -306,7 +306,7 @@ func TestValidateInput(t *testing.T) {
 		{
 			name:       "Valid NEXUS dataset",
 			methodType: sw.MethodFEL,
-			request:    &sw.FelRequest{Alignment: "test.nex"},
+			request:    &sw.FelRequest{Alignment: "testnex"},
 			dataset: sw.NewBaseDataset(
 				sw.DatasetMetadata{Type: "nexus", Name: "test"},
 				[]byte("test content"),
@@ -316,7 +316,7 @@ func TestValidateInput(t *testing.T) {
 		{
 			name:       "Invalid dataset type",
 			methodType: sw.MethodFEL,
-			request:    &sw.FelRequest{Alignment: "test.txt"},
+			request:    &sw.FelRequest{Alignment: "testtxt"},
 			dataset: sw.NewBaseDataset(
 				sw.DatasetMetadata{Type: "text", Name: "test"},
 				[]byte("test content"),
@@ -327,35 +327,35 @@ func TestValidateInput(t *testing.T) {
 		{
 			name:       "FEL with negative resample",
 			methodType: sw.MethodFEL,
-			request:    &sw.FelRequest{Alignment: "test.fas", Resample: -1},
+			request:    &sw.FelRequest{Alignment: "testfas", Resample: -1},
 			dataset: sw.NewBaseDataset(
 				sw.DatasetMetadata{Type: "fasta", Name: "test"},
 				[]byte("test content"),
 			),
 			wantErr:   true,
-			errSubstr: "resample value must be non-negative",
+			errSubstr: "resample: must be at least 0",
 		},
 		{
 			name:       "BUSTED with negative rates",
 			methodType: sw.MethodBUSTED,
-			request:    &sw.BustedRequest{Alignment: "test.fas", Rates: -1},
+			request:    &sw.BustedRequest{Alignment: "testfas", Rates: -1},
 			dataset: sw.NewBaseDataset(
 				sw.DatasetMetadata{Type: "fasta", Name: "test"},
 				[]byte("test content"),
 			),
 			wantErr:   true,
-			errSubstr: "rates must be non-negative",
+			errSubstr: "rates: must be between 1 and 10",
 		},
 		{
 			name:       "BUSTED with negative syn-rates",
 			methodType: sw.MethodBUSTED,
-			request:    &sw.BustedRequest{Alignment: "test.fas", SynRates: -1},
+			request:    &sw.BustedRequest{Alignment: "testfas", SynRates: -1},
 			dataset: sw.NewBaseDataset(
 				sw.DatasetMetadata{Type: "fasta", Name: "test"},
 				[]byte("test content"),
 			),
 			wantErr:   true,
-			errSubstr: "syn-rates must be non-negative",
+			errSubstr: "syn_rates: must be between 1 and 10",
 		},
 	}
 
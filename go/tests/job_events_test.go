@@ -0,0 +1,182 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+// statusScheduler is a SchedulerInterface that reports fixed statuses per job
+type statusScheduler struct {
+	statuses map[string]sw.JobStatusValue
+}
+
+func (s *statusScheduler) Submit(job sw.JobInterface) error { return nil }
+func (s *statusScheduler) Cancel(job sw.JobInterface) error { return nil }
+func (s *statusScheduler) CheckHealth() (bool, string, error) {
+	return true, "ok", nil
+}
+func (s *statusScheduler) GetStatus(job sw.JobInterface) (sw.JobStatusValue, error) {
+	status, ok := s.statuses[job.GetId()]
+	if !ok {
+		return "", fmt.Errorf("job not found")
+	}
+	return status, nil
+}
+
+// eventRecorder is a JobEventSubscriber that records received events
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []sw.JobEvent
+}
+
+func (r *eventRecorder) HandleJobEvent(event *sw.JobEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, *event)
+	return nil
+}
+
+func (r *eventRecorder) byJob() map[string]sw.JobEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := make(map[string]sw.JobEvent)
+	for _, event := range r.events {
+		events[event.JobID] = event
+	}
+	return events
+}
+
+// TestJobEventBusRejection tests that a rejected completion is delivered as a failure
+func TestJobEventBusRejection(t *testing.T) {
+	bus := sw.NewJobEventBus()
+	recorder := &eventRecorder{}
+
+	bus.Subscribe(sw.JobEventSubscriberFunc(func(event *sw.JobEvent) error {
+		return fmt.Errorf("bad results")
+	}))
+	bus.Subscribe(recorder)
+
+	completed := &sw.JobEvent{Type: sw.JobEventCompleted, JobID: "a", PreviousStatus: sw.JobStatusRunning, Status: sw.JobStatusComplete}
+	bus.Publish(completed)
+	if completed.Type != sw.JobEventFailed || completed.Status != sw.JobStatusFailed || completed.Error != "bad results" {
+		t.Errorf("Expected completion to be turned into failure, got %+v", completed)
+	}
+
+	// Errors on other event types are only logged
+	started := &sw.JobEvent{Type: sw.JobEventStarted, JobID: "b", Status: sw.JobStatusRunning}
+	bus.Publish(started)
+	if started.Type != sw.JobEventStarted {
+		t.Errorf("Expected started event to be unchanged, got %+v", started)
+	}
+
+	events := recorder.byJob()
+	if events["a"].Type != sw.JobEventFailed || events["a"].PreviousStatus != sw.JobStatusRunning {
+		t.Errorf("Expected later subscribers to see the failure, got %+v", events["a"])
+	}
+	if events["a"].Timestamp.IsZero() {
+		t.Error("Expected timestamp to be set")
+	}
+}
+
+// TestJobStatusMonitorEvents tests that the monitor publishes transitions and validates results
+func TestJobStatusMonitorEvents(t *testing.T) {
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+	tracker := sw.NewSQLiteJobTracker(db.GetDB())
+
+	jobs := map[string]struct {
+		previous sw.JobStatusValue
+		current  sw.JobStatusValue
+		output   string
+	}{
+		"started":   {sw.JobStatusPending, sw.JobStatusRunning, ""},
+		"valid":     {sw.JobStatusRunning, sw.JobStatusComplete, "{}"},
+		"broken":    {sw.JobStatusRunning, sw.JobStatusComplete, `{"MLE": {`},
+		"failed":    {sw.JobStatusRunning, sw.JobStatusFailed, ""},
+		"cancelled": {sw.JobStatusPending, sw.JobStatusCancelled, ""},
+		"unchanged": {sw.JobStatusRunning, sw.JobStatusRunning, ""},
+	}
+
+	scheduler := &statusScheduler{statuses: make(map[string]sw.JobStatusValue)}
+	for id, job := range jobs {
+		if err := tracker.StoreJobMapping(id, "sched-"+id); err != nil {
+			t.Fatalf("StoreJobMapping failed: %v", err)
+		}
+		if err := tracker.StoreJobMetadata(id, "", "", string(sw.MethodFEL), string(job.previous)); err != nil {
+			t.Fatalf("StoreJobMetadata failed: %v", err)
+		}
+		scheduler.statuses[id] = job.current
+		if job.output != "" {
+			outputPath := filepath.Join(dir, fmt.Sprintf("%s_%s_results.json", sw.MethodFEL, id))
+			if err := os.WriteFile(outputPath, []byte(job.output), 0644); err != nil {
+				t.Fatalf("Failed to write output: %v", err)
+			}
+		}
+	}
+
+	methodFactory := func(methodType sw.HyPhyMethodType) (sw.ComputeMethodInterface, error) {
+		return sw.NewHyPhyMethod(nil, dir, "hyphy", methodType, ""), nil
+	}
+	monitor := sw.NewJobStatusMonitor(tracker, scheduler, methodFactory, 10*time.Millisecond)
+	monitor.Events.Subscribe(sw.NewResultValidator())
+	recorder := &eventRecorder{}
+	monitor.Events.Subscribe(recorder)
+
+	monitor.Start()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(recorder.byJob()) < 5 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	monitor.Stop()
+
+	expected := map[string]struct {
+		eventType sw.JobEventType
+		status    sw.JobStatusValue
+	}{
+		"started":   {sw.JobEventStarted, sw.JobStatusRunning},
+		"valid":     {sw.JobEventCompleted, sw.JobStatusComplete},
+		"broken":    {sw.JobEventFailed, sw.JobStatusFailed},
+		"failed":    {sw.JobEventFailed, sw.JobStatusFailed},
+		"cancelled": {sw.JobEventCancelled, sw.JobStatusCancelled},
+	}
+
+	events := recorder.byJob()
+	for id, want := range expected {
+		event, ok := events[id]
+		if !ok {
+			t.Errorf("Expected event for job %s", id)
+			continue
+		}
+		if event.Type != want.eventType || event.Status != want.status || event.PreviousStatus != jobs[id].previous {
+			t.Errorf("Job %s: expected %s (%s -> %s), got %+v", id, want.eventType, jobs[id].previous, want.status, event)
+		}
+
+		_, _, _, status, err := tracker.GetJobMetadata(id)
+		if err != nil {
+			t.Fatalf("GetJobMetadata failed: %v", err)
+		}
+		if status != string(want.status) {
+			t.Errorf("Job %s: expected tracked status %s, got %s", id, want.status, status)
+		}
+	}
+
+	if _, ok := events["unchanged"]; ok {
+		t.Error("Expected no event for a job whose status did not change")
+	}
+
+	logContent, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%s_broken.log", sw.MethodFEL)))
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if !strings.Contains(string(logContent), "Result validation failed") {
+		t.Errorf("Expected validation failure in job log, got: %s", logContent)
+	}
+}
@@ -0,0 +1,248 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+// identityFixture holds a user's alignment stored in a test database
+type identityFixture struct {
+	*apiTestEnv
+	tracker     *sw.SQLiteJobTracker
+	alignmentID string
+}
+
+func setupIdentityFixture(t *testing.T) *identityFixture {
+	t.Helper()
+	f := &identityFixture{apiTestEnv: setupAPITestEnv(t)}
+	f.tracker = sw.NewSQLiteJobTracker(f.db.GetDB())
+	f.alignmentID = f.storeDataset(t, "fasta", ">a\nATGATG\n>b\nATGATA\n")
+	return f
+}
+
+// datasetTrackerAt returns a dataset tracker with its files in a subdirectory
+func (f *identityFixture) datasetTrackerAt(t *testing.T, name string) *sw.SQLiteDatasetTracker {
+	t.Helper()
+	dataDir := filepath.Join(f.dir, name)
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		t.Fatalf("Failed to create data dir: %v", err)
+	}
+	return sw.NewSQLiteDatasetTracker(f.db.GetDB(), dataDir)
+}
+
+// startFEL starts a FEL job and returns its ID
+func (f *identityFixture) startFEL(t *testing.T, api *sw.FELAPI, request *sw.FelRequest) string {
+	t.Helper()
+	adapted, err := sw.AdaptRequest(request)
+	if err != nil {
+		t.Fatalf("AdaptRequest failed: %v", err)
+	}
+	result, err := api.HandleStartJob(nil, adapted, sw.MethodFEL)
+	if err != nil {
+		t.Fatalf("HandleStartJob failed: %v", err)
+	}
+	return result.(map[string]interface{})["job_id"].(string)
+}
+
+// TestJobIdentityID tests which fields change a job's identity
+func TestJobIdentityID(t *testing.T) {
+	identity := func(request *sw.FelRequest, alignmentHash, version string) string {
+		adapted, err := sw.AdaptRequest(request)
+		if err != nil {
+			t.Fatalf("AdaptRequest failed: %v", err)
+		}
+		id, err := sw.NewJobIdentity(sw.MethodFEL, adapted, alignmentHash, "", version)
+		if err != nil {
+			t.Fatalf("NewJobIdentity failed: %v", err)
+		}
+		return id.ID()
+	}
+
+	base := identity(&sw.FelRequest{Alignment: "abc", Resample: 50}, "hash1", "2.5.62")
+
+	if id := identity(&sw.FelRequest{Alignment: "abc", Resample: 50, UserToken: "someone"}, "hash1", "2.5.62"); id != base {
+		t.Error("The user token should not change the job ID")
+	}
+	if id := identity(&sw.FelRequest{Alignment: "abc", Resample: 100}, "hash1", "2.5.62"); id == base {
+		t.Error("Parameters should change the job ID")
+	}
+	if id := identity(&sw.FelRequest{Alignment: "abc", Resample: 50}, "hash2", "2.5.62"); id == base {
+		t.Error("Alignment content should change the job ID")
+	}
+	if id := identity(&sw.FelRequest{Alignment: "abc", Resample: 50}, "hash1", "2.5.63"); id == base {
+		t.Error("The HyPhy version should change the job ID")
+	}
+
+	adapted, _ := sw.AdaptRequest(&sw.FelRequest{Alignment: "abc", Resample: 50})
+	slac, _ := sw.NewJobIdentity(sw.MethodSLAC, adapted, "hash1", "", "2.5.62")
+	if slac.ID() == base {
+		t.Error("The method should change the job ID")
+	}
+}
+
+// TestJobIdentityIgnoresDataDirectory tests that moving the data directory keeps job IDs
+func TestJobIdentityIgnoresDataDirectory(t *testing.T) {
+	f := setupIdentityFixture(t)
+	scheduler := &submitScheduler{tracker: f.tracker, statuses: map[string]sw.JobStatusValue{}}
+	request := &sw.FelRequest{Alignment: f.alignmentID, Resample: 50}
+
+	api := sw.NewFELAPI(filepath.Join(f.dir, "output"), "hyphy", scheduler, f.datasets, f.tracker)
+	api.HyPhyVersion = "2.5.62"
+	jobID := f.startFEL(t, api, request)
+
+	// Copy the uploads to a new directory and start the same analysis from there
+	moved := f.datasetTrackerAt(t, "moved")
+	dataset, err := f.datasets.Get(f.alignmentID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(f.datasets.GetDatasetDir(), dataset.GetContentHash()))
+	if err != nil {
+		t.Fatalf("Failed to read dataset: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(moved.GetDatasetDir(), dataset.GetContentHash()), content, 0644); err != nil {
+		t.Fatalf("Failed to write dataset: %v", err)
+	}
+
+	movedAPI := sw.NewFELAPI(filepath.Join(f.dir, "output"), "hyphy", scheduler, moved, f.tracker)
+	movedAPI.HyPhyVersion = "2.5.62"
+	if id := f.startFEL(t, movedAPI, request); id != jobID {
+		t.Errorf("Expected job %s after moving the data directory, got %s", jobID, id)
+	}
+	if len(scheduler.submitted) != 1 {
+		t.Errorf("Expected the job to be deduplicated, got %d submissions", len(scheduler.submitted))
+	}
+
+	// Upgrading HyPhy runs the analysis again
+	api.HyPhyVersion = "2.5.63"
+	if id := f.startFEL(t, api, request); id == jobID {
+		t.Error("Expected a new job after a HyPhy upgrade")
+	}
+	if len(scheduler.submitted) != 2 {
+		t.Errorf("Expected 2 submissions, got %d", len(scheduler.submitted))
+	}
+}
+
+// TestJobIdentityIgnoresDatasetIDs tests that the same content stored by two users gives the
+// same job ID, and that parameters set to their defaults hash the same as unset ones
+func TestJobIdentityIgnoresDatasetIDs(t *testing.T) {
+	f := setupIdentityFixture(t)
+	scheduler := &submitScheduler{tracker: f.tracker, statuses: map[string]sw.JobStatusValue{}}
+	api := sw.NewFELAPI(filepath.Join(f.dir, "output"), "hyphy", scheduler, f.datasets, f.tracker)
+	api.HyPhyVersion = "2.5.62"
+	jobID := f.startFEL(t, api, &sw.FelRequest{Alignment: f.alignmentID, Resample: 50})
+
+	// Another user uploads the same alignment, which gets a dataset ID of its own
+	other, _ := f.newUser(t)
+	dataset := sw.NewBaseDataset(sw.DatasetMetadata{Name: "fasta", Type: "fasta"}, []byte(">a\nATGATG\n>b\nATGATA\n"))
+	if err := f.datasets.StoreWithUser(dataset, other); err != nil {
+		t.Fatalf("StoreWithUser failed: %v", err)
+	}
+	if dataset.GetId() == f.alignmentID {
+		t.Fatal("Expected each user's upload to get its own dataset ID")
+	}
+
+	if id := f.startFEL(t, api, &sw.FelRequest{Alignment: dataset.GetId(), Resample: 50}); id != jobID {
+		t.Errorf("Expected job %s for the other user's copy of the alignment, got %s", jobID, id)
+	}
+	explicit := &sw.FelRequest{Alignment: dataset.GetId(), Resample: 50, Ci: "No", Srv: "Yes", MultipleHits: "None", SiteMultihit: "Estimate"}
+	if id := f.startFEL(t, api, explicit); id != jobID {
+		t.Errorf("Expected job %s with the defaults set explicitly, got %s", jobID, id)
+	}
+	if id := f.startFEL(t, api, &sw.FelRequest{Alignment: dataset.GetId(), Resample: 50, Ci: "Yes"}); id == jobID {
+		t.Error("Expected a new job when a parameter differs from its default")
+	}
+}
+
+// TestLegacyJobIDGolden tests that legacy job IDs match the IDs jobs were actually given
+// before job identities, which hashed the command line as it was rendered then
+func TestLegacyJobIDGolden(t *testing.T) {
+	newRequest := func(branches ...string) *sw.FelRequest {
+		return &sw.FelRequest{
+			Alignment:   "aln1",
+			Tree:        "tree1",
+			Branches:    branches,
+			Ci:          "Yes",
+			Resample:    50,
+			GeneticCode: "Universal",
+		}
+	}
+	adapted, err := sw.AdaptRequest(newRequest("{Foreground}", "Node3"))
+	if err != nil {
+		t.Fatalf("AdaptRequest failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		request interface{}
+		command string
+		id      string
+	}{
+		{
+			name:    "adapted request",
+			request: adapted,
+			command: "hyphy fel --alignment /data/datasets/aln1 --tree /data/datasets/tree1 --code Universal --branches {Foreground},Node3 --ci Yes --resample 50",
+			id:      "864378c340db2e445b0ba65c34d713687bc715555dbfe0dd9feef13d64f56644",
+		},
+		{
+			name:    "request fields",
+			request: newRequest("Foreground", "Node3"),
+			command: "hyphy fel --alignment /data/datasets/aln1 --tree /data/datasets/tree1 --ci Yes --resample 50.000000 --genetic_code Universal --branches 'Foreground,Node3'",
+			id:      "7616f4f7e45a6d9daa9937c87f53dd168d9c9779c84d07225f24a4eb75dd7663",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := sw.NewHyPhyMethod(tt.request, "/out", "hyphy", sw.MethodFEL, "/data/datasets")
+			if command := method.LegacyCommandLine(); command != tt.command {
+				t.Errorf("Expected legacy command line\n%s\ngot\n%s", tt.command, command)
+			}
+			if id := sw.LegacyJobID(method); id != tt.id {
+				t.Errorf("Expected legacy ID %s, got %s", tt.id, id)
+			}
+		})
+	}
+}
+
+// TestHandleGetJobResolvesLegacyID tests that jobs started before job identities can still be fetched
+func TestHandleGetJobResolvesLegacyID(t *testing.T) {
+	f := setupIdentityFixture(t)
+	scheduler := &submitScheduler{tracker: f.tracker, statuses: map[string]sw.JobStatusValue{}}
+	outputDir := filepath.Join(f.dir, "output")
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		t.Fatalf("Failed to create output dir: %v", err)
+	}
+
+	api := sw.NewFELAPI(outputDir, "hyphy", scheduler, f.datasets, f.tracker)
+	api.HyPhyVersion = "2.5.62"
+	request := &sw.FelRequest{Alignment: f.alignmentID, Resample: 50}
+	adapted, err := sw.AdaptRequest(request)
+	if err != nil {
+		t.Fatalf("AdaptRequest failed: %v", err)
+	}
+
+	// A completed job stored under the command hash
+	method := sw.NewHyPhyMethod(adapted, outputDir, "hyphy", sw.MethodFEL, f.datasets.GetDatasetDir())
+	legacyID := sw.LegacyJobID(method)
+	scheduler.statuses[legacyID] = sw.JobStatusComplete
+	if err := os.WriteFile(method.GetOutputPath(legacyID), []byte(`{"MLE":{}}`), 0644); err != nil {
+		t.Fatalf("Failed to write results: %v", err)
+	}
+
+	result, err := api.HandleGetJob(nil, adapted, sw.MethodFEL)
+	if err != nil {
+		t.Fatalf("HandleGetJob failed: %v", err)
+	}
+	if jobID := result.(map[string]interface{})["jobId"]; jobID != legacyID {
+		t.Errorf("Expected legacy job %s, got %v", legacyID, jobID)
+	}
+
+	// Unknown jobs are still reported as not found
+	other, _ := sw.AdaptRequest(&sw.FelRequest{Alignment: f.alignmentID, Resample: 75})
+	if _, err := api.HandleGetJob(nil, other, sw.MethodFEL); err == nil || err.Error() != "job not found" {
+		t.Errorf("Expected job not found, got %v", err)
+	}
+}
@@ -0,0 +1,414 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// TestValidateParameters tests that every violated constraint of a request is reported at once
+func TestValidateParameters(t *testing.T) {
+	tests := []struct {
+		name    string
+		request interface{}
+		fields  []string
+	}{
+		{"valid FEL", &sw.FelRequest{Alignment: "abc123", Ci: "Yes", Resample: 100, GeneticCode: sw.VERTEBRATE_MT_DNA}, nil},
+		{"empty BUSTED", &sw.BustedRequest{}, nil},
+		{
+			"FEL enums and code",
+			&sw.FelRequest{Alignment: "abc123", Ci: "yes", Srv: "Maybe", MultipleHits: "Triple", GeneticCode: "Martian"},
+			[]string{"ci", "srv", "multiple_hits", "genetic_code"},
+		},
+		{"dataset IDs", &sw.MemeRequest{Alignment: "../etc/passwd", Tree: "tree.nwk"}, []string{"alignment", "tree"}},
+		{"BUSTED ranges", &sw.BustedRequest{Rates: 11, SynRates: -1, GridSize: -5, Srv: "branch-site"}, []string{"rates", "syn_rates", "grid_size"}},
+		{"GARD enums", &sw.GardRequest{DataType: "DNA", RunMode: "Fastest", SiteToSiteVariation: "Beta-Gamma"}, []string{"data_type", "run_mode"}},
+		{"BGM data type", &sw.BgmRequest{DataType: "Nucleotide"}, []string{"data_type"}},
+		{"CONTRAST-FEL required and p-value", &sw.ContrastFelRequest{PValue: 1.5, QValue: 0.2}, []string{"alignment", "tree", "branch_sets", "p_value"}},
+		{"FUBAR concentration", &sw.FubarRequest{Alignment: "a", Tree: "t", ConcentrationParameter: 0.001, GridPoints: 51}, []string{"grid_points"}},
+		{"FADE Bayes factor", &sw.FadeRequest{BayesFactorThreshold: 1001}, []string{"bayes_factor_threshold"}},
+		{
+			"Slatkin weight and compartments",
+			&sw.SlatkinRequest{Weight: 1.5, Groups: 2, CompartmentDefinitions: []sw.SlatkinRequestCompartmentDefinitionsInner{
+				{Description: "lung", Regexp: "^L"},
+				{Description: "blood"},
+			}},
+			[]string{"compartment_definitions[1].regexp", "weight"},
+		},
+		{"adapted request", mustAdapt(t, &sw.AbsrelRequest{Srv: "No", Blb: 2}), []string{"blb"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := sw.ValidateParameters(tt.request)
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+			validationErr, ok := err.(*sw.ParameterValidationError)
+			if !ok {
+				t.Fatalf("Expected a ParameterValidationError, got %v", err)
+			}
+			var fields []string
+			for _, problem := range validationErr.InvalidData().Errors {
+				fields = append(fields, problem.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("Expected problems with %v, got %v", tt.fields, validationErr.Problems)
+			}
+		})
+	}
+}
+
+// TestValidateParametersJSON tests that zero values the request body sets explicitly are checked
+func TestValidateParametersJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		request interface{}
+		body    string
+		fields  []string
+	}{
+		{"unset rates", &sw.BustedRequest{}, `{}`, nil},
+		{"null rates", &sw.BustedRequest{}, `{"rates":null}`, nil},
+		{"zero rates", &sw.BustedRequest{}, `{"rates":0,"syn_rates":0}`, []string{"rates", "syn_rates"}},
+		{"empty enum", &sw.FelRequest{Alignment: "abc123"}, `{"alignment":"abc123","ci":""}`, []string{"ci"}},
+		{"zero within range", &sw.FelRequest{Alignment: "abc123"}, `{"alignment":"abc123","resample":0}`, nil},
+		{"empty tree ID", &sw.MemeRequest{Alignment: "abc123"}, `{"alignment":"abc123","tree":""}`, []string{"tree"}},
+		{
+			"zero in compartments",
+			&sw.SlatkinRequest{Groups: 2, CompartmentDefinitions: []sw.SlatkinRequestCompartmentDefinitionsInner{{Description: "lung", Regexp: "^L"}}},
+			`{"groups":2,"replicates":0,"compartment_definitions":[{"description":"lung","regexp":"^L"}]}`,
+			[]string{"replicates"},
+		},
+		{"not an object", &sw.BustedRequest{}, `[]`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			err := sw.ValidateParametersJSON(tt.request, []byte(tt.body))
+			if validationErr, ok := err.(*sw.ParameterValidationError); ok {
+				for _, problem := range validationErr.Problems {
+					fields = append(fields, problem.Field)
+				}
+			} else if err != nil {
+				t.Fatalf("Expected a ParameterValidationError, got %v", err)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("Expected problems with %v, got %v", tt.fields, err)
+			}
+		})
+	}
+}
+
+// TestValidateParametersInvalidPattern tests that a pattern that does not compile rejects values
+func TestValidateParametersInvalidPattern(t *testing.T) {
+	request := struct {
+		ID string `json:"id" validate:"regexp=^(abc$"`
+	}{ID: "abc"}
+	validationErr, ok := sw.ValidateParameters(&request).(*sw.ParameterValidationError)
+	if !ok || len(validationErr.Problems) != 1 || validationErr.Problems[0].Field != "id" {
+		t.Errorf("Expected id to be rejected, got %v", validationErr)
+	}
+}
+
+// specProperty is a property of a schema in api/openapi.yaml
+type specProperty struct {
+	Enum    []string    `yaml:"enum"`
+	Minimum *float64    `yaml:"minimum"`
+	Maximum *float64    `yaml:"maximum"`
+	Pattern string      `yaml:"pattern"`
+	Default interface{} `yaml:"default"`
+	Ref     string      `yaml:"$ref"`
+}
+
+// specSchema is a schema in api/openapi.yaml
+type specSchema struct {
+	Enum       []string                `yaml:"enum"`
+	Required   []string                `yaml:"required"`
+	Properties map[string]specProperty `yaml:"properties"`
+}
+
+// loadSpecSchemas reads the component schemas of api/openapi.yaml
+func loadSpecSchemas(t *testing.T) map[string]specSchema {
+	t.Helper()
+	data, err := os.ReadFile("../../api/openapi.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read the spec: %v", err)
+	}
+	var spec struct {
+		Components struct {
+			Schemas map[string]specSchema `yaml:"schemas"`
+		} `yaml:"components"`
+	}
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		t.Fatalf("Failed to parse the spec: %v", err)
+	}
+	return spec.Components.Schemas
+}
+
+// TestMethodParametersMatchSpec tests that the method listing reports the constraints api/openapi.yaml declares
+func TestMethodParametersMatchSpec(t *testing.T) {
+	schemas := loadSpecSchemas(t)
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	sw.NewMethodsAPIService().GetMethodsList(c)
+	var list sw.MethodsList
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to decode methods list: %v", err)
+	}
+
+	for i, def := range sw.GetMethodRegistry().GetMethods() {
+		schemaName := reflect.TypeOf(def.RequestType).Name()
+		requestSchema, ok := schemas[schemaName]
+		if !ok {
+			t.Errorf("Schema %s is missing from the spec", schemaName)
+			continue
+		}
+		required := map[string]bool{}
+		for _, name := range requestSchema.Required {
+			required[name] = true
+		}
+
+		listed := map[string]bool{}
+		for _, param := range list.Methods[i].Parameters {
+			listed[param.Name] = true
+			prop, ok := requestSchema.Properties[param.Name]
+			if !ok {
+				t.Errorf("%s.%s is not in the spec", schemaName, param.Name)
+				continue
+			}
+			want := prop.Enum
+			if prop.Ref != "" {
+				want = schemas[prop.Ref[len("#/components/schemas/"):]].Enum
+			}
+			if fmt.Sprint(want) != fmt.Sprint(param.Enum) {
+				t.Errorf("%s.%s: expected enum %v, got %v", schemaName, param.Name, want, param.Enum)
+			}
+			if fmt.Sprint(deref(prop.Minimum), deref(prop.Maximum)) != fmt.Sprint(deref(param.Minimum), deref(param.Maximum)) {
+				t.Errorf("%s.%s: expected range %v-%v, got %v-%v", schemaName, param.Name,
+					deref(prop.Minimum), deref(prop.Maximum), deref(param.Minimum), deref(param.Maximum))
+			}
+			if prop.Pattern != param.Pattern {
+				t.Errorf("%s.%s: expected pattern %q, got %q", schemaName, param.Name, prop.Pattern, param.Pattern)
+			}
+			if required[param.Name] != param.Required {
+				t.Errorf("%s.%s: expected required=%v", schemaName, param.Name, required[param.Name])
+			}
+		}
+		for name := range requestSchema.Properties {
+			if name != "user_token" && !listed[name] {
+				t.Errorf("%s.%s is in the spec but not listed", schemaName, name)
+			}
+		}
+	}
+}
+
+// deref returns a bound for printing, or "none"
+func deref(bound *float64) interface{} {
+	if bound == nil {
+		return "none"
+	}
+	return *bound
+}
+
+// requestModels are the request models whose constraint and default tags are maintained
+// by hand, by their schema name in api/openapi.yaml
+var requestModels = map[string]interface{}{
+	"AbsrelRequest":      sw.AbsrelRequest{},
+	"BgmRequest":         sw.BgmRequest{},
+	"BustedRequest":      sw.BustedRequest{},
+	"ContrastFelRequest": sw.ContrastFelRequest{},
+	"FadeRequest":        sw.FadeRequest{},
+	"FelRequest":         sw.FelRequest{},
+	"FubarRequest":       sw.FubarRequest{},
+	"GardRequest":        sw.GardRequest{},
+	"MemeRequest":        sw.MemeRequest{},
+	"MultihitRequest":    sw.MultihitRequest{},
+	"NrmRequest":         sw.NrmRequest{},
+	"RelaxRequest":       sw.RelaxRequest{},
+	"SlacRequest":        sw.SlacRequest{},
+	"SlatkinRequest":     sw.SlatkinRequest{},
+	"SlatkinRequest_compartment_definitions_inner": sw.SlatkinRequestCompartmentDefinitionsInner{},
+}
+
+// modelFileName returns the file the generator writes a model to, e.g. model_fel_request.go
+func modelFileName(typeName string) string {
+	var name strings.Builder
+	for i, r := range typeName {
+		if i > 0 && unicode.IsUpper(r) {
+			name.WriteByte('_')
+		}
+		name.WriteRune(unicode.ToLower(r))
+	}
+	return "go/model_" + name.String() + ".go"
+}
+
+// TestRequestModelsMatchSpec tests that the hand-maintained validate and default tags of
+// every request model agree with api/openapi.yaml, and that every request model kept from
+// the generator by .openapi-generator-ignore is checked
+func TestRequestModelsMatchSpec(t *testing.T) {
+	schemas := loadSpecSchemas(t)
+
+	checked := map[string]bool{}
+	for schemaName, model := range requestModels {
+		modelType := reflect.TypeOf(model)
+		checked[modelFileName(modelType.Name())] = true
+		schema, ok := schemas[schemaName]
+		if !ok {
+			t.Errorf("Schema %s is missing from the spec", schemaName)
+			continue
+		}
+		required := map[string]bool{}
+		for _, name := range schema.Required {
+			required[name] = true
+		}
+
+		fields := map[string]bool{}
+		for i := 0; i < modelType.NumField(); i++ {
+			field := modelType.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			fields[name] = true
+			prop, ok := schema.Properties[name]
+			if !ok {
+				t.Errorf("%s.%s is not in the spec", schemaName, name)
+				continue
+			}
+
+			// Parse the validate tag; the regexp key takes the rest of it
+			var enum []string
+			var minimum, maximum *float64
+			var pattern string
+			var isRequired bool
+			tag := field.Tag.Get("validate")
+			for tag != "" {
+				var item string
+				if strings.HasPrefix(tag, "regexp=") {
+					item, tag = tag, ""
+				} else {
+					item, tag, _ = strings.Cut(tag, ",")
+				}
+				key, value, _ := strings.Cut(item, "=")
+				switch key {
+				case "required":
+					isRequired = true
+				case "enum":
+					enum = strings.Split(value, "|")
+				case "minimum", "maximum":
+					bound, err := strconv.ParseFloat(value, 64)
+					if err != nil {
+						t.Errorf("%s.%s: invalid %s %q", schemaName, name, key, value)
+					}
+					if key == "minimum" {
+						minimum = &bound
+					} else {
+						maximum = &bound
+					}
+				case "regexp":
+					pattern = value
+				default:
+					t.Errorf("%s.%s: unknown validate key %q", schemaName, name, key)
+				}
+			}
+
+			// Enums of referenced types are checked through the method listing
+			if prop.Ref == "" && fmt.Sprint(prop.Enum) != fmt.Sprint(enum) {
+				t.Errorf("%s.%s: expected enum %v, got %v", schemaName, name, prop.Enum, enum)
+			}
+			if fmt.Sprint(deref(prop.Minimum), deref(prop.Maximum)) != fmt.Sprint(deref(minimum), deref(maximum)) {
+				t.Errorf("%s.%s: expected range %v-%v, got %v-%v", schemaName, name,
+					deref(prop.Minimum), deref(prop.Maximum), deref(minimum), deref(maximum))
+			}
+			if prop.Pattern != pattern {
+				t.Errorf("%s.%s: expected pattern %q, got %q", schemaName, name, prop.Pattern, pattern)
+			}
+			if required[name] != isRequired {
+				t.Errorf("%s.%s: expected required=%v", schemaName, name, required[name])
+			}
+
+			// Array defaults are empty, which an unset field already is
+			wantDefault, hasDefault := "", false
+			switch value := prop.Default.(type) {
+			case nil, []interface{}:
+			case float64:
+				wantDefault, hasDefault = strconv.FormatFloat(value, 'g', -1, 64), true
+			default:
+				wantDefault, hasDefault = fmt.Sprint(value), true
+			}
+			gotDefault, tagged := field.Tag.Lookup("default")
+			if tagged && hasDefault {
+				if want, err := strconv.ParseFloat(wantDefault, 64); err == nil {
+					if got, err := strconv.ParseFloat(gotDefault, 64); err != nil || got != want {
+						t.Errorf("%s.%s: expected default %s, got %s", schemaName, name, wantDefault, gotDefault)
+					}
+				} else if gotDefault != wantDefault {
+					t.Errorf("%s.%s: expected default %s, got %s", schemaName, name, wantDefault, gotDefault)
+				}
+			} else if tagged != hasDefault {
+				t.Errorf("%s.%s: expected default %q, got %q", schemaName, name, wantDefault, gotDefault)
+			}
+		}
+		for name := range schema.Properties {
+			if !fields[name] {
+				t.Errorf("%s.%s is in the spec but not in the model", schemaName, name)
+			}
+		}
+	}
+
+	// Every request model the generator no longer writes must be checked above
+	ignore, err := os.ReadFile("../../.openapi-generator-ignore")
+	if err != nil {
+		t.Fatalf("Failed to read .openapi-generator-ignore: %v", err)
+	}
+	for _, line := range strings.Split(string(ignore), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "go/model_") && strings.Contains(line, "_request") && !checked[line] {
+			t.Errorf("%s is maintained by hand but not checked against the spec", line)
+		}
+	}
+}
+
+// TestStartJobReportsInvalidParameters tests that starting a job lists every invalid parameter as a 400
+func TestStartJobReportsInvalidParameters(t *testing.T) {
+	f := setupRerunFixture(t)
+
+	code, response := f.post(t, "/api/v1/methods/fel-start",
+		fmt.Sprintf(`{"alignment":%q,"ci":"Maybe","srv":"No","resample":-5,"genetic_code":"Martian"}`, f.alignmentID), f.token)
+	if code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for invalid parameters, got %d: %v", code, response)
+	}
+	problems, _ := response["errors"].([]interface{})
+	if len(problems) != 3 {
+		t.Errorf("Expected ci, resample and genetic_code to be reported, got %v", response)
+	}
+	if len(f.scheduler.submitted) != 0 {
+		t.Errorf("Expected no job to be submitted, got %v", f.scheduler.submitted)
+	}
+
+	code, response = f.post(t, "/api/v1/methods/fel-start",
+		fmt.Sprintf(`{"alignment":%q,"ci":"","resample":0}`, f.alignmentID), f.token)
+	problems, _ = response["errors"].([]interface{})
+	if code != http.StatusBadRequest || len(problems) != 1 {
+		t.Errorf("Expected 400 reporting the empty ci, got %d: %v", code, response)
+	}
+
+	code, response = f.post(t, "/api/v1/methods/fel-start", `{"alignment":"not/an/id"}`, f.token)
+	if code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed dataset ID, got %d: %v", code, response)
+	}
+}
@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+// TestResourceProfileResolve tests method profiles and size-based tiers
+func TestResourceProfileResolve(t *testing.T) {
+	profiles := sw.DefaultResourceProfiles()
+
+	slac := profiles.Resolve(sw.MethodSLAC, 10, 300)
+	gard := profiles.Resolve(sw.MethodGARD, 10, 300)
+	if gard.CoresPerNode <= slac.CoresPerNode {
+		t.Errorf("Expected GARD to get more cores than SLAC, got %d vs %d", gard.CoresPerNode, slac.CoresPerNode)
+	}
+	if !gard.MPI || slac.MPI {
+		t.Errorf("Expected only GARD to use MPI, got gard=%v slac=%v", gard.MPI, slac.MPI)
+	}
+
+	// 1000 sequences x 3000 sites reaches the large-alignment tier
+	large := profiles.Resolve(sw.MethodGARD, 1000, 3000)
+	if large.Nodes != 2 || large.Memory != "32G" || large.MaxTime != "96:00:00" {
+		t.Errorf("Expected large GARD tier, got %+v", large)
+	}
+	if large.CoresPerNode != gard.CoresPerNode {
+		t.Errorf("Expected tier to keep cores from base profile, got %d", large.CoresPerNode)
+	}
+
+	// Unknown methods fall back to the default profile
+	unknown := profiles.Resolve(sw.HyPhyMethodType("unknown"), 1000, 3000)
+	if unknown.Nodes != 1 || unknown.CoresPerNode != 1 || unknown.Memory != "900M" || unknown.MaxTime != "01:00:00" {
+		t.Errorf("Expected default profile, got %+v", unknown)
+	}
+}
+
+// TestLoadResourceProfiles tests loading profiles from a config file
+func TestLoadResourceProfiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "profiles.json")
+	config := `{
+		"mpi_launcher": "srun --mpi=pmix",
+		"default": {"memory": "2G"},
+		"methods": {
+			"slac": {"cores_per_node": 2, "max_time": "1-00:00:00",
+				"tiers": [{"min_cells": 100, "memory": "64G"}, {"min_cells": 10, "memory": "8G"}]}
+		}
+	}`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	profiles, err := sw.LoadResourceProfiles(path)
+	if err != nil {
+		t.Fatalf("LoadResourceProfiles failed: %v", err)
+	}
+
+	if profiles.MPILauncher != "srun --mpi=pmix" || profiles.MPIExecutable != "HYPHYMPI" {
+		t.Errorf("Expected launcher override and built-in executable, got %q %q", profiles.MPILauncher, profiles.MPIExecutable)
+	}
+
+	small := profiles.Resolve(sw.MethodSLAC, 1, 5)
+	if small.CoresPerNode != 2 || small.Memory != "2G" || small.MaxTime != "1-00:00:00" {
+		t.Errorf("Expected file profile over default, got %+v", small)
+	}
+	if medium := profiles.Resolve(sw.MethodSLAC, 2, 10); medium.Memory != "8G" {
+		t.Errorf("Expected first tier, got %+v", medium)
+	}
+	if large := profiles.Resolve(sw.MethodSLAC, 10, 10); large.Memory != "64G" {
+		t.Errorf("Expected second tier, got %+v", large)
+	}
+
+	// Methods not in the file keep their built-in profile
+	if gard := profiles.Resolve(sw.MethodGARD, 1, 1); gard.CoresPerNode != 16 {
+		t.Errorf("Expected built-in GARD profile, got %+v", gard)
+	}
+
+	// Invalid values are rejected
+	if err := os.WriteFile(path, []byte(`{"methods": {"fel": {"memory": "lots"}}}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := sw.LoadResourceProfiles(path); err == nil {
+		t.Error("Expected error for invalid memory")
+	}
+}
+
+// TestResourceParsing tests memory and time limit conversions
+func TestResourceParsing(t *testing.T) {
+	memory := map[string]int64{"900M": 900, "4G": 4096, "16gb": 16384, "512": 512, "2048K": 2}
+	for input, want := range memory {
+		got, err := sw.ParseMemoryMB(input)
+		if err != nil || got != want {
+			t.Errorf("ParseMemoryMB(%q) = %d, %v; want %d", input, got, err, want)
+		}
+	}
+	if _, err := sw.ParseMemoryMB("4 gigs"); err == nil {
+		t.Error("Expected error for invalid memory")
+	}
+
+	times := map[string]int64{"30": 30, "01:00:00": 60, "12:30": 13, "48:00:00": 2880, "2-00:00:00": 2880, "1-12": 2160}
+	for input, want := range times {
+		got, err := sw.ParseTimeLimitMinutes(input)
+		if err != nil || got != want {
+			t.Errorf("ParseTimeLimitMinutes(%q) = %d, %v; want %d", input, got, err, want)
+		}
+	}
+	if _, err := sw.ParseTimeLimitMinutes("1:2:3:4"); err == nil {
+		t.Error("Expected error for invalid time limit")
+	}
+}
+
+// TestAlignmentStatsDimensions tests the sequence and site counts jobs are sized by
+func TestAlignmentStatsDimensions(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		sequences int32
+		sites     int32
+	}{
+		{"FASTA", ">a\nACGT\nACG\n>b\nACGTACG\n>c\nACGTACG\n", 3, 7},
+		{"NEXUS", "#NEXUS\nBEGIN DATA;\nDIMENSIONS NTAX=2 NCHAR=6;\nMATRIX\na ACGTAC\nb ACGTAA\n;\nEND;\n", 2, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := sw.CheckAlignment("", []byte(tt.content))
+			if err != nil {
+				t.Fatalf("CheckAlignment failed: %v", err)
+			}
+			if stats.SequenceCount != tt.sequences || stats.AlignmentLength != tt.sites {
+				t.Errorf("Expected %d x %d, got %d x %d", tt.sequences, tt.sites, stats.SequenceCount, stats.AlignmentLength)
+			}
+		})
+	}
+
+	// Content that is not an alignment has no dimensions, so jobs on it get base resources
+	if stats, err := sw.CheckAlignment("", []byte("not an alignment")); err != nil || stats != nil {
+		t.Errorf("Expected no stats for unknown content, got %+v, %v", stats, err)
+	}
+}
+
+// TestLaunchCommandMPI tests wrapping of MPI commands
+func TestLaunchCommandMPI(t *testing.T) {
+	resources := sw.JobResources{
+		Nodes:         2,
+		CoresPerNode:  8,
+		MPI:           true,
+		MPILauncher:   "mpirun -np {tasks}",
+		MPIExecutable: "HYPHYMPI",
+	}
+
+	got := resources.LaunchCommand(sw.NewCommand("/usr/bin/hyphy", "gard", "--alignment", "/data/a")).String()
+	want := "mpirun -np 16 HYPHYMPI gard --alignment /data/a"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	resources.MPI = false
+	if got := resources.LaunchCommand(sw.NewCommand("hyphy", "fel")).String(); got != "hyphy fel" {
+		t.Errorf("Expected non-MPI command unchanged, got %q", got)
+	}
+}
+
+// TestSlurmRestSubmitResources tests that the REST backend sends the resolved resources
+func TestSlurmRestSubmitResources(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Submit body is not valid JSON: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"job_id": 77}`))
+	}))
+	defer server.Close()
+
+	tracker := &MockJobTrackerWithInspection{mappings: make(map[string]string)}
+	scheduler := sw.NewSlurmRestScheduler(sw.SlurmRestConfig{
+		BaseURL:       server.URL,
+		SubmitAPIPath: "/slurm/v0.0.37",
+		AuthToken:     "mock-jwt-token",
+		JWTUsername:   "slurm",
+	}, tracker)
+	defer scheduler.Shutdown()
+
+	resources := sw.DefaultResourceProfiles().Resolve(sw.MethodGARD, 10, 300)
+	job := &sw.BaseJob{
+		Id:         "gard-job",
+		Method:     &argvMethod{command: sw.NewCommand("hyphy", "gard", "--alignment", "/data/quoted dir")},
+		OutputPath: "/data/gard-job_results.json",
+		LogPath:    "/data/gard-job.log",
+		Metadata:   map[string]interface{}{sw.JobResourcesMetadataKey: resources},
+	}
+
+	if err := scheduler.Submit(job); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if tracker.mappings["gard-job"] != "77" {
+		t.Errorf("Expected job mapping to be stored, got %q", tracker.mappings["gard-job"])
+	}
+
+	props, ok := body["job"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected job properties in body, got %v", body)
+	}
+	expected := map[string]float64{
+		"nodes":           1,
+		"tasks":           16,
+		"tasks_per_node":  16,
+		"memory_per_node": 16 * 1024,
+		"time_limit":      48 * 60,
+	}
+	for key, want := range expected {
+		if got, _ := props[key].(float64); got != want {
+			t.Errorf("Expected %s=%v, got %v", key, want, props[key])
+		}
+	}
+
+	script, _ := body["script"].(string)
+	if !strings.Contains(script, `mpirun -np 16 HYPHYMPI gard --alignment '/data/quoted dir' --output /data/gard-job_results.json`) {
+		t.Errorf("Expected MPI launch in script, got %q", script)
+	}
+}
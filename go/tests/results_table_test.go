@@ -0,0 +1,517 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+const felSiteResults = `{
+	"MLE": {
+		"headers": [["alpha", "Synonymous substitution rate at a site"], ["beta", "Non-synonymous substitution rate at a site"], ["p-value", "Asymptotic p-value"]],
+		"content": {
+			"0": [[1.0, 2.0, 0.01], [1.5, 0.5, 0.04]],
+			"1": [[0.5, 0.5, 0.03]]
+		}
+	},
+	"branch attributes": {
+		"0": {
+			"Node1": {"Nucleotide GTR": 0.1, "original name": "Node1"},
+			"Human": {"Nucleotide GTR": 0.2, "Global MG94xREV": 0.25, "original name": "Human"}
+		},
+		"attributes": {"Nucleotide GTR": {"attribute type": "branch length"}}
+	}
+}`
+
+func columnNames(table *sw.ResultTable) []string {
+	names := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		names[i] = column.Name
+	}
+	return names
+}
+
+// TestBuildSiteTableFEL tests that MLE rows are flattened with partition and site indices and q-values
+func TestBuildSiteTableFEL(t *testing.T) {
+	table, err := sw.BuildSiteTable(sw.MethodFEL, []byte(felSiteResults))
+	if err != nil {
+		t.Fatalf("BuildSiteTable failed: %v", err)
+	}
+
+	expected := []string{"partition", "site", "alpha", "beta", "p-value", "q-value"}
+	if got := columnNames(table); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected columns %v, got %v", expected, got)
+	}
+	if len(table.Rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %d", len(table.Rows))
+	}
+
+	last := table.Rows[2]
+	if last[0] != int64(1) || last[1] != int64(1) || last[2] != 0.5 {
+		t.Errorf("Unexpected row for partition 1, site 1: %v", last)
+	}
+
+	// Benjamini-Hochberg over p = 0.01, 0.04, 0.03
+	for i, expected := range []float64{0.03, 0.04, 0.04} {
+		if q := table.Rows[i][5].(float64); math.Abs(q-expected) > 1e-12 {
+			t.Errorf("Row %d: expected q-value %v, got %v", i, expected, q)
+		}
+	}
+}
+
+// TestBuildSiteTableKeepsQValues tests that methods reporting q-values are not adjusted again
+func TestBuildSiteTableKeepsQValues(t *testing.T) {
+	output := `{"MLE": {
+		"headers": [["beta (background)", ""], ["P-value (overall)", ""], ["Q-value (overall)", ""]],
+		"content": {"0": [[1.0, 0.01, 0.02]]}
+	}}`
+	table, err := sw.BuildSiteTable(sw.MethodCONTRASTFEL, []byte(output))
+	if err != nil {
+		t.Fatalf("BuildSiteTable failed: %v", err)
+	}
+	if len(table.Columns) != 5 {
+		t.Errorf("Expected no added columns, got %v", columnNames(table))
+	}
+}
+
+// TestBuildSiteTableSLAC tests that SLAC's averaged by-site rows are used and its
+// p-values for positive and negative selection are adjusted
+func TestBuildSiteTableSLAC(t *testing.T) {
+	output := `{"MLE": {
+		"headers": [["ES", ""], ["EN", ""], ["P [dN/dS > 1]", ""], ["P [dN/dS < 1]", ""]],
+		"content": {"0": {"by-site": {"AVERAGED": [[1.0, 2.0, 0.5, 0.01], [3.0, 4.0, 0.02, 0.9]], "RESOLVED": [[9.0, 9.0, 9.0, 9.0]]}}}
+	}}`
+	table, err := sw.BuildSiteTable(sw.MethodSLAC, []byte(output))
+	if err != nil {
+		t.Fatalf("BuildSiteTable failed: %v", err)
+	}
+	if len(table.Rows) != 2 || table.Rows[1][2] != 3.0 {
+		t.Errorf("Expected averaged rows, got %v", table.Rows)
+	}
+	expected := []string{"partition", "site", "ES", "EN", "P [dN/dS > 1]", "Q [dN/dS > 1]", "P [dN/dS < 1]", "Q [dN/dS < 1]"}
+	if got := columnNames(table); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected columns %v, got %v", expected, got)
+	}
+	if q := table.Rows[1][5]; q != 0.04 {
+		t.Errorf("Expected q-value 0.04, got %v", q)
+	}
+}
+
+// TestBuildSiteTablePValueColumns tests that only each method's p-value columns are adjusted
+func TestBuildSiteTablePValueColumns(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   sw.HyPhyMethodType
+		headers  string
+		expected []string
+	}{
+		{"meme weights", sw.MethodMEME, `["p-", ""], ["p+", ""], ["p-value", ""]`, []string{"p-", "p+", "p-value", "q-value"}},
+		{"contrast-fel pairs", sw.MethodCONTRASTFEL, `["P-value (overall)", ""], ["Q-value (overall)", ""], ["P-value for background vs TEST", ""]`,
+			[]string{"P-value (overall)", "Q-value (overall)", "P-value for background vs TEST", "Q-value for background vs TEST"}},
+		{"fubar posteriors", sw.MethodFUBAR, `["Prob[alpha<beta]", ""], ["p-value", ""]`, []string{"Prob[alpha<beta]", "p-value"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := `{"MLE": {"headers": [` + tt.headers + `], "content": {"0": [[0.1, 0.2, 0.3]]}}}`
+			table, err := sw.BuildSiteTable(tt.method, []byte(output))
+			if err != nil {
+				t.Fatalf("BuildSiteTable failed: %v", err)
+			}
+			expected := append([]string{"partition", "site"}, tt.expected...)
+			if got := columnNames(table); strings.Join(got, ",") != strings.Join(expected, ",") {
+				t.Errorf("Expected columns %v, got %v", expected, got)
+			}
+		})
+	}
+}
+
+// TestBuildSiteTableFADE tests that FADE rows are split by residue and joined with site annotations
+func TestBuildSiteTableFADE(t *testing.T) {
+	output := `{
+		"MLE": {
+			"headers": [["rate", ""], ["bias", ""], ["Prob[bias>0]", ""], ["BF[bias>0]", ""]],
+			"content": {
+				"A": {"0": [[1.0, 0.1, 0.5, 1.2], [1.0, 0.2, 0.6, 1.5]]},
+				"C": {"0": [[2.0, 0.3, 0.7, 2.0], [2.0, 0.4, 0.8, 3.0]]}
+			}
+		},
+		"site annotations": {
+			"headers": [["Composition", ""], ["Substitutions", ""]],
+			"site annotations": {"0": [["A2C1", "A->C(1)"], ["C3", ""]]}
+		}
+	}`
+	table, err := sw.BuildSiteTable(sw.MethodFADE, []byte(output))
+	if err != nil {
+		t.Fatalf("BuildSiteTable failed: %v", err)
+	}
+
+	expected := []string{"partition", "site", "residue", "rate", "bias", "Prob[bias>0]", "BF[bias>0]", "Composition", "Substitutions"}
+	if got := columnNames(table); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected columns %v, got %v", expected, got)
+	}
+	if len(table.Rows) != 4 {
+		t.Fatalf("Expected 4 rows, got %d", len(table.Rows))
+	}
+	row := table.Rows[1]
+	if row[1] != int64(1) || row[2] != "C" || row[4] != 0.3 || row[7] != "A2C1" {
+		t.Errorf("Unexpected row for site 1, residue C: %v", row)
+	}
+}
+
+// TestBuildSiteTableUnsupported tests methods without per-site estimates
+func TestBuildSiteTableUnsupported(t *testing.T) {
+	if _, err := sw.BuildSiteTable(sw.MethodBUSTED, []byte(`{}`)); !errors.Is(err, sw.ErrResultTableNotSupported) {
+		t.Errorf("Expected ErrResultTableNotSupported, got %v", err)
+	}
+}
+
+// TestBuildBranchTable tests that branch attributes become typed columns
+func TestBuildBranchTable(t *testing.T) {
+	table, err := sw.BuildBranchTable(sw.MethodFEL, []byte(felSiteResults))
+	if err != nil {
+		t.Fatalf("BuildBranchTable failed: %v", err)
+	}
+
+	expected := []string{"partition", "branch", "Global MG94xREV", "Nucleotide GTR", "original name"}
+	if got := columnNames(table); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected columns %v, got %v", expected, got)
+	}
+	if table.Columns[3].Type != sw.ResultColumnFloat || table.Columns[4].Type != sw.ResultColumnString {
+		t.Errorf("Unexpected column types: %v", table.Columns)
+	}
+	if len(table.Rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(table.Rows))
+	}
+	// Node1 has no Global MG94xREV
+	if row := table.Rows[1]; row[1] != "Node1" || row[2] != nil || row[3] != 0.1 {
+		t.Errorf("Unexpected row for Node1: %v", row)
+	}
+
+	if _, err := sw.BuildBranchTable(sw.MethodGARD, []byte(`{"breakpointData": {}}`)); !errors.Is(err, sw.ErrResultTableNotSupported) {
+		t.Errorf("Expected ErrResultTableNotSupported, got %v", err)
+	}
+}
+
+// TestResultTableWriteDelimited tests CSV and TSV output
+func TestResultTableWriteDelimited(t *testing.T) {
+	table, err := sw.BuildBranchTable(sw.MethodFEL, []byte(felSiteResults))
+	if err != nil {
+		t.Fatalf("BuildBranchTable failed: %v", err)
+	}
+
+	var csv bytes.Buffer
+	if err := table.WriteDelimited(&csv, ','); err != nil {
+		t.Fatalf("WriteDelimited failed: %v", err)
+	}
+	expected := "partition,branch,Global MG94xREV,Nucleotide GTR,original name\n" +
+		"0,Human,0.25,0.2,Human\n" +
+		"0,Node1,,0.1,Node1\n"
+	if csv.String() != expected {
+		t.Errorf("Expected CSV:\n%s\ngot:\n%s", expected, csv.String())
+	}
+
+	var tsv bytes.Buffer
+	if err := table.WriteDelimited(&tsv, '\t'); err != nil {
+		t.Fatalf("WriteDelimited failed: %v", err)
+	}
+	if !strings.HasPrefix(tsv.String(), "partition\tbranch\tGlobal MG94xREV") {
+		t.Errorf("Unexpected TSV header: %s", tsv.String())
+	}
+}
+
+// TestResultTableWriteParquet tests the Parquet file layout and footer metadata
+func TestResultTableWriteParquet(t *testing.T) {
+	table, err := sw.BuildSiteTable(sw.MethodFEL, []byte(felSiteResults))
+	if err != nil {
+		t.Fatalf("BuildSiteTable failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := table.WriteParquet(&buf); err != nil {
+		t.Fatalf("WriteParquet failed: %v", err)
+	}
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatal("Expected PAR1 magic at both ends")
+	}
+
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-footerLength : len(data)-8]
+	metadata := (&compactReader{data: footer}).readStruct(t)
+
+	if rows := metadata[3]; rows != int64(3) {
+		t.Errorf("Expected 3 rows, got %v", rows)
+	}
+	schema := metadata[2].([]interface{})
+	if len(schema) != len(table.Columns)+1 {
+		t.Fatalf("Expected %d schema elements, got %d", len(table.Columns)+1, len(schema))
+	}
+	for i, column := range table.Columns {
+		if name := string(schema[i+1].(map[int16]interface{})[4].([]byte)); name != column.Name {
+			t.Errorf("Schema element %d: expected %s, got %s", i+1, column.Name, name)
+		}
+	}
+	rowGroups := metadata[4].([]interface{})
+	chunks := rowGroups[0].(map[int16]interface{})[1].([]interface{})
+	if len(chunks) != len(table.Columns) {
+		t.Errorf("Expected %d column chunks, got %d", len(table.Columns), len(chunks))
+	}
+}
+
+// parquetTestTable is the table stored in testdata/sites.parquet, which was written by the
+// Apache Arrow Go Parquet writer (v18.0.0) without dictionary encoding or compression
+func parquetTestTable() *sw.ResultTable {
+	return &sw.ResultTable{
+		Columns: []sw.ResultColumn{
+			{Name: "partition", Type: sw.ResultColumnInt},
+			{Name: "site", Type: sw.ResultColumnInt},
+			{Name: "P [dN/dS > 1]", Type: sw.ResultColumnFloat},
+			{Name: "Substitutions", Type: sw.ResultColumnString},
+		},
+		Rows: [][]interface{}{
+			{int64(0), int64(1), 0.25, "A->C(1)"},
+			{int64(0), int64(2), nil, nil},
+			{int64(1), int64(1), 1e-300, ""},
+		},
+	}
+}
+
+// TestResultTableParquetGolden tests that the written file has the same schema as a file from an
+// independent Parquet writer and that both decode to the same columns and values, nulls included
+func TestResultTableParquetGolden(t *testing.T) {
+	golden, err := os.ReadFile(filepath.Join("testdata", "sites.parquet"))
+	if err != nil {
+		t.Fatalf("Failed to read golden file: %v", err)
+	}
+	table := parquetTestTable()
+	var buf bytes.Buffer
+	if err := table.WriteParquet(&buf); err != nil {
+		t.Fatalf("WriteParquet failed: %v", err)
+	}
+
+	goldenFields, goldenRows := readParquet(t, golden)
+	fields, rows := readParquet(t, buf.Bytes())
+
+	if len(fields) != len(goldenFields) {
+		t.Fatalf("Expected %d fields as in the golden file, got %d", len(goldenFields), len(fields))
+	}
+	for i := range goldenFields {
+		if fields[i] != goldenFields[i] {
+			t.Errorf("Field %d: expected %+v as in the golden file, got %+v", i, goldenFields[i], fields[i])
+		}
+	}
+	for name, read := range map[string][][]interface{}{"golden file": goldenRows, "written file": rows} {
+		if len(read) != len(table.Rows) {
+			t.Errorf("%s: expected %d rows, got %d", name, len(table.Rows), len(read))
+			continue
+		}
+		for r, row := range table.Rows {
+			for c, cell := range row {
+				if got := read[r][c]; got != cell {
+					t.Errorf("%s: row %d, column %s: expected %v, got %v", name, r, table.Columns[c].Name, cell, got)
+				}
+			}
+		}
+	}
+}
+
+// parquetField is the part of a Parquet schema element that WriteParquet sets
+type parquetField struct {
+	Name       string
+	Type       int64
+	Repetition int64
+	UTF8       bool
+}
+
+// readParquet decodes a flat Parquet file with uncompressed, PLAIN encoded data pages (v1)
+// and returns its fields and rows, with nil for null values
+func readParquet(t *testing.T, data []byte) ([]parquetField, [][]interface{}) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatal("Expected PAR1 magic at both ends")
+	}
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	metadata := (&compactReader{data: data[len(data)-8-footerLength : len(data)-8]}).readStruct(t)
+
+	var fields []parquetField
+	for _, element := range metadata[2].([]interface{})[1:] {
+		element := element.(map[int16]interface{})
+		convertedType, annotated := element[6]
+		fields = append(fields, parquetField{
+			Name:       string(element[4].([]byte)),
+			Type:       element[1].(int64),
+			Repetition: element[3].(int64),
+			UTF8:       annotated && convertedType == int64(0),
+		})
+	}
+
+	rows := make([][]interface{}, metadata[3].(int64))
+	for i := range rows {
+		rows[i] = make([]interface{}, len(fields))
+	}
+	for _, rowGroup := range metadata[4].([]interface{}) {
+		chunks := rowGroup.(map[int16]interface{})[1].([]interface{})
+		if len(chunks) != len(fields) {
+			t.Fatalf("Expected %d column chunks, got %d", len(fields), len(chunks))
+		}
+		for c, chunk := range chunks {
+			chunkMetadata := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+			if codec := chunkMetadata[4]; codec != int64(0) {
+				t.Fatalf("Column %s: expected an uncompressed chunk, got codec %v", fields[c].Name, codec)
+			}
+			if _, ok := chunkMetadata[11]; ok {
+				t.Fatalf("Column %s: unexpected dictionary page", fields[c].Name)
+			}
+			reader := &compactReader{data: data, pos: int(chunkMetadata[9].(int64))}
+			for row := 0; row < int(chunkMetadata[5].(int64)); {
+				header := reader.readStruct(t)
+				if pageType := header[1]; pageType != int64(0) {
+					t.Fatalf("Column %s: expected a data page, got page type %v", fields[c].Name, pageType)
+				}
+				pageHeader := header[5].(map[int16]interface{})
+				if encoding := pageHeader[2]; encoding != int64(0) {
+					t.Fatalf("Column %s: expected PLAIN values, got encoding %v", fields[c].Name, encoding)
+				}
+				page := data[reader.pos : reader.pos+int(header[3].(int64))]
+				reader.pos += len(page)
+
+				count := int(pageHeader[1].(int64))
+				levelsLength := int(binary.LittleEndian.Uint32(page))
+				levels := decodeDefinitionLevels(page[4:4+levelsLength], count)
+				values := page[4+levelsLength:]
+				for _, level := range levels {
+					if level == 1 {
+						var value interface{}
+						value, values = readPlainValue(t, fields[c].Type, values)
+						rows[row][c] = value
+					}
+					row++
+				}
+			}
+		}
+	}
+	return fields, rows
+}
+
+// decodeDefinitionLevels decodes count one-bit levels from the RLE/bit-packing hybrid encoding
+func decodeDefinitionLevels(data []byte, count int) []byte {
+	levels := make([]byte, 0, count)
+	for pos := 0; len(levels) < count && pos < len(data); {
+		header, n := binary.Uvarint(data[pos:])
+		pos += n
+		if header&1 == 1 {
+			for _, packed := range data[pos : pos+int(header>>1)] {
+				for bit := 0; bit < 8; bit++ {
+					levels = append(levels, packed>>bit&1)
+				}
+			}
+			pos += int(header >> 1)
+			continue
+		}
+		for i := 0; i < int(header>>1); i++ {
+			levels = append(levels, data[pos])
+		}
+		pos++
+	}
+	if len(levels) > count {
+		levels = levels[:count]
+	}
+	return levels
+}
+
+// readPlainValue decodes one PLAIN encoded value of a physical type and returns the remaining data
+func readPlainValue(t *testing.T, physicalType int64, data []byte) (interface{}, []byte) {
+	switch physicalType {
+	case 2:
+		return int64(binary.LittleEndian.Uint64(data)), data[8:]
+	case 5:
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), data[8:]
+	case 6:
+		length := int(binary.LittleEndian.Uint32(data))
+		return string(data[4 : 4+length]), data[4+length:]
+	}
+	t.Fatalf("Unexpected physical type %d", physicalType)
+	return nil, nil
+}
+
+// compactReader decodes the subset of the Thrift compact protocol used by Parquet footers and page headers
+type compactReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *compactReader) byte() byte {
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *compactReader) uvarint() uint64 {
+	value, n := binary.Uvarint(r.data[r.pos:])
+	r.pos += n
+	return value
+}
+
+func (r *compactReader) varint() int64 {
+	value, n := binary.Varint(r.data[r.pos:])
+	r.pos += n
+	return value
+}
+
+func (r *compactReader) readValue(t *testing.T, valueType byte) interface{} {
+	switch valueType {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 3:
+		return int64(int8(r.byte()))
+	case 4, 5, 6:
+		return r.varint()
+	case 8:
+		length := int(r.uvarint())
+		value := r.data[r.pos : r.pos+length]
+		r.pos += length
+		return value
+	case 9:
+		header := r.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		values := make([]interface{}, size)
+		for i := range values {
+			values[i] = r.readValue(t, header&0x0f)
+		}
+		return values
+	case 12:
+		return r.readStruct(t)
+	}
+	t.Fatalf("Unexpected thrift type %d at %d", valueType, r.pos)
+	return nil
+}
+
+func (r *compactReader) readStruct(t *testing.T) map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var lastID int16
+	for {
+		header := r.byte()
+		if header == 0 {
+			return fields
+		}
+		id := lastID + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.varint())
+		}
+		lastID = id
+		fields[id] = r.readValue(t, header&0x0f)
+	}
+}
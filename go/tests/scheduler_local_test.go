@@ -0,0 +1,333 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+// shellMethod is a ComputeMethodInterface that runs a shell script
+type shellMethod struct {
+	script string
+}
+
+func (m *shellMethod) GetCommand() sw.Command {
+	return sw.NewCommand("sh", "-c", m.script, "sh")
+}
+
+func (m *shellMethod) ValidateInput(dataset sw.DatasetInterface) error {
+	return nil
+}
+
+func (m *shellMethod) ParseResult(output string) (interface{}, error) {
+	return output, nil
+}
+
+// argvMethod is a ComputeMethodInterface that returns a fixed command
+type argvMethod struct {
+	shellMethod
+	command sw.Command
+}
+
+func (m *argvMethod) GetCommand() sw.Command {
+	return m.command
+}
+
+// newLocalTestJob creates a job that runs the given shell script
+func newLocalTestJob(t *testing.T, dir string, id string, script string, scheduler sw.SchedulerInterface) *sw.BaseJob {
+	t.Helper()
+	return &sw.BaseJob{
+		Id:          id,
+		AlignmentId: "alignment",
+		Scheduler:   scheduler,
+		Method:      &shellMethod{script: script},
+		OutputPath:  filepath.Join(dir, id+"_results.json"),
+		LogPath:     filepath.Join(dir, id+".log"),
+	}
+}
+
+// waitForLocalStatus polls the scheduler until the job reaches the wanted status
+func waitForLocalStatus(t *testing.T, scheduler sw.SchedulerInterface, job sw.JobInterface, want sw.JobStatusValue) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	var status sw.JobStatusValue
+	for time.Now().Before(deadline) {
+		var err error
+		status, err = scheduler.GetStatus(job)
+		if err == nil && status == want {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("Job %s did not reach status %s (last status: %s)", job.GetId(), want, status)
+}
+
+// TestLocalSchedulerExitCodes tests that exit codes are mapped to job statuses
+func TestLocalSchedulerExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+
+	scheduler := sw.NewLocalScheduler(sw.LocalSchedulerConfig{MaxWorkers: 2}, sw.NewSQLiteJobTracker(db.GetDB()))
+	defer scheduler.Shutdown()
+
+	okJob := newLocalTestJob(t, dir, "ok-job", "echo hello from job", scheduler)
+	failJob := newLocalTestJob(t, dir, "fail-job", "echo oops >&2; exit 3", scheduler)
+
+	for _, job := range []*sw.BaseJob{okJob, failJob} {
+		if err := scheduler.Submit(job); err != nil {
+			t.Fatalf("Submit(%s) failed: %v", job.Id, err)
+		}
+	}
+
+	waitForLocalStatus(t, scheduler, okJob, sw.JobStatusComplete)
+	waitForLocalStatus(t, scheduler, failJob, sw.JobStatusFailed)
+
+	logContent, err := os.ReadFile(okJob.LogPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if !strings.Contains(string(logContent), "hello from job") {
+		t.Errorf("Expected stdout in log, got: %s", logContent)
+	}
+
+	logContent, err = os.ReadFile(failJob.LogPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if !strings.Contains(string(logContent), "oops") {
+		t.Errorf("Expected stderr in log, got: %s", logContent)
+	}
+	if !strings.Contains(string(logContent), "exited with status 3") {
+		t.Errorf("Expected exit status in log, got: %s", logContent)
+	}
+}
+
+// TestLocalSchedulerRunsArgv tests that arguments and environment variables reach the
+// program without a shell interpreting them
+func TestLocalSchedulerRunsArgv(t *testing.T) {
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+
+	scheduler := sw.NewLocalScheduler(sw.LocalSchedulerConfig{MaxWorkers: 1}, sw.NewSQLiteJobTracker(db.GetDB()))
+	defer scheduler.Shutdown()
+
+	job := newLocalTestJob(t, dir, "argv-job", "", scheduler)
+	job.Method = &argvMethod{command: sw.NewCommand("printf", "[%s]", "Node 1", "$HOME", "*", "it's;true")}
+	if err := scheduler.Submit(job); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	waitForLocalStatus(t, scheduler, job, sw.JobStatusComplete)
+
+	logContent, err := os.ReadFile(job.LogPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	// printf also gets the --output argument the scheduler appends
+	want := "[Node 1][$HOME][*][it's;true][--output][" + job.OutputPath + "]"
+	if string(logContent) != want {
+		t.Errorf("Expected %q, got %q", want, logContent)
+	}
+
+	// The command's environment is set for the program as given
+	envJob := newLocalTestJob(t, dir, "env-job", "", scheduler)
+	envJob.Method = &argvMethod{command: sw.Command{
+		Args: []string{"sh", "-c", `printf '[%s]' "$LABEL"`, "sh"},
+		Env:  map[string]string{"LABEL": "a b;$(true) 'c'"},
+	}}
+	if err := scheduler.Submit(envJob); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	waitForLocalStatus(t, scheduler, envJob, sw.JobStatusComplete)
+	if logContent, _ := os.ReadFile(envJob.LogPath); string(logContent) != "[a b;$(true) 'c']" {
+		t.Errorf("Expected the environment variable in the log, got %q", logContent)
+	}
+}
+
+// TestLocalSchedulerWorkerPool tests that the number of concurrent jobs is bounded
+func TestLocalSchedulerWorkerPool(t *testing.T) {
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+
+	scheduler := sw.NewLocalScheduler(sw.LocalSchedulerConfig{MaxWorkers: 1}, sw.NewSQLiteJobTracker(db.GetDB()))
+	defer scheduler.Shutdown()
+
+	first := newLocalTestJob(t, dir, "first", "sleep 5", scheduler)
+	second := newLocalTestJob(t, dir, "second", "true", scheduler)
+
+	if err := scheduler.Submit(first); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	waitForLocalStatus(t, scheduler, first, sw.JobStatusRunning)
+
+	if err := scheduler.Submit(second); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if status, _ := scheduler.GetStatus(second); status != sw.JobStatusPending {
+		t.Errorf("Expected second job to wait for a free worker, got status %s", status)
+	}
+
+	// Cancelling the running job frees the worker for the queued one
+	if err := scheduler.Cancel(first); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	waitForLocalStatus(t, scheduler, first, sw.JobStatusCancelled)
+	waitForLocalStatus(t, scheduler, second, sw.JobStatusComplete)
+}
+
+// TestLocalSchedulerCancelQueued tests cancelling a job before it starts
+func TestLocalSchedulerCancelQueued(t *testing.T) {
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+
+	scheduler := sw.NewLocalScheduler(sw.LocalSchedulerConfig{MaxWorkers: 1}, sw.NewSQLiteJobTracker(db.GetDB()))
+	defer scheduler.Shutdown()
+
+	blocker := newLocalTestJob(t, dir, "blocker", "sleep 5", scheduler)
+	queued := newLocalTestJob(t, dir, "queued", "echo should not run", scheduler)
+
+	if err := scheduler.Submit(blocker); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if err := scheduler.Submit(queued); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if err := scheduler.Cancel(queued); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if err := scheduler.Cancel(blocker); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+
+	waitForLocalStatus(t, scheduler, blocker, sw.JobStatusCancelled)
+	time.Sleep(100 * time.Millisecond)
+	if status, _ := scheduler.GetStatus(queued); status != sw.JobStatusCancelled {
+		t.Errorf("Expected queued job to stay cancelled, got %s", status)
+	}
+	if _, err := os.Stat(queued.LogPath); !os.IsNotExist(err) {
+		t.Error("Cancelled queued job should never have started")
+	}
+}
+
+// TestLocalSchedulerRestartRecovery tests that jobs interrupted by shutdown are
+// marked failed or requeued on restart
+func TestLocalSchedulerRestartRecovery(t *testing.T) {
+	tests := []struct {
+		name       string
+		requeue    bool
+		wantStatus sw.JobStatusValue
+	}{
+		{name: "Mark failed", requeue: false, wantStatus: sw.JobStatusFailed},
+		{name: "Requeue", requeue: true, wantStatus: sw.JobStatusComplete},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+			defer cleanup()
+			tracker := sw.NewSQLiteJobTracker(db.GetDB())
+
+			config := sw.LocalSchedulerConfig{
+				MaxWorkers:       1,
+				StateFile:        filepath.Join(dir, "state.json"),
+				RequeueOnRestart: tt.requeue,
+			}
+
+			// A marker file makes the job hang before the restart and finish after it
+			marker := filepath.Join(dir, "marker")
+			if err := os.WriteFile(marker, nil, 0644); err != nil {
+				t.Fatalf("Failed to create marker: %v", err)
+			}
+			script := "while [ -f " + marker + " ]; do sleep 0.05; done"
+
+			scheduler := sw.NewLocalScheduler(config, tracker)
+			job := newLocalTestJob(t, dir, "interrupted", script, scheduler)
+			if err := scheduler.Submit(job); err != nil {
+				t.Fatalf("Submit failed: %v", err)
+			}
+			if err := tracker.StoreJobMetadata(job.Id, "", "", "fel", "running"); err != nil {
+				t.Fatalf("StoreJobMetadata failed: %v", err)
+			}
+			waitForLocalStatus(t, scheduler, job, sw.JobStatusRunning)
+			scheduler.Shutdown()
+
+			if _, err := os.Stat(config.StateFile); err != nil {
+				t.Fatalf("Expected in-flight jobs to be recorded: %v", err)
+			}
+			os.Remove(marker)
+
+			restarted := sw.NewLocalScheduler(config, tracker)
+			defer restarted.Shutdown()
+			job.Scheduler = restarted
+
+			waitForLocalStatus(t, restarted, job, tt.wantStatus)
+			if !tt.requeue {
+				_, _, _, status, err := tracker.GetJobMetadata(job.Id)
+				if err != nil {
+					t.Fatalf("GetJobMetadata failed: %v", err)
+				}
+				if status != string(sw.JobStatusFailed) {
+					t.Errorf("Expected tracked status failed, got %s", status)
+				}
+			}
+			if _, err := os.Stat(config.StateFile); !os.IsNotExist(err) {
+				t.Error("Expected state file to be removed after recovery")
+			}
+		})
+	}
+}
+
+// TestLocalSchedulerFetchesStagedBlobs tests that local jobs download remote datasets
+// before the command runs, and fail if a download does
+func TestLocalSchedulerFetchesStagedBlobs(t *testing.T) {
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	defer cleanup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/alignment" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(">a\nATG\n"))
+	}))
+	defer server.Close()
+
+	scheduler := sw.NewLocalScheduler(sw.LocalSchedulerConfig{MaxWorkers: 1}, sw.NewSQLiteJobTracker(db.GetDB()))
+	defer scheduler.Shutdown()
+
+	staged := sw.StagedBlob{Path: filepath.Join(dir, "staging", "alignment"), URL: server.URL + "/alignment"}
+	job := newLocalTestJob(t, dir, "fetch-job", "", scheduler)
+	job.Method = &argvMethod{command: sw.Command{Args: []string{"sh", "-c", `cat "$1"`, "sh", staged.Path}, Fetch: []sw.StagedBlob{staged}}}
+	missing := newLocalTestJob(t, dir, "missing-job", "", scheduler)
+	missing.Method = &argvMethod{command: sw.Command{
+		Args:  []string{"true"},
+		Fetch: []sw.StagedBlob{{Path: filepath.Join(dir, "staging", "missing"), URL: server.URL + "/missing"}},
+	}}
+
+	for _, j := range []*sw.BaseJob{job, missing} {
+		if err := scheduler.Submit(j); err != nil {
+			t.Fatalf("Submit(%s) failed: %v", j.Id, err)
+		}
+	}
+	waitForLocalStatus(t, scheduler, job, sw.JobStatusComplete)
+	waitForLocalStatus(t, scheduler, missing, sw.JobStatusFailed)
+
+	if logContent, _ := os.ReadFile(job.LogPath); string(logContent) != ">a\nATG\n" {
+		t.Errorf("Expected the job to read the downloaded blob, got %q", logContent)
+	}
+	if logContent, _ := os.ReadFile(missing.LogPath); !strings.Contains(string(logContent), "Failed to download") {
+		t.Errorf("Expected the failed download in the log, got %q", logContent)
+	}
+}
@@ -275,6 +275,18 @@ func (m *MockJobTrackerWithInspection) ListJobsByStatus(statuses []sw.JobStatusV
 	return jobs, nil
 }
 
+func (m *MockJobTrackerWithInspection) StoreJobRequest(jobID string, requestJSON string, command string) error {
+	return nil
+}
+
+func (m *MockJobTrackerWithInspection) GetJobRequest(jobID string) (string, string, error) {
+	return "", "", nil
+}
+
+func (m *MockJobTrackerWithInspection) GetJobDatasetVersions(jobID string) (int, int, error) {
+	return 0, 0, nil
+}
+
 // MockMethod is a mock implementation for testing
 type MockMethod struct {
 	command string
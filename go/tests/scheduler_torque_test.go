@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+// installFakeTorque writes fake qsub, qstat and qdel binaries to a directory on PATH.
+// qsub copies the submitted script to submitted.pbs, qstat prints qstat.out (or an
+// "Unknown Job Id" error if it does not exist) and qdel records its argument.
+func installFakeTorque(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	scripts := map[string]string{
+		"qsub": "#!/bin/sh\ncp \"$1\" " + dir + "/submitted.pbs\necho 4242.torque-server\n",
+		"qstat": "#!/bin/sh\necho \"$@\" > " + dir + "/qstat.args\n" +
+			"if [ -f " + dir + "/qstat.out ]; then cat " + dir + "/qstat.out; exit 0; fi\n" +
+			"echo \"qstat: Unknown Job Id $2\" >&2\nexit 153\n",
+		"qdel": "#!/bin/sh\necho \"$@\" > " + dir + "/qdel.args\n",
+	}
+	for name, content := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+			t.Fatalf("Failed to write fake %s: %v", name, err)
+		}
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+// setQstatOutput sets the output of the fake qstat
+func setQstatOutput(t *testing.T, dir string, output string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "qstat.out"), []byte(output), 0644); err != nil {
+		t.Fatalf("Failed to write qstat output: %v", err)
+	}
+}
+
+// readFakeFile reads a file written by the fake Torque binaries
+func readFakeFile(t *testing.T, dir string, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// TestTorqueSchedulerSubmit tests that submission writes the PBS script and stores the PBS job ID
+func TestTorqueSchedulerSubmit(t *testing.T) {
+	fakeDir := installFakeTorque(t)
+	tracker := &MockJobTrackerWithInspection{mappings: make(map[string]string)}
+
+	scheduler := sw.NewTorqueScheduler(sw.TorqueConfig{
+		Queue:         "batch",
+		MemoryPerNode: "4gb",
+		AccountName:   "hyphy",
+	}, tracker)
+
+	baseJob := &sw.BaseJob{
+		Id:          "job-hash",
+		AlignmentId: "alignment",
+		Scheduler:   scheduler,
+		Method: &argvMethod{command: sw.Command{
+			Args: []string{"hyphy", "fel", "--alignment", "it's.fasta"},
+			Env:  map[string]string{"LABEL": "a b"},
+		}},
+		OutputPath: "/data/job-hash_results.json",
+		LogPath:    "/data/job logs/job-hash.log",
+		Metadata: map[string]interface{}{
+			"torque_cores_per_node": 8,
+			"torque_wall_time":      "12:00:00",
+		},
+	}
+
+	// HyPhyJob must be accepted as well as BaseJob
+	job := &sw.HyPhyJob{BaseJob: baseJob}
+	if err := scheduler.Submit(job); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	if got := tracker.mappings["job-hash"]; got != "4242.torque-server" {
+		t.Errorf("Expected PBS job ID to be tracked, got %q", got)
+	}
+
+	script := readFakeFile(t, fakeDir, "submitted.pbs")
+	for _, want := range []string{
+		"#PBS -N job-hash",
+		"#PBS -q batch",
+		"#PBS -l nodes=1:ppn=8",
+		"#PBS -l mem=4gb",
+		"#PBS -l walltime=12:00:00",
+		"#PBS -o '/data/job logs/job-hash.log'",
+		"#PBS -A hyphy",
+		`LABEL='a b' hyphy fel --alignment 'it'\''s.fasta' --output /data/job-hash_results.json`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected PBS script to contain %q, got:\n%s", want, script)
+		}
+	}
+}
+
+// TestTorqueSchedulerGetStatus tests mapping of qstat job states to job statuses
+func TestTorqueSchedulerGetStatus(t *testing.T) {
+	fakeDir := installFakeTorque(t)
+	tracker := &MockJobTrackerWithInspection{mappings: map[string]string{"job-hash": "4242.torque-server"}}
+	scheduler := sw.NewTorqueScheduler(sw.TorqueConfig{}, tracker)
+
+	outputPath := filepath.Join(t.TempDir(), "results.json")
+	job := &sw.BaseJob{Id: "job-hash", OutputPath: outputPath}
+
+	tests := []struct {
+		name   string
+		qstat  string
+		status sw.JobStatusValue
+	}{
+		{"Queued", "    job_state = Q\n", sw.JobStatusPending},
+		{"Held", "    job_state = H\n", sw.JobStatusPending},
+		{"Running", "    job_state = R\n", sw.JobStatusRunning},
+		{"Exiting", "    job_state = E\n", sw.JobStatusRunning},
+		{"Completed", "    job_state = C\n    exit_status = 0\n", sw.JobStatusComplete},
+		{"Completed with error", "    job_state = C\n    exit_status = 2\n", sw.JobStatusFailed},
+		{"Killed", "    job_state = C\n    exit_status = 271\n", sw.JobStatusCancelled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setQstatOutput(t, fakeDir, "Job Id: 4242.torque-server\n"+tt.qstat)
+			status, err := scheduler.GetStatus(job)
+			if err != nil {
+				t.Fatalf("GetStatus failed: %v", err)
+			}
+			if status != tt.status {
+				t.Errorf("Expected status %s, got %s", tt.status, status)
+			}
+		})
+	}
+
+	if args := readFakeFile(t, fakeDir, "qstat.args"); args != "-f 4242.torque-server" {
+		t.Errorf("Expected qstat to be queried by PBS job ID, got %q", args)
+	}
+
+	// Purged jobs fall back to checking the output file
+	os.Remove(filepath.Join(fakeDir, "qstat.out"))
+	if status, err := scheduler.GetStatus(job); err != nil || status != sw.JobStatusFailed {
+		t.Errorf("Expected failed for purged job without output, got %s (%v)", status, err)
+	}
+	if err := os.WriteFile(outputPath, []byte("{}"), 0644); err != nil {
+		t.Fatalf("Failed to write output: %v", err)
+	}
+	if status, err := scheduler.GetStatus(job); err != nil || status != sw.JobStatusComplete {
+		t.Errorf("Expected complete for purged job with output, got %s (%v)", status, err)
+	}
+}
+
+// TestTorqueSchedulerCancel tests that cancellation uses the PBS job ID
+func TestTorqueSchedulerCancel(t *testing.T) {
+	fakeDir := installFakeTorque(t)
+	tracker := &MockJobTrackerWithInspection{mappings: map[string]string{"job-hash": "4242.torque-server"}}
+	scheduler := sw.NewTorqueScheduler(sw.TorqueConfig{}, tracker)
+
+	if err := scheduler.Cancel(&sw.BaseJob{Id: "job-hash"}); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+
+	if args := readFakeFile(t, fakeDir, "qdel.args"); args != "4242.torque-server" {
+		t.Errorf("Expected qdel to be called with PBS job ID, got %q", args)
+	}
+}
+
+// TestTorqueSchedulerJobConfig tests that scheduler defaults are overridden by job metadata
+func TestTorqueSchedulerJobConfig(t *testing.T) {
+	scheduler := sw.NewTorqueScheduler(sw.TorqueConfig{
+		NodeCount:     2,
+		CoresPerNode:  4,
+		MemoryPerNode: "8gb",
+		WallTime:      "24:00:00",
+	}, &MockJobTracker{})
+
+	config := scheduler.GetJobConfig(&sw.BaseJob{})
+	if config.NodeCount != 2 || config.CoresPerNode != 4 || config.MemoryPerNode != "8gb" || config.WallTime != "24:00:00" {
+		t.Errorf("Expected scheduler defaults, got %+v", config)
+	}
+
+	config = scheduler.GetJobConfig(&sw.BaseJob{Metadata: map[string]interface{}{
+		"torque_node_count":      1,
+		"torque_memory_per_node": "16gb",
+	}})
+	if config.NodeCount != 1 || config.CoresPerNode != 4 || config.MemoryPerNode != "16gb" {
+		t.Errorf("Expected metadata overrides, got %+v", config)
+	}
+}
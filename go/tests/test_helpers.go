@@ -1,10 +1,16 @@
 package tests
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 
 	sw "github.com/d-callan/service-datamonkey/go"
+	"github.com/gin-gonic/gin"
 )
 
 // setupTestDB creates a temporary unified database for testing
@@ -40,6 +46,85 @@ func createTestSession(t *testing.T, db *sw.UnifiedDB) string {
 	return session.Subject
 }
 
+// apiTestEnv is what the API test fixtures are built on: a test database, a session
+// service, a dataset tracker and a user with a token. Fixtures add their handlers to router.
+type apiTestEnv struct {
+	dir      string
+	db       *sw.UnifiedDB
+	session  *sw.SessionService
+	datasets *sw.SQLiteDatasetTracker
+	owner    string
+	token    string
+	router   *gin.Engine
+}
+
+// setupAPITestEnv creates an apiTestEnv that is removed when the test ends
+func setupAPITestEnv(t *testing.T) *apiTestEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	db, cleanup := setupTestDB(t, filepath.Join(dir, "test.db"))
+	t.Cleanup(cleanup)
+	keyPath := filepath.Join(dir, "jwt.key")
+	if err := os.WriteFile(keyPath, []byte("test-secret-key-for-jwt-testing-12345"), 0600); err != nil {
+		t.Fatalf("Failed to write test key: %v", err)
+	}
+
+	dataDir := filepath.Join(dir, "uploads")
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		t.Fatalf("Failed to create data dir: %v", err)
+	}
+
+	e := &apiTestEnv{
+		dir:      dir,
+		db:       db,
+		session:  sw.NewSessionService(sw.TokenConfig{KeyPath: keyPath}, sw.NewSQLiteSessionTracker(db.GetDB())),
+		datasets: sw.NewSQLiteDatasetTracker(db.GetDB(), dataDir),
+		router:   gin.New(),
+	}
+	e.owner, e.token = e.newUser(t)
+	return e
+}
+
+// newUser creates a session and returns its subject and a token for it
+func (e *apiTestEnv) newUser(t *testing.T) (string, string) {
+	t.Helper()
+	subject := createTestSession(t, e.db)
+	token, err := e.session.GenerateUserToken(subject)
+	if err != nil {
+		t.Fatalf("GenerateUserToken failed: %v", err)
+	}
+	return subject, token
+}
+
+// storeDataset stores a dataset owned by the user, with its content on disk, and returns its ID
+func (e *apiTestEnv) storeDataset(t *testing.T, datasetType string, content string) string {
+	t.Helper()
+	dataset := sw.NewBaseDataset(sw.DatasetMetadata{Name: datasetType, Type: datasetType}, []byte(content))
+	if err := e.datasets.StoreWithUser(dataset, e.owner); err != nil {
+		t.Fatalf("StoreWithUser failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(e.datasets.GetDatasetDir(), dataset.GetContentHash()), dataset.Content, 0644); err != nil {
+		t.Fatalf("Failed to write dataset: %v", err)
+	}
+	return dataset.GetId()
+}
+
+// post sends a JSON request with a user token and decodes the JSON response
+func (e *apiTestEnv) post(t *testing.T, path string, body string, token string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("user_token", token)
+	w := httptest.NewRecorder()
+	e.router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
 // MockJobTracker is a simple mock implementation of JobTracker for testing
 type MockJobTracker struct{}
 
@@ -98,3 +183,15 @@ func (m *MockJobTracker) GetJobMetadata(jobID string) (string, string, string, s
 func (m *MockJobTracker) ListJobsByStatus(statuses []sw.JobStatusValue) ([]sw.JobInfo, error) {
 	return []sw.JobInfo{}, nil
 }
+
+func (m *MockJobTracker) StoreJobRequest(jobID string, requestJSON string, command string) error {
+	return nil
+}
+
+func (m *MockJobTracker) GetJobRequest(jobID string) (string, string, error) {
+	return "", "", nil
+}
+
+func (m *MockJobTracker) GetJobDatasetVersions(jobID string) (int, int, error) {
+	return 0, 0, nil
+}
@@ -53,7 +53,7 @@ func TestNewTokenService(t *testing.T) {
 				Username: "testuser",
 			},
 			wantRefreshInterval: 12 * time.Hour,
-			wantExpirationSecs:  86400,
+			wantExpirationSecs:  900,
 		},
 		{
 			name: "Custom values",
@@ -522,6 +522,18 @@ func (m *mockJobTracker) ListJobsByStatus(statuses []sw.JobStatusValue) ([]sw.Jo
 	return []sw.JobInfo{}, nil
 }
 
+func (m *mockJobTracker) StoreJobRequest(jobID string, requestJSON string, command string) error {
+	return nil
+}
+
+func (m *mockJobTracker) GetJobRequest(jobID string) (string, string, error) {
+	return "", "", nil
+}
+
+func (m *mockJobTracker) GetJobDatasetVersions(jobID string) (int, int, error) {
+	return 0, 0, nil
+}
+
 // TestCheckJobAccess tests job access verification
 func TestCheckJobAccess(t *testing.T) {
 	keyPath, cleanup := setupTestKey(t)
@@ -750,6 +762,10 @@ func (m *mockDatasetTracker) ListByUser(userID string) ([]sw.DatasetInterface, e
 	return nil, nil
 }
 
+func (m *mockDatasetTracker) ListByUserWithFilters(userID string, filters map[string]interface{}) ([]sw.DatasetInterface, int, error) {
+	return nil, 0, nil
+}
+
 func (m *mockDatasetTracker) DeleteByUser(datasetID string, userID string) error {
 	return nil
 }
@@ -762,6 +778,14 @@ func (m *mockDatasetTracker) GetDatasetDir() string {
 	return ""
 }
 
+func (m *mockDatasetTracker) GetBlobStore() sw.BlobStore {
+	return nil
+}
+
+func (m *mockDatasetTracker) StoreContent(contentHash string, sourcePath string) (bool, error) {
+	return false, nil
+}
+
 func (m *mockDatasetTracker) Update(id string, updates map[string]interface{}) error {
 	return nil
 }
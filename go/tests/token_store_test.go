@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+// setupTokenStore returns a token store and a session to issue tokens for
+func setupTokenStore(t *testing.T) (*sw.SQLiteTokenStore, string) {
+	t.Helper()
+	db, cleanup := setupTestDB(t, filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(cleanup)
+	session, err := sw.NewSQLiteSessionTracker(db.GetDB()).CreateSession()
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	return sw.NewSQLiteTokenStore(db.GetDB()), session.Subject
+}
+
+// TestRefreshTokenRotation tests that refresh tokens work once and keep their identity
+func TestRefreshTokenRotation(t *testing.T) {
+	store, subject := setupTokenStore(t)
+	expiresAt := time.Now().Add(time.Hour)
+
+	first, err := store.CreateRefreshToken(sw.RefreshGrant{Subject: subject, ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken failed: %v", err)
+	}
+	second, grant, err := store.RotateRefreshToken(first, expiresAt)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken failed: %v", err)
+	}
+	if second == first || grant.Subject != subject || grant.AccountID != "" {
+		t.Errorf("Expected a new token for subject %s, got %s, %+v", subject, second, grant)
+	}
+	third, _, err := store.RotateRefreshToken(second, expiresAt)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken failed: %v", err)
+	}
+
+	// Reusing a token revokes the whole family, including the newest token
+	if _, _, err := store.RotateRefreshToken(first, expiresAt); !errors.Is(err, sw.ErrRefreshTokenReused) {
+		t.Errorf("Expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, _, err := store.RotateRefreshToken(third, expiresAt); !errors.Is(err, sw.ErrRefreshTokenInvalid) {
+		t.Errorf("Expected the family to be revoked, got %v", err)
+	}
+	if _, _, err := store.RotateRefreshToken("unknown", expiresAt); !errors.Is(err, sw.ErrRefreshTokenInvalid) {
+		t.Errorf("Expected ErrRefreshTokenInvalid for an unknown token, got %v", err)
+	}
+}
+
+// TestRefreshTokenRevocation tests revoking and expiring refresh tokens
+func TestRefreshTokenRevocation(t *testing.T) {
+	store, subject := setupTokenStore(t)
+	expiresAt := time.Now().Add(time.Hour)
+
+	token, _ := store.CreateRefreshToken(sw.RefreshGrant{Subject: subject, ExpiresAt: expiresAt})
+	other, _ := store.CreateRefreshToken(sw.RefreshGrant{Subject: subject, ExpiresAt: expiresAt})
+	if err := store.RevokeRefreshToken(token); err != nil {
+		t.Fatalf("RevokeRefreshToken failed: %v", err)
+	}
+	if _, _, err := store.RotateRefreshToken(token, expiresAt); !errors.Is(err, sw.ErrRefreshTokenInvalid) {
+		t.Errorf("Expected a revoked token to be invalid, got %v", err)
+	}
+	if _, _, err := store.RotateRefreshToken(other, expiresAt); err != nil {
+		t.Errorf("Expected other families to stay valid: %v", err)
+	}
+
+	expired, _ := store.CreateRefreshToken(sw.RefreshGrant{Subject: subject, ExpiresAt: time.Now().Add(-time.Minute)})
+	if _, _, err := store.RotateRefreshToken(expired, expiresAt); !errors.Is(err, sw.ErrRefreshTokenInvalid) {
+		t.Errorf("Expected an expired token to be invalid, got %v", err)
+	}
+}
+
+// TestAccessTokenRevocation tests the revocation list and its cleanup
+func TestAccessTokenRevocation(t *testing.T) {
+	store, subject := setupTokenStore(t)
+
+	if err := store.RevokeAccessToken("jti-current", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("RevokeAccessToken failed: %v", err)
+	}
+	if err := store.RevokeAccessToken("jti-expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("RevokeAccessToken failed: %v", err)
+	}
+	if revoked, err := store.IsAccessTokenRevoked("jti-current"); err != nil || !revoked {
+		t.Errorf("Expected jti-current to be revoked, got %v, %v", revoked, err)
+	}
+	if revoked, _ := store.IsAccessTokenRevoked("jti-other"); revoked {
+		t.Errorf("Expected jti-other not to be revoked")
+	}
+
+	store.CreateRefreshToken(sw.RefreshGrant{Subject: subject, ExpiresAt: time.Now().Add(-time.Minute)})
+	store.CreateRefreshToken(sw.RefreshGrant{Subject: subject, ExpiresAt: time.Now().Add(time.Hour)})
+	count, err := store.CleanupExpiredTokens()
+	if err != nil {
+		t.Fatalf("CleanupExpiredTokens failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 expired tokens to be removed, got %d", count)
+	}
+	if revoked, _ := store.IsAccessTokenRevoked("jti-current"); !revoked {
+		t.Errorf("Expected unexpired revocations to be kept")
+	}
+}
@@ -0,0 +1,255 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+// TestParseTreeFormats tests Newick and NEXUS trees, rooting and HyPhy branch labels
+func TestParseTreeFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		content string
+		rooted  bool
+		labels  []string
+	}{
+		{"unrooted newick", sw.TreeFormatNewick, "(human:0.1,chimp:0.2,(gorilla:0.3,orangutan:0.4)0.95:0.05);\n", false, []string{}},
+		{"rooted newick", sw.TreeFormatNewick, "((human,chimp),(gorilla,orangutan));", true, []string{}},
+		{"forced unrooted", sw.TreeFormatNewick, "[&U] ((human,chimp),(gorilla,orangutan));", false, []string{}},
+		{
+			"hyphy labels", sw.TreeFormatNewick,
+			"(human{Foreground}:0.1,chimp:0.2,(gorilla:0.3{Foreground},orangutan:0.4){Test}:0.05);",
+			false, []string{"Foreground", "Test"},
+		},
+		{"quoted names", sw.TreeFormatNewick, "('human':1,'chimp':1,(\"gorilla\":1,orangutan:1):1);", false, []string{}},
+		{"nexus with translate", sw.TreeFormatNEXUS, `#NEXUS
+BEGIN TREES;
+	TRANSLATE
+		1 human,
+		2 chimp,
+		3 'gorilla',
+		4 orangutan
+	;
+	TREE best = [&R] ((1:0.1,2:0.2):0.1,(3{Foreground}:0.3,4:0.4):0.1);
+END;
+`, true, []string{"Foreground"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := sw.ParseTree([]byte(tt.content))
+			if err != nil {
+				t.Fatalf("ParseTree failed: %v", err)
+			}
+			if tree.Format != tt.format {
+				t.Errorf("Format = %q, want %q", tree.Format, tt.format)
+			}
+			if tree.Rooted != tt.rooted {
+				t.Errorf("Rooted = %v, want %v", tree.Rooted, tt.rooted)
+			}
+			leaves := tree.Leaves()
+			want := []string{"human", "chimp", "gorilla", "orangutan"}
+			if !reflect.DeepEqual(leaves, want) {
+				t.Errorf("Leaves = %v, want %v", leaves, want)
+			}
+			if labels := tree.BranchLabels(); !reflect.DeepEqual(labels, tt.labels) {
+				t.Errorf("BranchLabels = %v, want %v", labels, tt.labels)
+			}
+
+			stats := tree.Stats()
+			if stats.LeafCount != 4 {
+				t.Errorf("LeafCount = %d, want 4", stats.LeafCount)
+			}
+		})
+	}
+}
+
+// TestParseTreeErrors tests that malformed trees are reported with the offending line
+func TestParseTreeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		line    int
+		message string
+	}{
+		{"unclosed parenthesis", "(human,\n(chimp,gorilla)", 1, "never closed"},
+		{"unexpected character", "(human,\n(chimp,gorilla);", 2, "expected ',' or ')'"},
+		{"missing leaf name", "(human,,chimp);", 1, "leaf has no name"},
+		{"bad branch length", "(human:0.1,\nchimp:abc);", 2, "invalid branch length"},
+		{"trailing text", "(human,chimp);\n(gorilla,orangutan);", 2, "after the end of the tree"},
+		{"nexus without tree", "#NEXUS\nBEGIN TREES;\nEND;\n", 2, "no TREE command"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sw.ParseTree([]byte(tt.content))
+			var treeErr *sw.TreeError
+			if !errors.As(err, &treeErr) {
+				t.Fatalf("Expected a TreeError, got %v", err)
+			}
+			if treeErr.Line != tt.line {
+				t.Errorf("Line = %d, want %d (%v)", treeErr.Line, tt.line, err)
+			}
+			if !strings.Contains(treeErr.Message, tt.message) {
+				t.Errorf("Message = %q, should contain %q", treeErr.Message, tt.message)
+			}
+		})
+	}
+}
+
+// TestCheckTree tests which uploads are parsed as trees
+func TestCheckTree(t *testing.T) {
+	stats, err := sw.CheckTree("newick", []byte("((a,b),c,d{FG});"))
+	if err != nil || stats == nil {
+		t.Fatalf("CheckTree failed: %v", err)
+	}
+	if stats.LeafCount != 4 || stats.InternalNodeCount != 2 || stats.Rooted || !reflect.DeepEqual(stats.BranchLabels, []string{"FG"}) {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if stats, err := sw.CheckTree("newick", []byte("((a,b,c),d,e,f);")); err != nil || stats.PolytomyCount != 2 {
+		t.Errorf("Expected the internal node and root to be counted as polytomies, got %+v, %v", stats, err)
+	}
+
+	if stats, err := sw.CheckTree("fasta", []byte(">a\nACGT\n")); err != nil || stats != nil {
+		t.Errorf("Alignments should be skipped, got %+v, %v", stats, err)
+	}
+	if _, err := sw.CheckTree("nwk", []byte("not a tree")); err == nil {
+		t.Error("Expected an error for a tree dataset that is not a tree")
+	}
+}
+
+// TestCheckTreeFile tests that checking a file as a stream matches checking its content
+func TestCheckTreeFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"newick", "((a,b),c,d{FG});"},
+		{"nexus with translate", "#NEXUS\nBEGIN TAXA;\nDIMENSIONS NTAX=3;\nEND;\nBEGIN TREES;\n\tTRANSLATE 1 a, 2 'b;c', 3 c;\n\tTREE t = [&R] ((1,2)[;],\n3);\nEND;\n"},
+		{"nexus without trees", "#NEXUS\nBEGIN DATA;\nDIMENSIONS NTAX=1 NCHAR=3;\nMATRIX\na ATG\n;\nEND;\n"},
+		{"alignment", ">a\nACGT\n"},
+		{"malformed newick", "(human,\n(chimp,gorilla);"},
+		{"malformed nexus", "#NEXUS\n\nBEGIN TREES;\nTRANSLATE 1 a b;\nTREE t = (1,2);\nEND;\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "upload")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write upload: %v", err)
+			}
+			want, wantErr := sw.CheckTree("nexus", []byte(tt.content))
+			got, err := sw.CheckTreeFile("nexus", path)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("CheckTreeFile = %+v, CheckTree = %+v", got, want)
+			}
+			if fmt.Sprint(err) != fmt.Sprint(wantErr) {
+				t.Errorf("CheckTreeFile error = %v, CheckTree error = %v", err, wantErr)
+			}
+		})
+	}
+}
+
+// TestCheckTreeAlignment tests the differences reported between a tree and an alignment
+func TestCheckTreeAlignment(t *testing.T) {
+	alignment, err := sw.ParseAlignment([]byte(">a\nATG\n>b\nATG\n>c\nATG\n>d\nATG\n"))
+	if err != nil {
+		t.Fatalf("ParseAlignment failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		tree     string
+		problems []string
+	}{
+		{"matching", "((a,b),c,d);", nil},
+		{"matching rooted", "((a,b),(c,d));", nil},
+		{"missing and extra taxa", "((a,b),c,e);", []string{`1 tree leaves are not sequences in the alignment: "e"`, `1 alignment sequences are not leaves of the tree: "d"`}},
+		{"duplicate labels", "((a,b),c,d,(a,b));", []string{`duplicate labels: "a", "b"`}},
+		{"multifurcating", "((a,b,c),d);", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := sw.ParseTree([]byte(tt.tree))
+			if err != nil {
+				t.Fatalf("ParseTree failed: %v", err)
+			}
+			err = sw.CheckTreeAlignment(tree, alignment)
+			if tt.problems == nil {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+			var treeErr *sw.TreeValidationError
+			if !errors.As(err, &treeErr) {
+				t.Fatalf("Expected a TreeValidationError, got %v", err)
+			}
+			if len(treeErr.Problems) != len(tt.problems) {
+				t.Fatalf("Expected %d problems, got %+v", len(tt.problems), treeErr.Problems)
+			}
+			for i, want := range tt.problems {
+				if !strings.Contains(treeErr.Problems[i].Message, want) {
+					t.Errorf("Problem %d = %q, should contain %q", i, treeErr.Problems[i].Message, want)
+				}
+			}
+		})
+	}
+}
+
+// jobStartFixture holds the FEL start endpoint wired to a test database
+type jobStartFixture struct {
+	*apiTestEnv
+	tracker     *sw.SQLiteJobTracker
+	scheduler   *submitScheduler
+	alignmentID string
+}
+
+func setupJobStartFixture(t *testing.T) *jobStartFixture {
+	t.Helper()
+	f := &jobStartFixture{apiTestEnv: setupAPITestEnv(t)}
+	f.tracker = sw.NewSQLiteJobTracker(f.db.GetDB())
+	f.scheduler = &submitScheduler{tracker: f.tracker, statuses: map[string]sw.JobStatusValue{}}
+	f.alignmentID = f.storeDataset(t, "fasta", ">a\nATGATG\n>b\nATGATA\n")
+
+	felAPI := sw.NewFELAPI(filepath.Join(f.dir, "output"), "hyphy", f.scheduler, f.datasets, f.tracker)
+	felAPI.SessionService = f.session
+	f.router.POST("/api/v1/methods/fel-start", felAPI.StartFELJob)
+	return f
+}
+
+// TestStartJobChecksTree tests that jobs with a tree that does not match the alignment are rejected
+func TestStartJobChecksTree(t *testing.T) {
+	f := setupJobStartFixture(t)
+
+	mismatched := f.storeDataset(t, "newick", "(a,c,d);")
+	code, response := f.post(t, "/api/v1/methods/fel-start",
+		fmt.Sprintf(`{"alignment":%q,"tree":%q}`, f.alignmentID, mismatched), f.token)
+	if code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a mismatched tree, got %d: %v", code, response)
+	}
+	problems, _ := response["errors"].([]interface{})
+	if len(problems) != 2 {
+		t.Fatalf("Expected the missing and extra taxa to be listed, got %v", response)
+	}
+	if len(f.scheduler.submitted) != 0 {
+		t.Errorf("Expected no job to be submitted, got %v", f.scheduler.submitted)
+	}
+
+	matching := f.storeDataset(t, "newick", "(a:0.1,b:0.2);")
+	code, response = f.post(t, "/api/v1/methods/fel-start",
+		fmt.Sprintf(`{"alignment":%q,"tree":%q}`, f.alignmentID, matching), f.token)
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 for a matching tree, got %d: %v", code, response)
+	}
+}
@@ -1,10 +1,13 @@
 package tests
 
 import (
+	"database/sql"
 	"os"
+	"path/filepath"
 	"testing"
 
 	sw "github.com/d-callan/service-datamonkey/go"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 func TestUnifiedDB_Creation(t *testing.T) {
@@ -234,3 +237,164 @@ func TestUnifiedDB_VisualizationCascadeDelete(t *testing.T) {
 		t.Error("Job was not cascade deleted when session was deleted")
 	}
 }
+
+// openV3Database creates a database with migrations 1 to 3 applied, as written by
+// releases before dataset contents were shared between datasets and jobs
+func openV3Database(t *testing.T, dbPath string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	)`); err != nil {
+		t.Fatalf("Failed to create migrations table: %v", err)
+	}
+	for _, migration := range sw.GetMigrations() {
+		if migration.Version > 3 {
+			continue
+		}
+		if _, err := db.Exec(migration.Up); err != nil {
+			t.Fatalf("Failed to apply migration %d: %v", migration.Version, err)
+		}
+		if _, err := db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+			migration.Version, migration.Name, 1000000); err != nil {
+			t.Fatalf("Failed to record migration %d: %v", migration.Version, err)
+		}
+	}
+	return db
+}
+
+func TestUnifiedDB_MigratePopulatedV3(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "v3.db")
+
+	v3 := openV3Database(t, dbPath)
+	statements := []string{
+		`INSERT INTO sessions (subject, created_at, last_seen) VALUES ('user-1', 1000000, 1000000)`,
+		`INSERT INTO sessions (subject, created_at, last_seen) VALUES ('user-2', 1000000, 1000000)`,
+		`INSERT INTO datasets (id, user_id, metadata_name, metadata_type, metadata_created, metadata_updated, content_hash, data_json)
+			VALUES ('aln-1', 'user-1', 'Alignment', 'fasta', 1000000, 1000000, 'hash-aln', '{}')`,
+		`INSERT INTO datasets (id, user_id, metadata_name, metadata_type, metadata_created, metadata_updated, content_hash, data_json)
+			VALUES ('tree-1', 'user-1', 'Tree', 'nwk', 1000000, 1000000, 'hash-tree', '{}')`,
+		`INSERT INTO datasets (id, user_id, metadata_name, metadata_type, metadata_created, metadata_updated, content_hash, data_json)
+			VALUES ('aln-2', 'user-2', 'Same alignment', 'fasta', 1000000, 1000000, 'hash-aln', '{}')`,
+		`INSERT INTO jobs (job_id, scheduler_job_id, user_id, alignment_id, tree_id, method_type, status, created_at, updated_at, request_json, command)
+			VALUES ('job-1', 'sched-1', 'user-1', 'aln-1', 'tree-1', 'fel', 'completed', 1000000, 1000000, '{}', 'hyphy fel')`,
+		`INSERT INTO jobs (job_id, scheduler_job_id, user_id, alignment_id, method_type, status, created_at, updated_at)
+			VALUES ('job-2', 'sched-2', 'user-2', 'aln-2', 'slac', 'pending', 1000000, 1000000)`,
+		`INSERT INTO visualizations (viz_id, job_id, dataset_id, user_id, title, spec, created_at, updated_at)
+			VALUES ('viz-1', 'job-1', 'aln-1', 'user-1', 'By dataset', '{}', 1000000, 1000000)`,
+		`INSERT INTO visualizations (viz_id, job_id, user_id, title, spec, created_at, updated_at)
+			VALUES ('viz-2', 'job-1', 'user-1', 'By job', '{}', 1000000, 1000000)`,
+		`INSERT INTO visualizations (viz_id, job_id, dataset_id, user_id, title, spec, created_at, updated_at)
+			VALUES ('viz-3', 'job-2', 'aln-2', 'user-2', 'Other user', '{}', 1000000, 1000000)`,
+	}
+	for _, statement := range statements {
+		if _, err := v3.Exec(statement); err != nil {
+			t.Fatalf("Failed to populate v3 database: %v", err)
+		}
+	}
+	v3.Close()
+
+	db, err := sw.NewUnifiedDB(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to migrate v3 database: %v", err)
+	}
+	defer db.Close()
+	conn := db.GetDB()
+
+	// Every row survives the rebuild of jobs and visualizations
+	counts := map[string]int{"sessions": 2, "datasets": 3, "jobs": 2, "visualizations": 3}
+	for table, want := range counts {
+		var count int
+		if err := conn.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+			t.Fatalf("Failed to count %s: %v", table, err)
+		}
+		if count != want {
+			t.Errorf("Expected %d rows in %s after migrating, got %d", want, table, count)
+		}
+	}
+
+	var alignmentHash, treeHash sql.NullString
+	if err := conn.QueryRow("SELECT alignment_hash, tree_hash FROM jobs WHERE job_id = 'job-1'").Scan(&alignmentHash, &treeHash); err != nil {
+		t.Fatalf("Failed to read job hashes: %v", err)
+	}
+	if alignmentHash.String != "hash-aln" || treeHash.String != "hash-tree" {
+		t.Errorf("Expected job hashes hash-aln and hash-tree, got %q and %q", alignmentHash.String, treeHash.String)
+	}
+
+	var refCount int
+	if err := conn.QueryRow("SELECT ref_count FROM blobs WHERE content_hash = 'hash-aln'").Scan(&refCount); err != nil {
+		t.Fatalf("Failed to read blob: %v", err)
+	}
+	if refCount != 4 {
+		t.Errorf("Expected hash-aln to be referenced by 2 datasets and 2 jobs, got %d", refCount)
+	}
+
+	// No rows point at missing parents
+	rows, err := conn.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		t.Fatalf("Failed to check foreign keys: %v", err)
+	}
+	if rows.Next() {
+		t.Error("Migrated database has foreign key violations")
+	}
+	rows.Close()
+
+	// Jobs keep their inputs' hashes when a dataset is deleted, visualizations go with it
+	onDelete := map[string]map[string]string{
+		"jobs": {
+			"user_id":      "CASCADE",
+			"alignment_id": "SET NULL",
+			"tree_id":      "SET NULL",
+		},
+		"visualizations": {
+			"job_id":     "CASCADE",
+			"dataset_id": "CASCADE",
+			"user_id":    "CASCADE",
+		},
+	}
+	for table, want := range onDelete {
+		rows, err := conn.Query("SELECT \"from\", on_delete FROM pragma_foreign_key_list(?)", table)
+		if err != nil {
+			t.Fatalf("Failed to list foreign keys of %s: %v", table, err)
+		}
+		got := map[string]string{}
+		for rows.Next() {
+			var column, action string
+			if err := rows.Scan(&column, &action); err != nil {
+				t.Fatalf("Failed to scan foreign key: %v", err)
+			}
+			got[column] = action
+		}
+		rows.Close()
+		for column, action := range want {
+			if got[column] != action {
+				t.Errorf("Expected %s.%s to be ON DELETE %s, got %q", table, column, action, got[column])
+			}
+		}
+	}
+
+	if _, err := conn.Exec("DELETE FROM datasets WHERE id = 'aln-1'"); err != nil {
+		t.Fatalf("Failed to delete dataset: %v", err)
+	}
+	var count int
+	conn.QueryRow("SELECT COUNT(*) FROM visualizations WHERE viz_id = 'viz-1'").Scan(&count)
+	if count != 0 {
+		t.Error("Visualization was not cascade deleted when its dataset was deleted")
+	}
+	conn.QueryRow("SELECT COUNT(*) FROM visualizations WHERE viz_id = 'viz-2'").Scan(&count)
+	if count != 1 {
+		t.Error("Visualization of the job was deleted with a dataset it does not reference")
+	}
+	var alignmentID sql.NullString
+	if err := conn.QueryRow("SELECT alignment_id, alignment_hash FROM jobs WHERE job_id = 'job-1'").Scan(&alignmentID, &alignmentHash); err != nil {
+		t.Fatalf("Job was deleted with its dataset: %v", err)
+	}
+	if alignmentID.Valid || alignmentHash.String != "hash-aln" {
+		t.Errorf("Expected job to keep hash-aln without an alignment ID, got %v and %q", alignmentID, alignmentHash.String)
+	}
+}
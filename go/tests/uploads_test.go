@@ -0,0 +1,283 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+const uploadedAlignment = ">human\nATGAAACCCGGG\n>chimp\nATGAAGCCCGGA\n>gorilla\nATGAAACCTGGG\n"
+
+// uploadFixture holds the upload endpoints wired to a test database
+type uploadFixture struct {
+	*apiTestEnv
+	uploads  *sw.UploadService
+	intruder string
+}
+
+func setupUploadFixture(t *testing.T, config sw.UploadConfig) *uploadFixture {
+	t.Helper()
+	f := &uploadFixture{apiTestEnv: setupAPITestEnv(t)}
+	_, f.intruder = f.newUser(t)
+
+	config.Dir = filepath.Join(f.datasets.GetDatasetDir(), ".partial")
+	uploads, err := sw.NewUploadService(config, sw.NewSQLiteUploadTracker(f.db.GetDB()))
+	if err != nil {
+		t.Fatalf("NewUploadService failed: %v", err)
+	}
+	f.uploads = uploads
+
+	api := sw.NewFileUploadAndQCAPI(f.datasets, f.session)
+	api.Uploads = uploads
+
+	f.router.POST("/api/v1/uploads", api.CreateUpload)
+	f.router.GET("/api/v1/uploads/:uploadId", api.GetUpload)
+	f.router.HEAD("/api/v1/uploads/:uploadId", api.GetUpload)
+	f.router.PATCH("/api/v1/uploads/:uploadId", api.PatchUpload)
+	f.router.DELETE("/api/v1/uploads/:uploadId", api.DeleteUpload)
+	f.router.POST("/api/v1/uploads/:uploadId/finalize", api.FinalizeUpload)
+	f.router.GET("/api/v1/datasets/:datasetId", api.GetDatasetById)
+	return f
+}
+
+func (f *uploadFixture) do(t *testing.T, method string, path string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, body)
+	req.Header.Set("user_token", f.token)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+// create starts an upload and returns its ID
+func (f *uploadFixture) create(t *testing.T, length int, sha string) string {
+	t.Helper()
+	body := `{"meta": {"name": "surveillance", "type": "fasta"}, "length": ` + strconv.Itoa(length) + `, "sha256": "` + sha + `"}`
+	w := f.do(t, http.MethodPost, "/api/v1/uploads", strings.NewReader(body), map[string]string{"Content-Type": "application/json"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 creating upload, got %d: %s", w.Code, w.Body.String())
+	}
+	var upload sw.Upload
+	json.Unmarshal(w.Body.Bytes(), &upload)
+	if w.Header().Get("Location") != "/api/v1/uploads/"+upload.Id || w.Header().Get("Upload-Offset") != "0" {
+		t.Errorf("Unexpected tus headers: %v", w.Header())
+	}
+	return upload.Id
+}
+
+func (f *uploadFixture) patch(t *testing.T, id string, offset int, chunk io.Reader) *httptest.ResponseRecorder {
+	t.Helper()
+	return f.do(t, http.MethodPatch, "/api/v1/uploads/"+id, chunk, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(offset),
+	})
+}
+
+// droppedConnection yields some bytes and then fails, like a client losing its connection
+type droppedConnection struct {
+	data []byte
+}
+
+func (d *droppedConnection) Read(p []byte) (int, error) {
+	if len(d.data) == 0 {
+		return 0, errors.New("connection reset by peer")
+	}
+	n := copy(p, d.data)
+	d.data = d.data[n:]
+	return n, nil
+}
+
+// TestResumableUpload tests an upload that is interrupted, resumed and finalized into a dataset
+func TestResumableUpload(t *testing.T) {
+	f := setupUploadFixture(t, sw.UploadConfig{})
+	content := []byte(uploadedAlignment)
+	id := f.create(t, len(content), "")
+
+	if w := f.patch(t, id, 0, bytes.NewReader(content[:20])); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "20" {
+		t.Fatalf("Expected 204 at offset 20, got %d (%s): %s", w.Code, w.Header().Get("Upload-Offset"), w.Body.String())
+	}
+
+	// The connection drops after 10 more bytes; they are kept
+	if w := f.patch(t, id, 20, &droppedConnection{data: content[20:30]}); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an interrupted chunk, got %d: %s", w.Code, w.Body.String())
+	}
+	w := f.do(t, http.MethodHead, "/api/v1/uploads/"+id, nil, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "30" || w.Header().Get("Upload-Length") != strconv.Itoa(len(content)) {
+		t.Fatalf("Expected HEAD to report offset 30, got %d: %v", w.Code, w.Header())
+	}
+
+	if w := f.patch(t, id, 20, bytes.NewReader(content[20:])); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a stale offset, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := f.do(t, http.MethodPost, "/api/v1/uploads/"+id+"/finalize", nil, nil); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 finalizing an incomplete upload, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := f.patch(t, id, 30, bytes.NewReader(content[30:])); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 for the last chunk, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The hash built up chunk by chunk matches the whole content
+	w = f.do(t, http.MethodGet, "/api/v1/uploads/"+id, nil, nil)
+	var upload sw.Upload
+	json.Unmarshal(w.Body.Bytes(), &upload)
+	sum := sha256.Sum256(content)
+	if !upload.Complete || upload.Sha256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected a complete upload with the content hash, got %+v", upload)
+	}
+
+	w = f.do(t, http.MethodPost, "/api/v1/uploads/"+id+"/finalize", nil, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 finalizing, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		ID        string             `json:"id"`
+		Alignment *sw.AlignmentStats `json:"alignment"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if created.Alignment == nil || created.Alignment.SequenceCount != 3 {
+		t.Errorf("Expected alignment stats for the finalized dataset, got %s", w.Body.String())
+	}
+	if w := f.do(t, http.MethodGet, "/api/v1/datasets/"+created.ID, nil, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected the dataset to exist, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := f.do(t, http.MethodGet, "/api/v1/uploads/"+id, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the upload to be gone after finalizing, got %d", w.Code)
+	}
+	if _, err := os.Stat(f.uploads.PartPath(id)); !os.IsNotExist(err) {
+		t.Errorf("Expected the partial file to be moved, got %v", err)
+	}
+}
+
+// TestUploadLimits tests size limits, quotas, ownership and protocol errors
+func TestUploadLimits(t *testing.T) {
+	f := setupUploadFixture(t, sw.UploadConfig{MaxUploadBytes: 100, UserQuotaBytes: 150})
+	start := func(body string) *httptest.ResponseRecorder {
+		return f.do(t, http.MethodPost, "/api/v1/uploads", strings.NewReader(body), map[string]string{"Content-Type": "application/json"})
+	}
+
+	if w := start(`{"meta": {"name": "big", "type": "fasta"}, "length": 101}`); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 above the maximum size, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := start(`{"meta": {"name": "none", "type": "fasta"}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a length, got %d: %s", w.Code, w.Body.String())
+	}
+
+	id := f.create(t, 100, strings.Repeat("0", 64))
+	if w := start(`{"meta": {"name": "second", "type": "fasta"}, "length": 60}`); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 over the user quota, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := f.patch(t, id, 0, bytes.NewReader(make([]byte, 101))); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a chunk past the declared length, got %d: %s", w.Code, w.Body.String())
+	}
+	w := f.do(t, http.MethodPatch, "/api/v1/uploads/"+id, strings.NewReader("x"), map[string]string{"Upload-Offset": "0", "Content-Type": "text/plain"})
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for the wrong content type, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodHead, "/api/v1/uploads/"+id, nil)
+	req.Header.Set("user_token", f.intruder)
+	w = httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another user's upload, got %d", w.Code)
+	}
+
+	// A checksum given at creation is verified when finalizing
+	if w := f.patch(t, id, 0, bytes.NewReader(bytes.Repeat([]byte("A"), 100))); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := f.do(t, http.MethodPost, "/api/v1/uploads/"+id+"/finalize", nil, nil); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a checksum mismatch, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Abandoning the upload frees the quota
+	if w := f.do(t, http.MethodDelete, "/api/v1/uploads/"+id, nil, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 deleting the upload, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := start(`{"meta": {"name": "second", "type": "fasta"}, "length": 60}`); w.Code != http.StatusCreated {
+		t.Errorf("Expected 201 once the quota is free, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestUploadQuotaConcurrent tests that uploads started at once can't overrun the quota together
+func TestUploadQuotaConcurrent(t *testing.T) {
+	f := setupUploadFixture(t, sw.UploadConfig{MaxUploadBytes: 100, UserQuotaBytes: 100})
+
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := `{"meta": {"name": "racing", "type": "fasta"}, "length": 60}`
+			w := f.do(t, http.MethodPost, "/api/v1/uploads", strings.NewReader(body), map[string]string{"Content-Type": "application/json"})
+			codes[i] = w.Code
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusRequestEntityTooLarge:
+		default:
+			t.Errorf("Expected 201 or 413, got %d", code)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected one upload to fit in the quota, got %d", created)
+	}
+}
+
+// TestUploadCleanupStale tests that uploads which stop receiving data are discarded
+func TestUploadCleanupStale(t *testing.T) {
+	f := setupUploadFixture(t, sw.UploadConfig{})
+	stale := f.create(t, 10, "")
+	fresh := f.create(t, 10, "")
+	if w := f.patch(t, stale, 0, strings.NewReader("ATG")); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	record, err := f.uploads.Tracker.Get(stale)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := f.uploads.Tracker.UpdateProgress(stale, record.Offset, record.HashState, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("UpdateProgress failed: %v", err)
+	}
+
+	count, err := f.uploads.CleanupStale()
+	if err != nil || count != 1 {
+		t.Fatalf("CleanupStale() = %d, %v, want 1", count, err)
+	}
+	if _, err := os.Stat(f.uploads.PartPath(stale)); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale partial file to be removed, got %v", err)
+	}
+	if w := f.do(t, http.MethodHead, "/api/v1/uploads/"+stale, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for the discarded upload, got %d", w.Code)
+	}
+	if w := f.do(t, http.MethodHead, "/api/v1/uploads/"+fresh, nil, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected the fresh upload to remain, got %d", w.Code)
+	}
+}
@@ -0,0 +1,280 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	sw "github.com/d-callan/service-datamonkey/go"
+)
+
+const fetchedAlignment = ">human\nATGAAA\n>chimp\nATGAAG\n"
+
+// fetchedAlignmentBzip2 is fetchedAlignment compressed with bzip2, which the standard library cannot write
+const fetchedAlignmentBzip2 = "QlpoOTFBWSZTWfMcHM0AAALPgAAQAAEggAQAKGNCACAAMQDQANT0nqaGaRurQQQMAyCL78025WC7kinChIeY4OZo"
+
+// newDatasetServer serves fetchedAlignment plain and compressed, plus error cases
+func newDatasetServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(fetchedAlignment))
+	gz.Close()
+
+	bzipped, err := base64.StdEncoding.DecodeString(fetchedAlignmentBzip2)
+	if err != nil {
+		t.Fatalf("Failed to decode bzip2 fixture: %v", err)
+	}
+
+	zipped := func(files map[string]string) []byte {
+		var buf bytes.Buffer
+		archive := zip.NewWriter(&buf)
+		for name, content := range files {
+			w, _ := archive.Create(name)
+			w.Write([]byte(content))
+		}
+		archive.Close()
+		return buf.Bytes()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/alignment.fas", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fetchedAlignment))
+	})
+	mux.HandleFunc("/alignment.fas.gz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(gzipped.Bytes())
+	})
+	mux.HandleFunc("/alignment.fas.bz2", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bzipped)
+	})
+	mux.HandleFunc("/alignment.zip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(zipped(map[string]string{"alignment.fas": fetchedAlignment, "__MACOSX/._alignment.fas": "x"}))
+	})
+	mux.HandleFunc("/two-files.zip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(zipped(map[string]string{"a.fas": fetchedAlignment, "b.fas": fetchedAlignment}))
+	})
+	mux.HandleFunc("/bucket/data/alignment.fas", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fetchedAlignment))
+	})
+	mux.HandleFunc("/streamed", func(w http.ResponseWriter, r *http.Request) {
+		// No Content-Length, so the limit must be enforced while reading
+		for i := 0; i < 64; i++ {
+			w.Write(bytes.Repeat([]byte("A"), 1024))
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/bomb.gz", func(w http.ResponseWriter, r *http.Request) {
+		gz := gzip.NewWriter(w)
+		gz.Write(bytes.Repeat([]byte("A"), 1<<20))
+		gz.Close()
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	mux.HandleFunc("/redirect-file", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/alignment.fas", http.StatusFound)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestURLFetcherDecompresses tests plain, gzip, bzip2 and zip downloads
+func TestURLFetcherDecompresses(t *testing.T) {
+	server := newDatasetServer(t)
+	fetcher := sw.NewURLFetcher(sw.URLFetchConfig{AllowPrivateNetworks: true, S3Endpoint: server.URL})
+	sum := sha256.Sum256([]byte(fetchedAlignment))
+
+	tests := []struct {
+		url         string
+		compression string
+	}{
+		{server.URL + "/alignment.fas", sw.CompressionNone},
+		{server.URL + "/alignment.fas.gz", sw.CompressionGzip},
+		{server.URL + "/alignment.fas.bz2", sw.CompressionBzip2},
+		{server.URL + "/alignment.zip", sw.CompressionZip},
+		{server.URL + "/redirect", sw.CompressionNone},
+		{"s3://bucket/data/alignment.fas", sw.CompressionNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			fetched, err := fetcher.Fetch(context.Background(), tt.url, t.TempDir())
+			if err != nil {
+				t.Fatalf("Fetch failed: %v", err)
+			}
+			content, err := os.ReadFile(fetched.Path)
+			if err != nil {
+				t.Fatalf("Failed to read fetched file: %v", err)
+			}
+			if string(content) != fetchedAlignment {
+				t.Errorf("Content = %q, want %q", content, fetchedAlignment)
+			}
+			if fetched.Compression != tt.compression {
+				t.Errorf("Compression = %q, want %q", fetched.Compression, tt.compression)
+			}
+			if fetched.SHA256 != hex.EncodeToString(sum[:]) || fetched.Size != int64(len(fetchedAlignment)) {
+				t.Errorf("Unexpected size or hash: %+v", fetched)
+			}
+		})
+	}
+}
+
+// TestURLFetcherRejects tests the scheme, address, size, time and archive checks
+func TestURLFetcherRejects(t *testing.T) {
+	server := newDatasetServer(t)
+	open := sw.NewURLFetcher(sw.URLFetchConfig{AllowPrivateNetworks: true, MaxBytes: 16 << 10, Timeout: 200 * time.Millisecond})
+	defaults := sw.NewURLFetcher(sw.DefaultURLFetchConfig())
+
+	tests := []struct {
+		name    string
+		fetcher *sw.URLFetcher
+		url     string
+		want    error
+	}{
+		{"local path", defaults, "/etc/passwd", sw.ErrURLNotAllowed},
+		{"file scheme", defaults, "file:///etc/passwd", sw.ErrURLNotAllowed},
+		{"ftp scheme", defaults, "ftp://example.org/alignment.fas", sw.ErrURLNotAllowed},
+		{"s3 without endpoint", defaults, "s3://bucket/alignment.fas", sw.ErrURLNotAllowed},
+		{"loopback", defaults, server.URL + "/alignment.fas", sw.ErrURLNotAllowed},
+		{"private address", defaults, "http://10.0.0.1/alignment.fas", sw.ErrURLNotAllowed},
+		{"cloud metadata", defaults, "http://169.254.169.254/latest/meta-data/", sw.ErrURLNotAllowed},
+		{"ipv6 loopback", defaults, "http://[::1]/alignment.fas", sw.ErrURLNotAllowed},
+		{"redirect to file", open, server.URL + "/redirect-file", sw.ErrURLNotAllowed},
+		{"streamed too large", open, server.URL + "/streamed", sw.ErrURLTooLarge},
+		{"decompresses too large", open, server.URL + "/bomb.gz", sw.ErrURLTooLarge},
+		{"zip with two files", open, server.URL + "/two-files.zip", sw.ErrBadArchive},
+		{"timeout", open, server.URL + "/slow", context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			_, err := tt.fetcher.Fetch(context.Background(), tt.url, dir)
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if errors.Is(tt.want, context.DeadlineExceeded) {
+				var netErr interface{ Timeout() bool }
+				if !errors.As(err, &netErr) || !netErr.Timeout() {
+					t.Errorf("Expected a timeout, got %v", err)
+				}
+			} else if !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+
+			// Rejected downloads leave nothing behind
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("Expected no files left in %s, got %d", dir, len(entries))
+			}
+		})
+	}
+
+	if _, err := open.Fetch(context.Background(), server.URL+"/missing", t.TempDir()); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected the remote status in the error, got %v", err)
+	}
+}
+
+// TestURLFetcherS3EndpointOnPrivateNetwork tests that a private S3 endpoint is reachable
+// while other private addresses, including redirects from the endpoint, are still refused
+func TestURLFetcherS3EndpointOnPrivateNetwork(t *testing.T) {
+	other := newDatasetServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bucket/alignment.fas", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fetchedAlignment))
+	})
+	mux.HandleFunc("/bucket/escape", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+"/alignment.fas", http.StatusFound)
+	})
+	endpoint := httptest.NewServer(mux)
+	t.Cleanup(endpoint.Close)
+
+	fetcher := sw.NewURLFetcher(sw.URLFetchConfig{S3Endpoint: endpoint.URL})
+
+	fetched, err := fetcher.Fetch(context.Background(), "s3://bucket/alignment.fas", t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected the S3 endpoint to be reachable, got %v", err)
+	}
+	if fetched.Size != int64(len(fetchedAlignment)) {
+		t.Errorf("Expected %d bytes, got %d", len(fetchedAlignment), fetched.Size)
+	}
+
+	for _, rawURL := range []string{other.URL + "/alignment.fas", "s3://bucket/escape"} {
+		if _, err := fetcher.Fetch(context.Background(), rawURL, t.TempDir()); !errors.Is(err, sw.ErrURLNotAllowed) {
+			t.Errorf("Expected %s to be refused, got %v", rawURL, err)
+		}
+	}
+}
+
+// TestPostDatasetFromURL tests importing datasets by URL through the upload endpoint
+func TestPostDatasetFromURL(t *testing.T) {
+	server := newDatasetServer(t)
+	f := setupDatasetUploadFixture(t)
+
+	post := func(url string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("url", url)
+		writer.WriteField("meta", `{"name": "remote", "type": "fasta"}`)
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/datasets", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("user_token", f.token)
+		w := httptest.NewRecorder()
+		f.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The test server is on loopback, which is refused by default
+	if w := post(server.URL + "/alignment.fas.gz"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a loopback URL, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := post("/etc/passwd"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a local path, got %d: %s", w.Code, w.Body.String())
+	}
+
+	f.api.URLFetcher = sw.NewURLFetcher(sw.URLFetchConfig{AllowPrivateNetworks: true, MaxBytes: 16 << 10})
+	w := post(server.URL + "/alignment.fas.gz")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		ID        string             `json:"id"`
+		Alignment *sw.AlignmentStats `json:"alignment"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if created.Alignment == nil || created.Alignment.SequenceCount != 2 {
+		t.Errorf("Expected the decompressed alignment to be parsed, got %s", w.Body.String())
+	}
+
+	if w := post(server.URL + "/streamed"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for an oversized download, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := post(server.URL + "/missing"); w.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 for a failed download, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,249 @@
+package datamonkey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Errors returned by TokenStore, so handlers can choose a status code
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used; its session's refresh tokens were revoked")
+)
+
+// RefreshGrant is the identity a refresh token was issued for
+type RefreshGrant struct {
+	FamilyID  string // Shared by every token in one rotation chain
+	Subject   string
+	AccountID string // Empty for anonymous sessions
+	LoginID   string // Empty for anonymous sessions
+	ExpiresAt time.Time
+}
+
+// TokenStore defines the interface for storing refresh tokens and revoked access tokens
+type TokenStore interface {
+	// CreateRefreshToken issues a refresh token starting a new family for a grant
+	CreateRefreshToken(grant RefreshGrant) (string, error)
+
+	// RotateRefreshToken uses up a refresh token and issues the next one in its family.
+	// Using a token twice revokes its family and returns ErrRefreshTokenReused.
+	RotateRefreshToken(token string, expiresAt time.Time) (string, *RefreshGrant, error)
+
+	// RevokeRefreshToken revokes the family a refresh token belongs to
+	RevokeRefreshToken(token string) error
+
+	// RevokeLoginRefreshTokens revokes every refresh token issued for a login
+	RevokeLoginRefreshTokens(loginID string) error
+
+	// RevokeAccessToken records an access token ID as revoked until it expires
+	RevokeAccessToken(jti string, expiresAt time.Time) error
+
+	// IsAccessTokenRevoked reports whether an access token ID was revoked
+	IsAccessTokenRevoked(jti string) (bool, error)
+
+	// CleanupExpiredTokens removes refresh tokens and revocations past their expiry
+	CleanupExpiredTokens() (int, error)
+}
+
+// SQLiteTokenStore implements TokenStore using the unified database
+type SQLiteTokenStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteTokenStore creates a new SQLiteTokenStore instance using the unified database
+func NewSQLiteTokenStore(db *sql.DB) *SQLiteTokenStore {
+	return &SQLiteTokenStore{db: db}
+}
+
+// hashRefreshToken returns the hash a refresh token is stored under, so a copy of the
+// database does not hold usable tokens
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshToken returns a random refresh token
+func newRefreshToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// insertRefreshToken stores a new token for a grant
+func insertRefreshToken(tx *sql.Tx, grant RefreshGrant) (string, error) {
+	token, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO refresh_tokens (token_hash, family_id, subject, account_id, login_id, created_at, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`,
+		hashRefreshToken(token), grant.FamilyID, grant.Subject,
+		sql.NullString{String: grant.AccountID, Valid: grant.AccountID != ""},
+		sql.NullString{String: grant.LoginID, Valid: grant.LoginID != ""},
+		time.Now().Unix(), grant.ExpiresAt.Unix(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to store refresh token: %v", err)
+	}
+	return token, nil
+}
+
+// CreateRefreshToken issues a refresh token starting a new family for a grant
+func (s *SQLiteTokenStore) CreateRefreshToken(grant RefreshGrant) (string, error) {
+	grant.FamilyID = uuid.New().String()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	token, err := insertRefreshToken(tx, grant)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit refresh token: %v", err)
+	}
+	return token, nil
+}
+
+// RotateRefreshToken uses up a refresh token and issues the next one in its family
+func (s *SQLiteTokenStore) RotateRefreshToken(token string, expiresAt time.Time) (string, *RefreshGrant, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var grant RefreshGrant
+	var accountID, loginID sql.NullString
+	var expires int64
+	var usedAt, revokedAt sql.NullInt64
+	err = tx.QueryRow(`
+	SELECT family_id, subject, account_id, login_id, expires_at, used_at, revoked_at
+	FROM refresh_tokens WHERE token_hash = ?
+	`, hashRefreshToken(token)).Scan(&grant.FamilyID, &grant.Subject, &accountID, &loginID, &expires, &usedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return "", nil, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get refresh token: %v", err)
+	}
+	now := time.Now()
+	if revokedAt.Valid || now.Unix() >= expires {
+		return "", nil, ErrRefreshTokenInvalid
+	}
+	// Mark the token used unless a concurrent refresh got there first
+	if !usedAt.Valid {
+		result, err := tx.Exec(`UPDATE refresh_tokens SET used_at = ? WHERE token_hash = ? AND used_at IS NULL`,
+			now.Unix(), hashRefreshToken(token))
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to use refresh token: %v", err)
+		}
+		if rows, err := result.RowsAffected(); err != nil {
+			return "", nil, fmt.Errorf("failed to check rows affected: %v", err)
+		} else if rows == 0 {
+			usedAt.Valid = true
+		}
+	}
+	if usedAt.Valid {
+		if _, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL`,
+			now.Unix(), grant.FamilyID); err != nil {
+			return "", nil, fmt.Errorf("failed to revoke refresh tokens: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return "", nil, fmt.Errorf("failed to commit revocation: %v", err)
+		}
+		log.Printf("Refresh token reused, revoked family %s of subject %s", grant.FamilyID, grant.Subject)
+		return "", nil, ErrRefreshTokenReused
+	}
+
+	grant.AccountID = accountID.String
+	grant.LoginID = loginID.String
+	grant.ExpiresAt = expiresAt
+	next, err := insertRefreshToken(tx, grant)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", nil, fmt.Errorf("failed to commit refresh token: %v", err)
+	}
+	return next, &grant, nil
+}
+
+// RevokeRefreshToken revokes the family a refresh token belongs to
+func (s *SQLiteTokenStore) RevokeRefreshToken(token string) error {
+	_, err := s.db.Exec(`
+	UPDATE refresh_tokens SET revoked_at = ?
+	WHERE revoked_at IS NULL AND family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = ?)
+	`, time.Now().Unix(), hashRefreshToken(token))
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %v", err)
+	}
+	return nil
+}
+
+// RevokeLoginRefreshTokens revokes every refresh token issued for a login
+func (s *SQLiteTokenStore) RevokeLoginRefreshTokens(loginID string) error {
+	_, err := s.db.Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE login_id = ? AND revoked_at IS NULL`,
+		time.Now().Unix(), loginID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
+	return nil
+}
+
+// RevokeAccessToken records an access token ID as revoked until it expires
+func (s *SQLiteTokenStore) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)`, jti, expiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %v", err)
+	}
+	return nil
+}
+
+// IsAccessTokenRevoked reports whether an access token ID was revoked
+func (s *SQLiteTokenStore) IsAccessTokenRevoked(jti string) (bool, error) {
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?`, jti).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %v", err)
+	}
+	return count > 0, nil
+}
+
+// CleanupExpiredTokens removes refresh tokens and revocations past their expiry
+func (s *SQLiteTokenStore) CleanupExpiredTokens() (int, error) {
+	now := time.Now().Unix()
+	total := 0
+	for _, query := range []string{
+		`DELETE FROM refresh_tokens WHERE expires_at < ?`,
+		`DELETE FROM revoked_tokens WHERE expires_at < ?`,
+	} {
+		result, err := s.db.Exec(query, now)
+		if err != nil {
+			return total, fmt.Errorf("failed to cleanup expired tokens: %v", err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("failed to check rows affected: %v", err)
+		}
+		total += int(rows)
+	}
+	return total, nil
+}
+
+// Ensure SQLiteTokenStore implements TokenStore interface
+var _ TokenStore = (*SQLiteTokenStore)(nil)
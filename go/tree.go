@@ -0,0 +1,711 @@
+package datamonkey
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tree formats recognized by ParseTree
+const (
+	TreeFormatNewick = "newick"
+	TreeFormatNEXUS  = "nexus"
+)
+
+// treeDatasetTypes are dataset types that must contain a tree
+var treeDatasetTypes = map[string]bool{
+	"newick": true, "nwk": true, "nhx": true,
+	"tree": true, "tre": true, "treefile": true,
+}
+
+// maxReportedNames caps the names listed in a single tree diagnostic
+const maxReportedNames = 20
+
+// maxNewickBytes caps the size of a Newick file checked as an upload, which is parsed in memory
+const maxNewickBytes = 64 << 20
+
+// Tree is a parsed phylogenetic tree
+type Tree struct {
+	Format string
+	Root   *TreeNode
+	// Rooted is true for trees declared [&R], or with a bifurcating root when undeclared
+	Rooted bool
+}
+
+// TreeNode is a node of a tree and the branch leading to it
+type TreeNode struct {
+	Name string
+	// Label is the HyPhy branch annotation, e.g. Foreground for name{Foreground}
+	Label     string
+	Length    float64
+	HasLength bool
+	Children  []*TreeNode
+}
+
+// IsLeaf reports whether the node has no children
+func (n *TreeNode) IsLeaf() bool {
+	return len(n.Children) == 0
+}
+
+// TreeError reports malformed tree input at a line of the file
+type TreeError struct {
+	Line    int
+	Message string
+}
+
+func (e *TreeError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+func treeErrorf(line int, format string, args ...interface{}) *TreeError {
+	return &TreeError{Line: line, Message: fmt.Sprintf(format, args...)}
+}
+
+// CheckTree parses an uploaded dataset as a tree and returns its statistics.
+// Content that is not a tree is skipped (nil stats) unless datasetType names a
+// tree format, in which case it is an error.
+func CheckTree(datasetType string, content []byte) (*TreeStats, error) {
+	return checkTree(datasetType, bytesSource(content))
+}
+
+// CheckTreeFile checks an uploaded file like CheckTree without reading it whole. Newick
+// files of up to maxNewickBytes are read in full; NEXUS files are read up to their TREES
+// block and then a command at a time.
+func CheckTreeFile(datasetType string, path string) (*TreeStats, error) {
+	return checkTree(datasetType, fileSource(path))
+}
+
+func checkTree(datasetType string, source contentSource) (*TreeStats, error) {
+	number, line, err := source.firstLine()
+	if err != nil {
+		return nil, treeErrorf(number, "failed to read tree: %v", err)
+	}
+
+	var tree *Tree
+	switch {
+	case strings.HasPrefix(line, "(") || strings.HasPrefix(line, "["):
+		tree, err = readNewick(source)
+	case strings.HasPrefix(strings.ToUpper(line), "#NEXUS"):
+		tree, err = readNEXUSTrees(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tree == nil {
+		if treeDatasetTypes[strings.ToLower(datasetType)] {
+			return nil, treeErrorf(number, "unrecognized tree format, expected Newick or a NEXUS TREES block")
+		}
+		return nil, nil
+	}
+	stats := tree.Stats()
+	return &stats, nil
+}
+
+// readNewick parses a Newick file, or returns nil if the file holds no tree
+func readNewick(source contentSource) (*Tree, error) {
+	reader, err := source()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(io.LimitReader(reader, maxNewickBytes+1))
+	if err != nil {
+		return nil, treeErrorf(1, "failed to read tree: %v", err)
+	}
+	if len(content) > maxNewickBytes {
+		return nil, treeErrorf(1, "Newick file is larger than %d MiB", maxNewickBytes>>20)
+	}
+	if DetectTreeFormat(content) != TreeFormatNewick {
+		return nil, nil
+	}
+	return parseNewick(string(content), 1, TreeFormatNewick)
+}
+
+// readNEXUSTrees parses the first tree of a NEXUS file's TREES block, reading the file a
+// line at a time until the block starts, or returns nil if the file has no TREES block
+func readNEXUSTrees(source contentSource) (*Tree, error) {
+	reader, err := source()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	buffered := bufio.NewReader(reader)
+	for number := 1; ; number++ {
+		line, err := buffered.ReadString('\n')
+		if location := nexusTreesBlockPattern.FindStringIndex(line); location != nil {
+			rest := io.MultiReader(strings.NewReader(line[location[1]:]), buffered)
+			return parseNEXUSTreesBlock(newNEXUSCommandReader(rest, number), number)
+		}
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, treeErrorf(number, "failed to read tree: %v", err)
+		}
+	}
+}
+
+// NEXUS TREES block pattern
+var nexusTreesBlockPattern = regexp.MustCompile(`(?i)\bbegin\s+trees\s*;`)
+
+// DetectTreeFormat returns the tree format of content, or "" if it is not a tree.
+// NEXUS files are trees when they have a TREES block.
+func DetectTreeFormat(content []byte) string {
+	line := firstContentLineText(content)
+	switch {
+	case strings.HasPrefix(line, "("):
+		return TreeFormatNewick
+	case strings.HasPrefix(line, "[") && strings.Contains(string(content), "("):
+		// Newick preceded by a rooting comment such as [&R]
+		return TreeFormatNewick
+	case strings.HasPrefix(strings.ToUpper(line), "#NEXUS") && nexusTreesBlockPattern.Match(content):
+		return TreeFormatNEXUS
+	}
+	return ""
+}
+
+// ParseTree parses a Newick tree or the first tree of a NEXUS TREES block
+func ParseTree(content []byte) (*Tree, error) {
+	switch DetectTreeFormat(content) {
+	case TreeFormatNewick:
+		return parseNewick(string(content), 1, TreeFormatNewick)
+	case TreeFormatNEXUS:
+		return parseNEXUSTrees(content)
+	}
+	return nil, treeErrorf(firstContentLine(content), "unrecognized tree format, expected Newick or a NEXUS TREES block")
+}
+
+// newickParser is a recursive descent parser over Newick text
+type newickParser struct {
+	text string
+	pos  int
+	line int
+	// rooting is set by a [&R] or [&U] comment
+	rooting string
+}
+
+// parseNewick parses one Newick tree starting at the given line of the file
+func parseNewick(text string, line int, format string) (*Tree, error) {
+	p := &newickParser{text: text, line: line}
+	p.skip()
+	if p.pos == len(p.text) || p.text[p.pos] != '(' {
+		return nil, treeErrorf(p.line, "tree must start with '('")
+	}
+	root, err := p.parseSubtree()
+	if err != nil {
+		return nil, err
+	}
+	p.skip()
+	if p.pos < len(p.text) && p.text[p.pos] == ';' {
+		p.pos++
+		p.skip()
+	}
+	if p.pos < len(p.text) {
+		return nil, treeErrorf(p.line, "unexpected %q after the end of the tree", p.text[p.pos])
+	}
+
+	tree := &Tree{Format: format, Root: root}
+	switch p.rooting {
+	case "R":
+		tree.Rooted = true
+	case "U":
+		tree.Rooted = false
+	default:
+		tree.Rooted = len(root.Children) == 2
+	}
+	return tree, nil
+}
+
+// skip moves past whitespace and [comments], recording rooting comments
+func (p *newickParser) skip() {
+	for p.pos < len(p.text) {
+		switch c := p.text[p.pos]; {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '[':
+			end := strings.IndexByte(p.text[p.pos:], ']')
+			if end < 0 {
+				// Left for the caller to report as an unexpected character
+				return
+			}
+			comment := strings.ToUpper(strings.TrimSpace(p.text[p.pos+1 : p.pos+end]))
+			if comment == "&R" || comment == "&U" {
+				p.rooting = comment[1:]
+			}
+			p.line += strings.Count(p.text[p.pos:p.pos+end], "\n")
+			p.pos += end + 1
+		default:
+			return
+		}
+	}
+}
+
+func (p *newickParser) parseSubtree() (*TreeNode, error) {
+	node := &TreeNode{}
+	p.skip()
+	if p.pos < len(p.text) && p.text[p.pos] == '(' {
+		open := p.line
+		p.pos++
+		for {
+			child, err := p.parseSubtree()
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+			p.skip()
+			if p.pos == len(p.text) {
+				return nil, treeErrorf(open, "unbalanced parentheses: '(' is never closed")
+			}
+			if p.text[p.pos] == ',' {
+				p.pos++
+				continue
+			}
+			if p.text[p.pos] == ')' {
+				p.pos++
+				break
+			}
+			return nil, treeErrorf(p.line, "expected ',' or ')' but found %q", p.text[p.pos])
+		}
+	}
+	if err := p.parseBranch(node); err != nil {
+		return nil, err
+	}
+	if node.IsLeaf() && node.Name == "" {
+		return nil, treeErrorf(p.line, "leaf has no name")
+	}
+	return node, nil
+}
+
+// parseBranch reads a node's name, {label} and :length, in either order of label and length
+func (p *newickParser) parseBranch(node *TreeNode) error {
+	p.skip()
+	name, err := p.parseName()
+	if err != nil {
+		return err
+	}
+	node.Name = name
+	for {
+		p.skip()
+		if p.pos == len(p.text) {
+			return nil
+		}
+		switch p.text[p.pos] {
+		case '{':
+			end := strings.IndexByte(p.text[p.pos:], '}')
+			if end < 0 {
+				return treeErrorf(p.line, "unterminated branch label")
+			}
+			node.Label = strings.TrimSpace(p.text[p.pos+1 : p.pos+end])
+			p.pos += end + 1
+		case ':':
+			p.pos++
+			p.skip()
+			start := p.pos
+			for p.pos < len(p.text) && strings.IndexByte("0123456789.eE+-", p.text[p.pos]) >= 0 {
+				p.pos++
+			}
+			length, err := strconv.ParseFloat(p.text[start:p.pos], 64)
+			if err != nil {
+				return treeErrorf(p.line, "invalid branch length %q", p.text[start:p.pos])
+			}
+			node.Length = length
+			node.HasLength = true
+		default:
+			return nil
+		}
+	}
+}
+
+// parseName reads a quoted or unquoted node name, which may be empty
+func (p *newickParser) parseName() (string, error) {
+	if p.pos == len(p.text) {
+		return "", nil
+	}
+	if quote := p.text[p.pos]; quote == '\'' || quote == '"' {
+		start := p.line
+		var name strings.Builder
+		p.pos++
+		for {
+			if p.pos == len(p.text) {
+				return "", treeErrorf(start, "unterminated quoted name")
+			}
+			c := p.text[p.pos]
+			if c == quote {
+				// Doubled quotes are an escaped quote
+				if p.pos+1 < len(p.text) && p.text[p.pos+1] == quote {
+					name.WriteByte(quote)
+					p.pos += 2
+					continue
+				}
+				p.pos++
+				return name.String(), nil
+			}
+			if c == '\n' {
+				p.line++
+			}
+			name.WriteByte(c)
+			p.pos++
+		}
+	}
+	start := p.pos
+	for p.pos < len(p.text) && !strings.ContainsRune("(),:;[]{} \t\r\n", rune(p.text[p.pos])) {
+		p.pos++
+	}
+	return p.text[start:p.pos], nil
+}
+
+// parseNEXUSTrees parses the first TREE command of a NEXUS TREES block, applying its TRANSLATE table
+func parseNEXUSTrees(content []byte) (*Tree, error) {
+	text := string(content)
+	location := nexusTreesBlockPattern.FindStringIndex(text)
+	blockLine := 1 + strings.Count(text[:location[0]], "\n")
+	return parseNEXUSTreesBlock(newNEXUSCommandReader(strings.NewReader(text[location[1]:]), blockLine), blockLine)
+}
+
+// parseNEXUSTreesBlock parses the first TREE command of the TREES block starting at blockLine
+func parseNEXUSTreesBlock(commands *nexusCommandReader, blockLine int) (*Tree, error) {
+	translate := map[string]string{}
+	for {
+		command, ok, err := commands.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, treeErrorf(blockLine, "TREES block has no TREE command")
+		}
+		keyword, rest := splitNEXUSKeyword(command.text)
+		switch strings.ToLower(keyword) {
+		case "end", "endblock":
+			return nil, treeErrorf(blockLine, "TREES block has no TREE command")
+		case "translate":
+			for _, entry := range strings.Split(rest, ",") {
+				fields := nexusFields(entry)
+				if len(fields) == 0 {
+					continue
+				}
+				if len(fields) != 2 {
+					return nil, treeErrorf(command.line, "invalid TRANSLATE entry %q", strings.TrimSpace(entry))
+				}
+				translate[fields[0]] = fields[1]
+			}
+		case "tree", "utree":
+			equals := strings.IndexByte(rest, '=')
+			if equals < 0 {
+				return nil, treeErrorf(command.line, "TREE command has no '='")
+			}
+			newick := rest[equals+1:]
+			line := command.line + strings.Count(command.text[:len(command.text)-len(rest)+equals+1], "\n")
+			tree, err := parseNewick(newick, line, TreeFormatNEXUS)
+			if err != nil {
+				return nil, err
+			}
+			if len(translate) > 0 {
+				tree.Root.walk(func(node *TreeNode) {
+					if name, ok := translate[node.Name]; ok {
+						node.Name = name
+					}
+				})
+			}
+			return tree, nil
+		}
+	}
+}
+
+// nexusCommand is the text of a ';'-terminated NEXUS command and the line it starts on
+type nexusCommand struct {
+	text string
+	line int
+}
+
+// nexusCommandReader splits NEXUS text into commands at ';' outside quotes and [comments]
+type nexusCommandReader struct {
+	reader *bufio.Reader
+	line   int
+}
+
+// newNEXUSCommandReader reads commands from text that starts at the given line
+func newNEXUSCommandReader(reader io.Reader, line int) *nexusCommandReader {
+	return &nexusCommandReader{reader: bufio.NewReader(reader), line: line}
+}
+
+// next returns the next command; ok is false at the end of the text
+func (r *nexusCommandReader) next() (nexusCommand, bool, error) {
+	var text strings.Builder
+	startLine := r.line
+	var quote byte
+	depth := 0
+	for {
+		c, err := r.reader.ReadByte()
+		if err == io.EOF {
+			if strings.TrimSpace(text.String()) == "" {
+				return nexusCommand{}, false, nil
+			}
+			return newNEXUSCommand(text.String(), startLine), true, nil
+		}
+		if err != nil {
+			return nexusCommand{}, false, treeErrorf(r.line, "failed to read tree: %v", err)
+		}
+		switch {
+		case c == '\n':
+			r.line++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '[':
+			depth++
+		case c == ']' && depth > 0:
+			depth--
+		case depth > 0:
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ';':
+			return newNEXUSCommand(text.String(), startLine), true, nil
+		}
+		text.WriteByte(c)
+	}
+}
+
+// newNEXUSCommand makes a command start at its first word, not after the previous ';'
+func newNEXUSCommand(text string, line int) nexusCommand {
+	trimmed := strings.TrimLeft(text, " \t\r\n")
+	return nexusCommand{text: trimmed, line: line + strings.Count(text[:len(text)-len(trimmed)], "\n")}
+}
+
+// splitNEXUSKeyword returns the first word of a command and the text after it
+func splitNEXUSKeyword(command string) (string, string) {
+	end := strings.IndexAny(command, " \t\r\n=")
+	if end < 0 {
+		return command, ""
+	}
+	return command[:end], command[end:]
+}
+
+// nexusFields splits a TRANSLATE entry into words, keeping quoted names whole
+func nexusFields(entry string) []string {
+	var fields []string
+	entry = strings.TrimSpace(entry)
+	for entry != "" {
+		if quote := entry[0]; quote == '\'' || quote == '"' {
+			end := strings.IndexByte(entry[1:], quote)
+			if end < 0 {
+				return append(fields, entry[1:])
+			}
+			fields = append(fields, entry[1:end+1])
+			entry = strings.TrimSpace(entry[end+2:])
+			continue
+		}
+		end := strings.IndexAny(entry, " \t\r\n")
+		if end < 0 {
+			return append(fields, entry)
+		}
+		fields = append(fields, entry[:end])
+		entry = strings.TrimSpace(entry[end:])
+	}
+	return fields
+}
+
+// walk calls fn for the node and its descendants in preorder
+func (n *TreeNode) walk(fn func(*TreeNode)) {
+	fn(n)
+	for _, child := range n.Children {
+		child.walk(fn)
+	}
+}
+
+// Leaves returns the names of the leaves in the order they appear in the tree
+func (t *Tree) Leaves() []string {
+	var leaves []string
+	t.Root.walk(func(node *TreeNode) {
+		if node.IsLeaf() {
+			leaves = append(leaves, node.Name)
+		}
+	})
+	return leaves
+}
+
+// BranchLabels returns the distinct HyPhy {label} annotations in the tree, sorted
+func (t *Tree) BranchLabels() []string {
+	seen := map[string]bool{}
+	labels := []string{}
+	t.Root.walk(func(node *TreeNode) {
+		if node.Label != "" && !seen[node.Label] {
+			seen[node.Label] = true
+			labels = append(labels, node.Label)
+		}
+	})
+	sort.Strings(labels)
+	return labels
+}
+
+// DuplicateNames returns node names that appear more than once, sorted
+func (t *Tree) DuplicateNames() []string {
+	counts := map[string]int{}
+	duplicates := []string{}
+	t.Root.walk(func(node *TreeNode) {
+		if node.Name == "" {
+			return
+		}
+		counts[node.Name]++
+		if counts[node.Name] == 2 {
+			duplicates = append(duplicates, node.Name)
+		}
+	})
+	sort.Strings(duplicates)
+	return duplicates
+}
+
+// Polytomies returns the multifurcating nodes: internal nodes with more than two children
+// and a root with more than three, which may be a trifurcation in an unrooted tree.
+// HyPhy accepts them, so they are only counted in the QC statistics.
+func (t *Tree) Polytomies() []*TreeNode {
+	var polytomies []*TreeNode
+	t.Root.walk(func(node *TreeNode) {
+		limit := 2
+		if node == t.Root {
+			limit = 3
+		}
+		if len(node.Children) > limit {
+			polytomies = append(polytomies, node)
+		}
+	})
+	return polytomies
+}
+
+// Stats returns summary statistics of the tree
+func (t *Tree) Stats() TreeStats {
+	stats := TreeStats{
+		Format:         t.Format,
+		Rooted:         t.Rooted,
+		BranchLabels:   t.BranchLabels(),
+		DuplicateNames: t.DuplicateNames(),
+	}
+	t.Root.walk(func(node *TreeNode) {
+		if node.IsLeaf() {
+			stats.LeafCount++
+		} else {
+			stats.InternalNodeCount++
+		}
+	})
+	stats.PolytomyCount = int32(len(t.Polytomies()))
+	return stats
+}
+
+// Newick writes the tree in Newick format with its HyPhy {label} annotations
+func (t *Tree) Newick() string {
+	var b strings.Builder
+	writeNewick(&b, t.Root)
+	b.WriteString(";\n")
+	return b.String()
+}
+
+func writeNewick(b *strings.Builder, node *TreeNode) {
+	if !node.IsLeaf() {
+		b.WriteByte('(')
+		for i, child := range node.Children {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeNewick(b, child)
+		}
+		b.WriteByte(')')
+	}
+	b.WriteString(newickName(node.Name))
+	if node.Label != "" {
+		b.WriteString("{" + node.Label + "}")
+	}
+	if node.HasLength {
+		b.WriteString(":" + strconv.FormatFloat(node.Length, 'g', -1, 64))
+	}
+}
+
+// newickName quotes a name that an unquoted Newick name could not hold
+func newickName(name string) string {
+	if !strings.ContainsAny(name, "(),:;[]{}'\" \t\r\n") {
+		return name
+	}
+	return "'" + strings.ReplaceAll(name, "'", "''") + "'"
+}
+
+// TreeValidationError lists every inconsistency between a tree and the alignment it is used with
+type TreeValidationError struct {
+	Problems []InvalidDataErrorErrorsInner
+}
+
+func (e *TreeValidationError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.Message
+	}
+	return fmt.Sprintf("invalid tree for this alignment: %s", strings.Join(messages, "; "))
+}
+
+// InvalidData returns the problems in the shape of the API's InvalidDataError
+func (e *TreeValidationError) InvalidData() InvalidDataError {
+	return InvalidDataError{Errors: e.Problems}
+}
+
+// CheckTreeAlignment checks that a tree can be used with an alignment: its labels are
+// unique and its leaves are exactly the alignment's sequence names. Multifurcating trees
+// are accepted, as HyPhy accepts them. All differences are reported together.
+func CheckTreeAlignment(tree *Tree, alignment *Alignment) error {
+	var problems []InvalidDataErrorErrorsInner
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, InvalidDataErrorErrorsInner{Field: "tree", Message: fmt.Sprintf(format, args...)})
+	}
+
+	if duplicates := tree.DuplicateNames(); len(duplicates) > 0 {
+		addProblem("tree has duplicate labels: %s", quotedList(duplicates))
+	}
+	inTree := map[string]bool{}
+	for _, leaf := range tree.Leaves() {
+		inTree[leaf] = true
+	}
+	inAlignment := map[string]bool{}
+	var missingFromTree []string
+	for _, sequence := range alignment.Sequences {
+		inAlignment[sequence.Name] = true
+		if !inTree[sequence.Name] {
+			missingFromTree = append(missingFromTree, sequence.Name)
+		}
+	}
+	var missingFromAlignment []string
+	for _, leaf := range tree.Leaves() {
+		if !inAlignment[leaf] {
+			missingFromAlignment = append(missingFromAlignment, leaf)
+		}
+	}
+	if len(missingFromAlignment) > 0 {
+		addProblem("%d tree leaves are not sequences in the alignment: %s", len(missingFromAlignment), quotedList(missingFromAlignment))
+	}
+	if len(missingFromTree) > 0 {
+		addProblem("%d alignment sequences are not leaves of the tree: %s", len(missingFromTree), quotedList(missingFromTree))
+	}
+
+	if len(problems) > 0 {
+		return &TreeValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// quotedList formats names for a diagnostic, listing at most maxReportedNames
+func quotedList(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for i, name := range names {
+		if i == maxReportedNames {
+			quoted = append(quoted, fmt.Sprintf("and %d more", len(names)-maxReportedNames))
+			break
+		}
+		quoted = append(quoted, strconv.Quote(name))
+	}
+	return strings.Join(quoted, ", ")
+}
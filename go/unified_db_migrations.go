@@ -137,6 +137,305 @@ DROP TABLE IF EXISTS conversations;
 DROP TABLE IF EXISTS jobs;
 DROP TABLE IF EXISTS datasets;
 DROP TABLE IF EXISTS sessions;
+`,
+		},
+		{
+			Version: 2,
+			Name:    "job_request_and_command",
+			Up: `
+-- Canonical request JSON and HyPhy command line, so jobs can be inspected and rerun
+ALTER TABLE jobs ADD COLUMN request_json TEXT;
+ALTER TABLE jobs ADD COLUMN command TEXT;
+`,
+			Down: `
+ALTER TABLE jobs DROP COLUMN command;
+ALTER TABLE jobs DROP COLUMN request_json;
+`,
+		},
+		{
+			Version: 3,
+			Name:    "resumable_uploads",
+			Up: `
+-- Resumable uploads in progress; received bytes live in a partial file named by id
+CREATE TABLE IF NOT EXISTS uploads (
+    id TEXT PRIMARY KEY,
+    user_id TEXT,
+    metadata_name TEXT NOT NULL,
+    metadata_description TEXT,
+    metadata_type TEXT NOT NULL,
+    length INTEGER NOT NULL,
+    received INTEGER NOT NULL DEFAULT 0,
+    hash_state BLOB,
+    sha256 TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES sessions(subject) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_uploads_user_id ON uploads(user_id);
+CREATE INDEX IF NOT EXISTS idx_uploads_expires_at ON uploads(expires_at);
+`,
+			Down: `
+DROP TABLE IF EXISTS uploads;
+`,
+		},
+		{
+			Version: 4,
+			Name:    "dataset_blobs",
+			Up: `
+-- ============================================================================
+-- BLOBS TABLE
+-- Dataset contents are stored once per content hash. ref_count counts the
+-- datasets and jobs that use a blob and is kept by the triggers below; blobs
+-- are deleted from storage once it drops to zero.
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS blobs (
+    content_hash TEXT PRIMARY KEY,
+    ref_count INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    updated_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+);
+CREATE INDEX IF NOT EXISTS idx_blobs_ref_count ON blobs(ref_count);
+
+-- Jobs outlive the datasets they ran on, so deleting a dataset no longer deletes
+-- its jobs; they keep the content hashes of their inputs instead. SQLite cannot
+-- change foreign keys in place, so jobs is rebuilt, with visualizations (which
+-- reference jobs) set aside meanwhile so the rebuild does not cascade to them.
+CREATE TABLE visualizations_old AS SELECT * FROM visualizations;
+DROP TABLE visualizations;
+
+CREATE TABLE jobs_new (
+    job_id TEXT PRIMARY KEY,
+    scheduler_job_id TEXT NOT NULL,
+    user_id TEXT,
+    alignment_id TEXT,
+    tree_id TEXT,
+    method_type TEXT,
+    status TEXT DEFAULT 'pending',
+    created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    updated_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    request_json TEXT,
+    command TEXT,
+    alignment_hash TEXT,
+    tree_hash TEXT,
+    FOREIGN KEY (user_id) REFERENCES sessions(subject) ON DELETE CASCADE,
+    FOREIGN KEY (alignment_id) REFERENCES datasets(id) ON DELETE SET NULL,
+    FOREIGN KEY (tree_id) REFERENCES datasets(id) ON DELETE SET NULL
+);
+INSERT INTO jobs_new (
+    job_id, scheduler_job_id, user_id, alignment_id, tree_id, method_type, status,
+    created_at, updated_at, request_json, command, alignment_hash, tree_hash
+)
+SELECT job_id, scheduler_job_id, user_id, alignment_id, tree_id, method_type, status,
+       created_at, updated_at, request_json, command,
+       (SELECT content_hash FROM datasets WHERE datasets.id = jobs.alignment_id),
+       (SELECT content_hash FROM datasets WHERE datasets.id = jobs.tree_id)
+FROM jobs;
+DROP TABLE jobs;
+ALTER TABLE jobs_new RENAME TO jobs;
+CREATE INDEX IF NOT EXISTS idx_jobs_scheduler_job_id ON jobs(scheduler_job_id);
+CREATE INDEX IF NOT EXISTS idx_jobs_user_id ON jobs(user_id);
+CREATE INDEX IF NOT EXISTS idx_jobs_alignment_id ON jobs(alignment_id);
+CREATE INDEX IF NOT EXISTS idx_jobs_tree_id ON jobs(tree_id);
+CREATE INDEX IF NOT EXISTS idx_jobs_method_type ON jobs(method_type);
+CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status);
+CREATE INDEX IF NOT EXISTS idx_jobs_created_at ON jobs(created_at);
+
+CREATE TABLE visualizations (
+    viz_id TEXT PRIMARY KEY,
+    job_id TEXT NOT NULL,
+    dataset_id TEXT,
+    user_id TEXT NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT,
+    spec TEXT NOT NULL,
+    config TEXT,
+    metadata TEXT,
+    created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    updated_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    FOREIGN KEY (job_id) REFERENCES jobs(job_id) ON DELETE CASCADE,
+    FOREIGN KEY (dataset_id) REFERENCES datasets(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES sessions(subject) ON DELETE CASCADE
+);
+INSERT INTO visualizations SELECT * FROM visualizations_old;
+DROP TABLE visualizations_old;
+CREATE INDEX IF NOT EXISTS idx_visualizations_job_id ON visualizations(job_id);
+CREATE INDEX IF NOT EXISTS idx_visualizations_dataset_id ON visualizations(dataset_id);
+CREATE INDEX IF NOT EXISTS idx_visualizations_user_id ON visualizations(user_id);
+CREATE INDEX IF NOT EXISTS idx_visualizations_created_at ON visualizations(created_at);
+
+-- Count the references that already exist
+INSERT INTO blobs (content_hash, ref_count)
+SELECT content_hash, COUNT(*) FROM (
+    SELECT content_hash FROM datasets
+    UNION ALL SELECT alignment_hash FROM jobs WHERE alignment_hash IS NOT NULL
+    UNION ALL SELECT tree_hash FROM jobs WHERE tree_hash IS NOT NULL
+) GROUP BY content_hash;
+
+CREATE TRIGGER IF NOT EXISTS blobs_dataset_insert AFTER INSERT ON datasets BEGIN
+    INSERT OR IGNORE INTO blobs (content_hash) VALUES (NEW.content_hash);
+    UPDATE blobs SET ref_count = ref_count + 1, updated_at = strftime('%s', 'now') WHERE content_hash = NEW.content_hash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS blobs_dataset_update AFTER UPDATE OF content_hash ON datasets
+WHEN OLD.content_hash IS NOT NEW.content_hash BEGIN
+    INSERT OR IGNORE INTO blobs (content_hash) VALUES (NEW.content_hash);
+    UPDATE blobs SET ref_count = ref_count + 1, updated_at = strftime('%s', 'now') WHERE content_hash = NEW.content_hash;
+    UPDATE blobs SET ref_count = ref_count - 1, updated_at = strftime('%s', 'now') WHERE content_hash = OLD.content_hash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS blobs_dataset_delete AFTER DELETE ON datasets BEGIN
+    UPDATE blobs SET ref_count = ref_count - 1, updated_at = strftime('%s', 'now') WHERE content_hash = OLD.content_hash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS blobs_job_insert AFTER INSERT ON jobs BEGIN
+    INSERT OR IGNORE INTO blobs (content_hash) SELECT NEW.alignment_hash WHERE NEW.alignment_hash IS NOT NULL;
+    INSERT OR IGNORE INTO blobs (content_hash) SELECT NEW.tree_hash WHERE NEW.tree_hash IS NOT NULL;
+    UPDATE blobs SET ref_count = ref_count + 1, updated_at = strftime('%s', 'now') WHERE content_hash = NEW.alignment_hash;
+    UPDATE blobs SET ref_count = ref_count + 1, updated_at = strftime('%s', 'now') WHERE content_hash = NEW.tree_hash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS blobs_job_update AFTER UPDATE OF alignment_hash, tree_hash ON jobs BEGIN
+    INSERT OR IGNORE INTO blobs (content_hash) SELECT NEW.alignment_hash WHERE NEW.alignment_hash IS NOT NULL;
+    INSERT OR IGNORE INTO blobs (content_hash) SELECT NEW.tree_hash WHERE NEW.tree_hash IS NOT NULL;
+    UPDATE blobs SET ref_count = ref_count + 1, updated_at = strftime('%s', 'now') WHERE content_hash = NEW.alignment_hash;
+    UPDATE blobs SET ref_count = ref_count + 1, updated_at = strftime('%s', 'now') WHERE content_hash = NEW.tree_hash;
+    UPDATE blobs SET ref_count = ref_count - 1, updated_at = strftime('%s', 'now') WHERE content_hash = OLD.alignment_hash;
+    UPDATE blobs SET ref_count = ref_count - 1, updated_at = strftime('%s', 'now') WHERE content_hash = OLD.tree_hash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS blobs_job_delete AFTER DELETE ON jobs BEGIN
+    UPDATE blobs SET ref_count = ref_count - 1, updated_at = strftime('%s', 'now') WHERE content_hash = OLD.alignment_hash;
+    UPDATE blobs SET ref_count = ref_count - 1, updated_at = strftime('%s', 'now') WHERE content_hash = OLD.tree_hash;
+END;
+`,
+			Down: `
+-- Jobs keep ON DELETE SET NULL; restoring the cascade would need another rebuild
+DROP TRIGGER IF EXISTS blobs_job_delete;
+DROP TRIGGER IF EXISTS blobs_job_update;
+DROP TRIGGER IF EXISTS blobs_job_insert;
+DROP TRIGGER IF EXISTS blobs_dataset_delete;
+DROP TRIGGER IF EXISTS blobs_dataset_update;
+DROP TRIGGER IF EXISTS blobs_dataset_insert;
+ALTER TABLE jobs DROP COLUMN tree_hash;
+ALTER TABLE jobs DROP COLUMN alignment_hash;
+DROP TABLE IF EXISTS blobs;
+`,
+		},
+		{
+			Version: 5,
+			Name:    "dataset_versions",
+			Up: `
+-- Versions of a logical dataset share a series_id, the ID of the first version,
+-- and are numbered from 1. Tags are a JSON array of strings.
+ALTER TABLE datasets ADD COLUMN series_id TEXT;
+ALTER TABLE datasets ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE datasets ADD COLUMN metadata_tags TEXT NOT NULL DEFAULT '[]';
+UPDATE datasets SET series_id = id WHERE series_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_datasets_series_version ON datasets(series_id, version);
+
+-- The dataset versions a job ran on
+ALTER TABLE jobs ADD COLUMN alignment_version INTEGER;
+ALTER TABLE jobs ADD COLUMN tree_version INTEGER;
+UPDATE jobs SET alignment_version = 1 WHERE alignment_id IS NOT NULL;
+UPDATE jobs SET tree_version = 1 WHERE tree_id IS NOT NULL;
+
+-- The tags of a resumable upload and the dataset it becomes a new version of
+ALTER TABLE uploads ADD COLUMN metadata_tags TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE uploads ADD COLUMN version_of TEXT;
+`,
+			Down: `
+ALTER TABLE uploads DROP COLUMN version_of;
+ALTER TABLE uploads DROP COLUMN metadata_tags;
+ALTER TABLE jobs DROP COLUMN tree_version;
+ALTER TABLE jobs DROP COLUMN alignment_version;
+DROP INDEX IF EXISTS idx_datasets_series_version;
+ALTER TABLE datasets DROP COLUMN metadata_tags;
+ALTER TABLE datasets DROP COLUMN version;
+ALTER TABLE datasets DROP COLUMN series_id;
+`,
+		},
+		{
+			Version: 6,
+			Name:    "accounts",
+			Up: `
+-- ============================================================================
+-- ACCOUNTS TABLE
+-- Registered accounts, signed in with a password or an OIDC identity. Each
+-- account owns a session subject that never expires, and anonymous sessions'
+-- resources can be claimed into it.
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS accounts (
+    id TEXT PRIMARY KEY,
+    subject TEXT NOT NULL UNIQUE,
+    email TEXT UNIQUE,
+    password_hash TEXT,
+    oidc_issuer TEXT,
+    oidc_subject TEXT,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    FOREIGN KEY (subject) REFERENCES sessions(subject) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_accounts_oidc ON accounts(oidc_issuer, oidc_subject);
+
+-- ============================================================================
+-- LOGINS TABLE
+-- One row per sign-in; account tokens carry the login ID and stop validating
+-- once the login has ended
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS logins (
+    id TEXT PRIMARY KEY,
+    account_id TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    ended_at INTEGER,
+    FOREIGN KEY (account_id) REFERENCES accounts(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_logins_account_id ON logins(account_id);
+`,
+			Down: `
+DROP TABLE IF EXISTS logins;
+DROP TABLE IF EXISTS accounts;
+`,
+		},
+		{
+			Version: 7,
+			Name:    "token_revocation",
+			Up: `
+-- ============================================================================
+-- REFRESH TOKENS TABLE
+-- Single-use refresh tokens, stored as SHA-256 hashes. Each refresh replaces the
+-- token with a new one in the same family; presenting a used token again revokes
+-- the whole family, since the token must have been stolen.
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    token_hash TEXT PRIMARY KEY,
+    family_id TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    account_id TEXT,
+    login_id TEXT,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at INTEGER,
+    revoked_at INTEGER,
+    FOREIGN KEY (subject) REFERENCES sessions(subject) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_login_id ON refresh_tokens(login_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);
+
+-- ============================================================================
+-- REVOKED TOKENS TABLE
+-- IDs (jti) of access tokens revoked before they expire, kept until they would
+-- have expired anyway
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti TEXT PRIMARY KEY,
+    expires_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
+`,
+			Down: `
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
 `,
 		},
 	}
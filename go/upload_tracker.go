@@ -0,0 +1,225 @@
+package datamonkey
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadRecord is the stored state of a resumable upload
+type UploadRecord struct {
+	Id        string
+	UserID    string
+	Meta      DatasetMeta
+	Length    int64
+	Offset    int64
+	HashState []byte // Marshaled SHA-256 state after Offset bytes
+	Sha256    string // Expected SHA-256 given by the client, if any
+	Created   time.Time
+	Expires   time.Time
+}
+
+// UploadTracker defines the interface for tracking resumable uploads
+type UploadTracker interface {
+	// Create stores a new upload and assigns its ID
+	Create(upload *UploadRecord) error
+
+	// CreateWithinQuota stores a new upload like Create if the user's uploads in progress
+	// and this one total at most quota bytes, and reports whether it was stored
+	CreateWithinQuota(upload *UploadRecord, quota int64) (bool, error)
+
+	// Get retrieves an upload by ID
+	Get(id string) (*UploadRecord, error)
+
+	// UpdateProgress records the bytes received so far and the hash state after them
+	UpdateProgress(id string, offset int64, hashState []byte, expires time.Time) error
+
+	// Delete removes an upload
+	Delete(id string) error
+
+	// UserBytes returns the total declared length of a user's uploads in progress
+	UserBytes(userID string) (int64, error)
+
+	// ListExpired returns uploads that expired before the given time
+	ListExpired(before time.Time) ([]*UploadRecord, error)
+}
+
+// SQLiteUploadTracker implements UploadTracker using the unified database
+type SQLiteUploadTracker struct {
+	db *sql.DB
+}
+
+// NewSQLiteUploadTracker creates a new SQLiteUploadTracker instance using the unified database
+func NewSQLiteUploadTracker(db *sql.DB) *SQLiteUploadTracker {
+	return &SQLiteUploadTracker{db: db}
+}
+
+// Create stores a new upload and assigns its ID
+func (t *SQLiteUploadTracker) Create(upload *UploadRecord) error {
+	_, err := t.insert(upload, "VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	return err
+}
+
+// CreateWithinQuota stores a new upload like Create if the user's uploads in progress
+// and this one total at most quota bytes, and reports whether it was stored. The quota is
+// checked by the INSERT itself, so concurrent uploads can't both fit in the same space.
+func (t *SQLiteUploadTracker) CreateWithinQuota(upload *UploadRecord, quota int64) (bool, error) {
+	userID := sql.NullString{String: upload.UserID, Valid: upload.UserID != ""}
+	rows, err := t.insert(upload, `SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+	WHERE (SELECT COALESCE(SUM(length), 0) FROM uploads WHERE user_id IS ?) + ? <= ?`,
+		userID, upload.Length, quota)
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		upload.Id = ""
+		return false, nil
+	}
+	return true, nil
+}
+
+// insert assigns an upload its ID and inserts it with the given VALUES or SELECT clause,
+// whose parameters after the upload's columns are extra; it returns the rows inserted
+func (t *SQLiteUploadTracker) insert(upload *UploadRecord, values string, extra ...interface{}) (int64, error) {
+	upload.Id = uuid.New().String()
+
+	query := `
+	INSERT INTO uploads (
+		id, user_id, metadata_name, metadata_description, metadata_type,
+		length, received, hash_state, sha256, created_at, expires_at,
+		metadata_tags, version_of
+	) ` + values
+	args := append([]interface{}{
+		upload.Id,
+		sql.NullString{String: upload.UserID, Valid: upload.UserID != ""},
+		upload.Meta.Name,
+		upload.Meta.Description,
+		upload.Meta.Type,
+		upload.Length,
+		upload.Offset,
+		upload.HashState,
+		upload.Sha256,
+		upload.Created.Unix(),
+		upload.Expires.Unix(),
+		marshalTags(upload.Meta.Tags),
+		sql.NullString{String: upload.Meta.VersionOf, Valid: upload.Meta.VersionOf != ""},
+	}, extra...)
+	result, err := t.db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store upload: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check rows affected: %v", err)
+	}
+	return rows, nil
+}
+
+// Get retrieves an upload by ID
+func (t *SQLiteUploadTracker) Get(id string) (*UploadRecord, error) {
+	query := `
+	SELECT id, user_id, metadata_name, metadata_description, metadata_type,
+	       length, received, hash_state, sha256, created_at, expires_at,
+	       metadata_tags, version_of
+	FROM uploads WHERE id = ?
+	`
+	upload, err := scanUpload(t.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("upload not found: %s", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload: %v", err)
+	}
+	return upload, nil
+}
+
+// UpdateProgress records the bytes received so far and the hash state after them
+func (t *SQLiteUploadTracker) UpdateProgress(id string, offset int64, hashState []byte, expires time.Time) error {
+	query := `UPDATE uploads SET received = ?, hash_state = ?, expires_at = ? WHERE id = ?`
+	result, err := t.db.Exec(query, offset, hashState, expires.Unix(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update upload: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %v", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("upload not found: %s", id)
+	}
+	return nil
+}
+
+// Delete removes an upload
+func (t *SQLiteUploadTracker) Delete(id string) error {
+	if _, err := t.db.Exec(`DELETE FROM uploads WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete upload: %v", err)
+	}
+	return nil
+}
+
+// UserBytes returns the total declared length of a user's uploads in progress
+func (t *SQLiteUploadTracker) UserBytes(userID string) (int64, error) {
+	var total int64
+	query := `SELECT COALESCE(SUM(length), 0) FROM uploads WHERE user_id IS ?`
+	if err := t.db.QueryRow(query, sql.NullString{String: userID, Valid: userID != ""}).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to sum uploads: %v", err)
+	}
+	return total, nil
+}
+
+// ListExpired returns uploads that expired before the given time
+func (t *SQLiteUploadTracker) ListExpired(before time.Time) ([]*UploadRecord, error) {
+	query := `
+	SELECT id, user_id, metadata_name, metadata_description, metadata_type,
+	       length, received, hash_state, sha256, created_at, expires_at,
+	       metadata_tags, version_of
+	FROM uploads WHERE expires_at < ?
+	`
+	rows, err := t.db.Query(query, before.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired uploads: %v", err)
+	}
+	defer rows.Close()
+
+	var uploads []*UploadRecord
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan upload: %v", err)
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+// scanUpload reads an upload row selected in the column order used above
+func scanUpload(row interface{ Scan(...interface{}) error }) (*UploadRecord, error) {
+	var upload UploadRecord
+	var userID sql.NullString
+	var description sql.NullString
+	var created, expires int64
+	var tags string
+	var versionOf sql.NullString
+	err := row.Scan(&upload.Id, &userID, &upload.Meta.Name, &description, &upload.Meta.Type,
+		&upload.Length, &upload.Offset, &upload.HashState, &upload.Sha256, &created, &expires,
+		&tags, &versionOf)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(tags), &upload.Meta.Tags); err != nil {
+		return nil, fmt.Errorf("invalid upload tags: %v", err)
+	}
+	upload.UserID = userID.String
+	upload.Meta.Description = description.String
+	upload.Meta.VersionOf = versionOf.String
+	upload.Created = time.Unix(created, 0)
+	upload.Expires = time.Unix(expires, 0)
+	return &upload, nil
+}
+
+// Ensure SQLiteUploadTracker implements UploadTracker interface
+var _ UploadTracker = (*SQLiteUploadTracker)(nil)
@@ -0,0 +1,306 @@
+package datamonkey
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Errors returned by UploadService, so handlers can choose a status code
+var (
+	ErrUploadTooLarge     = errors.New("upload exceeds the maximum size")
+	ErrUploadQuota        = errors.New("upload quota exceeded")
+	ErrUploadOffset       = errors.New("upload offset mismatch")
+	ErrUploadBusy         = errors.New("upload is already receiving data")
+	ErrUploadIncomplete   = errors.New("upload is incomplete")
+	ErrUploadHashMismatch = errors.New("upload content does not match the declared SHA-256")
+)
+
+// UploadConfig holds the limits for resumable uploads
+type UploadConfig struct {
+	Dir            string        // Where partial uploads are written; keep on the dataset filesystem so finalizing is a rename
+	MaxUploadBytes int64         // Maximum size of a single upload
+	UserQuotaBytes int64         // Maximum total size of one user's uploads in progress
+	Expiry         time.Duration // How long an upload may go without receiving data before it is discarded
+}
+
+// DefaultUploadConfig returns the limits used when none are configured
+func DefaultUploadConfig(dir string) UploadConfig {
+	return UploadConfig{
+		Dir:            dir,
+		MaxUploadBytes: 2 << 30,
+		UserQuotaBytes: 4 << 30,
+		Expiry:         24 * time.Hour,
+	}
+}
+
+// UploadService receives resumable uploads in chunks, hashing each chunk as it is
+// written so the content never has to be read back to be hashed
+type UploadService struct {
+	Config  UploadConfig
+	Tracker UploadTracker
+	mu      sync.Mutex
+	active  map[string]bool
+}
+
+// NewUploadService creates a new UploadService, filling unset limits with the defaults
+func NewUploadService(config UploadConfig, tracker UploadTracker) (*UploadService, error) {
+	defaults := DefaultUploadConfig(config.Dir)
+	if config.MaxUploadBytes <= 0 {
+		config.MaxUploadBytes = defaults.MaxUploadBytes
+	}
+	if config.UserQuotaBytes <= 0 {
+		config.UserQuotaBytes = defaults.UserQuotaBytes
+	}
+	if config.Expiry <= 0 {
+		config.Expiry = defaults.Expiry
+	}
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %v", err)
+	}
+
+	return &UploadService{
+		Config:  config,
+		Tracker: tracker,
+		active:  make(map[string]bool),
+	}, nil
+}
+
+// PartPath returns the file holding the bytes received for an upload
+func (s *UploadService) PartPath(id string) string {
+	return filepath.Join(s.Config.Dir, id+".part")
+}
+
+// Create starts an upload of length bytes for a user, enforcing the size limit and quota
+func (s *UploadService) Create(userID string, meta DatasetMeta, length int64, expectedSHA256 string) (*UploadRecord, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("upload length must be positive")
+	}
+	if length > s.Config.MaxUploadBytes {
+		return nil, fmt.Errorf("%w: %d bytes is more than %d", ErrUploadTooLarge, length, s.Config.MaxUploadBytes)
+	}
+	state, err := marshalHash(sha256.New())
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	upload := &UploadRecord{
+		UserID:    userID,
+		Meta:      meta,
+		Length:    length,
+		HashState: state,
+		Sha256:    expectedSHA256,
+		Created:   now,
+		Expires:   now.Add(s.Config.Expiry),
+	}
+	// The quota is checked as the upload is stored, so concurrent requests can't overrun it
+	created, err := s.Tracker.CreateWithinQuota(upload, s.Config.UserQuotaBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		inProgress, err := s.Tracker.UserBytes(userID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %d bytes already in progress, limit is %d", ErrUploadQuota, inProgress, s.Config.UserQuotaBytes)
+	}
+	if err := os.WriteFile(s.PartPath(upload.Id), nil, 0644); err != nil {
+		s.Tracker.Delete(upload.Id)
+		return nil, fmt.Errorf("failed to create upload file: %v", err)
+	}
+	return upload, nil
+}
+
+// Append writes a chunk starting at offset. Bytes received before a read error are kept,
+// so a client whose connection drops can resume from the returned upload's Offset.
+func (s *UploadService) Append(id string, offset int64, chunk io.Reader) (*UploadRecord, error) {
+	if !s.acquire(id) {
+		return nil, ErrUploadBusy
+	}
+	defer s.release(id)
+
+	upload, err := s.Tracker.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return upload, fmt.Errorf("%w: upload is at %d, chunk starts at %d", ErrUploadOffset, upload.Offset, offset)
+	}
+
+	digest := sha256.New()
+	if err := unmarshalHash(digest, upload.HashState); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(s.PartPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload file: %v", err)
+	}
+	defer file.Close()
+	// Drop anything written after the last recorded offset, e.g. by a crash mid-chunk
+	if err := file.Truncate(upload.Offset); err != nil {
+		return nil, fmt.Errorf("failed to truncate upload file: %v", err)
+	}
+	if _, err := file.Seek(upload.Offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek upload file: %v", err)
+	}
+
+	remaining := upload.Length - upload.Offset
+	written, copyErr := io.Copy(io.MultiWriter(file, digest), io.LimitReader(chunk, remaining))
+	if copyErr == nil && written == remaining {
+		if n, _ := chunk.Read(make([]byte, 1)); n > 0 {
+			file.Truncate(upload.Offset)
+			return upload, fmt.Errorf("%w: chunk extends past the declared length of %d bytes", ErrUploadTooLarge, upload.Length)
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync upload file: %v", err)
+	}
+
+	state, err := marshalHash(digest)
+	if err != nil {
+		return nil, err
+	}
+	upload.Offset += written
+	upload.HashState = state
+	upload.Expires = time.Now().Add(s.Config.Expiry)
+	if err := s.Tracker.UpdateProgress(id, upload.Offset, upload.HashState, upload.Expires); err != nil {
+		return nil, err
+	}
+	if copyErr != nil {
+		return upload, fmt.Errorf("upload interrupted after %d bytes: %w", written, copyErr)
+	}
+	return upload, nil
+}
+
+// Complete checks that every byte of an upload has arrived and returns its SHA-256
+func (s *UploadService) Complete(upload *UploadRecord) (string, error) {
+	if upload.Offset != upload.Length {
+		return "", fmt.Errorf("%w: received %d of %d bytes", ErrUploadIncomplete, upload.Offset, upload.Length)
+	}
+	sum, err := uploadSHA256(upload)
+	if err != nil {
+		return "", err
+	}
+	if upload.Sha256 != "" && upload.Sha256 != sum {
+		return "", fmt.Errorf("%w: got %s, expected %s", ErrUploadHashMismatch, sum, upload.Sha256)
+	}
+	return sum, nil
+}
+
+// Discard removes an upload and its partial file
+func (s *UploadService) Discard(id string) error {
+	if err := os.Remove(s.PartPath(id)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove upload file for %s: %v", id, err)
+	}
+	return s.Tracker.Delete(id)
+}
+
+// CleanupStale discards uploads that have not received data before their expiry
+func (s *UploadService) CleanupStale() (int, error) {
+	expired, err := s.Tracker.ListExpired(time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, upload := range expired {
+		// Leave uploads that are receiving data right now for the next pass
+		if !s.acquire(upload.Id) {
+			continue
+		}
+		err := s.Discard(upload.Id)
+		s.release(upload.Id)
+		if err != nil {
+			log.Printf("Failed to discard stale upload %s: %v", upload.Id, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// StartUploadCleanup starts a background goroutine to discard stale uploads
+func (s *UploadService) StartUploadCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			count, err := s.CleanupStale()
+			if err != nil {
+				log.Printf("Error cleaning up stale uploads: %v", err)
+			} else if count > 0 {
+				log.Printf("Cleaned up %d stale uploads", count)
+			}
+		}
+	}()
+
+	log.Printf("Started upload cleanup task (interval: %v, expiry: %v)", interval, s.Config.Expiry)
+}
+
+// ToUpload converts an upload record to its API representation
+func (s *UploadService) ToUpload(upload *UploadRecord) Upload {
+	result := Upload{
+		Id:       upload.Id,
+		Meta:     upload.Meta,
+		Length:   upload.Length,
+		Offset:   upload.Offset,
+		Complete: upload.Offset == upload.Length,
+		Created:  upload.Created,
+		Expires:  upload.Expires,
+	}
+	if result.Complete {
+		result.Sha256, _ = uploadSHA256(upload)
+	}
+	return result
+}
+
+func (s *UploadService) acquire(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active[id] {
+		return false
+	}
+	s.active[id] = true
+	return true
+}
+
+func (s *UploadService) release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.active, id)
+}
+
+// uploadSHA256 finishes the stored hash state of an upload
+func uploadSHA256(upload *UploadRecord) (string, error) {
+	digest := sha256.New()
+	if err := unmarshalHash(digest, upload.HashState); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+func marshalHash(digest hash.Hash) ([]byte, error) {
+	state, err := digest.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to save hash state: %v", err)
+	}
+	return state, nil
+}
+
+func unmarshalHash(digest hash.Hash, state []byte) error {
+	if err := digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return fmt.Errorf("failed to restore hash state: %v", err)
+	}
+	return nil
+}
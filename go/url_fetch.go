@@ -0,0 +1,379 @@
+package datamonkey
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Errors returned by URLFetcher.Fetch, so handlers can choose a status code
+var (
+	ErrURLNotAllowed = errors.New("URL not allowed")
+	ErrURLTooLarge   = errors.New("download exceeds the size limit")
+	ErrBadArchive    = errors.New("invalid compressed data")
+)
+
+// Compression formats recognised in downloaded datasets
+const (
+	CompressionNone  = ""
+	CompressionGzip  = "gzip"
+	CompressionBzip2 = "bzip2"
+	CompressionZip   = "zip"
+)
+
+// maxURLRedirects is how many redirects a dataset download may follow
+const maxURLRedirects = 5
+
+// blockedNetworks are address ranges not covered by the net.IP helpers that
+// a dataset URL must not reach
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // "this" network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved
+)
+
+// URLFetchConfig holds the limits applied to datasets imported from URLs
+type URLFetchConfig struct {
+	MaxBytes             int64         // Maximum size of the download and of its decompressed content
+	Timeout              time.Duration // Maximum time for the whole download
+	AllowPrivateNetworks bool          // Allow loopback and private addresses (tests and closed deployments only)
+	S3Endpoint           string        // Base URL that s3://bucket/key URLs are read from; s3 is disabled when empty. It may be a private address.
+}
+
+// DefaultURLFetchConfig returns the limits used when none are configured
+func DefaultURLFetchConfig() URLFetchConfig {
+	return URLFetchConfig{
+		MaxBytes: 100 << 20,
+		Timeout:  2 * time.Minute,
+	}
+}
+
+// URLFetcher downloads datasets from remote URLs to local files. Only http and https
+// (and s3 when an endpoint is configured) are allowed, connections to private and
+// loopback addresses other than the configured S3 endpoint are refused at dial time so
+// redirects and DNS changes cannot reach them, and downloads are streamed to disk under
+// a size and time limit.
+type URLFetcher struct {
+	Config URLFetchConfig
+	client *http.Client
+}
+
+// FetchedFile is a downloaded dataset, decompressed if it was compressed
+type FetchedFile struct {
+	Path        string // Local file holding the (decompressed) content; the caller removes it
+	Size        int64  // Size of the content in bytes
+	SHA256      string // Hex-encoded SHA-256 of the content
+	Compression string // Compression the download was stored with, if any
+}
+
+// NewURLFetcher creates a new URLFetcher, filling unset limits with the defaults
+func NewURLFetcher(config URLFetchConfig) *URLFetcher {
+	defaults := DefaultURLFetchConfig()
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = defaults.MaxBytes
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+
+	fetcher := &URLFetcher{Config: config}
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: fetcher.checkDialAddress,
+	}
+	// The S3 endpoint is set by the operator, so it is dialed without the address check;
+	// a MinIO or other object store is usually on a private network
+	endpointDialer := &net.Dialer{Timeout: 30 * time.Second}
+	endpoint := s3EndpointAddress(config.S3Endpoint)
+	transport := &http.Transport{
+		// Never use an environment proxy: the dialed address must be the checked one
+		Proxy: nil,
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if endpoint != "" && strings.EqualFold(address, endpoint) {
+				return endpointDialer.DialContext(ctx, network, address)
+			}
+			return dialer.DialContext(ctx, network, address)
+		},
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	fetcher.client = &http.Client{
+		Transport: transport,
+		Timeout:   config.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxURLRedirects {
+				return fmt.Errorf("%w: more than %d redirects", ErrURLNotAllowed, maxURLRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("%w: redirect to unsupported scheme %q", ErrURLNotAllowed, req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+	return fetcher
+}
+
+// resolveURL checks the scheme of a dataset URL and maps s3:// URLs to the configured endpoint
+func (f *URLFetcher) resolveURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrURLNotAllowed, err)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%w: URL has no host", ErrURLNotAllowed)
+		}
+		if u.User != nil {
+			return nil, fmt.Errorf("%w: credentials in URLs are not supported", ErrURLNotAllowed)
+		}
+		return u, nil
+	case "s3":
+		if f.Config.S3Endpoint == "" {
+			return nil, fmt.Errorf("%w: s3 URLs are not enabled on this server", ErrURLNotAllowed)
+		}
+		key := strings.TrimPrefix(u.Path, "/")
+		if u.Host == "" || key == "" {
+			return nil, fmt.Errorf("%w: s3 URLs must have the form s3://bucket/key", ErrURLNotAllowed)
+		}
+		endpoint, err := url.Parse(strings.TrimSuffix(f.Config.S3Endpoint, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 endpoint: %v", err)
+		}
+		endpoint.Path = path.Join(endpoint.Path, "/", u.Host, key)
+		return endpoint, nil
+	case "":
+		return nil, fmt.Errorf("%w: URL has no scheme", ErrURLNotAllowed)
+	default:
+		return nil, fmt.Errorf("%w: unsupported scheme %q (use http or https)", ErrURLNotAllowed, u.Scheme)
+	}
+}
+
+// s3EndpointAddress returns the host:port dialed for an S3 endpoint, or "" if none is configured
+func s3EndpointAddress(endpoint string) string {
+	if endpoint == "" {
+		return ""
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if strings.EqualFold(u.Scheme, "https") {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// checkDialAddress refuses connections to loopback, private and other non-public addresses
+func (f *URLFetcher) checkDialAddress(network, address string, _ syscall.RawConn) error {
+	if f.Config.AllowPrivateNetworks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrURLNotAllowed, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s is not a public address", ErrURLNotAllowed, host)
+	}
+	return nil
+}
+
+// isPublicIP reports whether an address is globally routable
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fetch downloads a dataset URL into dir, decompressing gzip, bzip2 and single-file zip
+// downloads, and returns the local file with its size and SHA-256
+func (f *URLFetcher) Fetch(ctx context.Context, rawURL string, dir string) (*FetchedFile, error) {
+	u, err := f.resolveURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrURLNotAllowed, err)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("remote server returned %s", resp.Status)
+	}
+	if resp.ContentLength > f.Config.MaxBytes {
+		return nil, fmt.Errorf("%w: %d bytes is more than %d", ErrURLTooLarge, resp.ContentLength, f.Config.MaxBytes)
+	}
+
+	// Stream the download to disk; the size limit also covers responses without a Content-Length
+	download, err := os.CreateTemp(dir, "download-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(download.Name())
+	defer download.Close()
+
+	written, err := io.Copy(download, io.LimitReader(resp.Body, f.Config.MaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("download failed: %w", err)
+	}
+	if written > f.Config.MaxBytes {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrURLTooLarge, f.Config.MaxBytes)
+	}
+	if _, err := download.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return decompressToFile(download, written, dir, f.Config.MaxBytes)
+}
+
+// DetectCompression identifies gzip, bzip2 and zip content from its leading bytes
+func DetectCompression(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return CompressionGzip
+	case bytes.HasPrefix(header, []byte("BZh")):
+		return CompressionBzip2
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")):
+		return CompressionZip
+	default:
+		return CompressionNone
+	}
+}
+
+// decompressToFile copies src into a new file in dir, decompressing it if needed and
+// hashing the result. The decompressed size is limited too, so archives cannot expand
+// past maxBytes.
+func decompressToFile(src *os.File, size int64, dir string, maxBytes int64) (*FetchedFile, error) {
+	header, err := bufio.NewReader(src).Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	compression := DetectCompression(header)
+	var content io.Reader
+	switch compression {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, fmt.Errorf("%w: gzip: %v", ErrBadArchive, err)
+		}
+		defer gz.Close()
+		content = gz
+	case CompressionBzip2:
+		content = bzip2.NewReader(src)
+	case CompressionZip:
+		entry, err := singleZipEntry(src, size)
+		if err != nil {
+			return nil, err
+		}
+		defer entry.Close()
+		content = entry
+	default:
+		content = src
+	}
+
+	out, err := os.CreateTemp(dir, "dataset-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(out, hash), io.LimitReader(content, maxBytes+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > maxBytes {
+		err = fmt.Errorf("%w: decompressed content is more than %d bytes", ErrURLTooLarge, maxBytes)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		if compression != CompressionNone && !errors.Is(err, ErrURLTooLarge) {
+			return nil, fmt.Errorf("%w: %s: %v", ErrBadArchive, compression, err)
+		}
+		return nil, err
+	}
+
+	return &FetchedFile{
+		Path:        out.Name(),
+		Size:        written,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		Compression: compression,
+	}, nil
+}
+
+// singleZipEntry opens the only file in a zip archive, ignoring directories and macOS metadata
+func singleZipEntry(src *os.File, size int64) (io.ReadCloser, error) {
+	archive, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: zip: %v", ErrBadArchive, err)
+	}
+
+	var entry *zip.File
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || strings.HasPrefix(file.Name, "__MACOSX/") {
+			continue
+		}
+		if entry != nil {
+			return nil, fmt.Errorf("%w: zip archive contains more than one file", ErrBadArchive)
+		}
+		entry = file
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("%w: zip archive contains no files", ErrBadArchive)
+	}
+	return entry.Open()
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
@@ -12,10 +12,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	sw "github.com/d-callan/service-datamonkey/go"
@@ -118,6 +124,163 @@ func initLocalSlurmConfig() sw.SlurmConfig {
 	}
 }
 
+// initLocalSchedulerConfig initializes and returns configuration for the local process scheduler
+func initLocalSchedulerConfig() sw.LocalSchedulerConfig {
+	maxWorkers, err := strconv.Atoi(getEnvWithDefault("LOCAL_SCHEDULER_MAX_WORKERS", "0"))
+	if err != nil {
+		log.Fatalf("Invalid LOCAL_SCHEDULER_MAX_WORKERS: %v", err)
+	}
+
+	return sw.LocalSchedulerConfig{
+		MaxWorkers:       maxWorkers, // 0 means one worker per CPU
+		StateFile:        getEnvWithDefault("LOCAL_SCHEDULER_STATE_FILE", "/data/stores/local_scheduler_state.json"),
+		RequeueOnRestart: getEnvWithDefault("LOCAL_SCHEDULER_REQUEUE_ON_RESTART", "false") == "true",
+	}
+}
+
+// initTorqueConfig initializes and returns Torque/PBS configuration
+func initTorqueConfig() sw.TorqueConfig {
+	nodeCount, err := strconv.Atoi(getEnvWithDefault("TORQUE_NODE_COUNT", "1"))
+	if err != nil {
+		log.Fatalf("Invalid TORQUE_NODE_COUNT: %v", err)
+	}
+	coresPerNode, err := strconv.Atoi(getEnvWithDefault("TORQUE_CORES_PER_NODE", "1"))
+	if err != nil {
+		log.Fatalf("Invalid TORQUE_CORES_PER_NODE: %v", err)
+	}
+
+	// Resource settings are defaults; jobs may override them via metadata
+	return sw.TorqueConfig{
+		Queue:         getEnvWithDefault("TORQUE_QUEUE", ""),
+		NodeCount:     nodeCount,
+		CoresPerNode:  coresPerNode,
+		MemoryPerNode: getEnvWithDefault("TORQUE_MEMORY_PER_NODE", "1gb"),
+		WallTime:      getEnvWithDefault("TORQUE_WALL_TIME", "01:00:00"),
+		AccountName:   getEnvWithDefault("TORQUE_ACCOUNT", ""),
+	}
+}
+
+// initURLFetchConfig initializes the limits applied to datasets imported from URLs
+func initURLFetchConfig() sw.URLFetchConfig {
+	maxBytes, err := strconv.ParseInt(getEnvWithDefault("DATASET_URL_MAX_BYTES", "104857600"), 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid DATASET_URL_MAX_BYTES: %v", err)
+	}
+	timeout, err := time.ParseDuration(getEnvWithDefault("DATASET_URL_TIMEOUT", "2m"))
+	if err != nil {
+		log.Fatalf("Invalid DATASET_URL_TIMEOUT: %v", err)
+	}
+
+	return sw.URLFetchConfig{
+		MaxBytes:             maxBytes,
+		Timeout:              timeout,
+		AllowPrivateNetworks: getEnvWithDefault("DATASET_URL_ALLOW_PRIVATE_NETWORKS", "false") == "true",
+		S3Endpoint:           getEnvWithDefault("DATASET_URL_S3_ENDPOINT", ""),
+	}
+}
+
+// initBlobStores initializes where dataset contents and job results are kept. With an S3
+// backend, jobs download datasets onto their compute node and HyPhy still writes results
+// to basePath.
+func initBlobStores(dataDir string, basePath string) (datasets sw.BlobStore, results sw.BlobStore) {
+	backend := getEnvWithDefault("STORAGE_BACKEND", "filesystem")
+	switch backend {
+	case "filesystem":
+		return sw.NewFileBlobStore(dataDir), sw.NewFileBlobStore(basePath)
+	case "s3":
+		config := sw.S3Config{
+			Endpoint:        getEnvWithFatal("S3_ENDPOINT"),
+			Region:          getEnvWithDefault("S3_REGION", "us-east-1"),
+			Bucket:          getEnvWithFatal("S3_BUCKET"),
+			AccessKeyID:     getEnvWithDefault("S3_ACCESS_KEY_ID", ""),
+			SecretAccessKey: getEnvWithDefault("S3_SECRET_ACCESS_KEY", ""),
+		}
+		presignExpiry, err := time.ParseDuration(getEnvWithDefault("S3_PRESIGN_EXPIRY", "168h"))
+		if err != nil {
+			log.Fatalf("Invalid S3_PRESIGN_EXPIRY: %v", err)
+		}
+		datasetConfig, resultConfig := config, config
+		datasetConfig.Prefix, resultConfig.Prefix = "datasets/", "results/"
+		datasetConfig.StagingDir = getEnvWithDefault("S3_STAGING_DIR", dataDir)
+		datasetConfig.PresignExpiry = presignExpiry
+
+		datasetStore, err := sw.NewS3BlobStore(datasetConfig)
+		if err != nil {
+			log.Fatalf("Failed to initialize dataset storage: %v", err)
+		}
+		resultStore, err := sw.NewS3BlobStore(resultConfig)
+		if err != nil {
+			log.Fatalf("Failed to initialize result storage: %v", err)
+		}
+		log.Printf("Storing datasets and results in S3 bucket %s at %s", config.Bucket, config.Endpoint)
+		return datasetStore, resultStore
+	default:
+		log.Fatalf("Invalid STORAGE_BACKEND: %s (must be filesystem or s3)", backend)
+		return nil, nil
+	}
+}
+
+// initUploadService initializes resumable uploads, kept next to the datasets so finalizing is a rename
+func initUploadService(db *sw.UnifiedDB, dataDir string) *sw.UploadService {
+	maxBytes, err := strconv.ParseInt(getEnvWithDefault("UPLOAD_MAX_BYTES", "2147483648"), 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid UPLOAD_MAX_BYTES: %v", err)
+	}
+	quotaBytes, err := strconv.ParseInt(getEnvWithDefault("UPLOAD_USER_QUOTA_BYTES", "4294967296"), 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid UPLOAD_USER_QUOTA_BYTES: %v", err)
+	}
+	expiry, err := time.ParseDuration(getEnvWithDefault("UPLOAD_EXPIRY", "24h"))
+	if err != nil {
+		log.Fatalf("Invalid UPLOAD_EXPIRY: %v", err)
+	}
+
+	uploadService, err := sw.NewUploadService(sw.UploadConfig{
+		Dir:            getEnvWithDefault("UPLOAD_LOCATION", filepath.Join(dataDir, ".partial")),
+		MaxUploadBytes: maxBytes,
+		UserQuotaBytes: quotaBytes,
+		Expiry:         expiry,
+	}, sw.NewSQLiteUploadTracker(db.GetDB()))
+	if err != nil {
+		log.Fatalf("Failed to initialize uploads: %v", err)
+	}
+
+	// Discard partial uploads that stopped receiving data
+	uploadService.StartUploadCleanup(1 * time.Hour)
+	return uploadService
+}
+
+// initResourceProfiles loads per-method scheduler resource profiles
+func initResourceProfiles() *sw.ResourceProfileConfig {
+	// Without a profile file the built-in profiles are used
+	path := getEnvWithDefault("RESOURCE_PROFILES_PATH", "")
+	profiles, err := sw.LoadResourceProfiles(path)
+	if err != nil {
+		log.Fatalf("Failed to load resource profiles: %v", err)
+	}
+	if path != "" {
+		log.Printf("Loaded resource profiles from %s", path)
+	}
+	return profiles
+}
+
+// initHyPhyVersion returns the HyPhy version that identifies jobs, from HYPHY_VERSION or `hyphy --version`
+func initHyPhyVersion(hyPhyPath string) string {
+	// Set HYPHY_VERSION when HyPhy only runs on compute nodes
+	if version := getEnvWithDefault("HYPHY_VERSION", ""); version != "" {
+		log.Printf("Using HyPhy version %s from HYPHY_VERSION", version)
+		return version
+	}
+
+	version, err := sw.DetectHyPhyVersion(hyPhyPath)
+	if err != nil {
+		log.Printf("Warning: Failed to detect HyPhy version, job IDs will not track HyPhy upgrades: %v", err)
+		return "unknown"
+	}
+	log.Printf("Detected HyPhy version: %s", version)
+	return version
+}
+
 // initScheduler initializes and returns a scheduler based on environment configuration
 func initScheduler(jobTracker sw.JobTracker) sw.SchedulerInterface {
 	schedulerType := getEnvWithDefault("SCHEDULER_TYPE", "SlurmRestScheduler")
@@ -129,6 +292,12 @@ func initScheduler(jobTracker sw.JobTracker) sw.SchedulerInterface {
 	case "SlurmScheduler":
 		config := initLocalSlurmConfig()
 		return sw.NewSlurmScheduler(config, jobTracker)
+	case "LocalScheduler":
+		config := initLocalSchedulerConfig()
+		return sw.NewLocalScheduler(config, jobTracker)
+	case "TorqueScheduler":
+		config := initTorqueConfig()
+		return sw.NewTorqueScheduler(config, jobTracker)
 	default:
 		log.Fatalf("Unknown scheduler type: %s", schedulerType)
 		return nil
@@ -136,7 +305,7 @@ func initScheduler(jobTracker sw.JobTracker) sw.SchedulerInterface {
 }
 
 // initSessionService initializes the session service for user authentication and session management
-func initSessionService(sessionTracker sw.SessionTracker) *sw.SessionService {
+func initSessionService(sessionTracker sw.SessionTracker, accountTracker sw.AccountTracker, tokenStore sw.TokenStore) *sw.SessionService {
 	// Check if session service is enabled
 	enabled := getEnvWithDefault("USER_TOKEN_ENABLED", "true") == "true"
 	if !enabled {
@@ -144,33 +313,62 @@ func initSessionService(sessionTracker sw.SessionTracker) *sw.SessionService {
 		return nil
 	}
 
-	// Get JWT key path from environment or use default
-	jwtKeyPath := getEnvWithDefault("USER_JWT_KEY_PATH", "")
-	// Use SLURM JWT key if user key is not specified
-	if jwtKeyPath == "" {
-		slurmKeyPath := getEnvWithDefault("JWT_KEY_PATH", "")
-		if slurmKeyPath != "" {
-			log.Printf("Using Slurm JWT key for user tokens: %s", slurmKeyPath)
-			jwtKeyPath = slurmKeyPath
+	// User tokens have their own keys: a file, or a directory of keys to rotate through.
+	// Sharing the Slurm key would let a leak of either key forge tokens for both.
+	jwtKeyPath := getEnvWithDefault("USER_JWT_KEY_PATH", "/data/stores/jwt_keys")
+	if slurmKeyPath := os.Getenv("JWT_KEY_PATH"); slurmKeyPath != "" && slurmKeyPath == jwtKeyPath {
+		log.Printf("Warning: USER_JWT_KEY_PATH is the Slurm JWT key; give user tokens their own key")
+	}
+	if err := ensureSigningKey(jwtKeyPath, getEnvWithDefault("USER_JWT_ALGORITHM", sw.AlgorithmEdDSA)); err != nil {
+		log.Fatalf("Failed to set up JWT keys for user tokens: %v", err)
+	}
+
+	// Tokens issued before user tokens had their own keys were signed with the Slurm key.
+	// Keep verifying them until they expire, so nobody loses their session on upgrade.
+	legacyKeyPath := os.Getenv("USER_JWT_LEGACY_KEY_PATH")
+	if legacyKeyPath == "" && os.Getenv("USER_JWT_KEY_PATH") == "" {
+		legacyKeyPath = os.Getenv("JWT_KEY_PATH")
+	}
+	var legacyKeyUntil time.Time
+	if legacyKeyPath != "" {
+		if _, err := os.Stat(legacyKeyPath); err != nil {
+			log.Printf("Warning: Legacy JWT key %s not readable, tokens it signed will not validate: %v", legacyKeyPath, err)
+			legacyKeyPath = ""
+		} else if legacyKeyUntil, err = legacyKeyDeadline(jwtKeyPath); err != nil {
+			log.Fatalf("Invalid USER_JWT_LEGACY_KEY_UNTIL: %v", err)
 		} else {
-			log.Fatalf("No JWT key specified for user tokens")
-			return nil
+			log.Printf("Verifying tokens signed with the legacy JWT key %s until %s", legacyKeyPath, legacyKeyUntil.Format(time.RFC3339))
 		}
 	}
 
-	// Get token expiration from environment or use default
-	expirationSecs := int64(86400) // Default to 24 hours
+	accessTokenTTL, err := time.ParseDuration(getEnvWithDefault("USER_ACCESS_TOKEN_TTL", "15m"))
+	if err != nil {
+		log.Fatalf("Invalid USER_ACCESS_TOKEN_TTL: %v", err)
+	}
+	refreshTokenTTL, err := time.ParseDuration(getEnvWithDefault("USER_REFRESH_TOKEN_TTL", "720h"))
+	if err != nil {
+		log.Fatalf("Invalid USER_REFRESH_TOKEN_TTL: %v", err)
+	}
 
 	// Create token config
 	config := sw.TokenConfig{
 		KeyPath:         jwtKeyPath,
+		SigningKeyID:    getEnvWithDefault("USER_JWT_SIGNING_KEY_ID", ""),
+		LegacyKeyPath:   legacyKeyPath,
+		LegacyKeyUntil:  legacyKeyUntil,
 		Username:        "datamonkey",
-		ExpirationSecs:  expirationSecs,
+		ExpirationSecs:  int64(accessTokenTTL.Seconds()),
+		RefreshTokenTTL: refreshTokenTTL,
 		RefreshInterval: 12 * time.Hour,
 	}
 
 	// Create session service
 	sessionService := sw.NewSessionService(config, sessionTracker)
+	sessionService.Accounts = accountTracker
+	sessionService.Tokens = tokenStore
+	if _, err := sessionService.Keys.SigningKey(); err != nil {
+		log.Fatalf("Failed to load JWT keys for user tokens: %v", err)
+	}
 
 	// Start session cleanup task (runs every hour, removes sessions older than 30 days)
 	cleanupInterval := 1 * time.Hour
@@ -181,8 +379,86 @@ func initSessionService(sessionTracker sw.SessionTracker) *sw.SessionService {
 	return sessionService
 }
 
+// legacyTokenTTL is how long tokens were valid for before they had key IDs
+const legacyTokenTTL = 24 * time.Hour
+
+// legacyKeyDeadline returns when the legacy key stops verifying: USER_JWT_LEGACY_KEY_UNTIL,
+// or else the lifetime of a legacy token after the oldest key in keyPath was added, since
+// the legacy key signed nothing after that
+func legacyKeyDeadline(keyPath string) (time.Time, error) {
+	if until := os.Getenv("USER_JWT_LEGACY_KEY_UNTIL"); until != "" {
+		return time.Parse(time.RFC3339, until)
+	}
+
+	paths := []string{keyPath}
+	if info, err := os.Stat(keyPath); err == nil && info.IsDir() {
+		entries, err := os.ReadDir(keyPath)
+		if err != nil {
+			return time.Time{}, err
+		}
+		paths = paths[:0]
+		for _, entry := range entries {
+			if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				paths = append(paths, filepath.Join(keyPath, entry.Name()))
+			}
+		}
+	}
+	oldest := time.Now()
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && info.ModTime().Before(oldest) {
+			oldest = info.ModTime()
+		}
+	}
+	return oldest.Add(legacyTokenTTL), nil
+}
+
+// ensureSigningKey generates a key in a key directory that has none, so a fresh
+// deployment works without a manual key setup. An existing key file is left alone.
+func ensureSigningKey(keyPath string, algorithm string) error {
+	info, err := os.Stat(keyPath)
+	if err == nil && !info.IsDir() {
+		return nil
+	}
+	if err == nil {
+		entries, err := os.ReadDir(keyPath)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				return nil
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	path, err := sw.GenerateSigningKey(keyPath, algorithm)
+	if err != nil {
+		return err
+	}
+	log.Printf("Generated %s JWT signing key: %s", algorithm, path)
+	return nil
+}
+
+// initOIDCProvider returns the OIDC provider accounts can sign in with, or nil if OIDC_ISSUER_URL is not set
+func initOIDCProvider() *sw.OIDCProvider {
+	issuerURL := getEnvWithDefault("OIDC_ISSUER_URL", "")
+	if issuerURL == "" {
+		return nil
+	}
+	config := sw.OIDCConfig{
+		IssuerURL:    issuerURL,
+		ClientID:     getEnvWithFatal("OIDC_CLIENT_ID"),
+		ClientSecret: getEnvWithDefault("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:  getEnvWithFatal("OIDC_REDIRECT_URL"),
+	}
+	log.Printf("OIDC sign-in enabled with issuer %s", issuerURL)
+	return sw.NewOIDCProvider(config)
+}
+
 // initAPIHandlers initializes the API handlers with the given components
-func initAPIHandlers(scheduler sw.SchedulerInterface, datasetTracker sw.DatasetTracker, jobTracker sw.JobTracker, conversationTracker sw.ConversationTracker, vizTracker sw.VisualizationTracker, sessionService *sw.SessionService) sw.ApiHandleFunctions {
+func initAPIHandlers(scheduler sw.SchedulerInterface, datasetTracker sw.DatasetTracker, jobTracker sw.JobTracker, conversationTracker sw.ConversationTracker, vizTracker sw.VisualizationTracker, accountTracker sw.AccountTracker, sessionService *sw.SessionService, jobEvents *sw.JobEventBus, uploadService *sw.UploadService, resultStore sw.BlobStore) sw.ApiHandleFunctions {
 	// Get HyPhy executable path from environment or use default
 	hyPhyPath := getEnvWithDefault("HYPHY_PATH", "hyphy")
 	// TODO: change this default so that upload files and log/ results are stored in a different directory
@@ -218,6 +494,22 @@ func initAPIHandlers(scheduler sw.SchedulerInterface, datasetTracker sw.DatasetT
 	nrmAPI := sw.NewNRMAPI(basePath, hyPhyPath, scheduler, datasetTracker, jobTracker)
 	fadeAPI := sw.NewFADEAPI(basePath, hyPhyPath, scheduler, datasetTracker, jobTracker)
 	slatkinAPI := sw.NewSLATKINAPI(basePath, hyPhyPath, scheduler, datasetTracker, jobTracker)
+	jobLauncher := sw.NewHyPhyBaseAPI(basePath, hyPhyPath, scheduler, datasetTracker, jobTracker, sessionService)
+
+	// Set the resource profiles, job event bus, HyPhy version and result store for each API
+	resourceProfiles := initResourceProfiles()
+	hyPhyVersion := initHyPhyVersion(hyPhyPath)
+	for _, api := range []*sw.HyPhyBaseAPI{
+		&absrelAPI.HyPhyBaseAPI, &felAPI.HyPhyBaseAPI, &bustedAPI.HyPhyBaseAPI, &slacAPI.HyPhyBaseAPI,
+		&multihitAPI.HyPhyBaseAPI, &gardAPI.HyPhyBaseAPI, &memeAPI.HyPhyBaseAPI, &fubarAPI.HyPhyBaseAPI,
+		&contrastfelAPI.HyPhyBaseAPI, &relaxAPI.HyPhyBaseAPI, &bgmAPI.HyPhyBaseAPI, &nrmAPI.HyPhyBaseAPI,
+		&fadeAPI.HyPhyBaseAPI, &slatkinAPI.HyPhyBaseAPI, &jobLauncher,
+	} {
+		api.ResourceProfiles = resourceProfiles
+		api.Events = jobEvents
+		api.HyPhyVersion = hyPhyVersion
+		api.Results = resultStore
+	}
 
 	// Set the SessionService for each API
 	if sessionService != nil {
@@ -239,15 +531,35 @@ func initAPIHandlers(scheduler sw.SchedulerInterface, datasetTracker sw.DatasetT
 
 	// Create JobsAPI
 	jobsAPI := sw.NewJobsAPI(jobTracker, sessionService, scheduler)
+	jobsAPI.Events = jobEvents
+	jobsAPI.VisualizationTracker = vizTracker
+	jobsAPI.Results = resultStore
+	jobsAPI.JobLauncher = &jobLauncher
+	jobsAPI.MethodFactory = func(methodType sw.HyPhyMethodType) (sw.ComputeMethodInterface, error) {
+		return sw.NewHyPhyMethod(nil, basePath, hyPhyPath, methodType, ""), nil
+	}
 
 	// Create MethodsAPI
 	methodsAPI := sw.NewMethodsAPIService()
 
+	// Create FileUploadAndQCAPI
+	fileUploadAPI := sw.NewFileUploadAndQCAPI(datasetTracker, sessionService)
+	fileUploadAPI.URLFetcher = sw.NewURLFetcher(initURLFetchConfig())
+	fileUploadAPI.Uploads = uploadService
+
 	// Create VisualizationsAPI
 	visualizationsAPI := sw.NewVisualizationsAPI(vizTracker, sessionService)
 
+	// Create AuthAPI
+	authAPI := sw.NewAuthAPI(sessionService, accountTracker)
+	authAPI.OIDC = initOIDCProvider()
+
+	// Create the Authorizer that enforces the route policies
+	authorizer := sw.NewAuthorizer(sessionService, jobTracker, datasetTracker, conversationTracker, vizTracker, uploadService.Tracker)
+
 	return sw.ApiHandleFunctions{
 		ABSRELAPI:          *absrelAPI,
+		AuthAPI:            *authAPI,
 		FELAPI:             *felAPI,
 		BUSTEDAPI:          *bustedAPI,
 		SLACAPI:            *slacAPI,
@@ -261,7 +573,7 @@ func initAPIHandlers(scheduler sw.SchedulerInterface, datasetTracker sw.DatasetT
 		NRMAPI:             *nrmAPI,
 		FADEAPI:            *fadeAPI,
 		SLATKINAPI:         *slatkinAPI,
-		FileUploadAndQCAPI: *sw.NewFileUploadAndQCAPI(datasetTracker, sessionService),
+		FileUploadAndQCAPI: *fileUploadAPI,
 		HealthAPI: sw.HealthAPI{
 			Scheduler:           scheduler,
 			DatasetTracker:      datasetTracker,
@@ -273,6 +585,7 @@ func initAPIHandlers(scheduler sw.SchedulerInterface, datasetTracker sw.DatasetT
 		MethodsAPI:        methodsAPI,
 		ChatAPI:           *sw.NewChatAPI(genkitClient, conversationTracker, sessionService),
 		VisualizationsAPI: *visualizationsAPI,
+		Authorizer:        authorizer,
 	}
 }
 
@@ -285,18 +598,28 @@ func main() {
 
 	// Initialize trackers
 	dataDir := getEnvWithDefault("DATASET_LOCATION", "/data/uploads")
-	datasetTracker := sw.NewSQLiteDatasetTracker(db.GetDB(), dataDir)
+	basePath := getEnvWithDefault("HYPHY_BASE_PATH", "/data/uploads")
+	datasetStore, resultStore := initBlobStores(dataDir, basePath)
+	datasetTracker := sw.NewSQLiteDatasetTrackerWithBlobStore(db.GetDB(), datasetStore, dataDir)
+	if moved, err := datasetTracker.MigrateLegacyBlobs(); err != nil {
+		log.Fatalf("Failed to move dataset contents to content-addressed storage: %v", err)
+	} else if moved > 0 {
+		log.Printf("Moved %d datasets to content-addressed storage", moved)
+	}
+	// Delete contents no dataset or job uses any more, e.g. after sessions expire
+	datasetTracker.StartBlobCleanup(1 * time.Hour)
 	jobTracker := sw.NewSQLiteJobTracker(db.GetDB())
 	sessionTracker := sw.NewSQLiteSessionTracker(db.GetDB())
 	conversationTracker := sw.NewSQLiteConversationTracker(db.GetDB())
 	vizTracker := sw.NewSQLiteVisualizationTracker(db.GetDB())
+	accountTracker := sw.NewSQLiteAccountTracker(db.GetDB())
+	tokenStore := sw.NewSQLiteTokenStore(db.GetDB())
 
 	// Initialize scheduler
 	scheduler := initScheduler(jobTracker)
 
 	// Create the method factory
 	hyphyPath := getEnvWithDefault("HYPHY_PATH", "hyphy")
-	basePath := getEnvWithDefault("HYPHY_BASE_PATH", "/data/uploads")
 	methodFactory := func(methodType sw.HyPhyMethodType) (sw.ComputeMethodInterface, error) {
 		return sw.NewHyPhyMethod(nil, basePath, hyphyPath, methodType, ""), nil
 	}
@@ -305,11 +628,18 @@ func main() {
 	// This is used to update the job status in the database
 	monitorInterval := 30 * time.Second // Check job statuses every 30 seconds
 	jobMonitor := sw.NewJobStatusMonitor(jobTracker, scheduler, methodFactory, monitorInterval)
+	// Finished jobs' output and logs are published to the result store, then completed
+	// jobs whose results fail to parse are marked failed
+	jobMonitor.Events.Subscribe(sw.NewResultPublisher(resultStore))
+	resultValidator := sw.NewResultValidator()
+	resultValidator.Results = resultStore
+	jobMonitor.Events.Subscribe(resultValidator)
+	jobMonitor.Events.Subscribe(sw.NewJobEventLogger())
 	jobMonitor.Start()
 	defer jobMonitor.Stop()
 
 	// Initialize session service
-	sessionService := initSessionService(sessionTracker)
+	sessionService := initSessionService(sessionTracker, accountTracker, tokenStore)
 
 	// Ensure proper shutdown of components
 	if slurmScheduler, ok := scheduler.(*sw.SlurmRestScheduler); ok {
@@ -317,14 +647,41 @@ func main() {
 		defer slurmScheduler.Shutdown()
 		log.Println("Token refresh service initialized")
 	}
+	if localScheduler, ok := scheduler.(*sw.LocalScheduler); ok {
+		// Records jobs that are still running so they can be recovered on restart
+		defer localScheduler.Shutdown()
+	}
+
+	// Initialize resumable uploads
+	uploadService := initUploadService(db, dataDir)
 
 	// Initialize API handlers
-	routes := initAPIHandlers(scheduler, datasetTracker, jobTracker, conversationTracker, vizTracker, sessionService)
+	routes := initAPIHandlers(scheduler, datasetTracker, jobTracker, conversationTracker, vizTracker, accountTracker, sessionService, jobMonitor.Events, uploadService, resultStore)
 
 	// Start server
 	port := getEnvWithDefault("SERVICE_DATAMONKEY_PORT", "9300")
-	log.Printf("Server starting on port %s", port)
-	if err := sw.NewRouter(routes).Run(fmt.Sprintf(":%s", port)); err != nil {
-		log.Fatalf("Server error: %v", err)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%s", port),
+		Handler: sw.NewRouter(routes),
+	}
+
+	// Stop on SIGINT/SIGTERM so that deferred shutdown hooks get a chance to run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down server...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown error: %v", err)
 	}
 }